@@ -0,0 +1,342 @@
+// Command shortener-admin moves the data of the URL shortening service between data stores,
+// for example from the file storage to the database, through a portable JSON lines, JSON array or CSV dump,
+// and permanently removes the URLs deleted longer ago than the retention period.
+//
+// Usage:
+//
+//	shortener-admin export [-d DSN | -f PATH] [-format jsonl|json|csv] [-o FILE] [-resume]
+//	shortener-admin import [-d DSN | -f PATH] [-format jsonl|json|csv] -i FILE [-conflict skip|overwrite|fail] [-resume]
+//	shortener-admin purge [-d DSN | -f PATH] [-before DURATION] [-batch N] [-dry-run]
+//
+// The data store is set by the -d and -f flags or by the DATABASE_DSN and FILE_STORAGE_PATH environment variables,
+// the database is used if both are set. The dump is written to the standard output if -o is not set.
+//
+// The progress is reported to the standard error. An interrupted export is resumed with -resume
+// after the last complete record of the output file. An interrupted import is resumed with -resume
+// after the records saved in the FILE.progress checkpoint, which is removed when the import is done.
+// The checkpoint is saved every 1000 records and when the import stops on an error or a signal,
+// so after a crash the last records may be imported again and are reported as conflicts by the fail policy.
+//
+// The purge removes the URLs deleted before the -before duration, PURGE_RETENTION or 30 days by default,
+// as the scheduled purge of the service does. With -dry-run, the records are only counted.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go-shortener-url/internal/pkg/dump"
+	"go-shortener-url/internal/pkg/purge"
+	"go-shortener-url/internal/storage"
+)
+
+const usage = `usage:
+  shortener-admin export [-d DSN | -f PATH] [-format jsonl|json|csv] [-o FILE] [-resume]
+  shortener-admin import [-d DSN | -f PATH] [-format jsonl|json|csv] -i FILE [-conflict skip|overwrite|fail] [-resume]
+  shortener-admin purge [-d DSN | -f PATH] [-before DURATION] [-batch N] [-dry-run]`
+
+// defaultRetention is the retention of the purge if neither -before nor PURGE_RETENTION is set.
+const defaultRetention = 30 * 24 * time.Hour
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		stop()
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "export":
+		return runExport(ctx, args[1:], stdout, stderr)
+	case "import":
+		return runImport(ctx, args[1:], stderr)
+	case "purge":
+		return runPurge(ctx, args[1:], stdout, stderr)
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
+}
+
+type storeFlags struct {
+	dsn  string
+	path string
+}
+
+func (s *storeFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&s.dsn, "d", os.Getenv("DATABASE_DSN"), "address connection database")
+	fs.StringVar(&s.path, "f", os.Getenv("FILE_STORAGE_PATH"), "file storage path")
+}
+
+// open opens the data store explicitly, unlike storage.New it never falls back to another data store.
+func (s *storeFlags) open(ctx context.Context) (storage.Storage, error) {
+	switch {
+	case s.dsn != "":
+		return storage.NewPostgresql(ctx, s.dsn)
+	case s.path != "":
+		store := storage.NewFileStorage(ctx, s.path)
+		if err := store.CheckStorage(ctx); err != nil {
+			return nil, fmt.Errorf("failed to open file storage: %w", err)
+		}
+		return store, nil
+	default:
+		return nil, errors.New("data store is not set: use -d or -f")
+	}
+}
+
+func runExport(ctx context.Context, args []string, stdout, stderr io.Writer) (err error) {
+	var (
+		sf     storeFlags
+		format string
+		output string
+		resume bool
+	)
+
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	sf.register(fs)
+	fs.StringVar(&format, "format", dump.FormatJSONL, "dump format: jsonl, json or csv")
+	fs.StringVar(&output, "o", "", "output file, the standard output by default")
+	fs.BoolVar(&resume, "resume", false, "continue the export after the last record of the output file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if resume && output == "" {
+		return errors.New("-resume requires -o")
+	}
+
+	if resume && format == dump.FormatJSON {
+		return errors.New("-resume is not supported for the json format, use jsonl")
+	}
+
+	store, err := sf.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	opts := dump.Options{Format: format}
+
+	w := stdout
+	if output != "" {
+		file, after, count, err := openOutput(output, format, resume)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if errClose := file.Close(); err == nil {
+				err = errClose
+			}
+		}()
+
+		if count > 0 {
+			fmt.Fprintf(stderr, "resuming export after %d records\n", count)
+		}
+
+		opts.After, w = after, file
+	}
+
+	opts.Progress = func(s dump.Stats) error {
+		fmt.Fprintf(stderr, "exported %d records\n", s.Written)
+		return nil
+	}
+
+	_, err = dump.Export(ctx, store, w, opts)
+	return err
+}
+
+// openOutput opens the output file of the export. To resume, the file is truncated after its last complete
+// record and opened for appending, the shortened URL and the number of the written records are returned.
+func openOutput(name, format string, resume bool) (*os.File, string, int, error) {
+	if !resume {
+		file, err := os.Create(name)
+		return file, "", 0, err
+	}
+
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	after, count, size, err := dump.Tail(file, format)
+	if err == nil {
+		err = file.Truncate(size)
+	}
+	if err == nil {
+		_, err = file.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, "", 0, err
+	}
+
+	// The CSV header is written only at the beginning of the file.
+	if count == 0 && size > 0 {
+		after = ""
+		if err := file.Truncate(0); err != nil {
+			file.Close()
+			return nil, "", 0, err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			file.Close()
+			return nil, "", 0, err
+		}
+	}
+
+	return file, after, count, nil
+}
+
+func runImport(ctx context.Context, args []string, stderr io.Writer) error {
+	var (
+		sf       storeFlags
+		format   string
+		input    string
+		conflict string
+		resume   bool
+	)
+
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	sf.register(fs)
+	fs.StringVar(&format, "format", dump.FormatJSONL, "dump format: jsonl, json or csv")
+	fs.StringVar(&input, "i", "", "input file")
+	fs.StringVar(&conflict, "conflict", dump.ConflictFail, "policy for existing URLs: skip, overwrite or fail")
+	fs.BoolVar(&resume, "resume", false, "continue the import after the records of the checkpoint")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if input == "" {
+		return errors.New("input file is not set: use -i")
+	}
+
+	file, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	checkpoint := input + ".progress"
+
+	opts := dump.Options{Format: format, Conflict: conflict}
+
+	if resume {
+		if opts.Skip, err = readCheckpoint(checkpoint); err != nil {
+			return err
+		}
+
+		if opts.Skip > 0 {
+			fmt.Fprintf(stderr, "resuming import after %d records\n", opts.Skip)
+		}
+	}
+
+	store, err := sf.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	opts.Progress = func(s dump.Stats) error {
+		fmt.Fprintf(stderr, "processed %d records: imported %d, skipped %d\n", s.Processed, s.Written, s.Skipped)
+		return writeCheckpoint(checkpoint, s.Processed)
+	}
+
+	stats, err := dump.Import(ctx, store, file, opts)
+	if err != nil {
+		if errCheckpoint := writeCheckpoint(checkpoint, stats.Processed); errCheckpoint != nil {
+			return errors.Join(err, errCheckpoint)
+		}
+		return err
+	}
+
+	return os.Remove(checkpoint)
+}
+
+func writeCheckpoint(name string, processed int) error {
+	return os.WriteFile(name, []byte(strconv.Itoa(processed)), 0644)
+}
+
+func readCheckpoint(name string) (int, error) {
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid checkpoint %s: %w", name, err)
+	}
+
+	return n, nil
+}
+
+func runPurge(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var (
+		sf        storeFlags
+		retention time.Duration
+		batchSize int
+		dryRun    bool
+	)
+
+	defaultBefore := defaultRetention
+	if v := os.Getenv("PURGE_RETENTION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid PURGE_RETENTION: %w", err)
+		}
+		defaultBefore = d
+	}
+
+	fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	sf.register(fs)
+	fs.DurationVar(&retention, "before", defaultBefore, "purge the URLs deleted longer ago than this")
+	fs.IntVar(&batchSize, "batch", 0, "maximum number of URLs removed at once, 1000 by default")
+	fs.BoolVar(&dryRun, "dry-run", false, "only count the records to remove")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if retention <= 0 {
+		return errors.New("-before must be positive")
+	}
+
+	store, err := sf.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	rst, err := purge.New(store, purge.Config{Retention: retention, BatchSize: batchSize}).Purge(ctx, dryRun)
+	if err != nil {
+		return err
+	}
+
+	verb := "purged"
+	if dryRun {
+		verb = "would purge"
+	}
+
+	fmt.Fprintf(stdout, "%s %d URLs and %d orphaned records\n", verb, rst.URLs, rst.Orphans)
+	return nil
+}
@@ -4,6 +4,7 @@ package app
 import (
 	"context"
 	"errors"
+	"fmt"
 	"go-shortener-url/internal/pkg/deleteurl"
 	"net/http"
 	"os/signal"
@@ -14,14 +15,20 @@ import (
 
 	"go-shortener-url/internal/config"
 	"go-shortener-url/internal/controller"
+	mw "go-shortener-url/internal/middleware"
+	"go-shortener-url/internal/pkg/domainlist"
+	"go-shortener-url/internal/pkg/geoip"
+	"go-shortener-url/internal/pkg/purge"
+	"go-shortener-url/internal/pkg/tracing"
+	"go-shortener-url/internal/pkg/urlcheck"
 	"go-shortener-url/internal/storage"
 	"go-shortener-url/internal/usecase"
 )
 
+const workersDeletingURLs = 2
+
 // Start is the entry point of the application.
 func Start() {
-	var workersDeletingURLs = 2
-
 	cfg, err := config.NewConfig()
 	if err != nil {
 		slog.Error(err.Error())
@@ -29,31 +36,180 @@ func Start() {
 	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+
+	if err := Run(ctx, cfg); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// Run starts the service and blocks until the context is done, then stops the service gracefully:
+// stops accepting connections and waits for the handlers, waits for the pending deletion jobs
+// and closes the data store.
+func Run(ctx context.Context, cfg *config.Config) error {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	interstitial := usecase.InterstitialPolicy{
+		Mode:          cfg.InterstitialMode,
+		Delay:         cfg.InterstitialDelay,
+		InternalHosts: cfg.InternalDomains,
+	}
+	if err := interstitial.Validate(); err != nil {
+		return err
+	}
 
-	db := storage.New(ctx, cfg.AddrConnDB, cfg.FileStoragePath)
-	defer db.Close()
+	if cfg.QueryConflict != "" {
+		if err := usecase.ValidateQueryConflict(cfg.QueryConflict); err != nil {
+			return err
+		}
+	}
 
-	deleterURLs := deleteurl.InitUrlDeleteService(db)
+	if cfg.RedirectType != 0 {
+		if err := usecase.ValidateRedirectType(cfg.RedirectType); err != nil {
+			return fmt.Errorf("%w: %d", err, cfg.RedirectType)
+		}
+	}
+
+	if _, err := mw.ParseTrustedProxies(cfg.TrustedProxies); err != nil {
+		return err
+	}
+
+	if _, err := usecase.ParseRateLimit(cfg.CreateRateLimit, cfg.CreateRateBurst); err != nil {
+		return fmt.Errorf("create rate limit: %w", err)
+	}
+
+	if _, err := usecase.ParseRateLimit(cfg.RedirectRateLimit, cfg.RedirectRateBurst); err != nil {
+		return fmt.Errorf("redirect rate limit: %w", err)
+	}
+
+	if cfg.DefaultLinkQuota < 0 {
+		return fmt.Errorf("%w: %d", usecase.ErrInvalidQuota, cfg.DefaultLinkQuota)
+	}
+
+	urlPolicy := usecase.URLPolicy{
+		Schemes:   cfg.URLSchemes,
+		MaxLength: cfg.MaxURLLength,
+	}
+	if err := urlPolicy.Validate(); err != nil {
+		return err
+	}
+
+	var domains *domainlist.List
+	if cfg.DomainListFile != "" {
+		var err error
+		if domains, err = domainlist.Open(cfg.DomainListFile); err != nil {
+			return fmt.Errorf("failed to open domain list: %w", err)
+		}
+		domains.Run(cfg.DomainListReload)
+		defer domains.Shutdown(context.Background())
+
+		urlPolicy.Domains = domains
+	}
+
+	var checkers urlcheck.Chain
+	if cfg.ThreatListFile != "" {
+		threats, err := urlcheck.OpenHashList(cfg.ThreatListFile)
+		if err != nil {
+			return fmt.Errorf("failed to open threat list: %w", err)
+		}
+		checkers = append(checkers, threats)
+	}
+	if cfg.URLCheckWebhook != "" {
+		checkers = append(checkers, urlcheck.NewWebhook(cfg.URLCheckWebhook, cfg.URLCheckWebhookToken, cfg.URLCheckTimeout))
+	}
+
+	var geo *geoip.Reader
+	if cfg.GeoIPDB != "" {
+		var err error
+		if geo, err = geoip.Open(cfg.GeoIPDB); err != nil {
+			return fmt.Errorf("failed to open GeoIP database: %w", err)
+		}
+	}
+
+	shutdownTracing, err := tracing.Init(ctx, tracing.Config{
+		Exporter:     cfg.TraceExporter,
+		File:         cfg.TraceFile,
+		OTLPEndpoint: cfg.OTLPEndpoint,
+		OTLPInsecure: cfg.OTLPInsecure,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("failed by shutdown tracing", "err", err)
+		}
+	}()
+
+	db := storage.WithTracing(storage.New(ctx, cfg.AddrConnDB, cfg.FileStoragePath))
+
+	journalPath := cfg.DeleteJournalPath
+	if journalPath == "" && cfg.FileStoragePath != "" {
+		journalPath = cfg.FileStoragePath + ".jobs"
+	}
+
+	queue, err := storage.NewJobQueue(db, journalPath)
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("failed to open deletion queue: %w", err)
+	}
+
+	deleterURLs := deleteurl.InitUrlDeleteService(db, queue, deleteurl.Config{
+		MaxAttempts:   cfg.DeleteMaxAttempts,
+		RetryDelay:    cfg.DeleteRetryDelay,
+		MaxBacklog:    cfg.DeleteMaxBacklog,
+		BatchSize:     cfg.DeleteBatchSize,
+		FlushInterval: cfg.DeleteFlushInterval,
+	})
 	deleterURLs.Run(workersDeletingURLs)
 
+	if cfg.PurgeRetention < cfg.RestorePeriod {
+		slog.Warn("purge retention is shorter than the restore period, deleted URLs are purged before they can be restored")
+	}
+
+	purger := purge.New(db, purge.Config{
+		Retention: cfg.PurgeRetention,
+		Interval:  cfg.PurgeInterval,
+		BatchSize: cfg.PurgeBatchSize,
+		DryRun:    cfg.PurgeDryRun,
+	})
+	purger.Run()
+
 	manager := usecase.New(db, deleterURLs, cfg.BaseURL)
+	manager.SetRestorePeriod(cfg.RestorePeriod)
+	manager.SetPurger(purger)
+	manager.SetInterstitial(interstitial)
+	manager.SetDefaultRedirectType(cfg.RedirectType)
+	manager.SetQueryConflict(cfg.QueryConflict)
+	manager.SetURLPolicy(urlPolicy)
+	manager.SetRateLimiter(storage.NewRateLimiter(db, cfg.RateLimitShared))
+	manager.SetDefaultQuota(cfg.DefaultLinkQuota)
+	if len(checkers) > 0 {
+		manager.SetURLChecker(checkers)
+		manager.SetRecheckOnRedirect(cfg.URLRecheckOnRedirect)
+	}
+	if geo != nil {
+		manager.SetGeoLocator(geo)
+	}
 
-	srv := controller.New(manager)
+	srv := controller.New(manager, cfg)
 	srv.Addr = cfg.ServerAddress
 
 	slog.Info("starting HTTP server go-shortener-url")
 
 	go func() {
+		var err error
 		if cfg.EnableHTTPS {
-			err := srv.ListenAndServeTLS("server.crt", "server.key")
-			if err != nil && !errors.Is(err, http.ErrServerClosed) {
-				slog.Error("failed to start server", err.Error())
-				stop()
-				return
-			}
+			err = srv.ListenAndServeTLS("server.crt", "server.key")
+		} else {
+			err = srv.ListenAndServe()
 		}
 
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			slog.Error("failed to start server", err.Error())
 			stop()
 		}
@@ -69,14 +225,38 @@ func Start() {
 
 	<-ctx.Done()
 
-	slog.Info("stopped HTTP server go-shortener-url")
+	manager.SetShuttingDown()
+
+	slog.Info("stopping HTTP server go-shortener-url")
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
+	var errs []error
+
 	if err := srv.Shutdown(shutdownCtx); err != nil {
-		slog.Error("failed by shutdown HTTP server", err.Error())
+		errs = append(errs, fmt.Errorf("failed by shutdown HTTP server: %w", err))
+	}
+
+	if err := deleterURLs.Shutdown(shutdownCtx); err != nil {
+		errs = append(errs, fmt.Errorf("failed by draining deletion queue: %w", err))
 	}
 
-	deleterURLs.Stop()
+	if err := purger.Shutdown(shutdownCtx); err != nil {
+		errs = append(errs, fmt.Errorf("failed by stopping purge: %w", err))
+	}
+
+	if journal, ok := queue.(*storage.JournalQueue); ok {
+		if err := journal.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed by closing deletion journal: %w", err))
+		}
+	}
+
+	if err := db.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed by closing storage: %w", err))
+	}
+
+	slog.Info("stopped HTTP server go-shortener-url")
+
+	return errors.Join(errs...)
 }
@@ -0,0 +1,183 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go-shortener-url/internal/config"
+	"go-shortener-url/internal/pkg/sign"
+	"go-shortener-url/internal/storage"
+)
+
+func TestRunGracefulShutdown(t *testing.T) {
+	const (
+		preloaded = 20
+		loaders   = 4
+	)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	cfg := &config.Config{
+		ServerAddress:   addr,
+		BaseURL:         "http://" + addr,
+		FileStoragePath: filepath.Join(t.TempDir(), "storage.txt"),
+		ShutdownTimeout: 5 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+
+	errRun := make(chan error, 1)
+	go func() {
+		errRun <- Run(ctx, cfg)
+	}()
+
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(cfg.BaseURL + "/healthz")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+
+	userID := sign.UserID()
+
+	create := func(origURL string) (string, bool) {
+		req, err := http.NewRequest(http.MethodPost, cfg.BaseURL, strings.NewReader(origURL))
+		if err != nil {
+			return "", false
+		}
+		req.Header.Set("Cookie", "id="+userID)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", false
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil || resp.StatusCode != http.StatusCreated {
+			return "", false
+		}
+
+		return string(body), true
+	}
+
+	remove := func(id string) bool {
+		body, _ := json.Marshal([]string{id})
+		req, err := http.NewRequest(http.MethodDelete, cfg.BaseURL+"/api/user/urls", strings.NewReader(string(body)))
+		if err != nil {
+			return false
+		}
+		req.Header.Set("Cookie", "id="+userID)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode == http.StatusAccepted
+	}
+
+	var (
+		mu      sync.Mutex
+		created = make(map[string]string)
+		deleted = make(map[string]bool)
+	)
+
+	toDelete := make([]string, 0, preloaded)
+	for i := 0; i < preloaded; i++ {
+		origURL := fmt.Sprintf("http://example.com/preloaded/%d", i)
+		shortURL, ok := create(origURL)
+		require.True(t, ok)
+
+		created[shortURL] = origURL
+		toDelete = append(toDelete, shortURL)
+	}
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < loaders; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; ; i++ {
+				origURL := fmt.Sprintf("http://example.com/load/%d/%d", w, i)
+				shortURL, ok := create(origURL)
+				if !ok {
+					return
+				}
+
+				mu.Lock()
+				created[shortURL] = origURL
+				mu.Unlock()
+			}
+		}(w)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for _, shortURL := range toDelete {
+			if !remove(strings.TrimPrefix(shortURL, cfg.BaseURL+"/")) {
+				return
+			}
+
+			mu.Lock()
+			deleted[shortURL] = true
+			mu.Unlock()
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+
+	select {
+	case err := <-errRun:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("service did not stop")
+	}
+
+	wg.Wait()
+
+	store := storage.NewFileStorage(context.Background(), cfg.FileStoragePath)
+	defer store.Close()
+
+	assert.Greater(t, len(created), preloaded)
+	assert.NotEmpty(t, deleted)
+
+	for shortURL, origURL := range created {
+		v, err := store.Get(context.Background(), shortURL)
+		if deleted[shortURL] {
+			assert.ErrorIs(t, err, storage.ErrDeletedURL, shortURL)
+			continue
+		}
+
+		require.NoError(t, err, shortURL)
+		assert.Equal(t, origURL, v.OriginalURL)
+	}
+}
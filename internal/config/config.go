@@ -4,12 +4,16 @@ package config
 import (
 	"encoding/json"
 	"flag"
+	"net/http"
 	"os"
+	"time"
 
 	"github.com/caarlos0/env/v7"
 )
 
 // Config contains the necessary parameters for the service to work.
+// The settings with a json key and those of fileDefaults may also be set in the configuration file,
+// which has the lowest priority. The other settings are set by the flags and the environment only.
 type Config struct {
 	// ServerAddress is the HTTP server startup address
 	ServerAddress string `env:"SERVER_ADDRESS" json:"server_address"`
@@ -25,13 +29,120 @@ type Config struct {
 	EnableHTTPS bool `env:"ENABLE_HTTPS" json:"enable_https"`
 	// Config path to service configuration file.
 	FileConfig string `env:"CONFIG"`
+	// TraceExporter is the name of the tracing exporter: "stdout", "otlp" or empty to disable tracing.
+	TraceExporter string `env:"TRACE_EXPORTER" json:"trace_exporter"`
+	// TraceFile path to the file into which the stdout exporter writes spans.
+	TraceFile string `env:"TRACE_FILE" json:"trace_file"`
+	// OTLPEndpoint is the host:port of the OTLP/HTTP collector.
+	OTLPEndpoint string `env:"OTLP_ENDPOINT" json:"otlp_endpoint"`
+	// OTLPInsecure disables TLS for the OTLP exporter.
+	OTLPInsecure bool `env:"OTLP_INSECURE" json:"otlp_insecure"`
+	// ShutdownTimeout limits the time to complete requests and pending jobs when the service is stopped.
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT"`
+	// DeleteJournalPath path to the journal of deletion jobs used when the database is not available.
+	// By default, the journal is placed next to the storage file.
+	DeleteJournalPath string `env:"DELETE_JOURNAL_PATH" json:"delete_journal_path"`
+	// DeleteMaxAttempts is the number of attempts after which a deletion job is moved to the dead-letter state.
+	DeleteMaxAttempts int `env:"DELETE_MAX_ATTEMPTS"`
+	// DeleteRetryDelay is the delay before the first retry of a deletion job, it doubles with each attempt.
+	DeleteRetryDelay time.Duration `env:"DELETE_RETRY_DELAY"`
+	// DeleteMaxBacklog is the number of pending deletion jobs above which the service is not ready.
+	DeleteMaxBacklog int `env:"DELETE_MAX_BACKLOG"`
+	// DeleteBatchSize is the maximum number of URLs deleted in the data store at once.
+	DeleteBatchSize int `env:"DELETE_BATCH_SIZE"`
+	// DeleteFlushInterval is how long the deletion workers wait for more jobs before applying an incomplete batch.
+	DeleteFlushInterval time.Duration `env:"DELETE_FLUSH_INTERVAL"`
+	// RestorePeriod is the time during which the user can restore a deleted URL.
+	RestorePeriod time.Duration `env:"RESTORE_PERIOD"`
+	// PurgeRetention is the time after deletion after which a URL is removed permanently.
+	PurgeRetention time.Duration `env:"PURGE_RETENTION"`
+	// PurgeInterval is the interval between the scheduled purges of deleted URLs.
+	PurgeInterval time.Duration `env:"PURGE_INTERVAL"`
+	// PurgeBatchSize is the maximum number of URLs removed permanently at once.
+	PurgeBatchSize int `env:"PURGE_BATCH_SIZE"`
+	// PurgeDryRun makes the scheduled purges only count the URLs to remove.
+	PurgeDryRun bool `env:"PURGE_DRY_RUN" json:"purge_dry_run"`
+	// AdminToken is the bearer token for the administrative API, the API is disabled if it is empty.
+	AdminToken string `env:"ADMIN_TOKEN" json:"admin_token"`
+	// QRCacheMaxAge is the time during which clients and proxies may cache the QR codes of the URLs.
+	QRCacheMaxAge time.Duration `env:"QR_CACHE_MAX_AGE"`
+	// InterstitialMode selects the URLs followed through the interstitial page with a countdown:
+	// "external" for the URLs leading outside of InternalDomains, "all" or empty to redirect immediately.
+	InterstitialMode string `env:"INTERSTITIAL_MODE"`
+	// InterstitialDelay is the countdown of the interstitial page.
+	InterstitialDelay time.Duration `env:"INTERSTITIAL_DELAY"`
+	// InternalDomains are the comma-separated domains whose URLs are not external, the base URL is always internal.
+	InternalDomains []string `env:"INTERNAL_DOMAINS"`
+	// RedirectType is the HTTP status of the redirects of the URLs without their own: 301, 302, 303, 307 or 308.
+	RedirectType int `env:"REDIRECT_TYPE"`
+	// RedirectCacheMaxAge is the time during which clients may cache the permanent redirects.
+	RedirectCacheMaxAge time.Duration `env:"REDIRECT_CACHE_MAX_AGE"`
+	// QueryConflict is the policy of the query parameters present both in the request and in the original URL
+	// of the URLs with the passthrough: "keep" the original values, "override" them or "append" the request values.
+	QueryConflict string `env:"QUERY_CONFLICT"`
+	// GeoIPDB path to the MaxMind DB (.mmdb) file with the countries of IP addresses for the targeting rules.
+	GeoIPDB string `env:"GEOIP_DB"`
+	// TrustedProxies are the comma-separated IP addresses and CIDR networks of the proxies
+	// whose X-Forwarded-For and X-Real-IP headers are used to find the address of the client.
+	TrustedProxies []string `env:"TRUSTED_PROXIES"`
+	// URLSchemes are the comma-separated schemes allowed in the destination URLs.
+	URLSchemes []string `env:"URL_SCHEMES"`
+	// MaxURLLength is the maximum length of the destination URLs in bytes.
+	MaxURLLength int `env:"MAX_URL_LENGTH"`
+	// DomainListFile path to the file with the allow and deny lists of the domains of the destination URLs,
+	// the file is reloaded when it changes.
+	DomainListFile string `env:"DOMAIN_LIST_FILE"`
+	// DomainListReload is the interval between the checks of the domain list file for changes.
+	DomainListReload time.Duration `env:"DOMAIN_LIST_RELOAD"`
+	// ThreatListFile path to the file with the SHA-256 hash prefixes of the unsafe URLs, as in the Safe Browsing lists.
+	ThreatListFile string `env:"THREAT_LIST_FILE"`
+	// URLCheckWebhook is the endpoint of the HTTP service checking the destination URLs.
+	URLCheckWebhook string `env:"URL_CHECK_WEBHOOK"`
+	// URLCheckWebhookToken is the bearer token of the requests to URLCheckWebhook.
+	URLCheckWebhookToken string `env:"URL_CHECK_WEBHOOK_TOKEN"`
+	// URLCheckTimeout is the timeout of the requests to URLCheckWebhook.
+	URLCheckTimeout time.Duration `env:"URL_CHECK_TIMEOUT"`
+	// URLRecheckOnRedirect checks the destinations again on every redirect and quarantines the flagged URLs.
+	URLRecheckOnRedirect bool `env:"URL_RECHECK_ON_REDIRECT"`
+	// CreateRateLimit is the rate of the requests creating URLs per user, such as "30/m", empty to disable the limit.
+	CreateRateLimit string `env:"CREATE_RATE_LIMIT" json:"create_rate_limit"`
+	// CreateRateBurst is the number of the requests creating URLs allowed at once, the number per unit of the rate if zero.
+	CreateRateBurst int `env:"CREATE_RATE_BURST" json:"create_rate_burst"`
+	// RedirectRateLimit is the rate of the redirects per client IP address, such as "20/s", empty to disable the limit.
+	RedirectRateLimit string `env:"REDIRECT_RATE_LIMIT" json:"redirect_rate_limit"`
+	// RedirectRateBurst is the number of the redirects allowed at once, the number per unit of the rate if zero.
+	RedirectRateBurst int `env:"REDIRECT_RATE_BURST" json:"redirect_rate_burst"`
+	// RateLimitShared keeps the rate limits in PostgreSQL, so they hold across the replicas of the service.
+	RateLimitShared bool `env:"RATE_LIMIT_SHARED" json:"rate_limit_shared"`
+	// DefaultLinkQuota is the number of the active links a user may hold unless the user has a quota of their own,
+	// zero for no limit.
+	DefaultLinkQuota int `env:"DEFAULT_LINK_QUOTA" json:"default_link_quota"`
 }
 
 // NewConfig initializes the Config structure.
 func NewConfig() (*Config, error) {
 	cfg := Config{
-		ServerAddress: "localhost:8080",
-		BaseURL:       "http://localhost:8080",
+		ServerAddress:       "localhost:8080",
+		BaseURL:             "http://localhost:8080",
+		ShutdownTimeout:     10 * time.Second,
+		DeleteMaxAttempts:   5,
+		DeleteRetryDelay:    time.Second,
+		DeleteMaxBacklog:    10000,
+		DeleteBatchSize:     100,
+		DeleteFlushInterval: 100 * time.Millisecond,
+		RestorePeriod:       24 * time.Hour,
+		PurgeRetention:      30 * 24 * time.Hour,
+		PurgeInterval:       time.Hour,
+		PurgeBatchSize:      1000,
+		QRCacheMaxAge:       24 * time.Hour,
+		InterstitialDelay:   5 * time.Second,
+		RedirectType:        http.StatusTemporaryRedirect,
+		RedirectCacheMaxAge: 24 * time.Hour,
+		QueryConflict:       "keep",
+		URLSchemes:          []string{"http", "https"},
+		MaxURLLength:        2048,
+		DomainListReload:    10 * time.Second,
+		URLCheckTimeout:     2 * time.Second,
 	}
 
 	setConfigWithArgs(&cfg)
@@ -54,6 +165,11 @@ func setConfigWithArgs(cfg *Config) {
 	flag.StringVar(&cfg.AddrConnDB, "d", cfg.AddrConnDB, "address connection database")
 	flag.BoolVar(&cfg.EnableHTTPS, "s", cfg.EnableHTTPS, "enable HTTPS")
 	flag.StringVar(&cfg.FileConfig, "c", cfg.FileConfig, "service configuration file")
+	flag.StringVar(&cfg.TraceExporter, "trace-exporter", cfg.TraceExporter, "tracing exporter: stdout or otlp")
+	flag.StringVar(&cfg.TraceFile, "trace-file", cfg.TraceFile, "file for the stdout tracing exporter")
+	flag.StringVar(&cfg.OTLPEndpoint, "otlp-endpoint", cfg.OTLPEndpoint, "address of the OTLP collector")
+	flag.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "graceful shutdown timeout")
+	flag.StringVar(&cfg.DeleteJournalPath, "delete-journal", cfg.DeleteJournalPath, "journal of deletion jobs")
 	flag.Parse()
 }
 
@@ -87,4 +203,119 @@ func setConfigWithFile(cfg *Config) {
 	if !cfg.EnableHTTPS || tmp.EnableHTTPS {
 		cfg.EnableHTTPS = tmp.EnableHTTPS
 	}
+
+	if cfg.TraceExporter == "" {
+		cfg.TraceExporter = tmp.TraceExporter
+	}
+
+	if cfg.TraceFile == "" {
+		cfg.TraceFile = tmp.TraceFile
+	}
+
+	if cfg.OTLPEndpoint == "" {
+		cfg.OTLPEndpoint = tmp.OTLPEndpoint
+	}
+
+	if !cfg.OTLPInsecure {
+		cfg.OTLPInsecure = tmp.OTLPInsecure
+	}
+
+	if cfg.DeleteJournalPath == "" {
+		cfg.DeleteJournalPath = tmp.DeleteJournalPath
+	}
+
+	if !cfg.PurgeDryRun {
+		cfg.PurgeDryRun = tmp.PurgeDryRun
+	}
+
+	if cfg.AdminToken == "" {
+		cfg.AdminToken = tmp.AdminToken
+	}
+
+	if cfg.CreateRateLimit == "" {
+		cfg.CreateRateLimit = tmp.CreateRateLimit
+	}
+
+	if cfg.CreateRateBurst == 0 {
+		cfg.CreateRateBurst = tmp.CreateRateBurst
+	}
+
+	if cfg.RedirectRateLimit == "" {
+		cfg.RedirectRateLimit = tmp.RedirectRateLimit
+	}
+
+	if cfg.RedirectRateBurst == 0 {
+		cfg.RedirectRateBurst = tmp.RedirectRateBurst
+	}
+
+	if !cfg.RateLimitShared {
+		cfg.RateLimitShared = tmp.RateLimitShared
+	}
+
+	if cfg.DefaultLinkQuota == 0 {
+		cfg.DefaultLinkQuota = tmp.DefaultLinkQuota
+	}
+
+	var defaults fileDefaults
+	if err = json.Unmarshal(b, &defaults); err != nil {
+		return
+	}
+
+	setFromFile(&cfg.DeleteMaxAttempts, defaults.DeleteMaxAttempts, "DELETE_MAX_ATTEMPTS")
+	setFromFile(&cfg.DeleteRetryDelay, (*time.Duration)(defaults.DeleteRetryDelay), "DELETE_RETRY_DELAY")
+	setFromFile(&cfg.DeleteMaxBacklog, defaults.DeleteMaxBacklog, "DELETE_MAX_BACKLOG")
+	setFromFile(&cfg.DeleteBatchSize, defaults.DeleteBatchSize, "DELETE_BATCH_SIZE")
+	setFromFile(&cfg.DeleteFlushInterval, (*time.Duration)(defaults.DeleteFlushInterval), "DELETE_FLUSH_INTERVAL")
+	setFromFile(&cfg.RestorePeriod, (*time.Duration)(defaults.RestorePeriod), "RESTORE_PERIOD")
+	setFromFile(&cfg.PurgeRetention, (*time.Duration)(defaults.PurgeRetention), "PURGE_RETENTION")
+	setFromFile(&cfg.PurgeInterval, (*time.Duration)(defaults.PurgeInterval), "PURGE_INTERVAL")
+	setFromFile(&cfg.PurgeBatchSize, defaults.PurgeBatchSize, "PURGE_BATCH_SIZE")
+}
+
+// fileDefaults are the settings of the configuration file that have defaults, so they cannot be told
+// from the unset ones by the zero value. They are applied if they are present in the file and not set
+// in the environment. The durations are written as in the environment, e.g. "1h30m".
+type fileDefaults struct {
+	DeleteMaxAttempts   *int      `json:"delete_max_attempts"`
+	DeleteRetryDelay    *duration `json:"delete_retry_delay"`
+	DeleteMaxBacklog    *int      `json:"delete_max_backlog"`
+	DeleteBatchSize     *int      `json:"delete_batch_size"`
+	DeleteFlushInterval *duration `json:"delete_flush_interval"`
+	RestorePeriod       *duration `json:"restore_period"`
+	PurgeRetention      *duration `json:"purge_retention"`
+	PurgeInterval       *duration `json:"purge_interval"`
+	PurgeBatchSize      *int      `json:"purge_batch_size"`
+}
+
+// duration is the time.Duration written in the configuration file as a string.
+type duration time.Duration
+
+// UnmarshalJSON parses the duration string, such as "300ms" or "1h30m".
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = duration(v)
+	return nil
+}
+
+// setFromFile sets the value from the configuration file unless it is missing in the file
+// or the environment variable key is set.
+func setFromFile[T any](dst *T, v *T, key string) {
+	if v == nil {
+		return
+	}
+
+	if _, ok := os.LookupEnv(key); ok {
+		return
+	}
+
+	*dst = *v
 }
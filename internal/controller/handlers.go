@@ -2,16 +2,33 @@ package controller
 
 import (
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
 
+	mw "go-shortener-url/internal/middleware"
+	"go-shortener-url/internal/pkg/dump"
+	"go-shortener-url/internal/pkg/qrcode"
+	"go-shortener-url/internal/storage"
 	"go-shortener-url/internal/usecase"
 )
 
+var tracer = otel.Tracer("go-shortener-url/internal/controller")
+
 func unzipBody(r *http.Request) ([]byte, error) {
 	var (
 		reader io.Reader
@@ -19,6 +36,9 @@ func unzipBody(r *http.Request) ([]byte, error) {
 	)
 
 	if r.Header.Get("Content-Encoding") == "gzip" {
+		_, span := tracer.Start(r.Context(), "controller.unzipBody")
+		defer span.End()
+
 		gz, err := gzip.NewReader(r.Body)
 		if err != nil {
 			return nil, err
@@ -40,6 +60,7 @@ func unzipBody(r *http.Request) ([]byte, error) {
 
 // CreateShortURL accepts the URL string to be shortened in the request body.
 // In the response body, returns a shortened URL as a text string.
+// The URLs rejected by the URL policy are answered with the code of the reason, see writeInvalidLink.
 func CreateShortURL(m *usecase.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		body, err := unzipBody(r)
@@ -59,13 +80,23 @@ func CreateShortURL(m *usecase.Manager) http.HandlerFunc {
 			w.Write([]byte(url))
 		}
 
-		shortURL, err := m.CreateShortURL(r.Context(), string(body), c.Value)
+		shortURL, err := m.CreateShortURL(r.Context(), string(body), c.Value, usecase.LinkOptions{})
 		if err != nil {
 			if errors.Is(err, usecase.ErrUniqueValue) {
 				writeResponse(shortURL, http.StatusConflict)
 				return
 			}
 
+			if isInvalidLink(err) {
+				writeInvalidLink(w, err)
+				return
+			}
+
+			if errors.Is(err, usecase.ErrQuotaExceeded) {
+				writeQuotaExceeded(w, err)
+				return
+			}
+
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -79,11 +110,18 @@ func CreateShortURL(m *usecase.Manager) http.HandlerFunc {
 //	  [
 //	     {
 //		       "correlation_id": "<string identifier>",
-//		       "original_url": "<URL to shorten>"
+//		       "original_url": "<URL to shorten>",
+//		       "tags": ["<tag>", ...],
+//		       "redirect_type": 301,
+//		       "passthrough": true,
+//		       "utm": {"source": "<source>", "medium": "<medium>", "campaign": "<campaign>", "term": "<term>", "content": "<content>"},
+//		       "rules": [{"os": "ios", "device": "mobile", "agent": "human", "language": "de", "url": "<destination>"}, ...],
+//		       "variants": [{"url": "<destination>", "weight": 70}, {"url": "<destination>", "weight": 30}]
 //		    },
 //		    ...
 //	  ].
 //
+// The tags, the redirect type, the passthrough, the UTM components, the targeting rules and the variants are optional.
 // The response returns a shortened URL for each URL in the set in the format:
 //
 //	  [
@@ -95,8 +133,14 @@ func CreateShortURL(m *usecase.Manager) http.HandlerFunc {
 //	  ].
 func CreateManyShortURL(m *usecase.Manager) http.HandlerFunc {
 	type request struct {
-		ID  string `json:"correlation_id"`
-		URL string `json:"original_url"`
+		ID           string            `json:"correlation_id"`
+		URL          string            `json:"original_url"`
+		Tags         []string          `json:"tags"`
+		RedirectType int               `json:"redirect_type"`
+		Passthrough  bool              `json:"passthrough"`
+		UTM          storage.UTM       `json:"utm"`
+		Rules        []storage.Rule    `json:"rules"`
+		Variants     []storage.Variant `json:"variants"`
 	}
 
 	type response struct {
@@ -132,16 +176,35 @@ func CreateManyShortURL(m *usecase.Manager) http.HandlerFunc {
 			return
 		}
 
+		urls := make([]usecase.NewURL, 0, len(req))
 		for _, v := range req {
-			var shortURL string
+			urls = append(urls, usecase.NewURL{
+				OriginalURL: v.URL,
+				Options: usecase.LinkOptions{
+					Tags:         v.Tags,
+					RedirectType: v.RedirectType,
+					Passthrough:  v.Passthrough,
+					UTM:          v.UTM,
+					Rules:        v.Rules,
+					Variants:     v.Variants,
+				},
+			})
+		}
 
-			shortURL, err = m.CreateShortURL(r.Context(), v.URL, c.Value)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+		shortURLs, err := m.CreateShortURLs(r.Context(), c.Value, urls)
+		if isInvalidLink(err) {
+			writeInvalidLink(w, err)
+			return
+		} else if errors.Is(err, usecase.ErrQuotaExceeded) {
+			writeQuotaExceeded(w, err)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
-			resp = append(resp, response{ID: v.ID, URL: shortURL})
+		for i, v := range req {
+			resp = append(resp, response{ID: v.ID, URL: shortURLs[i]})
 		}
 
 		data, err := json.Marshal(resp)
@@ -157,40 +220,334 @@ func CreateManyShortURL(m *usecase.Manager) http.HandlerFunc {
 }
 
 // GetFullURL takes a shortened URL identifier as a URL parameter.
-// The original URL is returned in the Location HTTP header.
-func GetFullURL(m *usecase.Manager) http.HandlerFunc {
+// The original URL is returned in the Location HTTP header with the redirect status of the URL,
+// the permanent redirects are cacheable for maxAge. If the passthrough is enabled for the URL,
+// the query parameters of the request and the path after the identifier are added to the original URL.
+// The path "/qr" right after the identifier is reserved for the QR code of the URL, see GetQRCode,
+// so it is never passed through; the longer paths starting with it are.
+// The identifier followed by "+" or the preview query parameter render the preview page instead,
+// the URLs selected by the interstitial policy are followed through the page with a countdown.
+// The targeting rules of the URL select the destination by the User-Agent and Accept-Language headers
+// and the country of the client, the targeted redirects are only cached by the client.
+// The visitors of a split URL are assigned to a variant, which is kept in a cookie, so that they see
+// the same variant again. The redirects to the variants are not cached, so that every click is counted.
+// The quarantined URLs render the warning page instead of the redirect or the preview.
+func GetFullURL(m *usecase.Manager, maxAge time.Duration) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		shortURL := chi.URLParam(r, "id")
+		shortURL, preview := strings.CutSuffix(chi.URLParam(r, "id"), "+")
 		if shortURL == "" {
 			http.Error(w, "ID param is missed", http.StatusBadRequest)
 			return
 		}
 
-		originalURL, err := m.GetFullURL(r.Context(), shortURL)
+		query := r.URL.Query()
+		if v := query.Get("preview"); v != "" {
+			preview, _ = strconv.ParseBool(v)
+		}
+		query.Del("preview")
+
+		pass := usecase.Passthrough{Path: pathTail(r), Query: query}
+		visitor := usecase.Visitor{
+			UserAgent:      r.UserAgent(),
+			AcceptLanguage: r.Header.Get("Accept-Language"),
+			Country:        mw.ClientFromContext(r.Context()).Country,
+			Preview:        preview,
+		}
+
+		if c, err := r.Cookie(variantCookie(shortURL)); err == nil {
+			visitor.Variant = c.Value
+		}
+
+		if !preview && !m.InterstitialEnabled() {
+			target, err := m.GetFullURL(r.Context(), shortURL, pass, visitor)
+			if err != nil {
+				writeFullURLError(w, r, err)
+				return
+			}
+
+			if target.Threat != "" {
+				writeWarning(w, target.URL, target.Threat)
+				return
+			}
+
+			setVariantCookie(w, shortURL, target.Variant)
+			redirect(w, r, target.URL, target.Status, maxAge, target.Targeted || target.Variant != "")
+			return
+		}
+
+		link, err := m.GetPreview(r.Context(), shortURL, pass, visitor)
+		if err != nil {
+			writeFullURLError(w, r, err)
+			return
+		}
+
+		if link.Threat != "" {
+			writeWarning(w, link.OriginalURL, link.Threat)
+			return
+		}
+
+		setVariantCookie(w, shortURL, link.Variant)
+		if !preview && link.Countdown == 0 {
+			redirect(w, r, link.OriginalURL, link.RedirectStatus, maxAge, link.Targeted || link.Variant != "")
+			return
+		}
+
+		writePreview(w, link)
+	}
+}
+
+// variantCookieMaxAge is how long the visitors of a split URL keep their variant.
+const variantCookieMaxAge = 30 * 24 * time.Hour
+
+// variantCookie returns the name of the cookie with the variant of the split URL.
+func variantCookie(id string) string {
+	return "ab_" + id
+}
+
+// setVariantCookie keeps the variant of the split URL for the visitor in the cookie, which is sent
+// with the requests of the URL only. Nothing is set for the URLs that are not split.
+func setVariantCookie(w http.ResponseWriter, id, variant string) {
+	if variant == "" {
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     variantCookie(id),
+		Value:    variant,
+		Path:     "/" + id,
+		MaxAge:   int(variantCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// pathTail returns the escaped path after the identifier of the shortened URL.
+func pathTail(r *http.Request) string {
+	_, tail, _ := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	return tail
+}
+
+// redirect redirects to the URL with the status. The permanent redirects are cacheable for maxAge,
+// the temporary ones are not stored, so that every click reaches the service. The redirects of the
+// targeted and split URLs depend on the visitor by more than its headers, e.g. the country or the variant
+// cookie, so they are not stored by any cache whatever the status.
+func redirect(w http.ResponseWriter, r *http.Request, url string, status int, maxAge time.Duration, personal bool) {
+	permanent := status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect
+	if permanent && !personal {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+
+	http.Redirect(w, r, url, status)
+}
+
+// isInvalidLink reports whether the settings of a new URL are invalid.
+func isInvalidLink(err error) bool {
+	return errors.Is(err, usecase.ErrInvalidTag) || errors.Is(err, usecase.ErrTooManyTags) ||
+		errors.Is(err, usecase.ErrInvalidRedirectType) || errors.Is(err, usecase.ErrInvalidUTM) ||
+		errors.Is(err, usecase.ErrInvalidRule) || errors.Is(err, usecase.ErrTooManyRules) ||
+		errors.Is(err, usecase.ErrInvalidVariant) || errors.Is(err, usecase.ErrTooManyVariants) ||
+		errors.Is(err, usecase.ErrUnsafeURL)
+}
+
+// writeInvalidLink responds to the invalid settings of a URL with 400 Bad Request. The URLs rejected
+// by the URL policy are described with the code of the reason in the format:
+//
+//	{"code": "scheme_not_allowed", "error": "scheme \"javascript\" is not allowed"}.
+func writeInvalidLink(w http.ResponseWriter, err error) {
+	var policyErr *usecase.PolicyError
+	if !errors.As(err, &policyErr) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := json.Marshal(struct {
+		Code  string `json:"code"`
+		Error string `json:"error"`
+	}{Code: policyErr.Code, Error: policyErr.Error()})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(data)
+}
+
+// writeQuotaExceeded responds to the requests exceeding the quota of the user's active links
+// with 403 Forbidden in the format:
+//
+//	{"code": "quota_exceeded", "error": "quota of 100 active links exceeded, 100 in use", "quota": 100, "used": 100}.
+func writeQuotaExceeded(w http.ResponseWriter, err error) {
+	var quotaErr *usecase.QuotaError
+	if !errors.As(err, &quotaErr) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	data, err := json.Marshal(struct {
+		Code  string `json:"code"`
+		Error string `json:"error"`
+		Quota int    `json:"quota"`
+		Used  int    `json:"used"`
+	}{Code: usecase.CodeQuotaExceeded, Error: quotaErr.Error(), Quota: quotaErr.Quota, Used: quotaErr.Used})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write(data)
+}
+
+func writeFullURLError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, usecase.ErrDeletedURL) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+
+	if !errors.Is(err, usecase.ErrNotFoundURL) && !errors.Is(err, storage.ErrNotFoundURL) {
+		mw.Logger(r.Context()).Error("failed to get the original URL", slog.String("error", err.Error()))
+	}
+
+	http.Error(w, err.Error(), http.StatusNotFound)
+}
+
+// GetQRCode returns the QR code of the shortened URL at /{id}/qr as a PNG or SVG image. The query parameters
+// are optional: format (png or svg), size in pixels, level of error correction (L, M, Q or H),
+// margin in modules, fg and bg hex colors.
+// The image is cacheable for maxAge and revalidated by its ETag.
+func GetQRCode(m *usecase.Manager, maxAge time.Duration) http.HandlerFunc {
+	contentTypes := map[string]string{
+		usecase.QRFormatPNG: "image/png",
+		usecase.QRFormatSVG: "image/svg+xml",
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, err := qrParams(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		data, err := m.QRCode(r.Context(), chi.URLParam(r, "id"), params)
 		if err != nil {
-			if errors.Is(err, usecase.ErrDeletedURL) {
+			switch {
+			case errors.Is(err, usecase.ErrDeletedURL):
 				http.Error(w, err.Error(), http.StatusGone)
-				return
+			case errors.Is(err, usecase.ErrNotFoundURL):
+				http.Error(w, err.Error(), http.StatusNotFound)
+			case errors.Is(err, usecase.ErrInvalidQRFormat), errors.Is(err, usecase.ErrInvalidQRSize),
+				errors.Is(err, usecase.ErrInvalidQRMargin), errors.Is(err, qrcode.ErrTooSmall):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
 			}
+			return
+		}
 
-			http.Error(w, err.Error(), http.StatusNotFound)
+		sum := sha256.Sum256(data)
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+
+		if etagMatch(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
 			return
 		}
 
-		http.Redirect(w, r, originalURL, http.StatusTemporaryRedirect)
+		w.Header().Set("Content-Type", contentTypes[params.Format])
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+func qrParams(r *http.Request) (usecase.QRParams, error) {
+	query := r.URL.Query()
+	params := usecase.DefaultQRParams()
+
+	if v := query.Get("format"); v != "" {
+		params.Format = strings.ToLower(v)
+	}
+
+	if v := query.Get("size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil {
+			return usecase.QRParams{}, usecase.ErrInvalidQRSize
+		}
+		params.Size = size
+	}
+
+	if v := query.Get("margin"); v != "" {
+		margin, err := strconv.Atoi(v)
+		if err != nil {
+			return usecase.QRParams{}, usecase.ErrInvalidQRMargin
+		}
+		params.Margin = margin
+	}
+
+	var err error
+	if v := query.Get("level"); v != "" {
+		if params.Level, err = qrcode.ParseLevel(v); err != nil {
+			return usecase.QRParams{}, err
+		}
+	}
+
+	if v := query.Get("fg"); v != "" {
+		if params.Foreground, err = qrcode.ParseColor(v); err != nil {
+			return usecase.QRParams{}, fmt.Errorf("fg: %w", err)
+		}
+	}
+
+	if v := query.Get("bg"); v != "" {
+		if params.Background, err = qrcode.ParseColor(v); err != nil {
+			return usecase.QRParams{}, fmt.Errorf("bg: %w", err)
+		}
+	}
+
+	return params, nil
+}
+
+// etagMatch reports whether the If-None-Match header matches the ETag, weak tags match as well.
+func etagMatch(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
 	}
+
+	return false
 }
 
-// GetShortByFullURL accepts a JSON object in the request body
+// GetShortByFullURL accepts a JSON object in the request body, the fields other than the URL are optional,
 //
-//	{"url":"<original_url>"}
+//	{"url":"<original_url>","tags":["<tag>",...],"redirect_type":301,"passthrough":true,"utm":{"source":"<source>",...},
+//	 "rules":[{"os":"ios","url":"<destination>"},...],"variants":[{"url":"<destination>","weight":70},...]}
 //
 // and returning an object
 //
 //	{"result":"<shorten_url>"}.
+//
+// The UTM components source, medium, campaign, term and content are added to the query of the URL
+// in this order before it is shortened, the result is the same for the same URL with the same UTM.
+// The targeting rules are checked in order on every redirect, the first rule matching all its conditions
+// on the OS, the device class, bot or human and the preferred language of the client selects the destination,
+// the URL is the fallback. The variants split the visitors by weight between several destinations
+// instead of the URL, see GetFullURL.
 func GetShortByFullURL(m *usecase.Manager) http.HandlerFunc {
 	type request struct {
-		URL string `json:"url"`
+		URL          string            `json:"url"`
+		Tags         []string          `json:"tags"`
+		RedirectType int               `json:"redirect_type"`
+		Passthrough  bool              `json:"passthrough"`
+		UTM          storage.UTM       `json:"utm"`
+		Rules        []storage.Rule    `json:"rules"`
+		Variants     []storage.Variant `json:"variants"`
 	}
 
 	type response struct {
@@ -239,13 +596,32 @@ func GetShortByFullURL(m *usecase.Manager) http.HandlerFunc {
 			w.Write(data)
 		}
 
-		shortURL, err := m.CreateShortURL(r.Context(), req.URL, c.Value)
+		opts := usecase.LinkOptions{
+			Tags:         req.Tags,
+			RedirectType: req.RedirectType,
+			Passthrough:  req.Passthrough,
+			UTM:          req.UTM,
+			Rules:        req.Rules,
+			Variants:     req.Variants,
+		}
+
+		shortURL, err := m.CreateShortURL(r.Context(), req.URL, c.Value, opts)
 		if err != nil {
 			if errors.Is(err, usecase.ErrUniqueValue) {
 				writeResponse(shortURL, http.StatusConflict)
 				return
 			}
 
+			if isInvalidLink(err) {
+				writeInvalidLink(w, err)
+				return
+			}
+
+			if errors.Is(err, usecase.ErrQuotaExceeded) {
+				writeQuotaExceeded(w, err)
+				return
+			}
+
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -254,41 +630,60 @@ func GetShortByFullURL(m *usecase.Manager) http.HandlerFunc {
 	}
 }
 
-// GetUserURLs returns all URLs shortened by the user in the format:
+// GetUserURLs returns the URLs shortened by the user, including the deleted ones,
+// ordered by the time of creation, in the format:
 //
 //	  [
 //		    {
 //		       "short_url": "http://...",
-//		       "original_url": "http://..."
+//		       "original_url": "http://...",
+//		       "deleted": true,
+//		       "created_at": "2006-01-02T15:04:05Z",
+//		       "tags": ["promo", "q3"]
 //		    },
 //		    ...
 //	  ].
+//
+// All URLs are returned unless the limit query parameter is set, then the cursor of the next page
+// is returned in the X-Next-Cursor header and passed back in the cursor parameter.
+// The URLs are filtered by the domain of the original URL and its subdomains, the deleted state,
+// the creation time range [created_from, created_to) in RFC 3339 or as dates, the substring q
+// of the original URL, the tag and the UTM components utm_source, utm_medium, utm_campaign, utm_term
+// and utm_content the URL was created with.
 func GetUserURLs(m *usecase.Manager) http.HandlerFunc {
-	type response struct {
-		ShortURL    string `json:"short_url"`
-		OriginalURL string `json:"original_url"`
-	}
-
 	return func(w http.ResponseWriter, r *http.Request) {
-		var resp []response
-
 		c, err := r.Cookie("id")
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		urls, err := m.GetUserURLs(r.Context(), c.Value)
-		if err != nil || len(urls) == 0 {
-			w.WriteHeader(http.StatusNoContent)
+		params, err := listParams(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		for k, v := range urls {
-			resp = append(resp, response{ShortURL: k, OriginalURL: v})
+		page, err := m.ListUserURLs(r.Context(), c.Value, params)
+		if errors.Is(err, usecase.ErrInvalidLimit) || errors.Is(err, usecase.ErrInvalidCursor) ||
+			errors.Is(err, usecase.ErrInvalidTag) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		data, err := json.Marshal(resp)
+		if page.NextCursor != "" {
+			w.Header().Set("X-Next-Cursor", page.NextCursor)
+		}
+
+		if len(page.URLs) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		data, err := json.Marshal(page.URLs)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -300,24 +695,90 @@ func GetUserURLs(m *usecase.Manager) http.HandlerFunc {
 	}
 }
 
-// CheckConnDB checks the connection to the database.
-func CheckConnDB(m *usecase.Manager) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if err := m.CheckStorage(r.Context()); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+func listParams(r *http.Request) (usecase.ListParams, error) {
+	query := r.URL.Query()
+
+	params := usecase.ListParams{
+		Cursor: query.Get("cursor"),
+		Domain: query.Get("domain"),
+		Search: query.Get("q"),
+		Tag:    query.Get("tag"),
+		UTM: storage.UTM{
+			Source:   query.Get("utm_source"),
+			Medium:   query.Get("utm_medium"),
+			Campaign: query.Get("utm_campaign"),
+			Term:     query.Get("utm_term"),
+			Content:  query.Get("utm_content"),
+		},
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return usecase.ListParams{}, usecase.ErrInvalidLimit
 		}
+		params.Limit = limit
+	}
 
-		w.WriteHeader(http.StatusOK)
+	if v := query.Get("deleted"); v != "" {
+		deleted, err := strconv.ParseBool(v)
+		if err != nil {
+			return usecase.ListParams{}, errors.New("deleted must be a boolean")
+		}
+		params.Deleted = &deleted
+	}
+
+	var err error
+	if params.CreatedFrom, err = parseTimeParam(query.Get("created_from")); err != nil {
+		return usecase.ListParams{}, fmt.Errorf("created_from: %w", err)
+	}
+
+	if params.CreatedTo, err = parseTimeParam(query.Get("created_to")); err != nil {
+		return usecase.ListParams{}, fmt.Errorf("created_to: %w", err)
 	}
+
+	return params, nil
 }
 
-// DeleteURLsByUser accepts a list of shortened URL identifiers to delete in the format:
+// parseTimeParam parses the time in RFC 3339 or the date in UTC, the empty string is the zero time.
+func parseTimeParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, errors.New("must be RFC 3339 time or date")
+	}
+
+	return t, nil
+}
+
+// UpdateUserURL replaces the tags, the redirect type, the passthrough, the targeting rules or the variants
+// of the user's URL, the request body is:
 //
-//	[ "a", "b", "c", "d", ...].
-func DeleteURLsByUser(m *usecase.Manager) http.HandlerFunc {
+//	{"tags": ["promo", "q3"], "redirect_type": 301, "passthrough": true, "rules": [{"os": "ios", "url": "https://..."}],
+//	 "variants": [{"url": "https://...", "weight": 70}, {"url": "https://...", "weight": 30}]}.
+//
+// The omitted fields are not changed, the redirect type 0 resets the URL to the default of the service,
+// the empty rules remove the targeting and the empty variants remove the split. Setting the variants
+// resets their clicks.
+// The response contains the updated URL in the format of GetUserURLs.
+func UpdateUserURL(m *usecase.Manager) http.HandlerFunc {
+	type request struct {
+		Tags         *[]string          `json:"tags"`
+		RedirectType *int               `json:"redirect_type"`
+		Passthrough  *bool              `json:"passthrough"`
+		Rules        *[]storage.Rule    `json:"rules"`
+		Variants     *[]storage.Variant `json:"variants"`
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req []string
+		var req request
 
 		if r.Header.Get("Content-Type") != "application/json" {
 			http.Error(w, "request must be json-format", http.StatusBadRequest)
@@ -341,8 +802,758 @@ func DeleteURLsByUser(m *usecase.Manager) http.HandlerFunc {
 			return
 		}
 
-		go m.ExecDeleting(req, c.Value)
+		update := usecase.URLUpdate{
+			Tags:         req.Tags,
+			RedirectType: req.RedirectType,
+			Passthrough:  req.Passthrough,
+			Rules:        req.Rules,
+			Variants:     req.Variants,
+		}
 
-		w.WriteHeader(http.StatusAccepted)
+		link, err := m.UpdateURL(r.Context(), c.Value, chi.URLParam(r, "id"), update)
+		switch {
+		case isInvalidLink(err), errors.Is(err, usecase.ErrEmptyUpdate):
+			writeInvalidLink(w, err)
+			return
+		case errors.Is(err, usecase.ErrNotFoundURL):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data, err := json.Marshal(link)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+// GetVariants lists the variants of the user's split URL with the number of redirects to each
+// since the variants were set, in the format:
+//
+//	[
+//	    {"key": "1c9f3e2a", "url": "https://...", "weight": 70, "clicks": 512},
+//	    ...
+//	].
+//
+// The key is the value of the cookie of the visitors assigned to the variant. The list is empty
+// if the URL is not split.
+func GetVariants(m *usecase.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		variants, err := m.GetVariants(r.Context(), c.Value, chi.URLParam(r, "id"))
+		if err != nil {
+			if errors.Is(err, usecase.ErrNotFoundURL) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data, err := json.Marshal(variants)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
 	}
 }
+
+// GetTags lists the user's tags with the number of URLs, ordered by name, in the format:
+//
+//	[
+//	    {"name": "promo", "urls": 3},
+//	    ...
+//	].
+func GetTags(m *usecase.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		tags, err := m.GetTags(r.Context(), c.Value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if len(tags) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		data, err := json.Marshal(tags)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+// RenameTag renames the user's tag given in the URL path, the request body is:
+//
+//	{"name": "<new name>"}.
+//
+// If the user already has the tag of the new name, the tags are merged. The response contains
+// the new name and the number of changed URLs:
+//
+//	{"name": "<new name>", "updated": 3}.
+func RenameTag(m *usecase.Manager) http.HandlerFunc {
+	type request struct {
+		Name string `json:"name"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req request
+
+		if r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "request must be json-format", http.StatusBadRequest)
+			return
+		}
+
+		body, err := unzipBody(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		c, err := r.Cookie("id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		name, err := url.PathUnescape(chi.URLParam(r, "name"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rst, err := m.RenameTag(r.Context(), c.Value, name, req.Name)
+		writeTagUpdate(w, rst, err)
+	}
+}
+
+// DeleteTag removes the user's tag given in the URL path from all URLs. The response contains
+// the name and the number of changed URLs:
+//
+//	{"name": "<name>", "updated": 3}.
+func DeleteTag(m *usecase.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		name, err := url.PathUnescape(chi.URLParam(r, "name"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rst, err := m.DeleteTag(r.Context(), c.Value, name)
+		writeTagUpdate(w, rst, err)
+	}
+}
+
+func writeTagUpdate(w http.ResponseWriter, rst usecase.TagUpdate, err error) {
+	switch {
+	case errors.Is(err, usecase.ErrInvalidTag):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, usecase.ErrNotFoundTag):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(rst)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// CheckConnDB checks the connection to the database.
+func CheckConnDB(m *usecase.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := m.CheckStorage(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// Liveness reports that the process is able to respond to requests.
+func Liveness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status":"ok"}`))
+	}
+}
+
+// Readiness reports whether the service is ready to accept requests.
+// The response contains the status of each dependency in the format:
+//
+//	{
+//	   "status": "ok",
+//	   "checks": {
+//	      "storage": {"status": "ok"},
+//	      "delete_queue": {"status": "fail", "error": "..."},
+//	      ...
+//	   }
+//	}.
+func Readiness(m *usecase.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rst := m.Readiness(r.Context())
+
+		data, err := json.Marshal(rst)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		statusCode := http.StatusOK
+		if !rst.Ready() {
+			statusCode = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		w.Write(data)
+	}
+}
+
+// DeleteURLsByUser accepts a list of shortened URL identifiers to delete in the format:
+//
+//	[ "a", "b", "c", "d", ...].
+//
+// The deletion jobs are queued before the response and performed in the background.
+// The response contains the identifier of the operation and its address in the Location header:
+//
+//	{"id": "<operation identifier>", "status": "pending"}.
+func DeleteURLsByUser(m *usecase.Manager) http.HandlerFunc {
+	type response struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req []string
+
+		if r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "request must be json-format", http.StatusBadRequest)
+			return
+		}
+
+		body, err := unzipBody(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		c, err := r.Cookie("id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Queueing must not be interrupted if the client goes away, so only the trace is carried over.
+		ctx := trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(r.Context()))
+		opID, err := m.ScheduleDeleting(ctx, req, c.Value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data, err := json.Marshal(response{ID: opID, Status: storage.OperationPending})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/api/user/operations/"+opID)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(data)
+	}
+}
+
+// RestoreURLs accepts a list of identifiers of the user's deleted URLs to restore in the format:
+//
+//	[ "a", "b", "c", "d", ...].
+//
+// Only URLs deleted within the restore period can be restored, the response lists the identifiers
+// of the restored URLs and of the rest:
+//
+//	{"restored": ["a", "b"], "skipped": ["c", "d"]}.
+func RestoreURLs(m *usecase.Manager) http.HandlerFunc {
+	type response struct {
+		Restored []string `json:"restored"`
+		Skipped  []string `json:"skipped"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req []string
+
+		if r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "request must be json-format", http.StatusBadRequest)
+			return
+		}
+
+		body, err := unzipBody(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		c, err := r.Cookie("id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		restored, skipped, err := m.RestoreURLs(r.Context(), req, c.Value)
+		if errors.Is(err, usecase.ErrQuotaExceeded) {
+			writeQuotaExceeded(w, err)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data, err := json.Marshal(response{Restored: restored, Skipped: skipped})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+// GetDeletedURLs lists the user's deleted URLs, the most recently deleted first, in the format:
+//
+//	[
+//	    {
+//	       "short_url": "http://...",
+//	       "original_url": "http://...",
+//	       "deleted_at": "2006-01-02T15:04:05Z",
+//	       "restorable": true
+//	    },
+//	    ...
+//	].
+//
+// The time of deletion is omitted if it is unknown.
+func GetDeletedURLs(m *usecase.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		urls, err := m.GetDeletedURLs(r.Context(), c.Value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if len(urls) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		data, err := json.Marshal(urls)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+// ExportUserURLs streams all URLs of the user, including the deleted ones, as a file to download.
+// The format is passed in the "format" query parameter: "json" (by default), "jsonl" or "csv".
+// The JSON format is:
+//
+//	[
+//	    {
+//	       "user_id": "...",
+//	       "short_url": "http://...",
+//	       "original_url": "http://...",
+//	       "deleted": true,
+//	       "deleted_at": "2006-01-02T15:04:05Z"
+//	    },
+//	    ...
+//	].
+//
+// The CSV file has the header user_id,short_url,original_url,deleted,deleted_at.
+func ExportUserURLs(m *usecase.Manager) http.HandlerFunc {
+	contentTypes := map[string]string{
+		dump.FormatJSON:  "application/json",
+		dump.FormatJSONL: "application/x-ndjson",
+		dump.FormatCSV:   "text/csv",
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = dump.FormatJSON
+		}
+
+		contentType, ok := contentTypes[format]
+		if !ok {
+			http.Error(w, "format must be json, jsonl or csv", http.StatusBadRequest)
+			return
+		}
+
+		c, err := r.Cookie("id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="urls.%s"`, format))
+		w.WriteHeader(http.StatusOK)
+
+		// The status is already sent, so an error can only be logged and the response is left incomplete.
+		if err := m.ExportUserURLs(r.Context(), c.Value, w, format); err != nil {
+			slog.Error("controller.ExportUserURLs", "err", err)
+		}
+	}
+}
+
+// GetDeleteOperation returns the state of the user's deletion operation in the format:
+//
+//	{
+//	   "id": "<operation identifier>",
+//	   "status": "pending|running|done|failed",
+//	   "requested": 4,
+//	   "pending": 0,
+//	   "running": 1,
+//	   "done": 2,
+//	   "failed": 0,
+//	   "skipped": ["d"],
+//	   "created_at": "2006-01-02T15:04:05Z"
+//	}.
+func GetDeleteOperation(m *usecase.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		op, err := m.GetDeleteOperation(r.Context(), chi.URLParam(r, "id"), c.Value)
+		if err != nil {
+			if errors.Is(err, usecase.ErrNotFoundOperation) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data, err := json.Marshal(op)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+// GetDeleteJobs lists deletion jobs for operators. The state is passed in the "state" query parameter
+// ("dead" by default), the maximum number of jobs in the "limit" parameter. The response format is:
+//
+//	[
+//	   {
+//	      "id": 1,
+//	      "user_id": "...",
+//	      "short_url": "http://...",
+//	      "state": "dead",
+//	      "attempts": 5,
+//	      "next_run_at": "2006-01-02T15:04:05Z",
+//	      "last_error": "...",
+//	      "created_at": "2006-01-02T15:04:05Z"
+//	   },
+//	   ...
+//	].
+func GetDeleteJobs(m *usecase.Manager) http.HandlerFunc {
+	const (
+		defaultLimit = 100
+		maxLimit     = 1000
+	)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		state := r.URL.Query().Get("state")
+		if state == "" {
+			state = "dead"
+		}
+
+		limit := defaultLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > maxLimit {
+				http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		jobs, err := m.DeleteJobs(r.Context(), state, limit)
+		if err != nil {
+			if errors.Is(err, usecase.ErrInvalidState) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data, err := json.Marshal(jobs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+// Purge permanently removes the URLs deleted before the retention period. With the "dry_run=true"
+// query parameter, the records are only counted. The response format is:
+//
+//	{"urls": 10, "orphans": 1, "dry_run": false}.
+func Purge(m *usecase.Manager) http.HandlerFunc {
+	type response struct {
+		storage.PurgeResult
+		DryRun bool `json:"dry_run"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var dryRun bool
+
+		if v := r.URL.Query().Get("dry_run"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, "dry_run must be a boolean", http.StatusBadRequest)
+				return
+			}
+			dryRun = b
+		}
+
+		rst, err := m.Purge(r.Context(), dryRun)
+		if err != nil {
+			if errors.Is(err, usecase.ErrPurgeDisabled) {
+				http.Error(w, err.Error(), http.StatusNotImplemented)
+				return
+			}
+
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data, err := json.Marshal(response{PurgeResult: rst, DryRun: dryRun})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+// QuarantineURL quarantines the URL, so the redirects to it show a warning page instead.
+// It accepts a JSON object with the type of the threat,
+//
+//	{"threat": "phishing"},
+//
+// and returns the quarantined URL in the format of GetUserURLs.
+func QuarantineURL(m *usecase.Manager) http.HandlerFunc {
+	type request struct {
+		Threat string `json:"threat"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req request
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		link, err := m.QuarantineURL(r.Context(), chi.URLParam(r, "id"), req.Threat)
+		writeQuarantine(w, link, err)
+	}
+}
+
+// ReleaseURL releases the quarantined URL, so it is redirected again, and returns the URL in the format
+// of GetUserURLs.
+func ReleaseURL(m *usecase.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		link, err := m.ReleaseURL(r.Context(), chi.URLParam(r, "id"))
+		writeQuarantine(w, link, err)
+	}
+}
+
+func writeQuarantine(w http.ResponseWriter, link usecase.UserURL, err error) {
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrInvalidThreat):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, usecase.ErrNotFoundURL):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	data, err := json.Marshal(link)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// GetQuota returns the quota of the user's active links, zero for no limit, in the format:
+//
+//	{"user_id": "...", "quota": 100, "used": 12, "override": false}.
+//
+// Override is set if the user has a quota of their own instead of the default.
+func GetQuota(m *usecase.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		quota, err := m.GetQuota(r.Context(), chi.URLParam(r, "id"))
+		writeQuota(w, quota, err)
+	}
+}
+
+// SetQuota sets the quota of the user's active links instead of the default. It accepts a JSON object
+//
+//	{"quota": 1000},
+//
+// zero for no limit, and returns the quota in the format of GetQuota.
+func SetQuota(m *usecase.Manager) http.HandlerFunc {
+	type request struct {
+		Quota *int `json:"quota"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req request
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.Quota == nil {
+			http.Error(w, "quota is required", http.StatusBadRequest)
+			return
+		}
+
+		quota, err := m.SetQuota(r.Context(), chi.URLParam(r, "id"), *req.Quota)
+		writeQuota(w, quota, err)
+	}
+}
+
+// DeleteQuota removes the quota of the user, so the default applies again, and returns the quota
+// in the format of GetQuota.
+func DeleteQuota(m *usecase.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		quota, err := m.DeleteQuota(r.Context(), chi.URLParam(r, "id"))
+		writeQuota(w, quota, err)
+	}
+}
+
+func writeQuota(w http.ResponseWriter, quota usecase.Quota, err error) {
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrInvalidQuota):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, usecase.ErrNotFoundQuota):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	data, err := json.Marshal(quota)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -1,19 +1,35 @@
 package controller
 
 import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"fmt"
 	"go-shortener-url/internal/pkg/deleteurl"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 
 	"go-shortener-url/internal/config"
+	"go-shortener-url/internal/pkg/domainlist"
+	"go-shortener-url/internal/pkg/purge"
 	"go-shortener-url/internal/pkg/sign"
+	"go-shortener-url/internal/pkg/urlcheck"
 	"go-shortener-url/internal/storage"
 	"go-shortener-url/internal/usecase"
 )
@@ -54,7 +70,7 @@ func TestCreateShortURL(t *testing.T) {
 	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080"}
 	store := storage.NewMemStorage()
 	manager := usecase.New(store, nil, cfg.BaseURL)
-	srv := New(manager)
+	srv := New(manager, cfg)
 	srv.Addr = cfg.ServerAddress
 	ts := httptest.NewServer(srv.Handler)
 	defer ts.Close()
@@ -106,17 +122,11 @@ func TestGetFullURL(t *testing.T) {
 	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080"}
 	store := storage.NewMemStorage()
 	manager := usecase.New(store, nil, cfg.BaseURL)
-	srv := New(manager)
+	srv := New(manager, cfg)
 	srv.Addr = cfg.ServerAddress
 	ts := httptest.NewServer(srv.Handler)
 	defer ts.Close()
 
-	client := &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
-	}
-
 	idUser := sign.UserID()
 
 	for _, tt := range tests {
@@ -124,7 +134,7 @@ func TestGetFullURL(t *testing.T) {
 			req, err := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader(tt.body))
 			req.Header.Set("Cookie", "id="+idUser)
 			require.NoError(t, err)
-			resp, err := client.Do(req)
+			resp, err := noRedirectClient.Do(req)
 			require.NoError(t, err)
 			err = resp.Body.Close()
 			require.NoError(t, err)
@@ -132,7 +142,7 @@ func TestGetFullURL(t *testing.T) {
 			req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s", ts.URL, tt.request), nil)
 			req.Header.Set("Cookie", "id="+idUser)
 			require.NoError(t, err)
-			resp, err = client.Do(req)
+			resp, err = noRedirectClient.Do(req)
 			require.NoError(t, err)
 			err = resp.Body.Close()
 			require.NoError(t, err)
@@ -221,7 +231,7 @@ func TestGetShortByFullURL(t *testing.T) {
 	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080"}
 	store := storage.NewMemStorage()
 	manager := usecase.New(store, nil, cfg.BaseURL)
-	srv := New(manager)
+	srv := New(manager, cfg)
 	srv.Addr = cfg.ServerAddress
 	ts := httptest.NewServer(srv.Handler)
 	defer ts.Close()
@@ -298,7 +308,7 @@ func TestGetUserURLs(t *testing.T) {
 	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080"}
 	store := storage.NewMemStorage()
 	manager := usecase.New(store, nil, cfg.BaseURL)
-	srv := New(manager)
+	srv := New(manager, cfg)
 	srv.Addr = cfg.ServerAddress
 	ts := httptest.NewServer(srv.Handler)
 	defer ts.Close()
@@ -318,6 +328,8 @@ func TestGetUserURLs(t *testing.T) {
 			require.NoError(t, err)
 			err = resp.Body.Close()
 			require.NoError(t, err)
+			// The time of creation differs between runs.
+			resBody = regexp.MustCompile(`,"created_at":"[^"]*"`).ReplaceAll(resBody, nil)
 			assert.Equal(t, string(resBody), tt.want.response)
 			if resp.StatusCode == http.StatusOK {
 				assert.Equal(t, resp.Header.Get(tt.want.header[0]), tt.want.header[1])
@@ -326,6 +338,98 @@ func TestGetUserURLs(t *testing.T) {
 	}
 }
 
+func TestListUserURLs(t *testing.T) {
+	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080"}
+	store := storage.NewMemStorage()
+	manager := usecase.New(store, nil, cfg.BaseURL)
+	ts := httptest.NewServer(New(manager, cfg).Handler)
+	defer ts.Close()
+
+	idUser := sign.UserID()
+	day := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, origURL := range []string{
+		"https://example.com/1", "https://go.dev/2", "https://blog.example.com/3", "https://example.org/4", "https://example.com/5",
+	} {
+		require.NoError(t, store.Put(context.Background(), storage.Link{
+			UserID:      idUser,
+			ShortURL:    fmt.Sprintf("http://localhost:8080/%d", i+1),
+			OriginalURL: origURL,
+			Deleted:     i == 4,
+			CreatedAt:   day.Add(time.Duration(i) * 24 * time.Hour),
+		}, false))
+	}
+
+	get := func(query string) (*http.Response, []usecase.UserURL) {
+		resp, body := doRequest(t, http.MethodGet, ts.URL+"/api/user/urls"+query, "", idUser, nil)
+
+		var urls []usecase.UserURL
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.Unmarshal([]byte(body), &urls))
+		}
+
+		return resp, urls
+	}
+
+	ids := func(urls []usecase.UserURL) []string {
+		rst := make([]string, 0, len(urls))
+		for _, v := range urls {
+			rst = append(rst, strings.TrimPrefix(v.ShortURL, "http://localhost:8080/"))
+		}
+		return rst
+	}
+
+	t.Run("pages", func(t *testing.T) {
+		var (
+			got    []string
+			cursor string
+		)
+
+		for pages := 0; pages < 10; pages++ {
+			resp, urls := get("?limit=2&cursor=" + cursor)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			got = append(got, ids(urls)...)
+
+			cursor = resp.Header.Get("X-Next-Cursor")
+			if cursor == "" {
+				break
+			}
+		}
+
+		assert.Equal(t, []string{"1", "2", "3", "4", "5"}, got)
+	})
+
+	tests := []struct {
+		name       string
+		query      string
+		statusCode int
+		want       []string
+	}{
+		{name: "all", query: "", statusCode: http.StatusOK, want: []string{"1", "2", "3", "4", "5"}},
+		{name: "domain", query: "?domain=example.com", statusCode: http.StatusOK, want: []string{"1", "3", "5"}},
+		{name: "active", query: "?domain=example.com&deleted=false", statusCode: http.StatusOK, want: []string{"1", "3"}},
+		{name: "created range", query: "?created_from=2023-10-02&created_to=2023-10-04T00:00:00Z", statusCode: http.StatusOK,
+			want: []string{"2", "3"}},
+		{name: "search", query: "?q=GO.DEV", statusCode: http.StatusOK, want: []string{"2"}},
+		{name: "nothing found", query: "?q=nothing", statusCode: http.StatusNoContent, want: []string{}},
+		{name: "bad limit", query: "?limit=1001", statusCode: http.StatusBadRequest},
+		{name: "bad cursor", query: "?limit=1&cursor=abc", statusCode: http.StatusBadRequest},
+		{name: "bad deleted", query: "?deleted=maybe", statusCode: http.StatusBadRequest},
+		{name: "bad date", query: "?created_from=yesterday", statusCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, urls := get(tt.query)
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+
+			if tt.want != nil {
+				assert.Equal(t, tt.want, ids(urls))
+			}
+		})
+	}
+}
+
 func TestCreateManyShortURL(t *testing.T) {
 	type want struct {
 		response   string
@@ -404,7 +508,7 @@ func TestCreateManyShortURL(t *testing.T) {
 	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080"}
 	store := storage.NewMemStorage()
 	manager := usecase.New(store, nil, cfg.BaseURL)
-	srv := New(manager)
+	srv := New(manager, cfg)
 	srv.Addr = cfg.ServerAddress
 	ts := httptest.NewServer(srv.Handler)
 	defer ts.Close()
@@ -470,12 +574,12 @@ func TestDeleteURLsByUser(t *testing.T) {
 	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080"}
 	store := storage.NewMemStorage()
 
-	deleter := deleteurl.InitUrlDeleteService(store)
+	deleter := deleteurl.InitUrlDeleteService(store, nil, deleteurl.Config{})
 	deleter.Run(1)
 	defer deleter.Stop()
 
 	manager := usecase.New(store, deleter, cfg.BaseURL)
-	srv := New(manager)
+	srv := New(manager, cfg)
 	srv.Addr = cfg.ServerAddress
 	ts := httptest.NewServer(srv.Handler)
 	defer ts.Close()
@@ -500,3 +604,1722 @@ func TestDeleteURLsByUser(t *testing.T) {
 		})
 	}
 }
+
+var (
+	spanRecorder    = tracetest.NewSpanRecorder()
+	initTracingOnce sync.Once
+)
+
+func TestTracing(t *testing.T) {
+	// Tracers of the packages are bound to the first global provider, so it is set only once.
+	initTracingOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+
+	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080"}
+	store := storage.WithTracing(storage.NewMemStorage())
+	manager := usecase.New(store, nil, cfg.BaseURL)
+	srv := New(manager, cfg)
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/123", nil)
+	require.NoError(t, err)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	req.Header.Set("Cookie", "id="+sign.UserID())
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	names := make(map[string]bool)
+	for _, s := range spanRecorder.Ended() {
+		if s.SpanContext().TraceID().String() == traceID {
+			names[s.Name()] = true
+		}
+	}
+
+	assert.True(t, names["GET /{id}"])
+	assert.True(t, names["Manager.GetFullURL"])
+	assert.True(t, names["storage.Get"])
+}
+
+func TestHealthProbes(t *testing.T) {
+	type want struct {
+		response   string
+		statusCode int
+	}
+
+	tests := []struct {
+		name         string
+		url          string
+		shuttingDown bool
+		want         want
+	}{
+		{
+			name: "liveness",
+			url:  "/healthz",
+			want: want{statusCode: http.StatusOK, response: `{"status":"ok"}`},
+		},
+		{
+			name: "readiness",
+			url:  "/readyz",
+			want: want{
+				statusCode: http.StatusOK,
+				response: `{"status":"ok","checks":{"delete_queue":{"status":"ok"},"delete_workers":{"status":"ok"},` +
+					`"shutdown":{"status":"ok"},"storage":{"status":"ok"}}}`,
+			},
+		},
+		{
+			name:         "readiness during shutdown",
+			url:          "/readyz",
+			shuttingDown: true,
+			want: want{
+				statusCode: http.StatusServiceUnavailable,
+				response: `{"status":"fail","checks":{"delete_queue":{"status":"ok"},"delete_workers":{"status":"ok"},` +
+					`"shutdown":{"status":"fail","error":"service is shutting down"},"storage":{"status":"ok"}}}`,
+			},
+		},
+		{
+			name:         "liveness during shutdown",
+			url:          "/healthz",
+			shuttingDown: true,
+			want:         want{statusCode: http.StatusOK, response: `{"status":"ok"}`},
+		},
+	}
+
+	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080"}
+	store := storage.NewMemStorage()
+
+	deleter := deleteurl.InitUrlDeleteService(store, nil, deleteurl.Config{})
+	deleter.Run(1)
+	defer deleter.Stop()
+
+	manager := usecase.New(store, deleter, cfg.BaseURL)
+	srv := New(manager, cfg)
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.shuttingDown {
+				manager.SetShuttingDown()
+			}
+
+			resp, err := http.Get(ts.URL + tt.url)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.statusCode, resp.StatusCode)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+			resBody, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			err = resp.Body.Close()
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want.response, string(resBody))
+		})
+	}
+}
+
+func TestGetDeleteJobs(t *testing.T) {
+	type want struct {
+		response   string
+		statusCode int
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		query string
+		auth  string
+		want  want
+	}{
+		{
+			name:  "admin API is disabled",
+			token: "",
+			auth:  "Bearer ",
+			want:  want{statusCode: http.StatusNotFound, response: "404 page not found"},
+		},
+		{
+			name:  "wrong token",
+			token: "secret",
+			auth:  "Bearer wrong",
+			want:  want{statusCode: http.StatusUnauthorized, response: "Unauthorized"},
+		},
+		{
+			name:  "positive test",
+			token: "secret",
+			auth:  "Bearer secret",
+			want:  want{statusCode: http.StatusOK, response: "[]"},
+		},
+		{
+			name:  "negative test unknown state",
+			token: "secret",
+			auth:  "Bearer secret",
+			query: "?state=unknown",
+			want:  want{statusCode: http.StatusBadRequest, response: usecase.ErrInvalidState.Error()},
+		},
+	}
+
+	store := storage.NewMemStorage()
+
+	deleter := deleteurl.InitUrlDeleteService(store, nil, deleteurl.Config{})
+	deleter.Run(1)
+	defer deleter.Stop()
+
+	manager := usecase.New(store, deleter, "http://localhost:8080")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{BaseURL: "http://localhost:8080", AdminToken: tt.token}
+			ts := httptest.NewServer(New(manager, cfg).Handler)
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/admin/delete-jobs"+tt.query, nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", tt.auth)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.statusCode, resp.StatusCode)
+
+			resBody, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			err = resp.Body.Close()
+			require.NoError(t, err)
+			assert.Contains(t, string(resBody), tt.want.response)
+		})
+	}
+}
+
+func TestGetDeleteOperation(t *testing.T) {
+	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080"}
+	store := storage.NewMemStorage()
+
+	deleter := deleteurl.InitUrlDeleteService(store, nil, deleteurl.Config{PollInterval: time.Millisecond})
+	deleter.Run(1)
+	defer deleter.Stop()
+
+	manager := usecase.New(store, deleter, cfg.BaseURL)
+	ts := httptest.NewServer(New(manager, cfg).Handler)
+	defer ts.Close()
+
+	idUser := sign.UserID()
+
+	resp, shortURL := doRequest(t, http.MethodPost, ts.URL, "http://example.com/operation", idUser, nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	id := strings.TrimPrefix(shortURL, cfg.BaseURL+"/")
+
+	resp, body := doRequest(t, http.MethodDelete, ts.URL+"/api/user/urls",
+		fmt.Sprintf(`["%s","foreign"]`, id), idUser, nil)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	location := resp.Header.Get("Location")
+	require.True(t, strings.HasPrefix(location, "/api/user/operations/"))
+	assert.JSONEq(t, fmt.Sprintf(`{"id":"%s","status":"pending"}`, strings.TrimPrefix(location, "/api/user/operations/")), body)
+
+	require.Eventually(t, func() bool {
+		resp, body = doRequest(t, http.MethodGet, ts.URL+location, "", idUser, nil)
+		return resp.StatusCode == http.StatusOK && strings.Contains(body, `"status":"done"`)
+	}, time.Second, time.Millisecond)
+
+	var op struct {
+		Requested int      `json:"requested"`
+		Pending   int      `json:"pending"`
+		Running   int      `json:"running"`
+		Done      int      `json:"done"`
+		Failed    int      `json:"failed"`
+		Skipped   []string `json:"skipped"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &op))
+	assert.Equal(t, 2, op.Requested)
+	assert.Equal(t, 1, op.Done)
+	assert.Equal(t, 0, op.Pending+op.Running+op.Failed)
+	assert.Equal(t, []string{"foreign"}, op.Skipped)
+
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+location, "", sign.UserID(), nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/api/user/operations/unknown", "", idUser, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestRestoreURLs(t *testing.T) {
+	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080"}
+	store := storage.NewMemStorage()
+
+	manager := usecase.New(store, deleteurl.InitUrlDeleteService(store, nil, deleteurl.Config{}), cfg.BaseURL)
+	ts := httptest.NewServer(New(manager, cfg).Handler)
+	defer ts.Close()
+
+	ctx := context.Background()
+	idUser := sign.UserID()
+
+	for _, id := range []string{"a", "b"} {
+		require.NoError(t, store.Put(ctx,
+			storage.Link{UserID: idUser, ShortURL: cfg.BaseURL + "/" + id, OriginalURL: "http://example.com/" + id}, false))
+	}
+	require.NoError(t, store.DeleteBatch(ctx, idUser, []string{cfg.BaseURL + "/a", cfg.BaseURL + "/b"}))
+
+	type deletedURL struct {
+		ShortURL   string `json:"short_url"`
+		Restorable bool   `json:"restorable"`
+	}
+
+	deleted := func() []deletedURL {
+		resp, body := doRequest(t, http.MethodGet, ts.URL+"/api/user/urls/deleted", "", idUser, nil)
+		if resp.StatusCode == http.StatusNoContent {
+			return nil
+		}
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var rst []deletedURL
+		require.NoError(t, json.Unmarshal([]byte(body), &rst))
+		return rst
+	}
+
+	assert.ElementsMatch(t, []deletedURL{
+		{ShortURL: cfg.BaseURL + "/a", Restorable: true},
+		{ShortURL: cfg.BaseURL + "/b", Restorable: true},
+	}, deleted())
+
+	resp, _ := doRequest(t, http.MethodGet, ts.URL+"/api/user/urls/deleted", "", sign.UserID(), nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, body := doRequest(t, http.MethodPost, ts.URL+"/api/user/urls/restore", `["a"]`, sign.UserID(), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"restored":[],"skipped":["a"]}`, body)
+
+	resp, body = doRequest(t, http.MethodPost, ts.URL+"/api/user/urls/restore", `["a","unknown"]`, idUser, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"restored":["a"],"skipped":["unknown"]}`, body)
+
+	link, err := store.Get(ctx, cfg.BaseURL+"/a")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/a", link.OriginalURL)
+
+	// The URL is not restored after the restore period.
+	manager.SetRestorePeriod(0)
+
+	resp, body = doRequest(t, http.MethodPost, ts.URL+"/api/user/urls/restore", `["b"]`, idUser, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"restored":[],"skipped":["b"]}`, body)
+	assert.Equal(t, []deletedURL{{ShortURL: cfg.BaseURL + "/b", Restorable: false}}, deleted())
+}
+
+func TestTags(t *testing.T) {
+	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080"}
+	store := storage.NewMemStorage()
+
+	manager := usecase.New(store, deleteurl.InitUrlDeleteService(store, nil, deleteurl.Config{}), cfg.BaseURL)
+	ts := httptest.NewServer(New(manager, cfg).Handler)
+	defer ts.Close()
+
+	idUser := sign.UserID()
+
+	shorten := func(origURL, tags string) string {
+		resp, body := doRequest(t, http.MethodPost, ts.URL+"/api/shorten",
+			`{"url":"`+origURL+`","tags":`+tags+`}`, idUser, nil)
+		require.Equal(t, http.StatusCreated, resp.StatusCode, body)
+
+		var rst struct {
+			Result string `json:"result"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(body), &rst))
+		return rst.Result[strings.LastIndex(rst.Result, "/")+1:]
+	}
+
+	a := shorten("http://example.com/a", `[" Promo ","q3"]`)
+	b := shorten("http://example.com/b", `[]`)
+
+	resp, body := doRequest(t, http.MethodPost, ts.URL+"/api/shorten",
+		`{"url":"http://example.com/c","tags":["a,b"]}`, idUser, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+
+	resp, body = doRequest(t, http.MethodPatch, ts.URL+"/api/user/urls/"+b, `{"tags":["q3","launch"]}`, idUser, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	var updated usecase.UserURL
+	require.NoError(t, json.Unmarshal([]byte(body), &updated))
+	assert.Equal(t, cfg.BaseURL+"/"+b, updated.ShortURL)
+	assert.Equal(t, []string{"launch", "q3"}, updated.Tags)
+
+	resp, _ = doRequest(t, http.MethodPatch, ts.URL+"/api/user/urls/"+b, `{"tags":["q3"]}`, sign.UserID(), nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = doRequest(t, http.MethodPatch, ts.URL+"/api/user/urls/"+b, `{}`, idUser, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, body = doRequest(t, http.MethodGet, ts.URL+"/api/user/tags", "", idUser, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `[{"name":"launch","urls":1},{"name":"promo","urls":1},{"name":"q3","urls":2}]`, body)
+
+	listed := func(tag string) []string {
+		resp, body := doRequest(t, http.MethodGet, ts.URL+"/api/user/urls?tag="+tag, "", idUser, nil)
+		if resp.StatusCode == http.StatusNoContent {
+			return nil
+		}
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var rst []usecase.UserURL
+		require.NoError(t, json.Unmarshal([]byte(body), &rst))
+
+		ids := make([]string, 0, len(rst))
+		for _, v := range rst {
+			ids = append(ids, v.ShortURL[strings.LastIndex(v.ShortURL, "/")+1:])
+		}
+		return ids
+	}
+
+	assert.ElementsMatch(t, []string{a, b}, listed("Q3"))
+	assert.Equal(t, []string{a}, listed("promo"))
+
+	resp, body = doRequest(t, http.MethodPatch, ts.URL+"/api/user/tags/promo", `{"name":"Launch"}`, idUser, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"name":"launch","updated":1}`, body)
+	assert.ElementsMatch(t, []string{a, b}, listed("launch"))
+
+	resp, _ = doRequest(t, http.MethodPatch, ts.URL+"/api/user/tags/promo", `{"name":"x"}`, idUser, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, body = doRequest(t, http.MethodDelete, ts.URL+"/api/user/tags/q3", "", idUser, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"name":"q3","updated":2}`, body)
+	assert.Empty(t, listed("q3"))
+
+	resp, _ = doRequest(t, http.MethodDelete, ts.URL+"/api/user/tags/q3", "", idUser, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/api/user/tags", "", sign.UserID(), nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func TestPurge(t *testing.T) {
+	type want struct {
+		response   string
+		statusCode int
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		purger bool
+		want   want
+	}{
+		{
+			name:  "purge is disabled",
+			query: "?dry_run=true",
+			want:  want{statusCode: http.StatusNotImplemented, response: usecase.ErrPurgeDisabled.Error()},
+		},
+		{
+			name:   "dry run",
+			query:  "?dry_run=true",
+			purger: true,
+			want:   want{statusCode: http.StatusOK, response: `{"urls":1,"orphans":0,"dry_run":true}`},
+		},
+		{
+			name:   "negative test invalid dry run",
+			query:  "?dry_run=maybe",
+			purger: true,
+			want:   want{statusCode: http.StatusBadRequest, response: "dry_run must be a boolean"},
+		},
+		{
+			name:   "positive test",
+			purger: true,
+			want:   want{statusCode: http.StatusOK, response: `{"urls":1,"orphans":0,"dry_run":false}`},
+		},
+	}
+
+	ctx := context.Background()
+	cfg := &config.Config{BaseURL: "http://localhost:8080", AdminToken: "secret"}
+
+	store := storage.NewMemStorage()
+	require.NoError(t, store.Put(ctx,
+		storage.Link{UserID: "1", ShortURL: cfg.BaseURL + "/a", OriginalURL: "http://example.com/a"}, false))
+	require.NoError(t, store.DeleteBatch(ctx, "1", []string{cfg.BaseURL + "/a"}))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := usecase.New(store, deleteurl.InitUrlDeleteService(store, nil, deleteurl.Config{}), cfg.BaseURL)
+			if tt.purger {
+				manager.SetPurger(purge.New(store, purge.Config{Retention: time.Nanosecond}))
+			}
+
+			ts := httptest.NewServer(New(manager, cfg).Handler)
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/admin/purge"+tt.query, nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer secret")
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.statusCode, resp.StatusCode)
+
+			resBody, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Contains(t, string(resBody), tt.want.response)
+		})
+	}
+
+	_, err := store.Get(ctx, cfg.BaseURL+"/a")
+	assert.ErrorIs(t, err, storage.ErrNotFoundURL)
+}
+
+func TestExportUserURLs(t *testing.T) {
+	type want struct {
+		contentType string
+		response    string
+		statusCode  int
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		gzip  bool
+		want  want
+	}{
+		{
+			name: "positive test json",
+			want: want{
+				statusCode:  http.StatusOK,
+				contentType: "application/json",
+				response: `[
+{"user_id":"%[1]s","short_url":"http://localhost:8080/a","original_url":"http://example.com/a","deleted":false,"created_at":"2023-09-01T12:00:00Z","updated_at":"2023-09-01T12:00:00Z","title":"Example","tags":["promo","q3"]},
+{"user_id":"%[1]s","short_url":"http://localhost:8080/b","original_url":"http://example.com/b","deleted":true,"deleted_at":"2023-10-01T12:00:00Z","created_at":"2023-09-01T12:00:00Z","updated_at":"2023-10-01T12:00:00Z"}
+]
+`,
+			},
+		},
+		{
+			name:  "positive test csv with gzip",
+			query: "?format=csv",
+			gzip:  true,
+			want: want{
+				statusCode:  http.StatusOK,
+				contentType: "text/csv",
+				response: `user_id,short_url,original_url,deleted,deleted_at,created_at,updated_at,title,notes,tags,redirect_type,passthrough,utm_source,utm_medium,utm_campaign,utm_term,utm_content,rules,variants,threat
+%[1]s,http://localhost:8080/a,http://example.com/a,false,,2023-09-01T12:00:00Z,2023-09-01T12:00:00Z,Example,,"promo,q3",,false,,,,,,,,
+%[1]s,http://localhost:8080/b,http://example.com/b,true,2023-10-01T12:00:00Z,2023-09-01T12:00:00Z,2023-10-01T12:00:00Z,,,,,false,,,,,,,,
+`,
+			},
+		},
+		{
+			name:  "negative test unknown format",
+			query: "?format=xml",
+			want: want{
+				statusCode:  http.StatusBadRequest,
+				contentType: "text/plain; charset=utf-8",
+				response:    "format must be json, jsonl or csv\n",
+			},
+		},
+	}
+
+	ctx := context.Background()
+	cfg := &config.Config{BaseURL: "http://localhost:8080"}
+	idUser := sign.UserID()
+	createdAt := time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC)
+	deletedAt := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	store := storage.NewMemStorage()
+	require.NoError(t, store.Put(ctx, storage.Link{
+		UserID: idUser, ShortURL: cfg.BaseURL + "/a", OriginalURL: "http://example.com/a",
+		CreatedAt: createdAt, UpdatedAt: createdAt, Title: "Example", Tags: []string{"q3", "promo"},
+	}, false))
+	require.NoError(t, store.Put(ctx, storage.Link{
+		UserID: idUser, ShortURL: cfg.BaseURL + "/b", OriginalURL: "http://example.com/b",
+		Deleted: true, DeletedAt: deletedAt, CreatedAt: createdAt, UpdatedAt: deletedAt,
+	}, false))
+	require.NoError(t, store.Put(ctx,
+		storage.Link{UserID: sign.UserID(), ShortURL: cfg.BaseURL + "/c", OriginalURL: "http://example.com/c"}, false))
+
+	manager := usecase.New(store, deleteurl.InitUrlDeleteService(store, nil, deleteurl.Config{}), cfg.BaseURL)
+	ts := httptest.NewServer(New(manager, cfg).Handler)
+	defer ts.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/user/export"+tt.query, nil)
+			require.NoError(t, err)
+			req.Header.Set("Cookie", "id="+idUser)
+			if tt.gzip {
+				// Setting the header disables the transparent decompression of the client.
+				req.Header.Set("Accept-Encoding", "gzip")
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.want.statusCode, resp.StatusCode)
+			assert.Equal(t, tt.want.contentType, resp.Header.Get("Content-Type"))
+
+			body := io.Reader(resp.Body)
+			if tt.gzip {
+				gz, err := gzip.NewReader(resp.Body)
+				require.NoError(t, err)
+				body = gz
+			}
+
+			resBody, err := io.ReadAll(body)
+			require.NoError(t, err)
+
+			want := tt.want.response
+			if strings.Contains(want, "%[1]s") {
+				want = fmt.Sprintf(want, idUser)
+			}
+			assert.Equal(t, want, string(resBody))
+		})
+	}
+}
+
+func TestGetQRCode(t *testing.T) {
+	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080", QRCacheMaxAge: time.Hour}
+	store := storage.NewMemStorage()
+	require.NoError(t, store.Put(context.Background(),
+		storage.Link{UserID: "user", ShortURL: cfg.BaseURL + "/abc", OriginalURL: "http://example.com"}, false))
+	require.NoError(t, store.Put(context.Background(),
+		storage.Link{UserID: "user", ShortURL: cfg.BaseURL + "/old", OriginalURL: "http://example.com/old"}, false))
+	require.NoError(t, store.DeleteBatch(context.Background(), "user", []string{cfg.BaseURL + "/old"}))
+
+	manager := usecase.New(store, nil, cfg.BaseURL)
+	ts := httptest.NewServer(New(manager, cfg).Handler)
+	defer ts.Close()
+
+	tests := []struct {
+		name        string
+		request     string
+		statusCode  int
+		contentType string
+	}{
+		{name: "default png", request: "/abc/qr", statusCode: http.StatusOK, contentType: "image/png"},
+		{name: "svg", request: "/abc/qr?format=svg&size=512&level=h&margin=2&fg=%23336699&bg=fff", statusCode: http.StatusOK, contentType: "image/svg+xml"},
+		{name: "unknown format", request: "/abc/qr?format=gif", statusCode: http.StatusBadRequest},
+		{name: "size too large", request: "/abc/qr?size=5000", statusCode: http.StatusBadRequest},
+		{name: "invalid margin", request: "/abc/qr?margin=-1", statusCode: http.StatusBadRequest},
+		{name: "invalid level", request: "/abc/qr?level=x", statusCode: http.StatusBadRequest},
+		{name: "invalid color", request: "/abc/qr?fg=red", statusCode: http.StatusBadRequest},
+		{name: "deleted", request: "/old/qr", statusCode: http.StatusGone},
+		{name: "not found", request: "/missing/qr", statusCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(ts.URL + tt.request)
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			assert.Equal(t, tt.statusCode, resp.StatusCode, string(body))
+			if tt.statusCode != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, tt.contentType, resp.Header.Get("Content-Type"))
+			assert.Equal(t, "public, max-age=3600", resp.Header.Get("Cache-Control"))
+			assert.NotEmpty(t, resp.Header.Get("ETag"))
+			assert.NotEmpty(t, body)
+		})
+	}
+
+	resp, err := http.Get(ts.URL + "/abc/qr?format=svg")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Contains(t, string(body), `width="256" height="256"`)
+
+	etag := resp.Header.Get("ETag")
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/abc/qr?format=svg", nil)
+	require.NoError(t, err)
+	req.Header.Set("If-None-Match", `"other", W/`+etag)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+
+	req.URL.RawQuery = "format=png"
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestPreview(t *testing.T) {
+	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080"}
+	store := storage.NewMemStorage()
+	created := time.Date(2023, 5, 17, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, store.Put(context.Background(), storage.Link{
+		UserID: "user", ShortURL: cfg.BaseURL + "/ext", OriginalURL: "https://example.com/page?a=1&b=<2>",
+		CreatedAt: created, Title: "Spring <sale>",
+	}, false))
+	require.NoError(t, store.Put(context.Background(), storage.Link{
+		UserID: "user", ShortURL: cfg.BaseURL + "/int", OriginalURL: "https://docs.example.org/guide", CreatedAt: created,
+	}, false))
+
+	manager := usecase.New(store, nil, cfg.BaseURL)
+	ts := httptest.NewServer(New(manager, cfg).Handler)
+	defer ts.Close()
+
+	for _, path := range []string{"/ext+", "/ext?preview=1"} {
+		resp, body := doRequest(t, http.MethodGet, ts.URL+path, "", "", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode, path)
+		assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+		assert.Contains(t, body, `href="https://example.com/page?a=1&amp;b=%3c2%3e"`)
+		assert.Contains(t, body, "Spring &lt;sale&gt;")
+		assert.Contains(t, body, "Created on 17 May 2023")
+		assert.Contains(t, body, "external site")
+		assert.NotContains(t, body, "countdown")
+	}
+
+	resp, _ := doRequest(t, http.MethodGet, ts.URL+"/ext", "", "", nil)
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/missing+", "", "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	tests := []struct {
+		name     string
+		policy   usecase.InterstitialPolicy
+		external bool
+		internal bool
+		seconds  int
+	}{
+		{name: "off", policy: usecase.InterstitialPolicy{}},
+		{name: "external", policy: usecase.InterstitialPolicy{Mode: usecase.InterstitialExternal, InternalHosts: []string{"Example.org"}}, external: true, seconds: 5},
+		{name: "all", policy: usecase.InterstitialPolicy{Mode: usecase.InterstitialAll, Delay: 3 * time.Second}, external: true, internal: true, seconds: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, tt.policy.Validate())
+
+			manager := usecase.New(store, nil, cfg.BaseURL)
+			manager.SetInterstitial(tt.policy)
+			ts := httptest.NewServer(New(manager, cfg).Handler)
+			defer ts.Close()
+
+			for path, forced := range map[string]bool{"/ext": tt.external, "/int": tt.internal} {
+				resp, body := doRequest(t, http.MethodGet, ts.URL+path, "", "", nil)
+				if !forced {
+					assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode, path)
+					continue
+				}
+
+				require.Equal(t, http.StatusOK, resp.StatusCode, path)
+				assert.Contains(t, body, `id="countdown"`)
+				assert.Contains(t, body, fmt.Sprintf(">%d</span>", tt.seconds))
+			}
+		})
+	}
+
+	assert.ErrorIs(t, usecase.InterstitialPolicy{Mode: "sometimes"}.Validate(), usecase.ErrInvalidInterstitial)
+}
+
+func TestRedirectType(t *testing.T) {
+	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080", RedirectCacheMaxAge: time.Hour}
+
+	cacheControl := map[int]string{
+		http.StatusMovedPermanently:  "public, max-age=3600",
+		http.StatusFound:             "private, no-store",
+		http.StatusSeeOther:          "private, no-store",
+		http.StatusTemporaryRedirect: "private, no-store",
+		http.StatusPermanentRedirect: "public, max-age=3600",
+	}
+
+	for _, status := range []int{301, 302, 303, 307, 308} {
+		t.Run(fmt.Sprintf("default %d", status), func(t *testing.T) {
+			store := storage.NewMemStorage()
+			require.NoError(t, store.Put(context.Background(),
+				storage.Link{UserID: "user", ShortURL: cfg.BaseURL + "/abc", OriginalURL: "http://example.com"}, false))
+
+			manager := usecase.New(store, nil, cfg.BaseURL)
+			manager.SetDefaultRedirectType(status)
+			ts := httptest.NewServer(New(manager, cfg).Handler)
+			defer ts.Close()
+
+			resp, _ := doRequest(t, http.MethodGet, ts.URL+"/abc", "", "user", nil)
+			assert.Equal(t, status, resp.StatusCode)
+			assert.Equal(t, "http://example.com", resp.Header.Get("Location"))
+			assert.Equal(t, cacheControl[status], resp.Header.Get("Cache-Control"))
+		})
+
+		t.Run(fmt.Sprintf("link %d", status), func(t *testing.T) {
+			manager := usecase.New(storage.NewMemStorage(), nil, cfg.BaseURL)
+			manager.SetDefaultRedirectType(http.StatusFound)
+			ts := httptest.NewServer(New(manager, cfg).Handler)
+			defer ts.Close()
+
+			user := sign.UserID()
+			body := fmt.Sprintf(`{"url":"http://example.com/%d","redirect_type":%d}`, status, status)
+			resp, body := doRequest(t, http.MethodPost, ts.URL+"/api/shorten", body, user, nil)
+			require.Equal(t, http.StatusCreated, resp.StatusCode, body)
+
+			var rst struct {
+				Result string `json:"result"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(body), &rst))
+			id := rst.Result[strings.LastIndex(rst.Result, "/")+1:]
+
+			resp, _ = doRequest(t, http.MethodGet, ts.URL+"/"+id, "", user, nil)
+			assert.Equal(t, status, resp.StatusCode)
+			assert.Equal(t, fmt.Sprintf("http://example.com/%d", status), resp.Header.Get("Location"))
+			assert.Equal(t, cacheControl[status], resp.Header.Get("Cache-Control"))
+
+			// The redirect type of the URL is reset to the default.
+			resp, body = doRequest(t, http.MethodPatch, ts.URL+"/api/user/urls/"+id, `{"redirect_type":0}`, user, nil)
+			require.Equal(t, http.StatusOK, resp.StatusCode, body)
+			assert.NotContains(t, body, "redirect_type")
+
+			resp, _ = doRequest(t, http.MethodGet, ts.URL+"/"+id, "", user, nil)
+			assert.Equal(t, http.StatusFound, resp.StatusCode)
+		})
+	}
+
+	manager := usecase.New(storage.NewMemStorage(), nil, cfg.BaseURL)
+	ts := httptest.NewServer(New(manager, cfg).Handler)
+	defer ts.Close()
+
+	user := sign.UserID()
+	resp, _ := doRequest(t, http.MethodPost, ts.URL+"/api/shorten",
+		`{"url":"http://example.com","redirect_type":200}`, user, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, body := doRequest(t, http.MethodPost, ts.URL+"/api/shorten", `{"url":"http://example.com"}`, user, nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	id := body[strings.LastIndex(body, "/")+1 : strings.LastIndex(body, `"`)]
+
+	resp, body = doRequest(t, http.MethodPatch, ts.URL+"/api/user/urls/"+id,
+		`{"tags":["seo"],"redirect_type":308}`, user, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	var updated usecase.UserURL
+	require.NoError(t, json.Unmarshal([]byte(body), &updated))
+	assert.Equal(t, []string{"seo"}, updated.Tags)
+	assert.Equal(t, http.StatusPermanentRedirect, updated.RedirectType)
+
+	for _, body := range []string{`{"redirect_type":304}`, `{"tags":["a,b"],"redirect_type":301}`, `{}`} {
+		resp, _ = doRequest(t, http.MethodPatch, ts.URL+"/api/user/urls/"+id, body, user, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+	}
+
+	// The invalid update is not applied partially.
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/"+id, "", user, nil)
+	assert.Equal(t, http.StatusPermanentRedirect, resp.StatusCode)
+}
+
+func TestPassthrough(t *testing.T) {
+	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080"}
+	store := storage.NewMemStorage()
+	require.NoError(t, store.Put(context.Background(), storage.Link{
+		UserID: "user", ShortURL: cfg.BaseURL + "/pass", OriginalURL: "http://example.com/base?utm_source=site&x=1",
+		Passthrough: true,
+	}, false))
+	require.NoError(t, store.Put(context.Background(), storage.Link{
+		UserID: "user", ShortURL: cfg.BaseURL + "/plain", OriginalURL: "http://example.com/plain?x=1",
+	}, false))
+
+	tests := []struct {
+		name       string
+		conflict   string
+		request    string
+		statusCode int
+		location   string
+	}{
+		{
+			name:       "query is dropped without passthrough",
+			request:    "/plain?utm_source=mail",
+			statusCode: http.StatusTemporaryRedirect,
+			location:   "http://example.com/plain?x=1",
+		},
+		{
+			name:       "path is not found without passthrough",
+			request:    "/plain/extra",
+			statusCode: http.StatusNotFound,
+		},
+		{
+			name:       "original values are kept",
+			conflict:   usecase.QueryKeep,
+			request:    "/pass?utm_source=mail&utm_medium=email",
+			statusCode: http.StatusTemporaryRedirect,
+			location:   "http://example.com/base?utm_medium=email&utm_source=site&x=1",
+		},
+		{
+			name:       "original values are overridden",
+			conflict:   usecase.QueryOverride,
+			request:    "/pass?utm_source=mail",
+			statusCode: http.StatusTemporaryRedirect,
+			location:   "http://example.com/base?utm_source=mail&x=1",
+		},
+		{
+			name:       "request values are appended",
+			conflict:   usecase.QueryAppend,
+			request:    "/pass?utm_source=mail",
+			statusCode: http.StatusTemporaryRedirect,
+			location:   "http://example.com/base?utm_source=site&utm_source=mail&x=1",
+		},
+		{
+			name:       "path is appended",
+			request:    "/pass/extra/a%20b/?y=2",
+			statusCode: http.StatusTemporaryRedirect,
+			location:   "http://example.com/base/extra/a%20b/?utm_source=site&x=1&y=2",
+		},
+		{
+			name:       "path under the qr segment is passed through",
+			request:    "/pass/qr/code",
+			statusCode: http.StatusTemporaryRedirect,
+			location:   "http://example.com/base/qr/code?utm_source=site&x=1",
+		},
+		{
+			name:       "path cannot leave the original path",
+			request:    "/pass/a/../../../admin",
+			statusCode: http.StatusTemporaryRedirect,
+			location:   "http://example.com/base/admin?utm_source=site&x=1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := usecase.New(store, nil, cfg.BaseURL)
+			manager.SetQueryConflict(tt.conflict)
+			ts := httptest.NewServer(New(manager, cfg).Handler)
+			defer ts.Close()
+
+			resp, err := noRedirectClient.Get(ts.URL + tt.request)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+			assert.Equal(t, tt.location, resp.Header.Get("Location"))
+		})
+	}
+
+	manager := usecase.New(store, nil, cfg.BaseURL)
+	ts := httptest.NewServer(New(manager, cfg).Handler)
+	defer ts.Close()
+
+	resp, err := noRedirectClient.Get(ts.URL + "/pass?preview=1&utm_medium=email")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `href="http://example.com/base?utm_medium=email&amp;utm_source=site&amp;x=1"`)
+
+	// The qr suffix is reserved for the QR code, so it is not passed through.
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/pass/qr", "", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+
+	assert.ErrorIs(t, usecase.ValidateQueryConflict("merge"), usecase.ErrInvalidQueryConflict)
+}
+
+func TestUTM(t *testing.T) {
+	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080"}
+	manager := usecase.New(storage.NewMemStorage(), nil, cfg.BaseURL)
+	ts := httptest.NewServer(New(manager, cfg).Handler)
+	defer ts.Close()
+
+	user := sign.UserID()
+
+	tests := []struct {
+		name       string
+		body       string
+		statusCode int
+		original   string
+	}{
+		{
+			name:       "parameters are added in canonical order",
+			body:       `{"url":"http://example.com/a?x=1#top","utm":{"content":"logo","source":"news","campaign":"spring sale"}}`,
+			statusCode: http.StatusCreated,
+			original:   "http://example.com/a?x=1&utm_source=news&utm_campaign=spring+sale&utm_content=logo#top",
+		},
+		{
+			name:       "final URL is deduplicated",
+			body:       `{"url":"http://example.com/a?x=1#top","utm":{"campaign":"spring sale","source":" news ","content":"logo"}}`,
+			statusCode: http.StatusConflict,
+			original:   "http://example.com/a?x=1&utm_source=news&utm_campaign=spring+sale&utm_content=logo#top",
+		},
+		{
+			name:       "parameters of the URL are replaced",
+			body:       `{"url":"http://example.com/b?utm_source=site&x=1&utm_medium=cpc","utm":{"source":"mail"}}`,
+			statusCode: http.StatusCreated,
+			original:   "http://example.com/b?x=1&utm_medium=cpc&utm_source=mail",
+		},
+		{
+			name:       "other UTM is another URL",
+			body:       `{"url":"http://example.com/a?x=1#top","utm":{"source":"mail","medium":"email"}}`,
+			statusCode: http.StatusCreated,
+			original:   "http://example.com/a?x=1&utm_source=mail&utm_medium=email#top",
+		},
+		{
+			name:       "too long value",
+			body:       `{"url":"http://example.com/c","utm":{"term":"` + strings.Repeat("a", usecase.MaxUTMLength+1) + `"}}`,
+			statusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := doRequest(t, http.MethodPost, ts.URL+"/api/shorten", tt.body, user, nil)
+			require.Equal(t, tt.statusCode, resp.StatusCode, body)
+
+			if tt.original == "" {
+				return
+			}
+
+			var rst struct {
+				Result string `json:"result"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(body), &rst))
+
+			redirect, err := manager.GetFullURL(context.Background(), rst.Result[strings.LastIndex(rst.Result, "/")+1:],
+				usecase.Passthrough{}, usecase.Visitor{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.original, redirect.URL)
+		})
+	}
+
+	resp, body := doRequest(t, http.MethodGet, ts.URL+"/api/user/urls?utm_source=news", "", user, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var urls []usecase.UserURL
+	require.NoError(t, json.Unmarshal([]byte(body), &urls))
+	require.Len(t, urls, 1)
+	assert.Equal(t, &storage.UTM{Source: "news", Campaign: "spring sale", Content: "logo"}, urls[0].UTM)
+
+	resp, body = doRequest(t, http.MethodGet, ts.URL+"/api/user/urls?utm_source=mail&utm_medium=email", "", user, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.Unmarshal([]byte(body), &urls))
+	require.Len(t, urls, 1)
+	assert.Equal(t, "http://example.com/a?x=1&utm_source=mail&utm_medium=email#top", urls[0].OriginalURL)
+
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/api/user/urls?utm_campaign=winter", "", user, nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func TestTargeting(t *testing.T) {
+	const (
+		iphone  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148"
+		android = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Mobile Safari/537.36"
+		windows = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36"
+		crawler = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+	)
+
+	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080"}
+	manager := usecase.New(storage.NewMemStorage(), nil, cfg.BaseURL)
+	ts := httptest.NewServer(New(manager, cfg).Handler)
+	defer ts.Close()
+
+	user := sign.UserID()
+
+	resp, body := doRequest(t, http.MethodPost, ts.URL+"/api/shorten", `{"url":"http://example.com/app","rules":[
+		{"agent":"bot","url":"http://example.com/app/about"},
+		{"os":"iOS","url":"https://apps.apple.com/app/id1"},
+		{"os":"android","device":"mobile","url":"https://play.google.com/store/apps/details?id=app"},
+		{"language":"de","url":"http://example.com/de/app"}]}`, user, nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode, body)
+
+	var rst struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &rst))
+	id := rst.Result[strings.LastIndex(rst.Result, "/")+1:]
+
+	tests := []struct {
+		name     string
+		header   map[string]string
+		location string
+	}{
+		{
+			name:     "ios",
+			header:   map[string]string{"User-Agent": iphone},
+			location: "https://apps.apple.com/app/id1",
+		},
+		{
+			name:     "android",
+			header:   map[string]string{"User-Agent": android, "Accept-Language": "de"},
+			location: "https://play.google.com/store/apps/details?id=app",
+		},
+		{
+			name:     "bot is matched first",
+			header:   map[string]string{"User-Agent": crawler},
+			location: "http://example.com/app/about",
+		},
+		{
+			name:     "preferred language",
+			header:   map[string]string{"User-Agent": windows, "Accept-Language": "en;q=0.5, de-AT"},
+			location: "http://example.com/de/app",
+		},
+		{
+			name:     "fallback",
+			header:   map[string]string{"User-Agent": windows, "Accept-Language": "en, de;q=0.9"},
+			location: "http://example.com/app",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := doRequest(t, http.MethodGet, ts.URL+"/"+id, "", user, tt.header)
+			assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+			assert.Equal(t, tt.location, resp.Header.Get("Location"))
+			assert.Equal(t, "private, no-store", resp.Header.Get("Cache-Control"))
+		})
+	}
+
+	// The rules are listed with the URL and replaced through the API.
+	resp, body = doRequest(t, http.MethodGet, ts.URL+"/api/user/urls", "", user, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var urls []usecase.UserURL
+	require.NoError(t, json.Unmarshal([]byte(body), &urls))
+	require.Len(t, urls, 1)
+	assert.Equal(t, storage.Rule{OS: "ios", URL: "https://apps.apple.com/app/id1"}, urls[0].Rules[1])
+
+	resp, body = doRequest(t, http.MethodPatch, ts.URL+"/api/user/urls/"+id,
+		`{"rules":[{"device":"tablet","url":"http://example.com/tablet"}],"redirect_type":301}`, user, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+
+	// The permanent redirects of the targeted URL are not cached either, even by the noRedirectClient.
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/"+id, "", user, map[string]string{"User-Agent": iphone})
+	assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	assert.Equal(t, "http://example.com/app", resp.Header.Get("Location"))
+	assert.Equal(t, "private, no-store", resp.Header.Get("Cache-Control"))
+
+	resp, body = doRequest(t, http.MethodPatch, ts.URL+"/api/user/urls/"+id, `{"rules":[]}`, user, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	assert.NotContains(t, body, "rules")
+
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/"+id, "", user, map[string]string{"User-Agent": iphone})
+	assert.Equal(t, "public, max-age=0", resp.Header.Get("Cache-Control"))
+
+	for _, rules := range []string{
+		`[{"url":"http://example.com"}]`,
+		`[{"os":"symbian","url":"http://example.com"}]`,
+		`[{"device":"watch","url":"http://example.com"}]`,
+		`[{"agent":"robot","url":"http://example.com"}]`,
+		`[{"language":"de_DE","url":"http://example.com"}]`,
+		`[{"os":"ios","url":"/relative"}]`,
+		`[` + strings.Repeat(`{"os":"ios","url":"http://example.com"},`, usecase.MaxRules) + `{"os":"ios","url":"http://example.com"}]`,
+	} {
+		resp, _ = doRequest(t, http.MethodPatch, ts.URL+"/api/user/urls/"+id, `{"rules":`+rules+`}`, user, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, rules)
+
+		resp, _ = doRequest(t, http.MethodPost, ts.URL+"/api/shorten",
+			`{"url":"http://example.com/other","rules":`+rules+`}`, user, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, rules)
+	}
+}
+
+// geoStub resolves the countries of the IP addresses from the map.
+type geoStub map[string]string
+
+func (g geoStub) Country(addr netip.Addr) (string, error) {
+	return g[addr.String()], nil
+}
+
+func TestGeoTargeting(t *testing.T) {
+	store := storage.NewMemStorage()
+	require.NoError(t, store.Put(context.Background(), storage.Link{
+		UserID: "user", ShortURL: "http://localhost:8080/geo", OriginalURL: "http://example.com",
+		Rules: []storage.Rule{
+			{Country: "DE", URL: "http://example.de"},
+			{Country: "FR", Device: "mobile", URL: "http://m.example.fr"},
+		},
+	}, false))
+
+	geo := geoStub{"203.0.113.5": "DE", "198.51.100.7": "FR", "127.0.0.1": "FR"}
+
+	tests := []struct {
+		name     string
+		proxies  []string
+		header   map[string]string
+		location string
+	}{
+		{
+			name:     "remote address",
+			header:   map[string]string{"User-Agent": "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile"},
+			location: "http://m.example.fr",
+		},
+		{
+			name:     "forwarded headers of untrusted clients are ignored",
+			header:   map[string]string{"X-Forwarded-For": "203.0.113.5", "X-Real-IP": "203.0.113.5"},
+			location: "http://example.com",
+		},
+		{
+			name:     "rightmost untrusted forwarded address",
+			proxies:  []string{"127.0.0.1", "10.0.0.0/8"},
+			header:   map[string]string{"X-Forwarded-For": "198.51.100.7, 203.0.113.5, 10.1.2.3"},
+			location: "http://example.de",
+		},
+		{
+			name:     "real IP of trusted proxy",
+			proxies:  []string{"127.0.0.0/8"},
+			header:   map[string]string{"X-Real-IP": "203.0.113.5"},
+			location: "http://example.de",
+		},
+		{
+			name:     "unknown country",
+			proxies:  []string{"127.0.0.1"},
+			header:   map[string]string{"X-Forwarded-For": "192.0.2.1"},
+			location: "http://example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080", TrustedProxies: tt.proxies}
+			manager := usecase.New(store, nil, cfg.BaseURL)
+			manager.SetGeoLocator(geo)
+			ts := httptest.NewServer(New(manager, cfg).Handler)
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/geo", nil)
+			require.NoError(t, err)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+
+			resp, err := noRedirectClient.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+			assert.Equal(t, tt.location, resp.Header.Get("Location"))
+		})
+	}
+
+	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080"}
+	manager := usecase.New(storage.NewMemStorage(), nil, cfg.BaseURL)
+	ts := httptest.NewServer(New(manager, cfg).Handler)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/api/shorten", "application/json",
+		strings.NewReader(`{"url":"http://example.com/b","rules":[{"country":"Germany","url":"http://example.de"}]}`))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Post(ts.URL+"/api/shorten", "application/json",
+		strings.NewReader(`{"url":"http://example.com/a","rules":[{"country":"de","url":"http://example.de"}]}`))
+	require.NoError(t, err)
+	var rst struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&rst))
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// The country of the rule is matched in uppercase.
+	redirect, err := manager.GetFullURL(context.Background(), rst.Result[strings.LastIndex(rst.Result, "/")+1:],
+		usecase.Passthrough{}, usecase.Visitor{Country: "DE"})
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.de", redirect.URL)
+}
+
+func TestSplit(t *testing.T) {
+	const iphone = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148"
+
+	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080"}
+	manager := usecase.New(storage.NewMemStorage(), nil, cfg.BaseURL)
+	ts := httptest.NewServer(New(manager, cfg).Handler)
+	defer ts.Close()
+
+	user := sign.UserID()
+
+	resp, body := doRequest(t, http.MethodPost, ts.URL+"/api/shorten", `{"url":"http://example.com/landing",
+		"rules":[{"os":"ios","url":"https://apps.apple.com/app/id1"}],
+		"variants":[{"url":"http://example.com/a","weight":70},{"url":" http://example.com/b ","weight":30}]}`, user, nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode, body)
+
+	var rst struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &rst))
+	id := rst.Result[strings.LastIndex(rst.Result, "/")+1:]
+
+	// The new visitors are split by weight and get the cookie of their variant.
+	const visits = 1000
+	counts := make(map[string]int64)
+	cookies := make(map[string]*http.Cookie)
+	for i := 0; i < visits; i++ {
+		resp, _ = doRequest(t, http.MethodGet, ts.URL+"/"+id, "", user, nil)
+		require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+		assert.Equal(t, "private, no-store", resp.Header.Get("Cache-Control"))
+
+		location := resp.Header.Get("Location")
+		counts[location]++
+
+		require.Len(t, resp.Cookies(), 1)
+		c := resp.Cookies()[0]
+		assert.Equal(t, "ab_"+id, c.Name)
+		assert.Equal(t, "/"+id, c.Path)
+		assert.True(t, c.HttpOnly)
+		cookies[location] = c
+	}
+
+	require.Len(t, counts, 2)
+	assert.InDelta(t, 700, counts["http://example.com/a"], 100)
+
+	// The visitors with the cookie keep their variant.
+	for i := 0; i < 20; i++ {
+		resp, _ = doRequest(t, http.MethodGet, ts.URL+"/"+id,
+			"", user, map[string]string{"Cookie": "id=" + user + "; ab_" + id + "=" + cookies["http://example.com/b"].Value})
+		require.Equal(t, "http://example.com/b", resp.Header.Get("Location"))
+	}
+	counts["http://example.com/b"] += 20
+
+	// A matching targeting rule takes precedence over the split and the preview is not a click.
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/"+id, "", user, map[string]string{"User-Agent": iphone})
+	assert.Equal(t, "https://apps.apple.com/app/id1", resp.Header.Get("Location"))
+	assert.Empty(t, resp.Cookies())
+
+	resp, body = doRequest(t, http.MethodGet, ts.URL+"/"+id+"+", "", user, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "http://example.com/")
+
+	resp, body = doRequest(t, http.MethodGet, ts.URL+"/api/user/urls/"+id+"/variants", "", user, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+
+	var variants []usecase.VariantStats
+	require.NoError(t, json.Unmarshal([]byte(body), &variants))
+	require.Len(t, variants, 2)
+	assert.Equal(t, "http://example.com/a", variants[0].URL)
+	assert.Equal(t, 70, variants[0].Weight)
+	assert.Equal(t, cookies["http://example.com/a"].Value, variants[0].Key)
+	assert.Equal(t, counts["http://example.com/a"], variants[0].Clicks)
+	assert.Equal(t, "http://example.com/b", variants[1].URL)
+	assert.Equal(t, counts["http://example.com/b"], variants[1].Clicks)
+
+	// The variants are listed with the URL.
+	resp, body = doRequest(t, http.MethodGet, ts.URL+"/api/user/urls", "", user, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `"variants":[{"url":"http://example.com/a","weight":70,"clicks":`)
+
+	// The variants of other users are not found.
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/user/urls/"+id+"/variants", nil)
+	require.NoError(t, err)
+	req.Header.Set("Cookie", "id="+sign.UserID())
+	resp, err = noRedirectClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Replacing the variants resets the clicks, the visitors of a removed variant are assigned again.
+	resp, body = doRequest(t, http.MethodPatch, ts.URL+"/api/user/urls/"+id,
+		`{"variants":[{"url":"http://example.com/a","weight":1},{"url":"http://example.com/c","weight":1}]}`, user, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/"+id,
+		"", user, map[string]string{"Cookie": "id=" + user + "; ab_" + id + "=" + cookies["http://example.com/b"].Value})
+	assert.NotEqual(t, "http://example.com/b", resp.Header.Get("Location"))
+
+	_, body = doRequest(t, http.MethodGet, ts.URL+"/api/user/urls/"+id+"/variants", "", user, nil)
+	require.NoError(t, json.Unmarshal([]byte(body), &variants))
+	require.Len(t, variants, 2)
+	assert.Equal(t, int64(1), variants[0].Clicks+variants[1].Clicks)
+
+	// No variants remove the split.
+	resp, body = doRequest(t, http.MethodPatch, ts.URL+"/api/user/urls/"+id, `{"variants":[]}`, user, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	assert.NotContains(t, body, "variants")
+
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/"+id, "", user, nil)
+	assert.Equal(t, "http://example.com/landing", resp.Header.Get("Location"))
+	assert.Empty(t, resp.Cookies())
+
+	_, body = doRequest(t, http.MethodGet, ts.URL+"/api/user/urls/"+id+"/variants", "", user, nil)
+	assert.Equal(t, "[]", body)
+
+	for _, variants := range []string{
+		`[{"url":"http://example.com/a","weight":1}]`,
+		`[{"url":"http://example.com/a","weight":0},{"url":"http://example.com/b","weight":1}]`,
+		`[{"url":"http://example.com/a","weight":1001},{"url":"http://example.com/b","weight":1}]`,
+		`[{"url":"/relative","weight":1},{"url":"http://example.com/b","weight":1}]`,
+		`[{"url":"http://example.com/a","weight":1},{"url":"http://example.com/a","weight":1}]`,
+		`[` + strings.Repeat(`{"url":"http://example.com/a","weight":1},`, usecase.MaxVariants) + `{"url":"http://example.com/b","weight":1}]`,
+	} {
+		resp, _ = doRequest(t, http.MethodPatch, ts.URL+"/api/user/urls/"+id, `{"variants":`+variants+`}`, user, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, variants)
+
+		resp, _ = doRequest(t, http.MethodPost, ts.URL+"/api/shorten",
+			`{"url":"http://example.com/other","variants":`+variants+`}`, user, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, variants)
+	}
+}
+
+func TestURLPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domains")
+	require.NoError(t, os.WriteFile(path, []byte("allow example.com\nallow example.org\ndeny ads.example.com\n"), 0o600))
+
+	domains, err := domainlist.Open(path)
+	require.NoError(t, err)
+
+	cfg := &config.Config{ServerAddress: ":8080", BaseURL: "http://localhost:8080"}
+	manager := usecase.New(storage.NewMemStorage(), nil, cfg.BaseURL)
+	manager.SetURLPolicy(usecase.URLPolicy{Schemes: []string{"https", "HTTP"}, MaxLength: 64, Domains: domains})
+	ts := httptest.NewServer(New(manager, cfg).Handler)
+	defer ts.Close()
+
+	user := sign.UserID()
+
+	tests := []struct {
+		name string
+		body string
+		code string
+	}{
+		{name: "javascript", body: `{"url":"javascript:alert(1)"}`, code: usecase.CodeSchemeNotAllowed},
+		{name: "file", body: `{"url":"file:///etc/passwd"}`, code: usecase.CodeSchemeNotAllowed},
+		{name: "no host", body: `{"url":"http:///path"}`, code: usecase.CodeMissingHost},
+		{name: "own domain", body: `{"url":"http://LOCALHOST:9090/abc"}`, code: usecase.CodeSelfReference},
+		{name: "loopback", body: `{"url":"http://127.0.0.1/admin"}`, code: usecase.CodePrivateAddress},
+		{name: "loopback IPv6", body: `{"url":"http://[::1]:8080/"}`, code: usecase.CodePrivateAddress},
+		{name: "private", body: `{"url":"https://10.1.2.3/"}`, code: usecase.CodePrivateAddress},
+		{name: "mapped private", body: `{"url":"https://[::ffff:192.168.0.1]/"}`, code: usecase.CodePrivateAddress},
+		{name: "link-local", body: `{"url":"http://169.254.169.254/latest/meta-data"}`, code: usecase.CodePrivateAddress},
+		{name: "denied subdomain", body: `{"url":"https://x.ads.example.com/"}`, code: usecase.CodeDomainDenied},
+		{name: "not allowed", body: `{"url":"https://example.net/"}`, code: usecase.CodeDomainNotAllowed},
+		{name: "too long", body: `{"url":"https://example.com/` + strings.Repeat("a", 64) + `"}`, code: usecase.CodeURLTooLong},
+		{name: "too long with UTM", body: `{"url":"https://example.com/","utm":{"campaign":"` + strings.Repeat("c", 40) + `"}}`, code: usecase.CodeURLTooLong},
+		{name: "rule", body: `{"url":"https://example.com/","rules":[{"os":"ios","url":"http://192.168.1.1"}]}`, code: usecase.CodePrivateAddress},
+		{name: "variant", body: `{"url":"https://example.com/","variants":[{"url":"https://example.org","weight":1},{"url":"ftp://example.org","weight":1}]}`, code: usecase.CodeSchemeNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := doRequest(t, http.MethodPost, ts.URL+"/api/shorten", tt.body, user, nil)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+			var rst struct {
+				Code  string `json:"code"`
+				Error string `json:"error"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(body), &rst), body)
+			assert.Equal(t, tt.code, rst.Code)
+			assert.NotEmpty(t, rst.Error)
+		})
+	}
+
+	resp, body := doRequest(t, http.MethodPost, ts.URL+"/",
+		"javascript:alert(1)", user, map[string]string{"Content-Type": "text/plain"})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.JSONEq(t, `{"code":"scheme_not_allowed","error":"scheme \"javascript\" is not allowed"}`, body)
+
+	resp, body = doRequest(t, http.MethodPost, ts.URL+"/api/shorten/batch",
+		`[{"correlation_id":"1","original_url":"https://www.example.com/"},{"correlation_id":"2","original_url":"http://localhost:8080/x"}]`, user, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, body, usecase.CodeSelfReference)
+
+	// The batch is validated before any of its URLs is stored.
+	resp, body = doRequest(t, http.MethodPost, ts.URL+"/", "https://www.example.com/", user,
+		map[string]string{"Content-Type": "text/plain"})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, body)
+
+	resp, body = doRequest(t, http.MethodPost, ts.URL+"/api/shorten", `{"url":"HTTPS://Example.com./a"}`, user, nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode, body)
+
+	var rst struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &rst))
+	id := rst.Result[strings.LastIndex(rst.Result, "/")+1:]
+
+	// The destinations of the updated rules and variants are checked too.
+	resp, body = doRequest(t, http.MethodPatch, ts.URL+"/api/user/urls/"+id,
+		`{"tags":["a"],"variants":[{"url":"https://example.org/1","weight":1},{"url":"https://`+rst.Result[len("http://"):]+`","weight":1}]}`, user, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.JSONEq(t, `{"code":"self_reference","error":"variant 2: URL leads back to the service"}`, body)
+
+	resp, body = doRequest(t, http.MethodGet, ts.URL+"/api/user/urls", "", user, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotContains(t, body, "tags")
+
+	// The changes of the domain list apply to the new URLs.
+	require.NoError(t, os.WriteFile(path, []byte("deny example.org\n"), 0o600))
+	_, err = domains.Reload()
+	require.NoError(t, err)
+
+	resp, body = doRequest(t, http.MethodPost, ts.URL+"/api/shorten", `{"url":"https://example.net/"}`, user, nil)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, body)
+
+	resp, body = doRequest(t, http.MethodPost, ts.URL+"/api/shorten", `{"url":"https://example.org/"}`, user, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, body, usecase.CodeDomainDenied)
+}
+
+func TestURLChecker(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		flagged = map[string]string{"https://evil.example.com/": "phishing"}
+	)
+
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			URL string `json:"url"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		mu.Lock()
+		threat := flagged[req.URL]
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"threat": threat})
+	}))
+	defer hook.Close()
+
+	cfg := &config.Config{BaseURL: "http://localhost:8080", AdminToken: "secret"}
+	manager := usecase.New(storage.NewMemStorage(), nil, cfg.BaseURL)
+	manager.SetURLChecker(urlcheck.NewWebhook(hook.URL, "", time.Second))
+	manager.SetRecheckOnRedirect(true)
+	ts := httptest.NewServer(New(manager, cfg).Handler)
+	defer ts.Close()
+
+	user := sign.UserID()
+
+	admin := map[string]string{"Authorization": "Bearer secret"}
+
+	// The flagged URLs are rejected on creation.
+	resp, body := doRequest(t, http.MethodPost, ts.URL+"/api/shorten",
+		`{"url":"https://evil.example.com/"}`, user, admin)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.JSONEq(t, `{"code":"malicious_url","error":"URL is flagged as phishing"}`, body)
+
+	resp, body = doRequest(t, http.MethodPost, ts.URL+"/api/shorten",
+		`{"url":"https://example.com/","rules":[{"os":"ios","url":"https://evil.example.com/"}]}`, user, admin)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.JSONEq(t, `{"code":"malicious_url","error":"rule 1: URL is flagged as phishing"}`, body)
+
+	resp, body = doRequest(t, http.MethodPost, ts.URL+"/api/shorten", `{"url":"https://example.com/"}`, user, admin)
+	require.Equal(t, http.StatusCreated, resp.StatusCode, body)
+
+	var rst struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &rst))
+	id := rst.Result[strings.LastIndex(rst.Result, "/")+1:]
+
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/"+id, "", user, admin)
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+	// The URL flagged after its creation is quarantined on the next redirect.
+	mu.Lock()
+	flagged["https://example.com/"] = "malware"
+	mu.Unlock()
+
+	resp, body = doRequest(t, http.MethodGet, ts.URL+"/"+id, "", user, admin)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+	assert.Empty(t, resp.Header.Get("Location"))
+	assert.Contains(t, body, "<strong>malware</strong>")
+	assert.Contains(t, body, `href="https://example.com/"`)
+
+	mu.Lock()
+	delete(flagged, "https://example.com/")
+	mu.Unlock()
+
+	// The quarantine is kept until released by the administrator.
+	resp, body = doRequest(t, http.MethodGet, ts.URL+"/"+id+"+", "", user, admin)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "Warning: unsafe link")
+
+	resp, body = doRequest(t, http.MethodGet, ts.URL+"/api/user/urls", "", user, admin)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `"threat":"malware"`)
+
+	resp, body = doRequest(t, http.MethodDelete, ts.URL+"/api/admin/urls/"+id+"/quarantine", "", user, admin)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	assert.NotContains(t, body, "threat")
+
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/"+id, "", user, admin)
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+	// The administrator quarantines the URLs manually.
+	resp, body = doRequest(t, http.MethodPut, ts.URL+"/api/admin/urls/"+id+"/quarantine",
+		`{"threat":" Phishing "}`, user, admin)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	assert.Contains(t, body, `"threat":"phishing"`)
+
+	resp, body = doRequest(t, http.MethodGet, ts.URL+"/"+id, "", user, admin)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "<strong>phishing</strong>")
+
+	resp, _ = doRequest(t, http.MethodPut, ts.URL+"/api/admin/urls/"+id+"/quarantine", `{"threat":""}`, user, admin)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = doRequest(t, http.MethodPut, ts.URL+"/api/admin/urls/missing/quarantine",
+		`{"threat":"phishing"}`, user, admin)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestRateLimit(t *testing.T) {
+	cfg := &config.Config{
+		BaseURL:           "http://localhost:8080",
+		TrustedProxies:    []string{"127.0.0.1", "::1"},
+		CreateRateLimit:   "2/h",
+		RedirectRateLimit: "1/m",
+		RedirectRateBurst: 2,
+	}
+	store := storage.NewMemStorage()
+	manager := usecase.New(store, nil, cfg.BaseURL)
+	ts := httptest.NewServer(New(manager, cfg).Handler)
+	defer ts.Close()
+
+	from := func(ip string) map[string]string {
+		return map[string]string{"X-Forwarded-For": ip}
+	}
+
+	user := sign.UserID()
+
+	// The URLs are created per user, on all the create endpoints.
+	resp, _ := doRequest(t, http.MethodPost, ts.URL+"/api/shorten",
+		`{"url":"https://example.com/1"}`, user, from("203.0.113.1"))
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, _ = doRequest(t, http.MethodPost, ts.URL+"/api/shorten/batch",
+		`[{"correlation_id":"1","original_url":"https://example.com/2"}]`, user, from("203.0.113.2"))
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, _ = doRequest(t, http.MethodPost, ts.URL+"/", "https://example.com/3", user, from("203.0.113.3"))
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "1800", resp.Header.Get("Retry-After"))
+
+	resp, _ = doRequest(t, http.MethodPost, ts.URL+"/api/shorten",
+		`{"url":"https://example.com/3"}`, sign.UserID(), from("203.0.113.1"))
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// The clients without the id cookie are limited by the IP address.
+	for i := 0; i < 2; i++ {
+		resp, _ = doRequest(t, http.MethodPost, ts.URL+"/api/shorten",
+			fmt.Sprintf(`{"url":"https://example.com/anon%d"}`, i), "", from("203.0.113.9"))
+		require.Equal(t, http.StatusCreated, resp.StatusCode, i)
+	}
+	resp, _ = doRequest(t, http.MethodPost, ts.URL+"/api/shorten",
+		`{"url":"https://example.com/anon"}`, "", from("203.0.113.9"))
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// The redirects are limited per IP address, regardless of the user.
+	id := "redirect"
+	require.NoError(t, store.Put(context.Background(),
+		storage.Link{UserID: user, ShortURL: cfg.BaseURL + "/" + id, OriginalURL: "https://example.com/r"}, false))
+
+	for i := 0; i < 2; i++ {
+		resp, _ = doRequest(t, http.MethodGet, ts.URL+"/"+id, "", sign.UserID(), from("198.51.100.1"))
+		require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode, i)
+	}
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/"+id+"/extra", "", user, from("198.51.100.1"))
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "60", resp.Header.Get("Retry-After"))
+
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/"+id, "", user, from("198.51.100.2"))
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+	// The other endpoints are not limited.
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/"+id+"/qr", "", user, from("198.51.100.1"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestQuota(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8080", AdminToken: "secret", DefaultLinkQuota: 2}
+	store := storage.NewMemStorage()
+	manager := usecase.New(store, nil, cfg.BaseURL)
+	manager.SetDefaultQuota(cfg.DefaultLinkQuota)
+	ts := httptest.NewServer(New(manager, cfg).Handler)
+	defer ts.Close()
+
+	admin := map[string]string{"Authorization": "Bearer secret"}
+
+	ctx := context.Background()
+	user := sign.UserID()
+
+	require.NoError(t, store.Put(ctx,
+		storage.Link{UserID: user, ShortURL: cfg.BaseURL + "/a", OriginalURL: "https://example.com/a"}, false))
+
+	// The batch is rejected as a whole if it does not fit into the quota.
+	resp, body := doRequest(t, http.MethodPost, ts.URL+"/api/shorten/batch",
+		`[{"correlation_id":"1","original_url":"https://example.com/1"},{"correlation_id":"2","original_url":"https://example.com/2"}]`, user, admin)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.JSONEq(t, `{"code":"quota_exceeded","error":"quota of 2 active links exceeded, 1 in use","quota":2,"used":1}`, body)
+
+	resp, _ = doRequest(t, http.MethodPost, ts.URL+"/api/shorten", `{"url":"https://example.com/b"}`, user, admin)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, body = doRequest(t, http.MethodPost, ts.URL+"/api/shorten", `{"url":"https://example.com/c"}`, user, admin)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.JSONEq(t, `{"code":"quota_exceeded","error":"quota of 2 active links exceeded, 2 in use","quota":2,"used":2}`, body)
+
+	resp, _ = doRequest(t, http.MethodPost, ts.URL+"/", "https://example.com/c", user, admin)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// The existing URL is a conflict rather than a new link over the quota.
+	resp, _ = doRequest(t, http.MethodPost, ts.URL+"/api/shorten", `{"url":"https://example.com/b"}`, user, admin)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	// The deleted links are not counted, but restoring them is.
+	require.NoError(t, store.DeleteBatch(ctx, user, []string{cfg.BaseURL + "/a"}))
+
+	resp, _ = doRequest(t, http.MethodPost, ts.URL+"/api/shorten", `{"url":"https://example.com/c"}`, user, admin)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, _ = doRequest(t, http.MethodPost, ts.URL+"/api/user/urls/restore", `["a"]`, user, admin)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// The administrators override the quota of the user.
+	resp, body = doRequest(t, http.MethodGet, ts.URL+"/api/admin/users/"+user+"/quota", "", "", admin)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"user_id":"`+user+`","quota":2,"used":2,"override":false}`, body)
+
+	resp, _ = doRequest(t, http.MethodPut, ts.URL+"/api/admin/users/"+user+"/quota", `{"quota":-1}`, "", admin)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, body = doRequest(t, http.MethodPut, ts.URL+"/api/admin/users/"+user+"/quota", `{"quota":3}`, "", admin)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"user_id":"`+user+`","quota":3,"used":2,"override":true}`, body)
+
+	resp, body = doRequest(t, http.MethodPost, ts.URL+"/api/user/urls/restore", `["a"]`, user, admin)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"restored":["a"],"skipped":[]}`, body)
+
+	resp, body = doRequest(t, http.MethodDelete, ts.URL+"/api/admin/users/"+user+"/quota", "", "", admin)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"user_id":"`+user+`","quota":2,"used":3,"override":false}`, body)
+
+	resp, _ = doRequest(t, http.MethodDelete, ts.URL+"/api/admin/users/"+user+"/quota", "", "", admin)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// The quota of the other users is not affected.
+	resp, _ = doRequest(t, http.MethodPost, ts.URL+"/api/shorten",
+		`{"url":"https://example.com/d"}`, sign.UserID(), admin)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+}
+
+// noRedirectClient returns the redirects instead of following them.
+var noRedirectClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// doRequest sends the JSON request with the cookie of the user, unless it is empty, and the header,
+// and returns the response with its body. The redirects are not followed.
+func doRequest(t *testing.T, method, url, body, user string, header map[string]string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	if user != "" {
+		req.Header.Set("Cookie", "id="+user)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := noRedirectClient.Do(req)
+	require.NoError(t, err)
+
+	resBody, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	return resp, string(resBody)
+}
@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"golang.org/x/exp/slog"
+
+	"go-shortener-url/internal/usecase"
+)
+
+// previewPage shows where a shortened URL leads. With a countdown, the page follows the link
+// of the destination when it expires, so the URL is sanitized by the template as any other link.
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex, nofollow">
+<title>{{if .Title}}{{.Title}}{{else}}Link preview{{end}}</title>
+<style>
+body{font-family:system-ui,sans-serif;max-width:40rem;margin:3rem auto;padding:0 1rem;color:#222}
+.url{word-break:break-all;font-size:1.1rem}
+.meta{color:#666}
+.external{color:#a15c00}
+</style>
+</head>
+<body>
+<h1>{{if .Title}}{{.Title}}{{else}}Link preview{{end}}</h1>
+<p><span class="meta">{{.ShortURL}} leads to</span></p>
+<p class="url"><a id="destination" href="{{.OriginalURL}}" rel="noopener noreferrer nofollow">{{.OriginalURL}}</a></p>
+{{if .External}}<p class="external">This link leads to an external site.</p>{{end}}
+{{if not .CreatedAt.IsZero}}<p class="meta">Created on {{.CreatedAt.UTC.Format "2 January 2006"}}</p>{{end}}
+{{if .Countdown}}<p>You will be redirected in <span id="countdown">{{.Seconds}}</span> s.</p>
+<script>
+(function () {
+  var left = {{.Seconds}};
+  var timer = setInterval(function () {
+    left--;
+    document.getElementById("countdown").textContent = left;
+    if (left <= 0) {
+      clearInterval(timer);
+      window.location.href = document.getElementById("destination").href;
+    }
+  }, 1000);
+})();
+</script>{{else}}<p><a href="{{.OriginalURL}}" rel="noopener noreferrer nofollow">Continue</a></p>{{end}}
+</body>
+</html>
+`))
+
+// warningPage is shown instead of the redirect of a quarantined URL, the visitor may still follow
+// the destination at their own risk.
+var warningPage = template.Must(template.New("warning").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex, nofollow">
+<title>Warning: unsafe link</title>
+<style>
+body{font-family:system-ui,sans-serif;max-width:40rem;margin:3rem auto;padding:0 1rem;color:#222}
+h1{color:#b00020}
+.url{word-break:break-all;font-size:1.1rem}
+.meta{color:#666}
+</style>
+</head>
+<body>
+<h1>Warning: unsafe link</h1>
+<p>The link you followed leads to a site flagged as <strong>{{.Threat}}</strong>.
+It may try to steal your personal information or harm your device.</p>
+<p class="url">{{.URL}}</p>
+<p class="meta"><a href="{{.URL}}" rel="noopener noreferrer nofollow">Proceed at your own risk</a></p>
+</body>
+</html>
+`))
+
+type previewData struct {
+	usecase.LinkPreview
+	Seconds int
+}
+
+// writePreview renders the preview page, with a countdown if it is set.
+func writePreview(w http.ResponseWriter, preview usecase.LinkPreview) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.WriteHeader(http.StatusOK)
+
+	data := previewData{LinkPreview: preview, Seconds: int(preview.Countdown / time.Second)}
+	if err := previewPage.Execute(w, data); err != nil {
+		slog.Error("controller.writePreview", "err", err)
+	}
+}
+
+// writeWarning renders the warning page of the quarantined URL leading to the destination.
+func writeWarning(w http.ResponseWriter, destination, threat string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.WriteHeader(http.StatusOK)
+
+	data := struct{ URL, Threat string }{URL: destination, Threat: threat}
+	if err := warningPage.Execute(w, data); err != nil {
+		slog.Error("controller.writeWarning", "err", err)
+	}
+}
@@ -3,40 +3,91 @@
 package controller
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
+	"go-shortener-url/internal/config"
 	mw "go-shortener-url/internal/middleware"
+	"go-shortener-url/internal/storage"
 	"go-shortener-url/internal/usecase"
 )
 
 // New is the constructor for the Server structure.
-func New(m *usecase.Manager) *http.Server {
-	router := configureRouter(m)
+func New(m *usecase.Manager, cfg *config.Config) *http.Server {
+	router := configureRouter(m, cfg)
 
 	return &http.Server{
 		Handler: router,
 	}
 }
 
-func configureRouter(m *usecase.Manager) chi.Router {
+func configureRouter(m *usecase.Manager, cfg *config.Config) chi.Router {
+	// The trusted proxies are validated when the service starts, the invalid ones are not trusted.
+	proxies, _ := mw.ParseTrustedProxies(cfg.TrustedProxies)
+
+	// The rate limits are validated when the service starts too, the invalid ones are disabled.
+	createLimit, _ := usecase.ParseRateLimit(cfg.CreateRateLimit, cfg.CreateRateBurst)
+	redirectLimit, _ := usecase.ParseRateLimit(cfg.RedirectRateLimit, cfg.RedirectRateBurst)
+	limitCreate := rateLimit(m, "create", createLimit, mw.UserKey)
+	limitRedirect := rateLimit(m, "redirect", redirectLimit, mw.ClientIPKey)
+
 	r := chi.NewRouter()
 	r.Use(
+		mw.Tracing,
 		middleware.Recoverer,
 		middleware.RequestID,
+		mw.ClientInfo(proxies, m.Country),
 		mw.GzipHandle,
 		mw.Identification,
 	)
 	r.Route("/", func(r chi.Router) {
-		r.Get("/{id}", GetFullURL(m))
-		r.Post("/", CreateShortURL(m))
-		r.Post("/api/shorten", GetShortByFullURL(m))
+		r.With(limitRedirect).Get("/{id}", GetFullURL(m, cfg.RedirectCacheMaxAge))
+		// The qr suffix is reserved for the QR code, it is not passed through to the original URL.
+		r.Get("/{id}/qr", GetQRCode(m, cfg.QRCacheMaxAge))
+		r.With(limitRedirect).Get("/{id}/*", GetFullURL(m, cfg.RedirectCacheMaxAge))
+		r.With(limitCreate).Post("/", CreateShortURL(m))
+		r.With(limitCreate).Post("/api/shorten", GetShortByFullURL(m))
 		r.Get("/api/user/urls", GetUserURLs(m))
 		r.Get("/ping", CheckConnDB(m))
-		r.Post("/api/shorten/batch", CreateManyShortURL(m))
+		r.Get("/healthz", Liveness())
+		r.Get("/readyz", Readiness(m))
+		r.With(limitCreate).Post("/api/shorten/batch", CreateManyShortURL(m))
 		r.Delete("/api/user/urls", DeleteURLsByUser(m))
+		r.Post("/api/user/urls/restore", RestoreURLs(m))
+		r.Get("/api/user/urls/deleted", GetDeletedURLs(m))
+		r.Get("/api/user/export", ExportUserURLs(m))
+		r.Get("/api/user/operations/{id}", GetDeleteOperation(m))
+		r.Patch("/api/user/urls/{id}", UpdateUserURL(m))
+		r.Get("/api/user/urls/{id}/variants", GetVariants(m))
+		r.Get("/api/user/tags", GetTags(m))
+		r.Patch("/api/user/tags/{name}", RenameTag(m))
+		r.Delete("/api/user/tags/{name}", DeleteTag(m))
+	})
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(mw.AdminAuth(cfg.AdminToken))
+		r.Get("/delete-jobs", GetDeleteJobs(m))
+		r.Post("/purge", Purge(m))
+		r.Put("/urls/{id}/quarantine", QuarantineURL(m))
+		r.Delete("/urls/{id}/quarantine", ReleaseURL(m))
+		r.Get("/users/{id}/quota", GetQuota(m))
+		r.Put("/users/{id}/quota", SetQuota(m))
+		r.Delete("/users/{id}/quota", DeleteQuota(m))
 	})
 	return r
 }
+
+// rateLimit limits the requests of the clients identified by key, the buckets of the policy are separated
+// by its name. The zero limit does not limit the requests.
+func rateLimit(m *usecase.Manager, name string, limit storage.RateLimit, key func(*http.Request) string) func(http.Handler) http.Handler {
+	if limit.IsZero() {
+		return func(next http.Handler) http.Handler { return next }
+	}
+
+	return mw.RateLimit(key, func(ctx context.Context, key string) (time.Duration, error) {
+		return m.TakeToken(ctx, name+":"+key, limit)
+	})
+}
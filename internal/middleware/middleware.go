@@ -1,16 +1,36 @@
-// Package middleware is designed to work with compressed input data and user identification.
+// Package middleware is designed to work with compressed input data, user identification, request tracing,
+// resolving the clients and limiting their rate.
 package middleware
 
 import (
 	"compress/gzip"
+	"context"
+	"crypto/subtle"
 	"errors"
+	"fmt"
 	"io"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
 
 	"go-shortener-url/internal/pkg/sign"
 )
 
+var tracer = otel.Tracer("go-shortener-url/internal/middleware")
+
 type gzipWriter struct {
 	http.ResponseWriter
 	Writer io.Writer
@@ -21,6 +41,17 @@ func (w gzipWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
 
+// Flush sends the compressed data to the client, so that streamed responses are not held in the buffer.
+func (w gzipWriter) Flush() {
+	if gz, ok := w.Writer.(*gzip.Writer); ok {
+		gz.Flush()
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 // GzipHandle wraps the API method response to compress it.
 func GzipHandle(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -29,6 +60,9 @@ func GzipHandle(next http.Handler) http.Handler {
 			return
 		}
 
+		ctx, span := tracer.Start(r.Context(), "middleware.GzipHandle")
+		defer span.End()
+
 		gz, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
 		if err != nil {
 			io.WriteString(w, err.Error())
@@ -37,19 +71,56 @@ func GzipHandle(next http.Handler) http.Handler {
 		defer gz.Close()
 
 		w.Header().Set("Content-Encoding", "gzip")
-		next.ServeHTTP(gzipWriter{ResponseWriter: w, Writer: gz}, r)
+		next.ServeHTTP(gzipWriter{ResponseWriter: w, Writer: gz}, r.WithContext(ctx))
+	})
+}
+
+// Tracing starts a server span for each request.
+// The trace context is extracted from the W3C headers of the incoming request.
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := tracer.Start(ctx, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.target", r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+			span.SetAttributes(attribute.String("http.route", rctx.RoutePattern()))
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		span.SetAttributes(attribute.Int("http.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
 	})
 }
 
 // Identification checks for the presence of a user ID and validates it.
 // If unsuccessful, a new identifier is created.
-// This identifier is passed to the business logic layer.
+// This identifier is passed to the business logic layer, the requests with a new identifier are marked
+// in the context, see IsNewUser.
 func Identification(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		c, err := r.Cookie("id")
 		if errors.Is(err, http.ErrNoCookie) || !sign.ValidateID(c.Value) {
 			cookie := &http.Cookie{Name: "id", Value: sign.UserID()}
 
+			r = r.WithContext(context.WithValue(r.Context(), newUserKey, true))
 			r.AddCookie(cookie)
 			http.SetCookie(w, cookie)
 		} else if err != nil {
@@ -62,3 +133,218 @@ func Identification(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// AdminAuth allows the request only if it carries the administrator token in the Authorization header.
+// If the token is not configured, the administrative API is disabled.
+func AdminAuth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				http.NotFound(w, r)
+				return
+			}
+
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type ctxKey int
+
+const (
+	clientKey ctxKey = iota
+	newUserKey
+)
+
+// IsNewUser reports whether the user identifier was issued by Identification for this request.
+func IsNewUser(ctx context.Context) bool {
+	isNew, _ := ctx.Value(newUserKey).(bool)
+	return isNew
+}
+
+// Client is the client of the request resolved by ClientInfo.
+type Client struct {
+	IP netip.Addr
+	// Country is the ISO 3166-1 alpha-2 code of the country of the IP address, empty if it is unknown.
+	Country string
+}
+
+// ClientFromContext returns the client of the request, it is zero if the request did not pass ClientInfo.
+func ClientFromContext(ctx context.Context) Client {
+	c, _ := ctx.Value(clientKey).(Client)
+	return c
+}
+
+// Logger returns the default logger with the IP address and the country of the client of the request.
+func Logger(ctx context.Context) *slog.Logger {
+	c := ClientFromContext(ctx)
+	if !c.IP.IsValid() {
+		return slog.Default()
+	}
+
+	return slog.Default().With(slog.String("client_ip", c.IP.String()), slog.String("country", c.Country))
+}
+
+// ParseTrustedProxies parses the IP addresses and the networks in CIDR notation of the trusted proxies.
+func ParseTrustedProxies(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		if addr, err := netip.ParseAddr(s); err == nil {
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// ClientInfo resolves the IP address of the client and its country with the country function,
+// the client is available to the handlers through ClientFromContext and Logger.
+// The X-Forwarded-For and X-Real-IP headers are only used if the request comes from a trusted proxy,
+// the address is the rightmost one in X-Forwarded-For that is not a trusted proxy.
+func ClientInfo(trusted []netip.Prefix, country func(netip.Addr) (string, error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var c Client
+
+			c.IP = clientIP(r, trusted)
+			if c.IP.IsValid() && country != nil {
+				var err error
+				if c.Country, err = country(c.IP); err != nil {
+					slog.Warn("failed to resolve the country of the client",
+						slog.String("client_ip", c.IP.String()), slog.String("error", err.Error()))
+				}
+			}
+
+			if c.Country != "" {
+				trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("client.geo.country", c.Country))
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey, c)))
+		})
+	}
+}
+
+func clientIP(r *http.Request, trusted []netip.Prefix) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	remote, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	remote = remote.Unmap()
+
+	if !isTrusted(remote, trusted) {
+		return remote
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+
+		addr := remote
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+
+			addr = hop.Unmap()
+			if !isTrusted(addr, trusted) {
+				break
+			}
+		}
+
+		return addr
+	}
+
+	if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return addr.Unmap()
+	}
+
+	return remote
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RateLimit allows the request if take takes a token from the bucket of the client identified by key,
+// otherwise it responds with 429 Too Many Requests and the Retry-After header in seconds.
+// The requests with an empty key are not limited, the requests are allowed if take fails.
+func RateLimit(key func(*http.Request) string, take func(ctx context.Context, key string) (time.Duration, error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k := key(r)
+			if k == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			wait, err := take(r.Context(), k)
+			if err != nil {
+				Logger(r.Context()).Error("failed to take a rate limit token", slog.String("error", err.Error()))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if wait > 0 {
+				trace.SpanFromContext(r.Context()).SetAttributes(attribute.Bool("http.rate_limited", true))
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClientIPKey identifies the client by its IP address resolved by ClientInfo.
+func ClientIPKey(r *http.Request) string {
+	if ip := ClientFromContext(r.Context()).IP; ip.IsValid() {
+		return "ip:" + ip.String()
+	}
+
+	return ""
+}
+
+// UserKey identifies the client by the user identifier from the id cookie. The clients without the identifier,
+// which get a new one on every request, are identified by their IP address instead.
+func UserKey(r *http.Request) string {
+	if IsNewUser(r.Context()) {
+		return ClientIPKey(r)
+	}
+
+	if c, err := r.Cookie("id"); err == nil && c.Value != "" {
+		return "user:" + c.Value
+	}
+
+	return ClientIPKey(r)
+}
@@ -1,81 +1,430 @@
 // Package deleteurl describes the management of the URL removal service.
-// The service launches background workers that receive tasks for execution through the channel.
-// The service is stopped by closing the channel.
+// Jobs are persisted in a queue so that they survive a restart of the service.
+// The service launches background workers that claim due jobs from the queue in batches,
+// apply a batch when it is full or the flush interval has passed, retry failed jobs
+// with exponential backoff and move jobs that keep failing to the dead-letter state.
+// The service is stopped after the workers have drained the due jobs.
 package deleteurl
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"go-shortener-url/internal/storage"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/exp/slog"
 )
 
+var tracer = otel.Tracer("go-shortener-url/internal/pkg/deleteurl")
+
 // DeleterURLs describes the URL removal service.
 type DeleterURLs interface {
 	Run(int)
-	Delete([]string, string)
+	Begin(context.Context, string, int) (string, error)
+	Delete(context.Context, string, string, []string, []string) error
+	Abort(context.Context, string, error) error
+	Operation(context.Context, string) (storage.DeleteOperation, error)
 	Stop()
+	Shutdown(context.Context) error
+	Workers() int
+	Backlog(context.Context) (int, error)
+	MaxBacklog() int
+	Jobs(context.Context, string, int) ([]storage.DeleteJob, error)
 }
 
-type job struct {
-	url    string
-	userID string
+// Config contains the settings of the URL removal service.
+// Zero values are replaced with the defaults.
+type Config struct {
+	// MaxAttempts is the number of attempts after which the job is moved to the dead-letter state.
+	MaxAttempts int
+	// RetryDelay is the delay before the second attempt, it doubles with each next attempt.
+	RetryDelay time.Duration
+	// MaxRetryDelay limits the delay between attempts.
+	MaxRetryDelay time.Duration
+	// PollInterval is the interval at which idle workers check the queue for due jobs.
+	PollInterval time.Duration
+	// MaxBacklog is the number of pending jobs above which the service is considered overloaded.
+	MaxBacklog int
+	// BatchSize is the maximum number of jobs applied to the data store at once.
+	BatchSize int
+	// FlushInterval is how long a worker waits for more jobs before applying an incomplete batch.
+	FlushInterval time.Duration
 }
 
+const (
+	defaultMaxAttempts   = 5
+	defaultRetryDelay    = time.Second
+	defaultMaxRetryDelay = 5 * time.Minute
+	defaultPollInterval  = time.Second
+	defaultMaxBacklog    = 10000
+	defaultBatchSize     = 100
+	defaultFlushInterval = 100 * time.Millisecond
+
+	jobTimeout = 10 * time.Second
+)
+
 // UrlDeleteService object for managing the service.
 type UrlDeleteService struct {
-	storage storage.Storage
-	chJob   chan job
-	wg      *sync.WaitGroup
+	storage  storage.Storage
+	queue    storage.JobQueue
+	cfg      Config
+	notify   chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       *sync.WaitGroup
+	workers  atomic.Int32
 }
 
 // InitUrlDeleteService initiates a service to remove the URL.
-func InitUrlDeleteService(storage storage.Storage) *UrlDeleteService {
+// If queue is nil, the jobs are kept only in memory.
+func InitUrlDeleteService(storage storage.Storage, queue storage.JobQueue, cfg Config) *UrlDeleteService {
+	if queue == nil {
+		queue = newMemQueue()
+	}
+
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = defaultRetryDelay
+	}
+	if cfg.MaxRetryDelay <= 0 {
+		cfg.MaxRetryDelay = defaultMaxRetryDelay
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.MaxBacklog <= 0 {
+		cfg.MaxBacklog = defaultMaxBacklog
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultFlushInterval
+	}
+
 	return &UrlDeleteService{
 		storage: storage,
-		chJob:   make(chan job, 10),
+		queue:   queue,
+		cfg:     cfg,
+		notify:  make(chan struct{}, 1),
+		stop:    make(chan struct{}),
 		wg:      &sync.WaitGroup{},
 	}
 }
 
 // Run starts the service.
 func (d *UrlDeleteService) Run(threadWork int) {
-	type keyUserID string
-
 	d.wg.Add(threadWork)
-
-	k := keyUserID("userID")
+	d.workers.Add(int32(threadWork))
 
 	for i := 0; i < threadWork; i++ {
 		go func() {
+			defer d.workers.Add(-1)
 			defer d.wg.Done()
 
-			for j := range d.chJob {
-				ctxWithCancel, cancel := context.WithTimeout(
-					context.WithValue(context.Background(), k, j.userID),
-					10*time.Second,
-				)
-
-				if err := d.storage.Delete(ctxWithCancel, j.url); err != nil {
-					slog.Error(err.Error())
-				}
-				cancel()
-			}
+			d.work()
 		}()
 	}
 }
 
-// Delete fills the channel that workers listen to.
-func (d *UrlDeleteService) Delete(items []string, userID string) {
+// Begin registers a pending deletion operation of the user and returns its identifier.
+func (d *UrlDeleteService) Begin(ctx context.Context, userID string, requested int) (string, error) {
+	id, err := newOperationID()
+	if err != nil {
+		return "", err
+	}
+
+	op := storage.DeleteOperation{
+		ID:        id,
+		UserID:    userID,
+		Requested: requested,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if err := d.queue.SaveDeleteOperation(ctx, op); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// Delete persists the jobs of the operation in the queue and wakes up the workers.
+// The skipped identifiers are recorded in the operation. The operation is not tracked if opID is empty.
+// The span from the context is linked to the spans of the jobs.
+func (d *UrlDeleteService) Delete(ctx context.Context, opID, userID string, items, skipped []string) error {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+
+	jobs := make([]storage.DeleteJob, 0, len(items))
 	for _, el := range items {
-		d.chJob <- job{el, userID}
+		jobs = append(jobs, storage.DeleteJob{
+			UserID:      userID,
+			ShortURL:    el,
+			TraceParent: carrier.Get("traceparent"),
+			OperationID: opID,
+		})
+	}
+
+	if len(jobs) > 0 {
+		if err := d.queue.EnqueueDeleteJobs(ctx, jobs); err != nil {
+			return err
+		}
 	}
+
+	if opID != "" {
+		op, err := d.queue.GetDeleteOperation(ctx, opID)
+		if err != nil {
+			return err
+		}
+
+		op.Planned, op.Skipped = true, skipped
+		if err := d.queue.SaveDeleteOperation(ctx, op); err != nil {
+			return err
+		}
+	}
+
+	d.wakeUp()
+	return nil
+}
+
+// Abort marks the operation as failed when its jobs could not be queued.
+func (d *UrlDeleteService) Abort(ctx context.Context, opID string, reason error) error {
+	op, err := d.queue.GetDeleteOperation(ctx, opID)
+	if err != nil {
+		return err
+	}
+
+	op.Error = reason.Error()
+	return d.queue.SaveDeleteOperation(ctx, op)
+}
+
+// Operation returns the deletion operation with the counters of its jobs.
+func (d *UrlDeleteService) Operation(ctx context.Context, opID string) (storage.DeleteOperation, error) {
+	return d.queue.GetDeleteOperation(ctx, opID)
+}
+
+// Workers returns the number of running workers.
+func (d *UrlDeleteService) Workers() int {
+	return int(d.workers.Load())
+}
+
+// Backlog returns the number of pending jobs.
+func (d *UrlDeleteService) Backlog(ctx context.Context) (int, error) {
+	return d.queue.CountDeleteJobs(ctx, storage.JobPending)
 }
 
-// Stop stops the service.
+// MaxBacklog returns the number of pending jobs above which the service is considered overloaded.
+func (d *UrlDeleteService) MaxBacklog() int {
+	return d.cfg.MaxBacklog
+}
+
+// Jobs lists up to limit jobs in the given state, for example the dead-letter ones.
+func (d *UrlDeleteService) Jobs(ctx context.Context, state string, limit int) ([]storage.DeleteJob, error) {
+	return d.queue.DeleteJobs(ctx, state, limit)
+}
+
+// Stop stops the service after all due jobs are done.
 func (d *UrlDeleteService) Stop() {
-	close(d.chJob)
-	d.wg.Wait()
+	_ = d.Shutdown(context.Background())
+}
+
+// Shutdown asks the workers to stop once there are no due jobs left and waits for them
+// or until the context is done. Jobs waiting for a retry stay in the queue.
+func (d *UrlDeleteService) Shutdown(ctx context.Context) error {
+	d.stopOnce.Do(func() { close(d.stop) })
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *UrlDeleteService) wakeUp() {
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (d *UrlDeleteService) work() {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		jobs := d.claim(d.cfg.BatchSize)
+
+		if len(jobs) > 0 {
+			d.process(d.fill(jobs))
+			continue
+		}
+
+		select {
+		case <-d.stop:
+			return
+		default:
+		}
+
+		select {
+		case <-d.stop:
+			return
+		case <-d.notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *UrlDeleteService) claim(limit int) []storage.DeleteJob {
+	jobs, err := d.queue.ClaimDeleteJobs(context.Background(), time.Now(), limit, jobTimeout)
+	if err != nil {
+		slog.Error(fmt.Sprintf("deleteurl.ClaimDeleteJobs: %v", err))
+	}
+
+	return jobs
+}
+
+// fill waits for more jobs until the batch is full, the flush interval has passed or the service is stopped.
+func (d *UrlDeleteService) fill(jobs []storage.DeleteJob) []storage.DeleteJob {
+	if len(jobs) >= d.cfg.BatchSize {
+		return jobs
+	}
+
+	timer := time.NewTimer(d.cfg.FlushInterval)
+	defer timer.Stop()
+
+	for len(jobs) < d.cfg.BatchSize {
+		select {
+		case <-timer.C:
+			return jobs
+		case <-d.stop:
+			return jobs
+		case <-d.notify:
+			jobs = append(jobs, d.claim(d.cfg.BatchSize-len(jobs))...)
+		}
+	}
+
+	return jobs
+}
+
+// process applies the jobs to the data store with one call per user.
+func (d *UrlDeleteService) process(jobs []storage.DeleteJob) {
+	users := make([]string, 0)
+	byUser := make(map[string][]storage.DeleteJob)
+
+	for _, j := range jobs {
+		if _, ok := byUser[j.UserID]; !ok {
+			users = append(users, j.UserID)
+		}
+		byUser[j.UserID] = append(byUser[j.UserID], j)
+	}
+
+	for _, userID := range users {
+		d.processBatch(userID, byUser[userID])
+	}
+}
+
+func (d *UrlDeleteService) processBatch(userID string, jobs []storage.DeleteJob) {
+	ctxWithCancel, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	defer cancel()
+
+	links := make([]trace.Link, 0, len(jobs))
+	ids := make([]int64, 0, len(jobs))
+	shortURLs := make([]string, 0, len(jobs))
+
+	for _, j := range jobs {
+		parent := propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier{"traceparent": j.TraceParent})
+		if sc := trace.SpanContextFromContext(parent); sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+
+		ids = append(ids, j.ID)
+		shortURLs = append(shortURLs, j.ShortURL)
+	}
+
+	ctx, span := tracer.Start(ctxWithCancel, "deleteurl.batch",
+		trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("batch.size", len(jobs))),
+	)
+	defer span.End()
+
+	err := d.storage.DeleteBatch(ctx, userID, shortURLs)
+	if err == nil {
+		if err := d.queue.CompleteDeleteJobs(ctx, ids); err != nil {
+			slog.Error(fmt.Sprintf("deleteurl.CompleteDeleteJobs: %v", err))
+		}
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+
+	for _, j := range jobs {
+		d.fail(ctx, j, err)
+	}
+}
+
+// fail schedules the next attempt of the job or moves it to the dead-letter state.
+func (d *UrlDeleteService) fail(ctx context.Context, j storage.DeleteJob, err error) {
+	if j.Attempts >= d.cfg.MaxAttempts || errors.Is(err, storage.ErrNotFoundURL) {
+		slog.Error(fmt.Sprintf("deleteurl: job %d is dead after %d attempts: %v", j.ID, j.Attempts, err))
+
+		if err := d.queue.BuryDeleteJob(ctx, j.ID, err.Error()); err != nil {
+			slog.Error(fmt.Sprintf("deleteurl.BuryDeleteJob: %v", err))
+		}
+		return
+	}
+
+	slog.Warn(fmt.Sprintf("deleteurl: job %d failed on attempt %d: %v", j.ID, j.Attempts, err))
+
+	nextRunAt := time.Now().Add(d.backoff(j.Attempts))
+	if err := d.queue.RetryDeleteJob(ctx, j.ID, nextRunAt, err.Error()); err != nil {
+		slog.Error(fmt.Sprintf("deleteurl.RetryDeleteJob: %v", err))
+	}
+}
+
+// backoff returns the delay after the given failed attempt.
+func (d *UrlDeleteService) backoff(attempt int) time.Duration {
+	delay := d.cfg.RetryDelay
+	for i := 1; i < attempt && delay < d.cfg.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > d.cfg.MaxRetryDelay {
+		delay = d.cfg.MaxRetryDelay
+	}
+
+	return delay
+}
+
+func newOperationID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func newMemQueue() storage.JobQueue {
+	q, _ := storage.NewJournalQueue("")
+	return q
 }
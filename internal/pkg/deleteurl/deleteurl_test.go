@@ -0,0 +1,191 @@
+package deleteurl
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go-shortener-url/internal/storage"
+)
+
+type failingStorage struct {
+	*storage.MemStorage
+	failures map[string]int
+	batches  []int
+	mu       sync.Mutex
+}
+
+func (f *failingStorage) DeleteBatch(ctx context.Context, userID string, shortURLs []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.batches = append(f.batches, len(shortURLs))
+
+	for _, v := range shortURLs {
+		if f.failures[v] > 0 {
+			f.failures[v]--
+			return errors.New("storage is unavailable")
+		}
+	}
+
+	return f.MemStorage.DeleteBatch(ctx, userID, shortURLs)
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		attempts int
+		want     string
+		wantErr  error
+	}{
+		{
+			name:     "done after retries",
+			failures: 2,
+			attempts: 3,
+			want:     storage.JobDone,
+			wantErr:  storage.ErrDeletedURL,
+		},
+		{
+			name:     "dead after max attempts",
+			failures: 10,
+			attempts: 3,
+			want:     storage.JobDead,
+			wantErr:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			store := &failingStorage{
+				MemStorage: storage.NewMemStorage(),
+				failures:   map[string]int{"http://localhost:8080/a": tt.failures},
+			}
+			require.NoError(t, store.Put(ctx,
+				storage.Link{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com"}, false))
+
+			d := InitUrlDeleteService(store, nil, Config{
+				MaxAttempts:  3,
+				RetryDelay:   time.Millisecond,
+				PollInterval: time.Millisecond,
+			})
+			d.Run(1)
+			defer d.Stop()
+
+			require.NoError(t, d.Delete(ctx, "", "1", []string{"http://localhost:8080/a"}, nil))
+
+			require.Eventually(t, func() bool {
+				jobs, err := d.Jobs(ctx, tt.want, 10)
+				return err == nil && len(jobs) == 1
+			}, time.Second, time.Millisecond)
+
+			jobs, err := d.Jobs(ctx, tt.want, 10)
+			require.NoError(t, err)
+			assert.Equal(t, tt.attempts, jobs[0].Attempts)
+
+			_, err = store.Get(ctx, "http://localhost:8080/a")
+			assert.Equal(t, tt.wantErr, err)
+		})
+	}
+}
+
+func TestBatching(t *testing.T) {
+	ctx := context.Background()
+
+	store := &failingStorage{MemStorage: storage.NewMemStorage()}
+
+	require.NoError(t, store.Put(ctx,
+		storage.Link{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com/a"}, false))
+
+	var first []string
+	for _, id := range []string{"b", "c", "d", "e"} {
+		shortURL := "http://localhost:8080/" + id
+		require.NoError(t, store.Put(ctx,
+			storage.Link{UserID: "1", ShortURL: shortURL, OriginalURL: "http://example.com/" + id}, false))
+		first = append(first, shortURL)
+	}
+	require.NoError(t, store.Put(ctx,
+		storage.Link{UserID: "2", ShortURL: "http://localhost:8080/f", OriginalURL: "http://example.com/f"}, false))
+
+	d := InitUrlDeleteService(store, nil, Config{
+		BatchSize:     10,
+		FlushInterval: time.Millisecond,
+		PollInterval:  time.Millisecond,
+	})
+
+	require.NoError(t, d.Delete(ctx, "", "1", first, nil))
+	// The URL of another user is not deleted even if it reaches the batch.
+	require.NoError(t, d.Delete(ctx, "", "2", []string{"http://localhost:8080/f", "http://localhost:8080/a"}, nil))
+
+	d.Run(1)
+	d.Stop()
+
+	assert.Equal(t, []int{4, 2}, store.batches)
+
+	jobs, err := d.Jobs(ctx, storage.JobDone, 10)
+	require.NoError(t, err)
+	assert.Len(t, jobs, 6)
+
+	for _, v := range append(first, "http://localhost:8080/f") {
+		_, err := store.Get(ctx, v)
+		assert.ErrorIs(t, err, storage.ErrDeletedURL)
+	}
+
+	orig, err := store.Get(ctx, "http://localhost:8080/a")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/a", orig.OriginalURL)
+}
+
+func TestJournalRecovery(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "jobs")
+
+	queue, err := storage.NewJournalQueue(path)
+	require.NoError(t, err)
+
+	store := storage.NewMemStorage()
+	require.NoError(t, store.Put(ctx,
+		storage.Link{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com"}, false))
+
+	// The service is not started, so the job stays in the journal.
+	d := InitUrlDeleteService(store, queue, Config{})
+	opID, err := d.Begin(ctx, "1", 2)
+	require.NoError(t, err)
+	require.NoError(t, d.Delete(ctx, opID, "1", []string{"http://localhost:8080/a"}, []string{"b"}))
+	require.NoError(t, queue.Close())
+
+	queue, err = storage.NewJournalQueue(path)
+	require.NoError(t, err)
+	defer queue.Close()
+
+	d = InitUrlDeleteService(store, queue, Config{PollInterval: time.Millisecond})
+	backlog, err := d.Backlog(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, backlog)
+
+	d.Run(1)
+	d.Stop()
+
+	_, err = store.Get(ctx, "http://localhost:8080/a")
+	assert.ErrorIs(t, err, storage.ErrDeletedURL)
+
+	backlog, err = d.Backlog(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, backlog)
+
+	op, err := d.Operation(ctx, opID)
+	require.NoError(t, err)
+	assert.Equal(t, storage.OperationDone, op.Status)
+	assert.Equal(t, "1", op.UserID)
+	assert.Equal(t, 2, op.Requested)
+	assert.Equal(t, 1, op.Done)
+	assert.Equal(t, []string{"b"}, op.Skipped)
+}
@@ -0,0 +1,249 @@
+// Package domainlist loads the allow and deny lists of domains from a file and reloads the file
+// when it changes. Every line of the file is a rule "allow <domain>" or "deny <domain>",
+// the empty lines and the lines starting with "#" are ignored:
+//
+//	# partners
+//	allow example.com
+//	deny ads.example.com
+//
+// A domain matches itself and its subdomains. The deny list takes precedence over the allow list,
+// all hosts are allowed if the allow list is empty.
+package domainlist
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// ErrInvalidList is returned if the file has an invalid rule.
+var ErrInvalidList = errors.New("invalid domain list")
+
+const defaultInterval = 10 * time.Second
+
+// List is the allow and deny lists loaded from the file, it is safe for concurrent use.
+type List struct {
+	path string
+
+	mu      sync.RWMutex
+	allow   map[string]bool
+	deny    map[string]bool
+	modTime time.Time
+	size    int64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// Open loads the lists from the file.
+func Open(path string) (*List, error) {
+	l := &List{path: path, stop: make(chan struct{})}
+	if _, err := l.Reload(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Reload loads the file again if its size or the time of modification changed and reports whether it did.
+// The current lists are kept if the file cannot be read or is invalid.
+func (l *List) Reload() (bool, error) {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return false, err
+	}
+
+	l.mu.RLock()
+	changed := l.allow == nil || !info.ModTime().Equal(l.modTime) || info.Size() != l.size
+	l.mu.RUnlock()
+
+	if !changed {
+		return false, nil
+	}
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	allow, deny, err := parse(f)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", l.path, err)
+	}
+
+	l.mu.Lock()
+	l.allow, l.deny = allow, deny
+	l.modTime, l.size = info.ModTime(), info.Size()
+	l.mu.Unlock()
+
+	return true, nil
+}
+
+// Run checks the file for changes at the interval in the background, the default interval is 10 seconds.
+func (l *List) Run(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	l.wg.Add(1)
+
+	go func() {
+		defer l.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-l.stop:
+				return
+			case <-ticker.C:
+			}
+
+			reloaded, err := l.Reload()
+			if err != nil {
+				slog.Error(fmt.Sprintf("domainlist.Reload: %v", err))
+				continue
+			}
+
+			if reloaded {
+				allow, deny := l.Len()
+				slog.Info(fmt.Sprintf("domainlist: reloaded %s allow=%d deny=%d", l.path, allow, deny))
+			}
+		}
+	}()
+}
+
+// Shutdown stops checking the file for changes.
+func (l *List) Shutdown(ctx context.Context) error {
+	l.stopOnce.Do(func() { close(l.stop) })
+
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Len returns the number of the allowed and the denied domains.
+func (l *List) Len() (int, int) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return len(l.allow), len(l.deny)
+}
+
+// Denied reports whether the host or one of its parent domains is denied.
+func (l *List) Denied(host string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return match(l.deny, host)
+}
+
+// Allowed reports whether the allow list is empty or contains the host or one of its parent domains.
+func (l *List) Allowed(host string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return len(l.allow) == 0 || match(l.allow, host)
+}
+
+// match reports whether the host or one of its parent domains is in the list, the IP addresses match exactly.
+func match(domains map[string]bool, host string) bool {
+	host = normalize(host)
+	if _, err := netip.ParseAddr(host); err == nil {
+		return domains[host]
+	}
+
+	for host != "" {
+		if domains[host] {
+			return true
+		}
+
+		_, host, _ = strings.Cut(host, ".")
+	}
+
+	return false
+}
+
+func parse(r io.Reader) (map[string]bool, map[string]bool, error) {
+	allow, deny := make(map[string]bool), make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("%w: line %d: want \"allow <domain>\" or \"deny <domain>\"", ErrInvalidList, n)
+		}
+
+		domain := normalize(fields[1])
+		if !isDomain(domain) {
+			return nil, nil, fmt.Errorf("%w: line %d: invalid domain %q", ErrInvalidList, n, fields[1])
+		}
+
+		switch strings.ToLower(fields[0]) {
+		case "allow":
+			allow[domain] = true
+		case "deny":
+			deny[domain] = true
+		default:
+			return nil, nil, fmt.Errorf("%w: line %d: unknown rule %q", ErrInvalidList, n, fields[0])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return allow, deny, nil
+}
+
+// normalize lowercases the domain and removes the trailing dot of the fully qualified names.
+func normalize(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(domain), ".")
+}
+
+// isDomain reports whether s is a domain name or an IP address literal, such as "example.com" or "10.1.2.3".
+func isDomain(s string) bool {
+	if s == "" || len(s) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
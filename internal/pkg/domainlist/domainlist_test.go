@@ -0,0 +1,90 @@
+package domainlist
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domains")
+	require.NoError(t, os.WriteFile(path, []byte(`# partners
+allow example.com
+allow Example.org.
+
+deny ads.example.com
+DENY 10.1.2.3
+`), 0o600))
+
+	l, err := Open(path)
+	require.NoError(t, err)
+
+	tests := []struct {
+		host    string
+		allowed bool
+		denied  bool
+	}{
+		{host: "example.com", allowed: true},
+		{host: "www.EXAMPLE.com", allowed: true},
+		{host: "example.org.", allowed: true},
+		{host: "ads.example.com", allowed: true, denied: true},
+		{host: "x.ads.example.com", allowed: true, denied: true},
+		{host: "badexample.com"},
+		{host: "com"},
+		{host: "10.1.2.3", denied: true},
+		{host: "1.2.3"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.allowed, l.Allowed(tt.host), tt.host)
+		assert.Equal(t, tt.denied, l.Denied(tt.host), tt.host)
+	}
+
+	// The unchanged file is not loaded again.
+	reloaded, err := l.Reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded)
+
+	// The invalid file is not loaded, the current lists are kept.
+	require.NoError(t, os.WriteFile(path, []byte("allow example.com\nblock example.net\n"), 0o600))
+	_, err = l.Reload()
+	assert.ErrorIs(t, err, ErrInvalidList)
+	assert.True(t, l.Denied("ads.example.com"))
+
+	// Without the allow list, all hosts not denied are allowed.
+	require.NoError(t, os.WriteFile(path, []byte("deny example.net\n"), 0o600))
+	reloaded, err = l.Reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.True(t, l.Allowed("example.org"))
+	assert.True(t, l.Denied("www.example.net"))
+	assert.False(t, l.Denied("ads.example.com"))
+
+	for _, content := range []string{"allow", "allow a b", "permit example.com", "deny exa mple.com", "deny -example.com", "allow example..com"} {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		_, err = Open(path)
+		assert.ErrorIs(t, err, ErrInvalidList, content)
+	}
+
+	_, err = Open(filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestListRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domains")
+	require.NoError(t, os.WriteFile(path, []byte("deny example.com\n"), 0o600))
+
+	l, err := Open(path)
+	require.NoError(t, err)
+
+	l.Run(10 * time.Millisecond)
+	defer func() { require.NoError(t, l.Shutdown(context.Background())) }()
+
+	require.NoError(t, os.WriteFile(path, []byte("deny example.com\ndeny example.net\n"), 0o600))
+	assert.Eventually(t, func() bool { return l.Denied("example.net") }, time.Second, 10*time.Millisecond)
+}
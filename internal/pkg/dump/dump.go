@@ -0,0 +1,236 @@
+// Package dump describes the export of a data store to a portable dump and the import of a dump into a data store.
+// The dump is a JSON lines, JSON array or CSV file with a record per shortened URL: its owner, the original URL,
+// the deletion mark and the time of deletion. The records are ordered by the shortened URL,
+// so an interrupted export can be resumed after the last written record.
+// The export can be limited to the records of one user.
+package dump
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"go-shortener-url/internal/storage"
+)
+
+// Conflict policies of the import for shortened URLs that already exist in the data store.
+const (
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictFail      = "fail"
+)
+
+const defaultProgressEvery = 1000
+
+// Errors of the import.
+var (
+	ErrUnknownConflict = errors.New("unknown conflict policy")
+	ErrConflict        = errors.New("shortened URL already exists")
+)
+
+// Stats contains the counters of the export or the import.
+type Stats struct {
+	// Processed is the number of records read from the data store or the dump.
+	Processed int
+	// Written is the number of records written to the dump or the data store.
+	Written int
+	// Skipped is the number of records not imported because of a conflict.
+	Skipped int
+}
+
+// Options contains the settings of the export and the import.
+type Options struct {
+	// Format is the format of the dump, FormatJSONL by default.
+	Format string
+	// Conflict is the conflict policy of the import, ConflictFail by default.
+	Conflict string
+	// After is the shortened URL after which the export starts, the CSV header is written only if it is empty.
+	// It is not used with UserID.
+	After string
+	// UserID limits the export to the records of the user.
+	UserID string
+	// Skip is the number of records at the beginning of the dump that were imported before.
+	Skip int
+	// ProgressEvery is the number of processed records between calls to Progress, 1000 by default.
+	ProgressEvery int
+	// Progress is called with the counters periodically and at the end, an error stops the process.
+	Progress func(Stats) error
+}
+
+func (o *Options) setDefaults() {
+	if o.Format == "" {
+		o.Format = FormatJSONL
+	}
+	if o.Conflict == "" {
+		o.Conflict = ConflictFail
+	}
+	if o.ProgressEvery <= 0 {
+		o.ProgressEvery = defaultProgressEvery
+	}
+	if o.Progress == nil {
+		o.Progress = func(Stats) error { return nil }
+	}
+}
+
+// Export writes the records of the data store to the dump.
+func Export(ctx context.Context, store storage.Storage, w io.Writer, opts Options) (Stats, error) {
+	opts.setDefaults()
+
+	var stats Stats
+
+	enc, err := NewEncoder(w, opts.Format, opts.After == "")
+	if err != nil {
+		return stats, err
+	}
+
+	fn := func(rec storage.Link) error {
+		stats.Processed++
+
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+		stats.Written++
+
+		if stats.Processed%opts.ProgressEvery == 0 {
+			if err := enc.Flush(); err != nil {
+				return err
+			}
+			return opts.Progress(stats)
+		}
+
+		return nil
+	}
+
+	if opts.UserID != "" {
+		err = store.WalkByUser(ctx, opts.UserID, fn)
+	} else {
+		err = store.Walk(ctx, opts.After, fn)
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	if err := enc.Close(); err != nil {
+		return stats, err
+	}
+
+	return stats, opts.Progress(stats)
+}
+
+// Import saves the records of the dump to the data store, resolving conflicts according to the policy.
+// Conflicts of the original URL that cannot be overwritten are handled as with ConflictFail.
+// On error, the counters include only the records that were handled before it.
+// The records are written in one batch if the data store is a storage.Batcher.
+func Import(ctx context.Context, store storage.Storage, r io.Reader, opts Options) (Stats, error) {
+	b, ok := store.(storage.Batcher)
+	if !ok {
+		return importRecords(ctx, store, r, opts)
+	}
+
+	b.BeginBatch()
+	stats, err := importRecords(ctx, store, r, opts)
+
+	return stats, errors.Join(err, b.EndBatch())
+}
+
+func importRecords(ctx context.Context, store storage.Storage, r io.Reader, opts Options) (Stats, error) {
+	opts.setDefaults()
+
+	var stats Stats
+
+	switch opts.Conflict {
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+	default:
+		return stats, fmt.Errorf("%w: %q", ErrUnknownConflict, opts.Conflict)
+	}
+
+	dec, err := NewDecoder(r, opts.Format)
+	if err != nil {
+		return stats, err
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		rec, err := dec.Decode()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return stats, fmt.Errorf("record %d: %w", stats.Processed+1, err)
+		}
+
+		if stats.Processed < opts.Skip {
+			stats.Processed++
+			continue
+		}
+
+		err = store.Put(ctx, rec, opts.Conflict == ConflictOverwrite)
+		switch {
+		case errors.Is(err, storage.ErrUniqueValue) && opts.Conflict == ConflictSkip:
+			stats.Skipped++
+		case errors.Is(err, storage.ErrUniqueValue):
+			return stats, fmt.Errorf("record %d: %w: %s", stats.Processed+1, ErrConflict, rec.ShortURL)
+		case err != nil:
+			return stats, fmt.Errorf("record %d: %w", stats.Processed+1, err)
+		default:
+			stats.Written++
+		}
+
+		stats.Processed++
+
+		if stats.Processed%opts.ProgressEvery == 0 {
+			if err := opts.Progress(stats); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	return stats, opts.Progress(stats)
+}
+
+// Tail reads the JSON lines or CSV dump and returns the shortened URL of the last complete record, the number of records
+// and the size of the complete part of the dump. A partly written last line is not counted,
+// the dump should be truncated to the size before it is appended.
+func Tail(r io.Reader, format string) (string, int, int64, error) {
+	var (
+		last   string
+		count  int
+		offset int64
+	)
+
+	rd := bufio.NewReader(r)
+	for {
+		line, err := rd.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			// A line without the trailing newline is incomplete.
+			return last, count, offset, nil
+		} else if err != nil {
+			return "", 0, 0, err
+		}
+
+		dec, err := NewDecoder(strings.NewReader(line), format)
+		if err != nil {
+			return "", 0, 0, err
+		}
+
+		rec, err := dec.Decode()
+		if errors.Is(err, io.EOF) {
+			// The CSV header.
+			offset += int64(len(line))
+			continue
+		} else if err != nil {
+			if _, errPeek := rd.Peek(1); errPeek == nil {
+				return "", 0, 0, fmt.Errorf("record %d: %w", count+1, err)
+			}
+			return last, count, offset, nil
+		}
+
+		last, count = rec.ShortURL, count+1
+		offset += int64(len(line))
+	}
+}
@@ -0,0 +1,256 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go-shortener-url/internal/storage"
+)
+
+func testRecords() []storage.Link {
+	return []storage.Link{
+		{
+			UserID:      "1",
+			ShortURL:    "http://localhost:8080/a",
+			OriginalURL: "http://example.com/?a=1,2",
+			CreatedAt:   time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC),
+			UpdatedAt:   time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC),
+			Title:       "Example, \"A\"",
+			Tags:        []string{"promo", "q3"},
+			Passthrough: true,
+			Rules:       []storage.Rule{{OS: "ios", URL: "https://apps.apple.com/app/id1"}, {Language: "de", URL: "http://example.com/de"}},
+		},
+		{
+			UserID:       "1",
+			ShortURL:     "http://localhost:8080/b",
+			OriginalURL:  "http://example.com/b",
+			Deleted:      true,
+			DeletedAt:    time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
+			CreatedAt:    time.Date(2023, 9, 2, 12, 0, 0, 0, time.UTC),
+			UpdatedAt:    time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
+			Notes:        "old version",
+			RedirectType: 301,
+			UTM:          storage.UTM{Source: "news", Campaign: "spring, 2023"},
+			Variants:     []storage.Variant{{URL: "http://example.com/b1", Weight: 70, Clicks: 7}, {URL: "http://example.com/b2", Weight: 30}},
+		},
+		{
+			UserID:      "2",
+			ShortURL:    "http://localhost:8080/c",
+			OriginalURL: "http://example.com/c",
+			Deleted:     true,
+			CreatedAt:   time.Date(2023, 9, 3, 12, 0, 0, 0, time.UTC),
+			UpdatedAt:   time.Date(2023, 9, 3, 12, 0, 0, 0, time.UTC),
+			Threat:      "phishing",
+		},
+	}
+}
+
+func walk(t *testing.T, store storage.Storage) []storage.Link {
+	var rst []storage.Link
+	require.NoError(t, store.Walk(context.Background(), "", func(rec storage.Link) error {
+		rst = append(rst, rec)
+		return nil
+	}))
+
+	return rst
+}
+
+func TestExportImport(t *testing.T) {
+	for _, format := range []string{FormatJSONL, FormatJSON, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			ctx := context.Background()
+
+			src := storage.NewFileStorage(ctx, filepath.Join(t.TempDir(), "urls"))
+			defer src.Close()
+
+			for _, rec := range testRecords() {
+				require.NoError(t, src.Put(ctx, rec, false))
+			}
+
+			var (
+				buf      bytes.Buffer
+				progress []int
+			)
+
+			stats, err := Export(ctx, src, &buf, Options{
+				Format:        format,
+				ProgressEvery: 2,
+				Progress: func(s Stats) error {
+					progress = append(progress, s.Written)
+					return nil
+				},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, Stats{Processed: 3, Written: 3}, stats)
+			assert.Equal(t, []int{2, 3}, progress)
+
+			dst := storage.NewMemStorage()
+			stats, err = Import(ctx, dst, &buf, Options{Format: format})
+			require.NoError(t, err)
+			assert.Equal(t, Stats{Processed: 3, Written: 3}, stats)
+			assert.Equal(t, testRecords(), walk(t, dst))
+		})
+	}
+}
+
+func TestExportUser(t *testing.T) {
+	ctx := context.Background()
+
+	src := storage.NewMemStorage()
+	for _, rec := range testRecords() {
+		require.NoError(t, src.Put(ctx, rec, false))
+	}
+
+	tests := []struct {
+		name   string
+		userID string
+		want   string
+	}{
+		{
+			name:   "deleted URLs are included",
+			userID: "1",
+			want: `[
+{"user_id":"1","short_url":"http://localhost:8080/a","original_url":"http://example.com/?a=1,2","deleted":false,"created_at":"2023-09-01T12:00:00Z","updated_at":"2023-09-01T12:00:00Z","title":"Example, \"A\"","tags":["promo","q3"],"passthrough":true,"rules":[{"os":"ios","url":"https://apps.apple.com/app/id1"},{"language":"de","url":"http://example.com/de"}]},
+{"user_id":"1","short_url":"http://localhost:8080/b","original_url":"http://example.com/b","deleted":true,"deleted_at":"2023-10-01T12:00:00Z","created_at":"2023-09-02T12:00:00Z","updated_at":"2023-10-01T12:00:00Z","notes":"old version","redirect_type":301,"utm":{"source":"news","campaign":"spring, 2023"},"variants":[{"url":"http://example.com/b1","weight":70,"clicks":7},{"url":"http://example.com/b2","weight":30}]}
+]
+`,
+		},
+		{
+			name:   "no URLs",
+			userID: "3",
+			want:   "[]\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			_, err := Export(ctx, src, &buf, Options{Format: FormatJSON, UserID: tt.userID})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestImportConflict(t *testing.T) {
+	tests := []struct {
+		name     string
+		conflict string
+		want     Stats
+		wantErr  error
+		wantOrig string
+	}{
+		{
+			name:     "skip",
+			conflict: ConflictSkip,
+			want:     Stats{Processed: 3, Written: 2, Skipped: 1},
+			wantOrig: "http://example.com/old",
+		},
+		{
+			name:     "overwrite",
+			conflict: ConflictOverwrite,
+			want:     Stats{Processed: 3, Written: 3},
+			wantOrig: "http://example.com/?a=1,2",
+		},
+		{
+			name:     "fail",
+			conflict: ConflictFail,
+			want:     Stats{},
+			wantErr:  ErrConflict,
+			wantOrig: "http://example.com/old",
+		},
+		{
+			name:     "unknown policy",
+			conflict: "merge",
+			wantErr:  ErrUnknownConflict,
+			wantOrig: "http://example.com/old",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			var buf bytes.Buffer
+			enc, err := NewEncoder(&buf, FormatJSONL, false)
+			require.NoError(t, err)
+			for _, rec := range testRecords() {
+				require.NoError(t, enc.Encode(rec))
+			}
+
+			store := storage.NewMemStorage()
+			require.NoError(t, store.Put(ctx,
+				storage.Link{UserID: "3", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com/old"}, false))
+
+			stats, err := Import(ctx, store, &buf, Options{Conflict: tt.conflict})
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, stats)
+
+			link, err := store.Get(ctx, "http://localhost:8080/a")
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantOrig, link.OriginalURL)
+		})
+	}
+}
+
+func TestResume(t *testing.T) {
+	for _, format := range []string{FormatJSONL, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			ctx := context.Background()
+
+			src := storage.NewMemStorage()
+			for _, rec := range testRecords() {
+				require.NoError(t, src.Put(ctx, rec, false))
+			}
+
+			var full bytes.Buffer
+			_, err := Export(ctx, src, &full, Options{Format: format})
+			require.NoError(t, err)
+
+			// The export is interrupted in the middle of the last line.
+			lines := strings.SplitAfter(full.String(), "\n")
+			partial := strings.Join(lines[:len(lines)-2], "") + lines[len(lines)-2][:5]
+
+			after, count, size, err := Tail(strings.NewReader(partial), format)
+			require.NoError(t, err)
+			assert.Equal(t, "http://localhost:8080/b", after)
+			assert.Equal(t, 2, count)
+
+			resumed := bytes.NewBufferString(partial[:size])
+			_, err = Export(ctx, src, resumed, Options{Format: format, After: after})
+			require.NoError(t, err)
+			assert.Equal(t, full.String(), resumed.String())
+
+			// The import is resumed after the first record.
+			dst := storage.NewMemStorage()
+			stats, err := Import(ctx, dst, resumed, Options{Format: format, Skip: 1})
+			require.NoError(t, err)
+			assert.Equal(t, Stats{Processed: 3, Written: 2}, stats)
+			assert.Equal(t, testRecords()[1:], walk(t, dst))
+		})
+	}
+}
+
+func TestTailBrokenRecord(t *testing.T) {
+	_, _, _, err := Tail(strings.NewReader("{\"short_url\":1}\n{}\n"), FormatJSONL)
+	assert.Error(t, err)
+}
+
+func TestDecodeLegacyCSV(t *testing.T) {
+	dec, err := NewDecoder(strings.NewReader(
+		"user_id,short_url,original_url,deleted,deleted_at\n1,http://localhost:8080/a,http://example.com/a,false,\n",
+	), FormatCSV)
+	require.NoError(t, err)
+
+	rec, err := dec.Decode()
+	require.NoError(t, err)
+	assert.Equal(t, storage.Link{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com/a"}, rec)
+}
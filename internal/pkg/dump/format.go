@@ -0,0 +1,427 @@
+package dump
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-shortener-url/internal/storage"
+)
+
+// Supported formats of the dump: JSON lines, a JSON array and CSV.
+const (
+	FormatJSONL = "jsonl"
+	FormatJSON  = "json"
+	FormatCSV   = "csv"
+)
+
+// ErrUnknownFormat is returned for an unsupported format.
+var ErrUnknownFormat = errors.New("unknown dump format")
+
+var csvHeader = []string{"user_id", "short_url", "original_url", "deleted", "deleted_at", "created_at", "updated_at", "title", "notes", "tags", "redirect_type", "passthrough",
+	"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content", "rules", "variants", "threat"}
+
+// csvLegacyFields is the number of columns of the CSV dumps written before created_at was added,
+// the columns after it are optional.
+const csvLegacyFields = 5
+
+// Encoder writes records to the dump.
+type Encoder interface {
+	Encode(rec storage.Link) error
+	// Flush writes the buffered records.
+	Flush() error
+	// Close writes the buffered records and the end of the dump.
+	Close() error
+}
+
+// Decoder reads records from the dump, io.EOF is returned when there are no records left.
+type Decoder interface {
+	Decode() (storage.Link, error)
+}
+
+type entry struct {
+	UserID       string            `json:"user_id"`
+	ShortURL     string            `json:"short_url"`
+	OriginalURL  string            `json:"original_url"`
+	Deleted      bool              `json:"deleted"`
+	DeletedAt    *time.Time        `json:"deleted_at,omitempty"`
+	CreatedAt    *time.Time        `json:"created_at,omitempty"`
+	UpdatedAt    *time.Time        `json:"updated_at,omitempty"`
+	Title        string            `json:"title,omitempty"`
+	Notes        string            `json:"notes,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	RedirectType int               `json:"redirect_type,omitempty"`
+	Passthrough  bool              `json:"passthrough,omitempty"`
+	UTM          *storage.UTM      `json:"utm,omitempty"`
+	Rules        []storage.Rule    `json:"rules,omitempty"`
+	Variants     []storage.Variant `json:"variants,omitempty"`
+	Threat       string            `json:"threat,omitempty"`
+}
+
+// NewEncoder returns the encoder of the format. The CSV header is written if header is set.
+func NewEncoder(w io.Writer, format string, header bool) (Encoder, error) {
+	switch format {
+	case FormatJSONL:
+		return &jsonEncoder{enc: json.NewEncoder(w)}, nil
+	case FormatJSON:
+		return &jsonArrayEncoder{w: w}, nil
+	case FormatCSV:
+		enc := &csvEncoder{w: csv.NewWriter(w)}
+		if header {
+			if err := enc.w.Write(csvHeader); err != nil {
+				return nil, err
+			}
+		}
+		return enc, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+}
+
+// NewDecoder returns the decoder of the format. The CSV header is skipped if present.
+func NewDecoder(r io.Reader, format string) (Decoder, error) {
+	switch format {
+	case FormatJSONL:
+		return &jsonDecoder{dec: json.NewDecoder(r)}, nil
+	case FormatJSON:
+		return &jsonArrayDecoder{dec: json.NewDecoder(r)}, nil
+	case FormatCSV:
+		rd := csv.NewReader(r)
+		rd.FieldsPerRecord = -1
+		return &csvDecoder{r: rd}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+}
+
+type jsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *jsonEncoder) Encode(rec storage.Link) error {
+	return e.enc.Encode(newEntry(rec))
+}
+
+func (e *jsonEncoder) Flush() error {
+	return nil
+}
+
+func (e *jsonEncoder) Close() error {
+	return nil
+}
+
+type jsonDecoder struct {
+	dec *json.Decoder
+}
+
+func (d *jsonDecoder) Decode() (storage.Link, error) {
+	var v entry
+
+	if err := d.dec.Decode(&v); err != nil {
+		return storage.Link{}, err
+	}
+
+	return v.record()
+}
+
+// jsonArrayEncoder writes the records as the elements of a JSON array, one per line.
+type jsonArrayEncoder struct {
+	w     io.Writer
+	count int
+}
+
+func (e *jsonArrayEncoder) Encode(rec storage.Link) error {
+	data, err := json.Marshal(newEntry(rec))
+	if err != nil {
+		return err
+	}
+
+	prefix := ",\n"
+	if e.count == 0 {
+		prefix = "[\n"
+	}
+	e.count++
+
+	_, err = io.WriteString(e.w, prefix+string(data))
+	return err
+}
+
+func (e *jsonArrayEncoder) Flush() error {
+	return nil
+}
+
+func (e *jsonArrayEncoder) Close() error {
+	end := "\n]\n"
+	if e.count == 0 {
+		end = "[]\n"
+	}
+
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+type jsonArrayDecoder struct {
+	dec     *json.Decoder
+	started bool
+}
+
+func (d *jsonArrayDecoder) Decode() (storage.Link, error) {
+	if !d.started {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return storage.Link{}, err
+		}
+
+		if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			return storage.Link{}, errors.New("dump must be a JSON array")
+		}
+		d.started = true
+	}
+
+	if !d.dec.More() {
+		return storage.Link{}, io.EOF
+	}
+
+	var v entry
+	if err := d.dec.Decode(&v); err != nil {
+		return storage.Link{}, err
+	}
+
+	return v.record()
+}
+
+func newEntry(rec storage.Link) entry {
+	v := entry{
+		UserID:       rec.UserID,
+		ShortURL:     rec.ShortURL,
+		OriginalURL:  rec.OriginalURL,
+		Deleted:      rec.Deleted,
+		DeletedAt:    timeOrNil(rec.DeletedAt),
+		CreatedAt:    timeOrNil(rec.CreatedAt),
+		UpdatedAt:    timeOrNil(rec.UpdatedAt),
+		Title:        rec.Title,
+		Notes:        rec.Notes,
+		Tags:         rec.Tags,
+		RedirectType: rec.RedirectType,
+		Passthrough:  rec.Passthrough,
+		Rules:        rec.Rules,
+		Variants:     rec.Variants,
+		Threat:       rec.Threat,
+	}
+
+	if !rec.UTM.IsZero() {
+		utm := rec.UTM
+		v.UTM = &utm
+	}
+
+	return v
+}
+
+// timeOrNil returns nil for the zero time, so that it is omitted from JSON.
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	t = t.UTC()
+	return &t
+}
+
+func (v entry) record() (storage.Link, error) {
+	rec := storage.Link{
+		UserID:       v.UserID,
+		ShortURL:     v.ShortURL,
+		OriginalURL:  v.OriginalURL,
+		Deleted:      v.Deleted,
+		Title:        v.Title,
+		Notes:        v.Notes,
+		Tags:         v.Tags,
+		RedirectType: v.RedirectType,
+		Passthrough:  v.Passthrough,
+		Rules:        v.Rules,
+		Variants:     v.Variants,
+		Threat:       v.Threat,
+	}
+
+	if v.UTM != nil {
+		rec.UTM = *v.UTM
+	}
+
+	if v.DeletedAt != nil {
+		rec.DeletedAt = *v.DeletedAt
+	}
+
+	if v.CreatedAt != nil {
+		rec.CreatedAt = *v.CreatedAt
+	}
+
+	if v.UpdatedAt != nil {
+		rec.UpdatedAt = *v.UpdatedAt
+	}
+
+	return rec, validate(rec)
+}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) Encode(rec storage.Link) error {
+	rules, err := formatJSON(rec.Rules)
+	if err != nil {
+		return err
+	}
+
+	variants, err := formatJSON(rec.Variants)
+	if err != nil {
+		return err
+	}
+
+	return e.w.Write([]string{
+		rec.UserID, rec.ShortURL, rec.OriginalURL, strconv.FormatBool(rec.Deleted),
+		formatTime(rec.DeletedAt), formatTime(rec.CreatedAt), formatTime(rec.UpdatedAt), rec.Title, rec.Notes,
+		strings.Join(rec.Tags, ","), formatInt(rec.RedirectType), strconv.FormatBool(rec.Passthrough),
+		rec.UTM.Source, rec.UTM.Medium, rec.UTM.Campaign, rec.UTM.Term, rec.UTM.Content, rules, variants, rec.Threat,
+	})
+}
+
+// formatJSON formats the list for CSV as JSON, the empty list is written as an empty string.
+func formatJSON[T any](list []T) (string, error) {
+	if len(list) == 0 {
+		return "", nil
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// formatInt formats the number for CSV, zero is written as an empty string.
+func formatInt(v int) string {
+	if v == 0 {
+		return ""
+	}
+
+	return strconv.Itoa(v)
+}
+
+// formatTime formats the time for CSV, the zero time is written as an empty string.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) Close() error {
+	return e.Flush()
+}
+
+type csvDecoder struct {
+	r *csv.Reader
+}
+
+func (d *csvDecoder) Decode() (storage.Link, error) {
+	row, err := d.r.Read()
+	if err != nil {
+		return storage.Link{}, err
+	}
+
+	if len(row) < csvLegacyFields || len(row) > len(csvHeader) {
+		return storage.Link{}, fmt.Errorf("record has %d fields, want %d", len(row), len(csvHeader))
+	}
+
+	if row[0] == csvHeader[0] && row[1] == csvHeader[1] {
+		return d.Decode()
+	}
+
+	rec := storage.Link{UserID: row[0], ShortURL: row[1], OriginalURL: row[2]}
+
+	if rec.Deleted, err = strconv.ParseBool(row[3]); err != nil {
+		return storage.Link{}, fmt.Errorf("deleted: %w", err)
+	}
+
+	if row[4] != "" {
+		if rec.DeletedAt, err = time.Parse(time.RFC3339Nano, row[4]); err != nil {
+			return storage.Link{}, fmt.Errorf("deleted_at: %w", err)
+		}
+	}
+
+	// The optional columns are missing in the dumps of the previous versions.
+	row = append(row, make([]string, len(csvHeader)-len(row))...)
+
+	if rec.CreatedAt, err = parseTime(row[5]); err != nil {
+		return storage.Link{}, fmt.Errorf("created_at: %w", err)
+	}
+
+	if rec.UpdatedAt, err = parseTime(row[6]); err != nil {
+		return storage.Link{}, fmt.Errorf("updated_at: %w", err)
+	}
+
+	rec.Title, rec.Notes = row[7], row[8]
+
+	if row[9] != "" {
+		rec.Tags = strings.Split(row[9], ",")
+	}
+
+	if row[10] != "" {
+		if rec.RedirectType, err = strconv.Atoi(row[10]); err != nil {
+			return storage.Link{}, fmt.Errorf("redirect_type: %w", err)
+		}
+	}
+
+	if row[11] != "" {
+		if rec.Passthrough, err = strconv.ParseBool(row[11]); err != nil {
+			return storage.Link{}, fmt.Errorf("passthrough: %w", err)
+		}
+	}
+
+	rec.UTM = storage.UTM{Source: row[12], Medium: row[13], Campaign: row[14], Term: row[15], Content: row[16]}
+
+	if row[17] != "" {
+		if err = json.Unmarshal([]byte(row[17]), &rec.Rules); err != nil {
+			return storage.Link{}, fmt.Errorf("rules: %w", err)
+		}
+	}
+
+	if row[18] != "" {
+		if err = json.Unmarshal([]byte(row[18]), &rec.Variants); err != nil {
+			return storage.Link{}, fmt.Errorf("variants: %w", err)
+		}
+	}
+
+	rec.Threat = row[19]
+
+	return rec, validate(rec)
+}
+
+// parseTime parses the time written by formatTime.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func validate(rec storage.Link) error {
+	if rec.ShortURL == "" || rec.OriginalURL == "" {
+		return errors.New("short_url and original_url are required")
+	}
+
+	return nil
+}
@@ -0,0 +1,222 @@
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// Types of the fields of the data section.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// maxDepth limits the nesting of maps, arrays and pointers, so that a corrupted file cannot loop forever.
+const maxDepth = 32
+
+// uintSizes are the maximum sizes in bytes of the unsigned integer types.
+var uintSizes = map[int]uint{typeUint16: 2, typeUint32: 4, typeUint64: 8}
+
+var errOutOfBounds = errors.New("data exceeds the section")
+
+// decoder decodes the fields of the data section in buf. The maps are decoded as map[string]any,
+// the arrays as []any, the unsigned integers up to 64 bits as uint64 and uint128 as *big.Int.
+type decoder struct {
+	buf []byte
+}
+
+// decode returns the field at the offset and the offset of the next field.
+func (d decoder) decode(offset uint) (any, uint, error) {
+	return d.decodeDepth(offset, 0)
+}
+
+func (d decoder) decodeDepth(offset uint, depth int) (any, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, errors.New("data is nested too deep")
+	}
+
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		ptr, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		v, _, err := d.decodeDepth(ptr, depth+1)
+		return v, next, err
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			var k, v any
+			if k, offset, err = d.decodeDepth(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+
+			if v, offset, err = d.decodeDepth(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, size)
+		for i := uint(0); i < size; i++ {
+			var v any
+			if v, offset, err = d.decodeDepth(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+		}
+		return a, offset, nil
+	case typeBool:
+		if size > 1 {
+			return nil, 0, fmt.Errorf("bool of size %d", size)
+		}
+		return size == 1, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errOutOfBounds
+	}
+	b := d.buf[offset : offset+size]
+
+	switch typ {
+	case typeString:
+		return string(b), offset + size, nil
+	case typeBytes:
+		return append([]byte(nil), b...), offset + size, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("double of size %d", size)
+		}
+		return math.Float64frombits(readUint(b)), offset + size, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("float of size %d", size)
+		}
+		return math.Float32frombits(uint32(readUint(b))), offset + size, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > uintSizes[typ] {
+			return nil, 0, fmt.Errorf("unsigned integer of size %d", size)
+		}
+		return readUint(b), offset + size, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("int32 of size %d", size)
+		}
+		return int32(uint32(readUint(b))), offset + size, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, fmt.Errorf("uint128 of size %d", size)
+		}
+		return new(big.Int).SetBytes(b), offset + size, nil
+	default:
+		return nil, 0, fmt.Errorf("unexpected type %d", typ)
+	}
+}
+
+// control reads the control byte of the field at the offset and returns the type, the size
+// and the offset of the payload. For pointers, the size is the raw 5 bits of the control byte.
+func (d decoder) control(offset uint) (int, uint, uint, error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, 0, 0, errOutOfBounds
+	}
+
+	c := d.buf[offset]
+	offset++
+
+	typ := int(c >> 5)
+	if typ == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return 0, 0, 0, errOutOfBounds
+		}
+		typ = 7 + int(d.buf[offset])
+		offset++
+
+		if typ <= typeMap {
+			return 0, 0, 0, fmt.Errorf("invalid extended type %d", typ)
+		}
+	}
+
+	size := uint(c & 0x1f)
+	if typ == typePointer || size < 29 {
+		return typ, size, offset, nil
+	}
+
+	n := size - 28
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, 0, errOutOfBounds
+	}
+
+	v := uint(readUint(d.buf[offset : offset+n]))
+	switch size {
+	case 29:
+		size = 29 + v
+	case 30:
+		size = 285 + v
+	default:
+		size = 65821 + v
+	}
+
+	return typ, size, offset + n, nil
+}
+
+// pointer returns the offset the pointer refers to and the offset of the next field.
+func (d decoder) pointer(size, offset uint) (uint, uint, error) {
+	n := (size>>3)&0x3 + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errOutOfBounds
+	}
+
+	v := uint(readUint(d.buf[offset : offset+n]))
+	prefix := size & 0x7
+
+	switch n {
+	case 1:
+		v |= prefix << 8
+	case 2:
+		v = (v | prefix<<16) + 2048
+	case 3:
+		v = (v | prefix<<24) + 526336
+	}
+
+	return v, offset + n, nil
+}
+
+// readUint reads the big-endian unsigned integer of up to 8 bytes.
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+
+	return v
+}
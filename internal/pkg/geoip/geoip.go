@@ -0,0 +1,177 @@
+// Package geoip looks up the countries of IP addresses in a MaxMind DB (.mmdb) file,
+// such as GeoLite2-Country or GeoLite2-City. The file is read into memory once and used offline.
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// ErrInvalidDatabase is returned if the file is not a valid MaxMind DB.
+var ErrInvalidDatabase = errors.New("invalid MaxMind DB")
+
+// metadataMarker precedes the metadata at the end of the file.
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// Reader looks up the records of the IP addresses in the database, it is safe for concurrent use.
+type Reader struct {
+	buf        []byte
+	data       decoder
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// ipv4Start is the node of ::/96, where the IPv4 addresses start in an IPv6 database.
+	ipv4Start uint
+}
+
+// Open reads the database from the file.
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(buf)
+}
+
+// New returns the reader of the database in buf, buf must not be changed afterwards.
+func New(buf []byte) (*Reader, error) {
+	i := bytes.LastIndex(buf, metadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: no metadata", ErrInvalidDatabase)
+	}
+
+	v, _, err := decoder{buf: buf[i+len(metadataMarker):]}.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %v", ErrInvalidDatabase, err)
+	}
+
+	meta, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrInvalidDatabase)
+	}
+
+	r := &Reader{
+		buf:        buf,
+		nodeCount:  toUint(meta["node_count"]),
+		recordSize: toUint(meta["record_size"]),
+		ipVersion:  toUint(meta["ip_version"]),
+	}
+
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("%w: record size %d", ErrInvalidDatabase, r.recordSize)
+	}
+
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("%w: IP version %d", ErrInvalidDatabase, r.ipVersion)
+	}
+
+	// Every node holds two records, the search tree is followed by 16 zero bytes and the data section.
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+16 > uint(i) {
+		return nil, fmt.Errorf("%w: search tree exceeds the file", ErrInvalidDatabase)
+	}
+	r.data = decoder{buf: buf[treeSize+16 : i]}
+
+	if r.ipVersion == 6 {
+		for j := 0; j < 96 && r.ipv4Start < r.nodeCount; j++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+
+	return r, nil
+}
+
+// Lookup returns the record of the network containing the address, nil if there is none.
+func (r *Reader) Lookup(addr netip.Addr) (any, error) {
+	addr = addr.Unmap()
+
+	var (
+		ip   []byte
+		node uint
+	)
+
+	switch {
+	case addr.Is4():
+		b := addr.As4()
+		ip, node = b[:], r.ipv4Start
+	case r.ipVersion == 4:
+		return nil, nil
+	default:
+		b := addr.As16()
+		ip = b[:]
+	}
+
+	for i := 0; i < len(ip)*8 && node < r.nodeCount; i++ {
+		node = r.record(node, uint(ip[i/8]>>(7-i%8)&1))
+	}
+
+	if node == r.nodeCount {
+		return nil, nil
+	}
+
+	if node < r.nodeCount || node-r.nodeCount < 16 {
+		return nil, fmt.Errorf("%w: invalid record %d", ErrInvalidDatabase, node)
+	}
+
+	v, _, err := r.data.decode(node - r.nodeCount - 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDatabase, err)
+	}
+
+	return v, nil
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country of the address, falling back
+// to the country where the network is registered. It is empty if the address is not in the database.
+func (r *Reader) Country(addr netip.Addr) (string, error) {
+	v, err := r.Lookup(addr)
+	if err != nil {
+		return "", err
+	}
+
+	rec, _ := v.(map[string]any)
+	for _, key := range []string{"country", "registered_country"} {
+		country, _ := rec[key].(map[string]any)
+		if code, _ := country["iso_code"].(string); code != "" {
+			return strings.ToUpper(code), nil
+		}
+	}
+
+	return "", nil
+}
+
+// record returns the left (bit 0) or the right (bit 1) record of the node.
+func (r *Reader) record(node, bit uint) uint {
+	b := r.buf[node*r.recordSize/4:]
+
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		// The middle byte holds the high nibbles of both records.
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+func toUint(v any) uint {
+	switch n := v.(type) {
+	case uint64:
+		return uint(n)
+	case int32:
+		return uint(n)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,246 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encode writes the value in the format of the data section: maps, strings, uint16, uint32 and pointers.
+func encode(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		writeControl(buf, typeMap, len(v))
+		for _, k := range keys {
+			encode(buf, k)
+			encode(buf, v[k])
+		}
+	case string:
+		writeControl(buf, typeString, len(v))
+		buf.WriteString(v)
+	case uint16:
+		writeControl(buf, typeUint16, 2)
+		binary.Write(buf, binary.BigEndian, v)
+	case uint32:
+		writeControl(buf, typeUint32, 4)
+		binary.Write(buf, binary.BigEndian, v)
+	case pointer:
+		buf.WriteByte(typePointer<<5 | byte(v>>8)&0x7)
+		buf.WriteByte(byte(v))
+	default:
+		panic(fmt.Sprintf("unsupported type %T", v))
+	}
+}
+
+// pointer is an offset in the data section below 2048.
+type pointer uint16
+
+func writeControl(buf *bytes.Buffer, typ, size int) {
+	var ext []byte
+	if typ > typeMap {
+		ext = []byte{byte(typ - 7)}
+		typ = typeExtended
+	}
+
+	switch {
+	case size < 29:
+		buf.WriteByte(byte(typ<<5 | size))
+		buf.Write(ext)
+	case size < 285:
+		buf.WriteByte(byte(typ<<5 | 29))
+		buf.Write(ext)
+		buf.WriteByte(byte(size - 29))
+	default:
+		buf.WriteByte(byte(typ<<5 | 30))
+		buf.Write(ext)
+		buf.WriteByte(byte((size - 285) >> 8))
+		buf.WriteByte(byte(size - 285))
+	}
+}
+
+type node struct {
+	next [2]*node
+	data [2]int
+}
+
+// build writes the database with the networks and the data section, the data of a network is its offset.
+func build(t *testing.T, ipVersion, recordSize int, networks map[string]int, data []byte) []byte {
+	t.Helper()
+
+	root := &node{data: [2]int{-1, -1}}
+	for s, offset := range networks {
+		prefix := netip.MustParsePrefix(s)
+
+		var bits []byte
+		if prefix.Addr().Is4() {
+			b := prefix.Addr().As4()
+			if ipVersion == 6 {
+				bits = make([]byte, 12)
+			}
+			bits = append(bits, b[:]...)
+		} else {
+			b := prefix.Addr().As16()
+			bits = b[:]
+		}
+
+		depth := prefix.Bits()
+		if prefix.Addr().Is4() && ipVersion == 6 {
+			depth += 96
+		}
+
+		cur := root
+		for i := 0; i < depth; i++ {
+			bit := bits[i/8] >> (7 - i%8) & 1
+			if i == depth-1 {
+				cur.data[bit] = offset
+				break
+			}
+
+			if cur.next[bit] == nil {
+				cur.next[bit] = &node{data: [2]int{-1, -1}}
+			}
+			cur = cur.next[bit]
+		}
+	}
+
+	var nodes []*node
+	ids := make(map[*node]int)
+	var walk func(n *node)
+	walk = func(n *node) {
+		ids[n] = len(nodes)
+		nodes = append(nodes, n)
+		for _, next := range n.next {
+			if next != nil {
+				walk(next)
+			}
+		}
+	}
+	walk(root)
+
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		var records [2]uint32
+		for bit := range records {
+			switch {
+			case n.next[bit] != nil:
+				records[bit] = uint32(ids[n.next[bit]])
+			case n.data[bit] >= 0:
+				records[bit] = uint32(len(nodes) + 16 + n.data[bit])
+			default:
+				records[bit] = uint32(len(nodes))
+			}
+		}
+
+		l, r := records[0], records[1]
+		switch recordSize {
+		case 24:
+			buf.Write([]byte{byte(l >> 16), byte(l >> 8), byte(l), byte(r >> 16), byte(r >> 8), byte(r)})
+		case 28:
+			buf.Write([]byte{byte(l >> 16), byte(l >> 8), byte(l), byte(l>>20)&0xF0 | byte(r>>24)&0x0F,
+				byte(r >> 16), byte(r >> 8), byte(r)})
+		default:
+			binary.Write(&buf, binary.BigEndian, records)
+		}
+	}
+
+	buf.Write(make([]byte, 16))
+	buf.Write(data)
+	buf.Write(metadataMarker)
+	encode(&buf, map[string]any{
+		"binary_format_major_version": uint16(2),
+		"database_type":               "Test-Country",
+		"ip_version":                  uint16(ipVersion),
+		"node_count":                  uint32(len(nodes)),
+		"record_size":                 uint16(recordSize),
+	})
+
+	return buf.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	var data bytes.Buffer
+
+	de := data.Len()
+	encode(&data, map[string]any{"country": map[string]any{"iso_code": "DE", "geoname_id": uint32(2921044)}})
+
+	// The record of the network registered in another country refers to the country of the first one,
+	// which follows the control byte of the record and the key.
+	registered := data.Len()
+	encode(&data, map[string]any{"registered_country": pointer(de + 1 + 1 + len("country"))})
+
+	us := data.Len()
+	encode(&data, map[string]any{"country": map[string]any{"iso_code": "us"}})
+
+	for _, ipVersion := range []int{4, 6} {
+		for _, recordSize := range []int{24, 28, 32} {
+			t.Run(fmt.Sprintf("IPv%d record size %d", ipVersion, recordSize), func(t *testing.T) {
+				networks := map[string]int{"1.2.3.0/24": de, "5.6.0.0/16": registered, "8.8.8.8/32": us}
+				if ipVersion == 6 {
+					networks["2001:db8::/32"] = us
+				}
+
+				path := filepath.Join(t.TempDir(), "test.mmdb")
+				require.NoError(t, os.WriteFile(path, build(t, ipVersion, recordSize, networks, data.Bytes()), 0o600))
+
+				r, err := Open(path)
+				require.NoError(t, err)
+
+				tests := []struct {
+					addr string
+					want string
+				}{
+					{addr: "1.2.3.4", want: "DE"},
+					{addr: "1.2.4.4", want: ""},
+					{addr: "5.6.7.8", want: "DE"},
+					{addr: "8.8.8.8", want: "US"},
+					{addr: "8.8.8.9", want: ""},
+					{addr: "::ffff:1.2.3.4", want: "DE"},
+					{addr: "2001:db8::1", want: map[int]string{4: "", 6: "US"}[ipVersion]},
+					{addr: "2001:db9::1", want: ""},
+				}
+
+				for _, tt := range tests {
+					got, err := r.Country(netip.MustParseAddr(tt.addr))
+					require.NoError(t, err, tt.addr)
+					assert.Equal(t, tt.want, got, tt.addr)
+				}
+
+				v, err := r.Lookup(netip.MustParseAddr("1.2.3.4"))
+				require.NoError(t, err)
+				assert.Equal(t, map[string]any{"country": map[string]any{"iso_code": "DE", "geoname_id": uint64(2921044)}}, v)
+			})
+		}
+	}
+}
+
+func TestReaderInvalid(t *testing.T) {
+	_, err := New([]byte("not a database"))
+	assert.ErrorIs(t, err, ErrInvalidDatabase)
+
+	var buf bytes.Buffer
+	buf.Write(metadataMarker)
+	encode(&buf, map[string]any{"ip_version": uint16(6), "node_count": uint32(1000), "record_size": uint16(24)})
+	_, err = New(buf.Bytes())
+	assert.ErrorIs(t, err, ErrInvalidDatabase)
+
+	// The record of the address refers beyond the data section.
+	db := build(t, 4, 24, map[string]int{"1.0.0.0/8": 100}, []byte{0xE0})
+	r, err := New(db)
+	require.NoError(t, err)
+	_, err = r.Country(netip.MustParseAddr("1.1.1.1"))
+	assert.ErrorIs(t, err, ErrInvalidDatabase)
+}
@@ -0,0 +1,175 @@
+// Package purge describes the service that permanently removes URLs deleted longer ago than the retention period,
+// together with the orphaned records of their owners.
+// The service runs in the background at the given interval and removes the records in batches.
+// A run can also be started manually, in the dry-run mode the records are only counted.
+package purge
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+
+	"go-shortener-url/internal/storage"
+)
+
+var tracer = otel.Tracer("go-shortener-url/internal/pkg/purge")
+
+// Purger describes the service of permanent removal of deleted URLs.
+type Purger interface {
+	Purge(ctx context.Context, dryRun bool) (storage.PurgeResult, error)
+}
+
+// Config contains the settings of the purge service.
+// Zero values are replaced with the defaults.
+type Config struct {
+	// Retention is the time after deletion during which the URL is kept.
+	Retention time.Duration
+	// Interval is the interval between the scheduled runs.
+	Interval time.Duration
+	// BatchSize is the maximum number of URLs removed by one call to the data store.
+	BatchSize int
+	// DryRun makes the scheduled runs only count the records to remove.
+	DryRun bool
+}
+
+const (
+	defaultRetention = 30 * 24 * time.Hour
+	defaultInterval  = time.Hour
+	defaultBatchSize = 1000
+)
+
+// Service object for managing the purge service.
+type Service struct {
+	store    storage.Storage
+	cfg      Config
+	mu       sync.Mutex
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// New is the constructor for the Service structure.
+func New(store storage.Storage, cfg Config) *Service {
+	if cfg.Retention <= 0 {
+		cfg.Retention = defaultRetention
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+
+	return &Service{
+		store: store,
+		cfg:   cfg,
+		stop:  make(chan struct{}),
+	}
+}
+
+// Run starts the scheduled runs in the background.
+func (s *Service) Run() {
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go func() {
+			select {
+			case <-s.stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		ticker := time.NewTicker(s.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+
+			rst, err := s.Purge(ctx, s.cfg.DryRun)
+			if err != nil {
+				slog.Error(fmt.Sprintf("purge.Purge: %v", err))
+				continue
+			}
+
+			slog.Info(fmt.Sprintf("purge: urls=%d orphans=%d dry_run=%t", rst.URLs, rst.Orphans, s.cfg.DryRun))
+		}
+	}()
+}
+
+// Purge removes the URLs deleted before the retention period in batches until none are left
+// or the context is done. With dryRun, the records are only counted. Runs do not overlap.
+func (s *Service) Purge(ctx context.Context, dryRun bool) (storage.PurgeResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deletedBefore := time.Now().Add(-s.cfg.Retention)
+
+	ctx, span := tracer.Start(ctx, "purge.run",
+		trace.WithAttributes(attribute.Bool("purge.dry_run", dryRun)),
+	)
+	defer span.End()
+
+	var total storage.PurgeResult
+
+	for {
+		rst, err := s.store.Purge(ctx, deletedBefore, s.cfg.BatchSize, dryRun)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return total, err
+		}
+
+		total.URLs += rst.URLs
+		total.Orphans += rst.Orphans
+
+		if dryRun || (rst.URLs < s.cfg.BatchSize && rst.Orphans < s.cfg.BatchSize) {
+			break
+		}
+
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+
+	span.SetAttributes(
+		attribute.Int("purge.urls", total.URLs),
+		attribute.Int("purge.orphans", total.Orphans),
+	)
+
+	return total, nil
+}
+
+// Shutdown stops the scheduled runs and waits for the current run or until the context is done.
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
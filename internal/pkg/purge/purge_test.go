@@ -0,0 +1,54 @@
+package purge
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go-shortener-url/internal/storage"
+)
+
+func TestPurge(t *testing.T) {
+	ctx := context.Background()
+
+	store := storage.NewMemStorage()
+	for _, id := range []string{"a", "b", "c"} {
+		require.NoError(t, store.Put(ctx,
+			storage.Link{UserID: "1", ShortURL: "http://localhost:8080/" + id, OriginalURL: "http://example.com/" + id}, false))
+	}
+	require.NoError(t, store.DeleteBatch(ctx, "1", []string{"http://localhost:8080/a", "http://localhost:8080/b"}))
+
+	s := New(store, Config{Retention: time.Nanosecond, BatchSize: 1})
+	time.Sleep(time.Millisecond)
+
+	rst, err := s.Purge(ctx, true)
+	require.NoError(t, err)
+	assert.Equal(t, storage.PurgeResult{URLs: 2}, rst)
+
+	_, err = store.Get(ctx, "http://localhost:8080/a")
+	assert.ErrorIs(t, err, storage.ErrDeletedURL)
+
+	rst, err = s.Purge(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, storage.PurgeResult{URLs: 2}, rst)
+
+	for _, id := range []string{"a", "b"} {
+		_, err = store.Get(ctx, "http://localhost:8080/"+id)
+		assert.ErrorIs(t, err, storage.ErrNotFoundURL)
+	}
+
+	links, err := store.GetByUser(ctx, "1")
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Equal(t, "http://example.com/c", links[0].OriginalURL)
+
+	// URLs deleted within the retention period are kept.
+	require.NoError(t, store.DeleteBatch(ctx, "1", []string{"http://localhost:8080/c"}))
+
+	rst, err = New(store, Config{Retention: time.Hour}).Purge(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, storage.PurgeResult{}, rst)
+}
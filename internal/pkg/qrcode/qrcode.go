@@ -0,0 +1,572 @@
+// Package qrcode encodes data into QR codes (ISO/IEC 18004) and renders them as PNG or SVG images.
+// The data is encoded in the byte mode, the smallest version fitting the data is chosen
+// and the mask is selected by the penalty rules of the standard.
+package qrcode
+
+import (
+	"errors"
+	"strings"
+)
+
+// Level is the error correction level, the higher the level, the more damage the code survives
+// and the larger it is.
+type Level int
+
+// Error correction levels, recovering about 7%, 15%, 25% and 30% of the codewords.
+const (
+	L Level = iota
+	M
+	Q
+	H
+)
+
+// ErrTooLong is returned if the data does not fit into a QR code of the largest version.
+var ErrTooLong = errors.New("data is too long for a QR code")
+
+// ErrInvalidLevel is returned by ParseLevel for an unknown level.
+var ErrInvalidLevel = errors.New("error correction level must be L, M, Q or H")
+
+// ParseLevel parses the name of the error correction level, case-insensitively.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "L":
+		return L, nil
+	case "M":
+		return M, nil
+	case "Q":
+		return Q, nil
+	case "H":
+		return H, nil
+	default:
+		return 0, ErrInvalidLevel
+	}
+}
+
+// String returns the name of the level.
+func (l Level) String() string {
+	return [...]string{"L", "M", "Q", "H"}[l]
+}
+
+// formatBits are the bits of the level in the format information.
+func (l Level) formatBits() int {
+	return [...]int{1, 0, 3, 2}[l]
+}
+
+// Code is an encoded QR code, a square of dark and light modules.
+type Code struct {
+	// Version is the version of the code from 1 to 40, the side of the code is 4*Version+17 modules.
+	Version int
+	Level   Level
+	// Mask is the mask pattern applied to the data modules, from 0 to 7.
+	Mask int
+
+	size       int
+	modules    []bool
+	isFunction []bool
+}
+
+// Size returns the number of modules on a side of the code, without the quiet zone.
+func (c *Code) Size() int {
+	return c.size
+}
+
+// Dark reports whether the module in column x and row y is dark. The modules outside the code are light.
+func (c *Code) Dark(x, y int) bool {
+	if x < 0 || y < 0 || x >= c.size || y >= c.size {
+		return false
+	}
+
+	return c.modules[y*c.size+x]
+}
+
+// Encode encodes the data into the QR code of the smallest version at the level.
+func Encode(data []byte, level Level) (*Code, error) {
+	if level < L || level > H {
+		return nil, ErrInvalidLevel
+	}
+
+	version := 1
+	for ; version <= 40; version++ {
+		if 4+charCountBits(version)+8*len(data) <= numDataCodewords(version, level)*8 {
+			break
+		}
+	}
+
+	if version > 40 {
+		return nil, ErrTooLong
+	}
+
+	c := &Code{Version: version, Level: level, size: 4*version + 17}
+	c.modules = make([]bool, c.size*c.size)
+	c.isFunction = make([]bool, c.size*c.size)
+
+	c.drawFunctionPatterns()
+	c.drawCodewords(c.addECCAndInterleave(encodeData(data, version, level)))
+
+	// The mask with the lowest penalty is kept, masks are involutions so a rejected one is undone by reapplying it.
+	minPenalty := -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+
+		if penalty := c.penalty(); minPenalty < 0 || penalty < minPenalty {
+			c.Mask, minPenalty = mask, penalty
+		}
+
+		c.applyMask(mask)
+	}
+
+	c.applyMask(c.Mask)
+	c.drawFormatBits(c.Mask)
+
+	return c, nil
+}
+
+// charCountBits is the length of the character count indicator of the byte mode.
+func charCountBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+
+	return 16
+}
+
+// encodeData returns the data codewords: the byte mode segment, the terminator and the padding.
+func encodeData(data []byte, version int, level Level) []byte {
+	var bb bitBuffer
+
+	bb.append(0b0100, 4)
+	bb.append(len(data), charCountBits(version))
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+
+	capacity := numDataCodewords(version, level) * 8
+
+	terminator := capacity - len(bb)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bb.append(0, terminator)
+	bb.append(0, (8-len(bb)%8)%8)
+
+	for pad := 0xEC; len(bb) < capacity; pad ^= 0xEC ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	rst := make([]byte, len(bb)/8)
+	for i, bit := range bb {
+		if bit {
+			rst[i>>3] |= 1 << (7 - i&7)
+		}
+	}
+
+	return rst
+}
+
+type bitBuffer []bool
+
+func (bb *bitBuffer) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*bb = append(*bb, v>>i&1 != 0)
+	}
+}
+
+// addECCAndInterleave splits the data into blocks, appends the error correction codewords to each block
+// and interleaves the blocks.
+func (c *Code) addECCAndInterleave(data []byte) []byte {
+	numBlocks := numErrorCorrectionBlocks[c.Level][c.Version]
+	blockECCLen := eccCodewordsPerBlock[c.Level][c.Version]
+	rawCodewords := numRawDataModules(c.Version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	divisor := reedSolomonDivisor(blockECCLen)
+
+	blocks := make([][]byte, 0, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		n := shortBlockLen - blockECCLen
+		if i >= numShortBlocks {
+			n++
+		}
+
+		block := make([]byte, 0, shortBlockLen+1)
+		block = append(block, data[k:k+n]...)
+		k += n
+
+		ecc := reedSolomonRemainder(block, divisor)
+		if i < numShortBlocks {
+			// The short blocks are aligned with the long ones, the placeholder is skipped below.
+			block = append(block, 0)
+		}
+
+		blocks = append(blocks, append(block, ecc...))
+	}
+
+	rst := make([]byte, 0, rawCodewords)
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			if i != shortBlockLen-blockECCLen || j >= numShortBlocks {
+				rst = append(rst, block[i])
+			}
+		}
+	}
+
+	return rst
+}
+
+func (c *Code) set(x, y int, dark bool) {
+	c.modules[y*c.size+x] = dark
+	c.isFunction[y*c.size+x] = true
+}
+
+func (c *Code) drawFunctionPatterns() {
+	for i := 0; i < c.size; i++ {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+
+	c.drawFinderPattern(3, 3)
+	c.drawFinderPattern(c.size-4, 3)
+	c.drawFinderPattern(3, c.size-4)
+
+	positions := alignmentPatternPositions(c.Version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			// The corners are occupied by the finder patterns.
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+
+			c.drawAlignmentPattern(x, y)
+		}
+	}
+
+	// The format bits are reserved here and drawn after masking.
+	c.drawFormatBits(0)
+	c.drawVersion()
+}
+
+// drawFinderPattern draws the finder pattern with its separator around the center.
+func (c *Code) drawFinderPattern(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= c.size || yy >= c.size {
+				continue
+			}
+
+			dist := maxInt(absInt(dx), absInt(dy))
+			c.set(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (c *Code) drawAlignmentPattern(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.set(x+dx, y+dy, maxInt(absInt(dx), absInt(dy)) != 1)
+		}
+	}
+}
+
+// drawFormatBits draws both copies of the level and the mask protected by the BCH code.
+func (c *Code) drawFormatBits(mask int) {
+	data := c.Level.formatBits()<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(i))
+	}
+	c.set(8, 7, bit(6))
+	c.set(8, 8, bit(7))
+	c.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.set(c.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.size-15+i, bit(i))
+	}
+	c.set(8, c.size-8, true)
+}
+
+// drawVersion draws both copies of the version information, present from version 7.
+func (c *Code) drawVersion() {
+	if c.Version < 7 {
+		return
+	}
+
+	rem := c.Version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1F25
+	}
+	bits := c.Version<<12 | rem
+
+	for i := 0; i < 18; i++ {
+		dark := bits>>i&1 != 0
+		a, b := c.size-11+i%3, i/3
+		c.set(a, b, dark)
+		c.set(b, a, dark)
+	}
+}
+
+// drawCodewords places the codewords in the zigzag order, upwards and downwards in two-module columns
+// from the right, skipping the function modules.
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+
+		for vert := 0; vert < c.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = c.size - 1 - vert
+				}
+
+				if c.isFunction[y*c.size+x] || i >= len(data)*8 {
+					continue
+				}
+
+				c.modules[y*c.size+x] = data[i>>3]>>(7-i&7)&1 != 0
+				i++
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by the mask pattern.
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			if c.isFunction[y*c.size+x] {
+				continue
+			}
+
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+
+			if invert {
+				c.modules[y*c.size+x] = !c.modules[y*c.size+x]
+			}
+		}
+	}
+}
+
+// penalty scores the code by the rules of the standard: runs of the same color, 2x2 blocks,
+// patterns resembling the finder pattern and the imbalance of dark and light modules.
+func (c *Code) penalty() int {
+	var rst, dark int
+
+	finderLike := [][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+
+	for i := 0; i < c.size; i++ {
+		for _, horizontal := range []bool{true, false} {
+			at := func(j int) bool {
+				if horizontal {
+					return c.Dark(j, i)
+				}
+				return c.Dark(i, j)
+			}
+
+			run := 1
+			for j := 1; j <= c.size; j++ {
+				if j < c.size && at(j) == at(j-1) {
+					run++
+					continue
+				}
+
+				if run >= 5 {
+					rst += 3 + run - 5
+				}
+				run = 1
+			}
+
+			for j := 0; j+11 <= c.size; j++ {
+				for _, pattern := range finderLike {
+					match := true
+					for k, v := range pattern {
+						if at(j+k) != v {
+							match = false
+							break
+						}
+					}
+
+					if match {
+						rst += 40
+					}
+				}
+			}
+		}
+	}
+
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			v := c.Dark(x, y)
+			if v {
+				dark++
+			}
+
+			if x+1 < c.size && y+1 < c.size && v == c.Dark(x+1, y) && v == c.Dark(x, y+1) && v == c.Dark(x+1, y+1) {
+				rst += 3
+			}
+		}
+	}
+
+	total := c.size * c.size
+	k := (absInt(dark*20-total*10)+total-1)/total - 1
+	rst += k * 10
+
+	return rst
+}
+
+// alignmentPatternPositions returns the coordinates of the centers of the alignment patterns on each axis.
+func alignmentPatternPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+
+	numAlign := version/7 + 2
+	step := (version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+
+	rst := make([]int, numAlign)
+	rst[0] = 6
+	for i, pos := numAlign-1, 4*version+10; i >= 1; i, pos = i-1, pos-step {
+		rst[i] = pos
+	}
+
+	return rst
+}
+
+// numRawDataModules returns the number of modules available for the data and error correction codewords.
+func numRawDataModules(version int) int {
+	rst := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		rst -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			rst -= 36
+		}
+	}
+
+	return rst
+}
+
+// numDataCodewords returns the number of data codewords of the version at the level.
+func numDataCodewords(version int, level Level) int {
+	return numRawDataModules(version)/8 -
+		eccCodewordsPerBlock[level][version]*numErrorCorrectionBlocks[level][version]
+}
+
+// reedSolomonDivisor returns the coefficients of the generator polynomial of the degree,
+// from the highest power without the leading one.
+func reedSolomonDivisor(degree int) []byte {
+	rst := make([]byte, degree)
+	rst[degree-1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range rst {
+			rst[j] = gfMultiply(rst[j], root)
+			if j+1 < len(rst) {
+				rst[j] ^= rst[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+
+	return rst
+}
+
+// reedSolomonRemainder returns the error correction codewords of the data.
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	rst := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ rst[0]
+		copy(rst, rst[1:])
+		rst[len(rst)-1] = 0
+
+		for i, coef := range divisor {
+			rst[i] ^= gfMultiply(coef, factor)
+		}
+	}
+
+	return rst
+}
+
+// gfMultiply multiplies the elements of GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+
+	return byte(z)
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+
+	return v
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+// eccCodewordsPerBlock is the number of error correction codewords in each block by level and version.
+var eccCodewordsPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+// numErrorCorrectionBlocks is the number of blocks the codewords are split into by level and version.
+var numErrorCorrectionBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
@@ -0,0 +1,228 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// The 1-M code of "HELLO WORLD" from the specification walkthrough.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	assert.Equal(t, want, reedSolomonRemainder(data, reedSolomonDivisor(len(want))))
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		level   Level
+		version int
+	}{
+		{name: "smallest", data: "a", level: L, version: 1},
+		{name: "full version 1", data: strings.Repeat("a", 17), level: L, version: 1},
+		{name: "overflow version 1", data: strings.Repeat("a", 18), level: L, version: 2},
+		{name: "short url", data: "http://localhost:8080/EwHXdJfB", level: M, version: 3},
+		{name: "version information", data: strings.Repeat("x", 200), level: H, version: 15},
+		{name: "largest", data: strings.Repeat("z", 2953), level: L, version: 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Encode([]byte(tt.data), tt.level)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.version, code.Version)
+			assert.Equal(t, 4*tt.version+17, code.Size())
+			assert.Equal(t, []byte(tt.data), readData(t, code))
+		})
+	}
+
+	_, err := Encode(make([]byte, 2954), L)
+	assert.ErrorIs(t, err, ErrTooLong)
+}
+
+func TestFunctionPatterns(t *testing.T) {
+	code, err := Encode([]byte("a"), L)
+	require.NoError(t, err)
+
+	// Format information of the level L: two bits of the level, three of the mask and ten of BCH.
+	formats := []string{
+		"111011111000100", "111001011110011", "111110110101010", "111100010011101",
+		"110011000101111", "110001100011000", "110110001000001", "110100101110110",
+	}
+
+	var format strings.Builder
+	for i := 0; i <= 5; i++ {
+		format.WriteString(bit(code.Dark(i, 8)))
+	}
+	format.WriteString(bit(code.Dark(7, 8)) + bit(code.Dark(8, 8)) + bit(code.Dark(8, 7)))
+	for i := 5; i >= 0; i-- {
+		format.WriteString(bit(code.Dark(8, i)))
+	}
+	assert.Equal(t, formats[code.Mask], format.String())
+
+	code, err = Encode(bytes.Repeat([]byte("x"), 150), L)
+	require.NoError(t, err)
+	require.Equal(t, 7, code.Version)
+
+	var version strings.Builder
+	for i := 17; i >= 0; i-- {
+		version.WriteString(bit(code.Dark(i/3, code.Size()-11+i%3)))
+	}
+	assert.Equal(t, "000111110010010100", version.String())
+}
+
+func TestRender(t *testing.T) {
+	code, err := Encode([]byte("http://localhost:8080/EwHXdJfB"), M)
+	require.NoError(t, err)
+
+	opts := DefaultOptions(300)
+	opts.Foreground, err = ParseColor("#1a2b3c")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, code.WritePNG(&buf, opts))
+
+	img, err := png.Decode(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, 300, img.Bounds().Dx())
+
+	// 37 modules with the quiet zone fit 8 pixels each, the code is centered.
+	scale, offset := 8, (300-8*29)/2
+	for y := 0; y < code.Size(); y++ {
+		for x := 0; x < code.Size(); x++ {
+			want := opts.Background
+			if code.Dark(x, y) {
+				want = opts.Foreground
+			}
+
+			r, g, b, _ := img.At(offset+x*scale+scale/2, offset+y*scale+scale/2).RGBA()
+			assert.Equal(t, [3]uint8{want.R, want.G, want.B}, [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)})
+		}
+	}
+
+	buf.Reset()
+	require.NoError(t, code.WriteSVG(&buf, opts))
+	assert.Contains(t, buf.String(), `width="300" height="300"`)
+	assert.Contains(t, buf.String(), `fill="#1a2b3c"`)
+
+	opts.Size = 36
+	assert.ErrorIs(t, code.WritePNG(&buf, opts), ErrTooSmall)
+}
+
+func TestParse(t *testing.T) {
+	level, err := ParseLevel("q")
+	require.NoError(t, err)
+	assert.Equal(t, Q, level)
+
+	_, err = ParseLevel("x")
+	assert.ErrorIs(t, err, ErrInvalidLevel)
+
+	c, err := ParseColor("f80")
+	require.NoError(t, err)
+	assert.Equal(t, "#ff8800", hexColor(c))
+
+	for _, s := range []string{"", "ff", "#ggg", "1234567"} {
+		_, err = ParseColor(s)
+		assert.ErrorIs(t, err, ErrInvalidColor, s)
+	}
+}
+
+func bit(dark bool) string {
+	if dark {
+		return "1"
+	}
+
+	return "0"
+}
+
+// readData reads the code back: it unmasks the modules, reads the codewords in the placement order,
+// deinterleaves and checks the blocks and decodes the byte mode segment.
+func readData(t *testing.T, code *Code) []byte {
+	t.Helper()
+
+	c := &Code{Version: code.Version, Level: code.Level, size: code.size}
+	c.modules = append([]bool(nil), code.modules...)
+	c.isFunction = make([]bool, len(c.modules))
+	c.drawFunctionPatterns()
+	copy(c.modules, code.modules)
+	c.applyMask(code.Mask)
+
+	var raw []byte
+	var bits int
+	var cur byte
+	for right := c.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.size - 1 - vert
+				}
+				if c.isFunction[y*c.size+x] {
+					continue
+				}
+
+				cur = cur<<1 | map[bool]byte{true: 1}[c.Dark(x, y)]
+				if bits++; bits%8 == 0 {
+					raw = append(raw, cur)
+				}
+			}
+		}
+	}
+
+	numBlocks := numErrorCorrectionBlocks[c.Level][c.Version]
+	eccLen := eccCodewordsPerBlock[c.Level][c.Version]
+	total := numRawDataModules(c.Version) / 8
+	require.Len(t, raw, total)
+
+	// The short blocks miss the last data codeword, the long ones are one codeword longer.
+	numShort := numBlocks - total%numBlocks
+	shortLen := total / numBlocks
+	blocks := make([][]byte, numBlocks)
+	for i, k := 0, 0; i <= shortLen; i++ {
+		for j := range blocks {
+			if i == shortLen-eccLen && j < numShort {
+				continue
+			}
+
+			blocks[j] = append(blocks[j], raw[k])
+			k++
+		}
+	}
+
+	var data []byte
+	for _, block := range blocks {
+		n := len(block) - eccLen
+		assert.Equal(t, block[n:], reedSolomonRemainder(block[:n], reedSolomonDivisor(eccLen)))
+		data = append(data, block[:n]...)
+	}
+
+	require.Equal(t, byte(0b0100), data[0]>>4)
+
+	pos := 4
+	read := func(n int) int {
+		v := 0
+		for i := 0; i < n; i++ {
+			v = v<<1 | int(data[pos>>3]>>(7-pos&7)&1)
+			pos++
+		}
+		return v
+	}
+
+	rst := make([]byte, read(charCountBits(c.Version)))
+	for i := range rst {
+		rst[i] = byte(read(8))
+	}
+
+	return rst
+}
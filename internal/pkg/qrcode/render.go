@@ -0,0 +1,140 @@
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultMargin is the width of the quiet zone around the code in modules recommended by the standard.
+const DefaultMargin = 4
+
+// ErrInvalidColor is returned by ParseColor for a malformed color.
+var ErrInvalidColor = errors.New("color must be a hex RGB value like 000 or 1a2b3c")
+
+// ErrTooSmall is returned if the image is smaller than one pixel per module.
+var ErrTooSmall = errors.New("image size is too small for the code")
+
+// Options are the rendering options of the image.
+type Options struct {
+	// Size is the width and the height of the image in pixels. The modules are scaled by a whole number
+	// of pixels, the rest is added to the quiet zone.
+	Size int
+	// Margin is the width of the quiet zone in modules.
+	Margin     int
+	Foreground color.RGBA
+	Background color.RGBA
+}
+
+// DefaultOptions returns black on white options with the standard quiet zone.
+func DefaultOptions(size int) Options {
+	return Options{
+		Size:       size,
+		Margin:     DefaultMargin,
+		Foreground: color.RGBA{A: 0xff},
+		Background: color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+	}
+}
+
+// ParseColor parses a hex RGB color of 3 or 6 digits with an optional leading #.
+func ParseColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+
+	if len(s) != 6 {
+		return color.RGBA{}, ErrInvalidColor
+	}
+
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, ErrInvalidColor
+	}
+
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+// layout returns the pixel size of a module and the offset of the code in the image.
+func (c *Code) layout(opts Options) (scale, offset int, err error) {
+	modules := c.size + 2*opts.Margin
+
+	scale = opts.Size / modules
+	if scale < 1 {
+		return 0, 0, ErrTooSmall
+	}
+
+	return scale, (opts.Size - scale*c.size) / 2, nil
+}
+
+// WritePNG writes the code as a two-color PNG image.
+func (c *Code) WritePNG(w io.Writer, opts Options) error {
+	scale, offset, err := c.layout(opts)
+	if err != nil {
+		return err
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, opts.Size, opts.Size), color.Palette{opts.Background, opts.Foreground})
+
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			if !c.Dark(x, y) {
+				continue
+			}
+
+			for py := 0; py < scale; py++ {
+				row := (offset+y*scale+py)*img.Stride + offset + x*scale
+				for px := 0; px < scale; px++ {
+					img.Pix[row+px] = 1
+				}
+			}
+		}
+	}
+
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	return enc.Encode(w, img)
+}
+
+// WriteSVG writes the code as an SVG image, the dark modules are drawn as a single path
+// of horizontal runs.
+func (c *Code) WriteSVG(w io.Writer, opts Options) error {
+	scale, offset, err := c.layout(opts)
+	if err != nil {
+		return err
+	}
+
+	var path strings.Builder
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			if !c.Dark(x, y) {
+				continue
+			}
+
+			run := 1
+			for c.Dark(x+run, y) {
+				run++
+			}
+
+			fmt.Fprintf(&path, "M%d %dh%dv%dh-%dz", offset+x*scale, offset+y*scale, run*scale, scale, run*scale)
+			x += run
+		}
+	}
+
+	_, err = fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="%[1]d" height="%[1]d" viewBox="0 0 %[1]d %[1]d" shape-rendering="crispEdges">
+<rect width="100%%" height="100%%" fill="%[2]s"/>
+<path d="%[3]s" fill="%[4]s"/>
+</svg>
+`, opts.Size, hexColor(opts.Background), path.String(), hexColor(opts.Foreground))
+
+	return err
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,201 @@
+package urlcheck
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// DefaultThreat is the threat of the list entries with no threat given.
+const DefaultThreat = "malware"
+
+// Lengths of the hash prefixes in bytes.
+const (
+	minPrefixLen = 4
+	maxPrefixLen = sha256.Size
+)
+
+// HashList matches URLs against the SHA-256 hash prefixes of unsafe URL expressions, the way
+// the Safe Browsing lists do. Every line of the file is a hex-encoded hash prefix of 4 to 32 bytes
+// and an optional threat, the empty lines and the lines starting with "#" are ignored:
+//
+//	# sha256("evil.example.com/")
+//	a3f1c2d4 phishing
+//	0badcafe0badcafe
+//
+// The expressions of a URL combine its host suffixes with its path prefixes, see Expressions.
+type HashList struct {
+	// prefixes are the threats by the hash prefix, keyed by the length of the prefix.
+	prefixes map[int]map[string]string
+}
+
+// OpenHashList loads the hash prefixes from the file.
+func OpenHashList(path string) (*HashList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	l, err := parseHashList(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return l, nil
+}
+
+// Len returns the number of the hash prefixes.
+func (l *HashList) Len() int {
+	n := 0
+	for _, m := range l.prefixes {
+		n += len(m)
+	}
+
+	return n
+}
+
+// Check returns the threat of the first expression of the URL whose hash matches a prefix of the list.
+func (l *HashList) Check(_ context.Context, rawURL string) (string, error) {
+	exprs, err := Expressions(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	for _, expr := range exprs {
+		sum := sha256.Sum256([]byte(expr))
+		for n, m := range l.prefixes {
+			if threat, ok := m[string(sum[:n])]; ok {
+				return threat, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// Expressions returns the host suffix and path prefix expressions of the URL as the Safe Browsing lookups
+// do: the exact host and up to 4 of its parent domains, each combined with the exact path and query,
+// the exact path and up to 4 path prefixes from the root. For "http://a.b.example.com/1/2.html?x=1":
+//
+//	a.b.example.com/1/2.html?x=1
+//	a.b.example.com/1/2.html
+//	a.b.example.com/
+//	a.b.example.com/1/
+//	b.example.com/1/2.html?x=1
+//	...
+//	example.com/1/
+//
+// The host is lowercased, the scheme, the user info, the port and the fragment are dropped.
+func Expressions(rawURL string) ([]string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, err
+	}
+
+	host := strings.Trim(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return nil, fmt.Errorf("URL %q has no host", rawURL)
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	var paths []string
+	if u.RawQuery != "" {
+		paths = append(paths, path+"?"+u.RawQuery)
+	}
+	paths = append(paths, path)
+
+	// The prefixes are built from the directories of the path, the exact path is not repeated.
+	prefixes := []string{"/"}
+	dir := path[:strings.LastIndex(path, "/")+1]
+	for _, c := range strings.Split(dir, "/") {
+		if c != "" && len(prefixes) < 4 {
+			prefixes = append(prefixes, prefixes[len(prefixes)-1]+c+"/")
+		}
+	}
+
+	for _, p := range prefixes {
+		if p != path {
+			paths = append(paths, p)
+		}
+	}
+
+	rst := make([]string, 0, len(paths)*5)
+	for _, h := range hostSuffixes(host) {
+		for _, p := range paths {
+			rst = append(rst, h+p)
+		}
+	}
+
+	return rst, nil
+}
+
+// hostSuffixes returns the host and up to 4 of its parent domains formed from its last 5 labels,
+// excluding the top-level domain. The IP addresses have no parent domains.
+func hostSuffixes(host string) []string {
+	rst := []string{host}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return rst
+	}
+
+	labels := strings.Split(host, ".")
+	start := len(labels) - 5
+	if start < 1 {
+		start = 1
+	}
+
+	for i := start; i < len(labels)-1; i++ {
+		rst = append(rst, strings.Join(labels[i:], "."))
+	}
+
+	return rst
+}
+
+func parseHashList(r io.Reader) (*HashList, error) {
+	l := &HashList{prefixes: make(map[int]map[string]string)}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%w: line %d: want \"<hash prefix> [threat]\"", ErrInvalidList, n)
+		}
+
+		prefix, err := hex.DecodeString(fields[0])
+		if err != nil || len(prefix) < minPrefixLen || len(prefix) > maxPrefixLen {
+			return nil, fmt.Errorf("%w: line %d: invalid hash prefix %q", ErrInvalidList, n, fields[0])
+		}
+
+		threat := DefaultThreat
+		if len(fields) == 2 {
+			threat = strings.ToLower(fields[1])
+		}
+
+		if l.prefixes[len(prefix)] == nil {
+			l.prefixes[len(prefix)] = make(map[string]string)
+		}
+		l.prefixes[len(prefix)][string(prefix)] = threat
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
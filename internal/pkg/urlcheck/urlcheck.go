@@ -0,0 +1,38 @@
+// Package urlcheck looks up URLs in the lists of unsafe URLs, such as the lists of phishing and malware sites.
+package urlcheck
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrInvalidList is returned if the list file has an invalid entry.
+var ErrInvalidList = errors.New("invalid threat list")
+
+// Checker checks URLs, the threat is the type of the threat the URL is flagged for, empty if it is safe.
+type Checker interface {
+	Check(ctx context.Context, rawURL string) (string, error)
+}
+
+// Chain consults the checkers in order and returns the first threat found. The checkers failing
+// do not stop the chain, their errors are returned joined if none of the others flags the URL.
+type Chain []Checker
+
+// Check checks the URL with all the checkers of the chain.
+func (c Chain) Check(ctx context.Context, rawURL string) (string, error) {
+	var errs []error
+
+	for _, checker := range c {
+		threat, err := checker.Check(ctx, rawURL)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if threat != "" {
+			return threat, nil
+		}
+	}
+
+	return "", errors.Join(errs...)
+}
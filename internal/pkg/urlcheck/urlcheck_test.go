@@ -0,0 +1,156 @@
+package urlcheck
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hashPrefix(expr string, n int) string {
+	sum := sha256.Sum256([]byte(expr))
+	return hex.EncodeToString(sum[:n])
+}
+
+func TestExpressions(t *testing.T) {
+	exprs, err := Expressions("http://user@A.B.C.D.E.F.G.example.com:8080/1/2.html?param=1#frag")
+	require.NoError(t, err)
+
+	assert.Contains(t, exprs, "a.b.c.d.e.f.g.example.com/1/2.html?param=1")
+	assert.Contains(t, exprs, "a.b.c.d.e.f.g.example.com/1/")
+	assert.Contains(t, exprs, "g.example.com/")
+	assert.Contains(t, exprs, "example.com/1/2.html")
+	assert.NotContains(t, exprs, "d.e.f.g.example.com/")
+	assert.NotContains(t, exprs, "com/")
+	assert.NotContains(t, exprs, "example.com/1/2.html/")
+	assert.Len(t, exprs, 5*4)
+
+	exprs, err = Expressions("http://10.1.2.3")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.1.2.3/"}, exprs)
+
+	exprs, err = Expressions("http://example.com/a/b/c/d/e/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com/a/b/c/d/e/", "example.com/", "example.com/a/", "example.com/a/b/", "example.com/a/b/c/"}, exprs)
+
+	_, err = Expressions("mailto:user@example.com")
+	assert.Error(t, err)
+}
+
+func TestHashList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "threats")
+	require.NoError(t, os.WriteFile(path, []byte("# test list\n"+
+		hashPrefix("evil.example.com/", 4)+" Phishing\n\n"+
+		hashPrefix("example.net/downloads/", 32)+"\n"), 0o600))
+
+	l, err := OpenHashList(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, l.Len())
+
+	tests := []struct {
+		url    string
+		threat string
+	}{
+		{url: "https://evil.example.com/login?next=/", threat: "phishing"},
+		{url: "http://www.EVIL.example.com", threat: "phishing"},
+		{url: "http://example.net/downloads/setup.exe", threat: DefaultThreat},
+		{url: "http://example.net/downloads"},
+		{url: "http://example.com/"},
+	}
+
+	for _, tt := range tests {
+		threat, err := l.Check(context.Background(), tt.url)
+		require.NoError(t, err, tt.url)
+		assert.Equal(t, tt.threat, threat, tt.url)
+	}
+
+	for _, content := range []string{"abc", "zzzzzzzz", "0badcafe a b", "0bad", hashPrefix("x", 32) + "00"} {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		_, err = OpenHashList(path)
+		assert.ErrorIs(t, err, ErrInvalidList, content)
+	}
+
+	_, err = OpenHashList(filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestWebhook(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var req webhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch req.URL {
+		case "http://evil.example.com/":
+			w.Write([]byte(`{"threat":"Phishing"}`))
+		case "http://slow.example.com/":
+			time.Sleep(200 * time.Millisecond)
+		case "http://broken.example.com/":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+
+	hook := NewWebhook(srv.URL, "secret", 100*time.Millisecond)
+
+	threat, err := hook.Check(context.Background(), "http://evil.example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, "phishing", threat)
+
+	threat, err = hook.Check(context.Background(), "http://example.com/")
+	require.NoError(t, err)
+	assert.Empty(t, threat)
+
+	_, err = hook.Check(context.Background(), "http://broken.example.com/")
+	assert.Error(t, err)
+
+	_, err = hook.Check(context.Background(), "http://slow.example.com/")
+	assert.Error(t, err)
+
+	_, err = NewWebhook(srv.URL, "wrong", 0).Check(context.Background(), "http://example.com/")
+	assert.Error(t, err)
+}
+
+type checkerFunc func(ctx context.Context, rawURL string) (string, error)
+
+func (f checkerFunc) Check(ctx context.Context, rawURL string) (string, error) {
+	return f(ctx, rawURL)
+}
+
+func TestChain(t *testing.T) {
+	errBroken := errors.New("broken")
+	broken := checkerFunc(func(context.Context, string) (string, error) { return "", errBroken })
+	safe := checkerFunc(func(context.Context, string) (string, error) { return "", nil })
+	flagged := checkerFunc(func(context.Context, string) (string, error) { return "malware", nil })
+
+	threat, err := Chain{broken, safe, flagged}.Check(context.Background(), "http://example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, "malware", threat)
+
+	threat, err = Chain{safe, broken}.Check(context.Background(), "http://example.com/")
+	assert.ErrorIs(t, err, errBroken)
+	assert.Empty(t, threat)
+
+	threat, err = Chain{}.Check(context.Background(), "http://example.com/")
+	require.NoError(t, err)
+	assert.Empty(t, threat)
+}
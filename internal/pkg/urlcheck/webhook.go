@@ -0,0 +1,86 @@
+package urlcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is the timeout of the webhook requests, unless set.
+const DefaultTimeout = 2 * time.Second
+
+// Webhook checks URLs with an HTTP service. It posts the URL as JSON:
+//
+//	{
+//		"url": "http://evil.example.com/login"
+//	}
+//
+// and expects a 2xx response with the threat, empty or missing if the URL is safe:
+//
+//	{
+//		"threat": "phishing"
+//	}.
+//
+// The token, if set, is sent in the Authorization header as a bearer token.
+type Webhook struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+type webhookRequest struct {
+	URL string `json:"url"`
+}
+
+type webhookResponse struct {
+	Threat string `json:"threat"`
+}
+
+// NewWebhook returns the checker posting to the endpoint, the timeout is DefaultTimeout if not positive.
+func NewWebhook(endpoint, token string, timeout time.Duration) *Webhook {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &Webhook{endpoint: endpoint, token: token, client: &http.Client{Timeout: timeout}}
+}
+
+// Check posts the URL to the endpoint and returns the threat of the response.
+func (w *Webhook) Check(ctx context.Context, rawURL string) (string, error) {
+	body, err := json.Marshal(webhookRequest{URL: rawURL})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(io.Discard, resp.Body)
+		return "", fmt.Errorf("webhook: unexpected status %s", resp.Status)
+	}
+
+	var rst webhookResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&rst); err != nil && err != io.EOF {
+		return "", fmt.Errorf("webhook: %w", err)
+	}
+
+	return strings.ToLower(strings.TrimSpace(rst.Threat)), nil
+}
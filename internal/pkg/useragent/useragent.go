@@ -0,0 +1,135 @@
+// Package useragent classifies the clients by the User-Agent and Accept-Language HTTP headers.
+package useragent
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Operating systems of the clients.
+const (
+	OSIOS      = "ios"
+	OSAndroid  = "android"
+	OSWindows  = "windows"
+	OSMacOS    = "macos"
+	OSChromeOS = "chromeos"
+	OSLinux    = "linux"
+	OSOther    = "other"
+)
+
+// Device classes of the clients.
+const (
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+)
+
+// Agent is the client described by the User-Agent header.
+type Agent struct {
+	OS     string
+	Device string
+	// Bot is set for crawlers, link previews of messengers and HTTP libraries.
+	Bot bool
+}
+
+// botMarkers are the substrings of the lowercase User-Agent of the automated clients.
+var botMarkers = []string{
+	"bot", "crawl", "spider", "slurp", "preview", "facebookexternalhit", "headless",
+	"curl/", "wget/", "python-", "go-http-client", "java/", "okhttp", "axios/",
+}
+
+// Parse classifies the client by the User-Agent header, the empty header is considered a bot.
+func Parse(ua string) Agent {
+	s := strings.ToLower(ua)
+
+	var a Agent
+
+	switch {
+	case strings.Contains(s, "iphone"), strings.Contains(s, "ipad"), strings.Contains(s, "ipod"):
+		a.OS = OSIOS
+	case strings.Contains(s, "android"):
+		a.OS = OSAndroid
+	case strings.Contains(s, "windows"):
+		a.OS = OSWindows
+	case strings.Contains(s, "cros"):
+		a.OS = OSChromeOS
+	case strings.Contains(s, "macintosh"), strings.Contains(s, "mac os x"):
+		a.OS = OSMacOS
+	case strings.Contains(s, "linux"):
+		a.OS = OSLinux
+	default:
+		a.OS = OSOther
+	}
+
+	switch {
+	case strings.Contains(s, "ipad"), strings.Contains(s, "tablet"),
+		a.OS == OSAndroid && !strings.Contains(s, "mobile"):
+		a.Device = DeviceTablet
+	case strings.Contains(s, "mobi"), strings.Contains(s, "iphone"), strings.Contains(s, "ipod"):
+		a.Device = DeviceMobile
+	default:
+		a.Device = DeviceDesktop
+	}
+
+	a.Bot = s == ""
+	for _, marker := range botMarkers {
+		if strings.Contains(s, marker) {
+			a.Bot = true
+			break
+		}
+	}
+
+	return a
+}
+
+// Languages returns the lowercase language tags of the Accept-Language header
+// in the order of preference, the tags with zero quality and the wildcard are skipped.
+func Languages(header string) []string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+
+	var langs []lang
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if !ok || strings.ToLower(name) != "q" {
+				continue
+			}
+
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				v = 0
+			}
+			q = v
+		}
+
+		if q > 0 {
+			langs = append(langs, lang{tag: tag, q: q})
+		}
+	}
+
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	rst := make([]string, 0, len(langs))
+	for _, l := range langs {
+		rst = append(rst, l.tag)
+	}
+
+	return rst
+}
+
+// MatchLanguage reports whether the language tag is the range or its subtag, "pt" matches "pt-br".
+// Both are expected in lowercase.
+func MatchLanguage(tag, rng string) bool {
+	return tag == rng || strings.HasPrefix(tag, rng+"-")
+}
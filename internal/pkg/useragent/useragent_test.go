@@ -0,0 +1,90 @@
+package useragent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		ua   string
+		want Agent
+	}{
+		{
+			name: "iphone",
+			ua:   "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1",
+			want: Agent{OS: OSIOS, Device: DeviceMobile},
+		},
+		{
+			name: "ipad",
+			ua:   "Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+			want: Agent{OS: OSIOS, Device: DeviceTablet},
+		},
+		{
+			name: "android phone",
+			ua:   "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Mobile Safari/537.36",
+			want: Agent{OS: OSAndroid, Device: DeviceMobile},
+		},
+		{
+			name: "android tablet",
+			ua:   "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36",
+			want: Agent{OS: OSAndroid, Device: DeviceTablet},
+		},
+		{
+			name: "windows",
+			ua:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36",
+			want: Agent{OS: OSWindows, Device: DeviceDesktop},
+		},
+		{
+			name: "macos",
+			ua:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/605.1.15",
+			want: Agent{OS: OSMacOS, Device: DeviceDesktop},
+		},
+		{
+			name: "crawler",
+			ua:   "Mozilla/5.0 (Linux; Android 6.0.1; Nexus 5X Build/MMB29P) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Mobile Safari/537.36 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			want: Agent{OS: OSAndroid, Device: DeviceMobile, Bot: true},
+		},
+		{
+			name: "http library",
+			ua:   "curl/8.4.0",
+			want: Agent{OS: OSOther, Device: DeviceDesktop, Bot: true},
+		},
+		{
+			name: "empty",
+			want: Agent{OS: OSOther, Device: DeviceDesktop, Bot: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Parse(tt.ua))
+		})
+	}
+}
+
+func TestLanguages(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{header: "", want: []string{}},
+		{header: "de-DE", want: []string{"de-de"}},
+		{header: "en;q=0.5, pt-BR, *;q=0.1, fr;q=0", want: []string{"pt-br", "en"}},
+		{header: "ru, en-US;q=0.9, en;q=0.9", want: []string{"ru", "en-us", "en"}},
+		{header: "es;q=bad, it", want: []string{"it"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			assert.Equal(t, tt.want, Languages(tt.header))
+		})
+	}
+
+	assert.True(t, MatchLanguage("pt-br", "pt"))
+	assert.True(t, MatchLanguage("pt-br", "pt-br"))
+	assert.False(t, MatchLanguage("pt", "pt-br"))
+	assert.False(t, MatchLanguage("ptx", "pt"))
+}
@@ -0,0 +1,666 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// States of the deletion job.
+const (
+	JobPending = "pending"
+	JobDone    = "done"
+	JobDead    = "dead"
+)
+
+// Statuses of the deletion operation.
+const (
+	OperationPending = "pending"
+	OperationRunning = "running"
+	OperationDone    = "done"
+	OperationFailed  = "failed"
+)
+
+// operationRetention is how long operations are kept in the queue, the done jobs are removed with them.
+const operationRetention = 24 * time.Hour
+
+// pruneInterval is the interval between the removals of the expired operations and their done jobs.
+const pruneInterval = time.Hour
+
+// compactMinLines is the number of lines appended to the journal after which it is compacted,
+// provided that most of them are outdated.
+const compactMinLines = 10000
+
+// Errors returned when the deletion job or operation does not exist.
+var (
+	ErrNotFoundJob       = errors.New("job not found")
+	ErrNotFoundOperation = errors.New("operation not found")
+)
+
+// DeleteJob is a request to mark the shortened URL of the user as deleted.
+type DeleteJob struct {
+	ID        int64     `json:"id"`
+	UserID    string    `json:"user_id"`
+	ShortURL  string    `json:"short_url"`
+	State     string    `json:"state"`
+	Attempts  int       `json:"attempts"`
+	NextRunAt time.Time `json:"next_run_at"`
+	LastError string    `json:"last_error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// TraceParent is the W3C trace context of the request that created the job.
+	TraceParent string `json:"trace_parent,omitempty"`
+	// OperationID is the deletion operation the job belongs to.
+	OperationID string `json:"operation_id,omitempty"`
+}
+
+// DeleteOperation is a request of the user to delete a set of shortened URLs.
+// The counters and the status are calculated from the jobs of the operation when it is read.
+type DeleteOperation struct {
+	ID     string `json:"id"`
+	UserID string `json:"-"`
+	Status string `json:"status"`
+	// Requested is the number of identifiers in the request.
+	Requested int `json:"requested"`
+	// Pending is the number of jobs that have not been attempted yet.
+	Pending int `json:"pending"`
+	// Running is the number of jobs that are being processed or wait for a retry.
+	Running int `json:"running"`
+	Done    int `json:"done"`
+	Failed  int `json:"failed"`
+	// Skipped contains the identifiers that are not owned by the user.
+	Skipped []string `json:"skipped"`
+	// Planned is set when the jobs of the operation have been queued.
+	Planned   bool      `json:"-"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// resolve sets the status of the operation from its counters.
+func (op *DeleteOperation) resolve() {
+	switch {
+	case op.Error != "":
+		op.Status = OperationFailed
+	case op.Pending+op.Running > 0 && op.Running+op.Done+op.Failed == 0:
+		op.Status = OperationPending
+	case op.Pending+op.Running > 0:
+		op.Status = OperationRunning
+	case !op.Planned:
+		op.Status = OperationPending
+	case op.Failed > 0:
+		op.Status = OperationFailed
+	default:
+		op.Status = OperationDone
+	}
+
+	if op.Skipped == nil {
+		op.Skipped = []string{}
+	}
+}
+
+// count adds the job to the counters of the operation.
+func (op *DeleteOperation) count(job *DeleteJob) {
+	switch {
+	case job.State == JobDone:
+		op.Done++
+	case job.State == JobDead:
+		op.Failed++
+	case job.Attempts > 0:
+		op.Running++
+	default:
+		op.Pending++
+	}
+}
+
+// JobQueue describes the contract for persisting deletion jobs between restarts of the service.
+type JobQueue interface {
+	// EnqueueDeleteJobs saves the jobs as pending, only the user, the shortened URL and the trace context are used.
+	EnqueueDeleteJobs(ctx context.Context, jobs []DeleteJob) error
+	// ClaimDeleteJobs takes up to limit pending jobs that are due at now.
+	// The attempt counter of each job is increased and the job is hidden from other workers for lease.
+	ClaimDeleteJobs(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]DeleteJob, error)
+	// CompleteDeleteJobs marks the jobs as done.
+	CompleteDeleteJobs(ctx context.Context, ids []int64) error
+	// RetryDeleteJob schedules the next attempt of the job.
+	RetryDeleteJob(ctx context.Context, id int64, nextRunAt time.Time, reason string) error
+	// BuryDeleteJob moves the job to the dead-letter state.
+	BuryDeleteJob(ctx context.Context, id int64, reason string) error
+	// DeleteJobs lists up to limit jobs in the given state, oldest first.
+	DeleteJobs(ctx context.Context, state string, limit int) ([]DeleteJob, error)
+	// CountDeleteJobs returns the number of jobs in the given state.
+	CountDeleteJobs(ctx context.Context, state string) (int, error)
+	// SaveDeleteOperation creates the operation or updates its stored fields.
+	SaveDeleteOperation(ctx context.Context, op DeleteOperation) error
+	// GetDeleteOperation returns the operation with the counters of its jobs.
+	GetDeleteOperation(ctx context.Context, id string) (DeleteOperation, error)
+}
+
+// NewJobQueue returns the queue that matches the data store:
+// the database table for PostgreSQL, a journal file at journalPath otherwise.
+// If journalPath is empty, the jobs are kept only in memory.
+func NewJobQueue(store Storage, journalPath string) (JobQueue, error) {
+	if w, ok := store.(interface{ Unwrap() Storage }); ok {
+		store = w.Unwrap()
+	}
+
+	if pg, ok := store.(*Postgresql); ok {
+		return pg, nil
+	}
+
+	return NewJournalQueue(journalPath)
+}
+
+// JournalQueue keeps deletion jobs and operations in memory and appends every change to a journal file.
+type JournalQueue struct {
+	jobs       map[int64]*DeleteJob
+	operations map[string]*DeleteOperation
+	// byOperation indexes the jobs by the operation they belong to.
+	byOperation map[string]map[int64]*DeleteJob
+	nextID      int64
+	path        string
+	file        *os.File
+	writer      *bufio.Writer
+	// lines is the number of lines in the journal, lastPrune is the time of the last removal of the expired entries.
+	lines     int
+	lastPrune time.Time
+	mu        sync.Mutex
+}
+
+// NewJournalQueue is the constructor for the JournalQueue structure.
+// The jobs from an existing journal are restored and the journal is compacted.
+// While the queue runs, the expired entries are removed once in a pruneInterval and the journal
+// is compacted again when it grows mostly outdated.
+func NewJournalQueue(path string) (*JournalQueue, error) {
+	q := &JournalQueue{
+		jobs:        make(map[int64]*DeleteJob),
+		operations:  make(map[string]*DeleteOperation),
+		byOperation: make(map[string]map[int64]*DeleteJob),
+		path:        path,
+	}
+
+	if path == "" {
+		q.lastPrune = time.Now()
+		return q, nil
+	}
+
+	if err := q.replay(path); err != nil {
+		return nil, err
+	}
+
+	q.prune(time.Now())
+
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// EnqueueDeleteJobs saves pending jobs and syncs the journal to the disk.
+func (q *JournalQueue) EnqueueDeleteJobs(_ context.Context, jobs []DeleteJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	for _, j := range jobs {
+		q.nextID++
+
+		job := &DeleteJob{
+			ID:          q.nextID,
+			UserID:      j.UserID,
+			ShortURL:    j.ShortURL,
+			State:       JobPending,
+			NextRunAt:   now,
+			CreatedAt:   now,
+			TraceParent: j.TraceParent,
+			OperationID: j.OperationID,
+		}
+		q.addJob(job)
+
+		if err := q.append(job); err != nil {
+			return err
+		}
+	}
+
+	return q.sync()
+}
+
+// ClaimDeleteJobs takes the due pending jobs in the order they were added.
+func (q *JournalQueue) ClaimDeleteJobs(_ context.Context, now time.Time, limit int, lease time.Duration) ([]DeleteJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	due := make([]*DeleteJob, 0)
+	for _, job := range q.jobs {
+		if job.State == JobPending && !job.NextRunAt.After(now) {
+			due = append(due, job)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool { return due[i].ID < due[j].ID })
+
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	rst := make([]DeleteJob, 0, len(due))
+	for _, job := range due {
+		job.Attempts++
+		job.NextRunAt = now.Add(lease).UTC()
+
+		if err := q.append(job); err != nil {
+			return nil, err
+		}
+
+		rst = append(rst, *job)
+	}
+
+	return rst, q.flush()
+}
+
+// CompleteDeleteJobs marks the jobs as done.
+func (q *JournalQueue) CompleteDeleteJobs(_ context.Context, ids []int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, id := range ids {
+		job, ok := q.jobs[id]
+		if !ok {
+			return ErrNotFoundJob
+		}
+
+		job.State = JobDone
+		job.LastError = ""
+
+		if err := q.append(job); err != nil {
+			return err
+		}
+	}
+
+	if err := q.flush(); err != nil {
+		return err
+	}
+
+	q.maintain(time.Now())
+	return nil
+}
+
+// maintain removes the expired entries if a pruneInterval has passed since the last removal
+// and compacts the journal if most of its lines are outdated. The journal stays as it is if it cannot
+// be compacted, the next completion retries.
+func (q *JournalQueue) maintain(now time.Time) {
+	if now.Sub(q.lastPrune) >= pruneInterval {
+		q.prune(now)
+	}
+
+	if q.file == nil || q.lines < compactMinLines || q.lines < 2*(len(q.jobs)+len(q.operations)) {
+		return
+	}
+
+	q.compact()
+}
+
+// prune removes the operations created before the retention period and the done jobs
+// that do not belong to a kept operation.
+func (q *JournalQueue) prune(now time.Time) {
+	q.lastPrune = now
+	expired := now.Add(-operationRetention)
+
+	for id, op := range q.operations {
+		if op.CreatedAt.Before(expired) {
+			delete(q.operations, id)
+			delete(q.byOperation, id)
+		}
+	}
+
+	for id, job := range q.jobs {
+		if _, ok := q.operations[job.OperationID]; job.State == JobDone && !ok {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// RetryDeleteJob schedules the next attempt of the job.
+func (q *JournalQueue) RetryDeleteJob(_ context.Context, id int64, nextRunAt time.Time, reason string) error {
+	return q.update(id, func(job *DeleteJob) {
+		job.NextRunAt = nextRunAt.UTC()
+		job.LastError = reason
+	})
+}
+
+// BuryDeleteJob moves the job to the dead-letter state.
+func (q *JournalQueue) BuryDeleteJob(_ context.Context, id int64, reason string) error {
+	return q.update(id, func(job *DeleteJob) {
+		job.State = JobDead
+		job.LastError = reason
+	})
+}
+
+// DeleteJobs lists up to limit jobs in the given state, oldest first.
+func (q *JournalQueue) DeleteJobs(_ context.Context, state string, limit int) ([]DeleteJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rst := make([]DeleteJob, 0)
+	for _, job := range q.jobs {
+		if job.State == state {
+			rst = append(rst, *job)
+		}
+	}
+
+	sort.Slice(rst, func(i, j int) bool { return rst[i].ID < rst[j].ID })
+
+	if len(rst) > limit {
+		rst = rst[:limit]
+	}
+
+	return rst, nil
+}
+
+// CountDeleteJobs returns the number of jobs in the given state.
+func (q *JournalQueue) CountDeleteJobs(_ context.Context, state string) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var n int
+	for _, job := range q.jobs {
+		if job.State == state {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// SaveDeleteOperation creates the operation or updates its stored fields.
+func (q *JournalQueue) SaveDeleteOperation(_ context.Context, op DeleteOperation) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stored := &DeleteOperation{
+		ID:        op.ID,
+		UserID:    op.UserID,
+		Requested: op.Requested,
+		Skipped:   op.Skipped,
+		Planned:   op.Planned,
+		Error:     op.Error,
+		CreatedAt: op.CreatedAt.UTC(),
+	}
+	q.operations[op.ID] = stored
+
+	if err := q.appendOperation(stored); err != nil {
+		return err
+	}
+
+	return q.sync()
+}
+
+// GetDeleteOperation returns the operation with the counters of its jobs.
+func (q *JournalQueue) GetDeleteOperation(_ context.Context, id string) (DeleteOperation, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stored, ok := q.operations[id]
+	if !ok {
+		return DeleteOperation{}, ErrNotFoundOperation
+	}
+
+	op := *stored
+	for _, job := range q.byOperation[id] {
+		op.count(job)
+	}
+	op.resolve()
+
+	return op, nil
+}
+
+// Close writes the buffered changes and closes the journal.
+func (q *JournalQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.file == nil {
+		return nil
+	}
+
+	if err := q.writer.Flush(); err != nil {
+		q.file.Close()
+		return err
+	}
+
+	return q.file.Close()
+}
+
+// addJob adds the job or replaces its previous state.
+func (q *JournalQueue) addJob(job *DeleteJob) {
+	q.jobs[job.ID] = job
+
+	if job.OperationID == "" {
+		return
+	}
+
+	jobs, ok := q.byOperation[job.OperationID]
+	if !ok {
+		jobs = make(map[int64]*DeleteJob)
+		q.byOperation[job.OperationID] = jobs
+	}
+	jobs[job.ID] = job
+}
+
+func (q *JournalQueue) update(id int64, fn func(job *DeleteJob)) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return ErrNotFoundJob
+	}
+
+	fn(job)
+
+	if err := q.append(job); err != nil {
+		return err
+	}
+
+	return q.flush()
+}
+
+func (q *JournalQueue) replay(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+
+		if entry.Operation != nil {
+			q.operations[entry.Operation.ID] = entry.Operation
+		}
+
+		job := entry.Job
+		if job == nil && entry.Operation == nil {
+			// Lines written before operations were introduced contain a bare job.
+			job = &DeleteJob{}
+			if err := json.Unmarshal(scanner.Bytes(), job); err != nil || job.ID == 0 {
+				continue
+			}
+		}
+
+		if job != nil {
+			q.addJob(job)
+			if job.ID > q.nextID {
+				q.nextID = job.ID
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// compact rewrites the journal with only the last state of the jobs and operations.
+func (q *JournalQueue) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), ".jobs-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if q.file != nil {
+		if err := q.writer.Flush(); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	file, writer, lines := q.file, q.writer, q.lines
+	q.file, q.writer, q.lines = tmp, bufio.NewWriter(tmp), 0
+
+	// The queue keeps appending to the previous journal if it cannot be replaced.
+	restore := func(err error) error {
+		tmp.Close()
+		q.file, q.writer, q.lines = file, writer, lines
+		return err
+	}
+
+	for _, op := range q.operations {
+		if err := q.appendOperation(op); err != nil {
+			return restore(err)
+		}
+	}
+
+	for _, job := range q.jobs {
+		if err := q.append(job); err != nil {
+			return restore(err)
+		}
+	}
+
+	if err := q.sync(); err != nil {
+		return restore(err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return restore(err)
+	}
+
+	if err := os.Rename(tmp.Name(), q.path); err != nil {
+		return restore(err)
+	}
+
+	if file != nil {
+		file.Close()
+	}
+
+	file, err = os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		q.file, q.writer = nil, nil
+		return err
+	}
+
+	q.file, q.writer = file, bufio.NewWriter(file)
+	return nil
+}
+
+// journalEntry is a line of the journal, it contains either a job or an operation.
+type journalEntry struct {
+	Job       *DeleteJob       `json:"job,omitempty"`
+	Operation *DeleteOperation `json:"operation,omitempty"`
+}
+
+// journalOperation keeps the fields of the operation that are hidden from the API.
+type journalOperation struct {
+	DeleteOperation
+	UserID  string `json:"user_id"`
+	Planned bool   `json:"planned"`
+}
+
+// MarshalJSON encodes all stored fields of the operation for the journal.
+func (e journalEntry) MarshalJSON() ([]byte, error) {
+	type entry struct {
+		Job       *DeleteJob        `json:"job,omitempty"`
+		Operation *journalOperation `json:"operation,omitempty"`
+	}
+
+	rst := entry{Job: e.Job}
+	if e.Operation != nil {
+		rst.Operation = &journalOperation{DeleteOperation: *e.Operation, UserID: e.Operation.UserID, Planned: e.Operation.Planned}
+	}
+
+	return json.Marshal(rst)
+}
+
+// UnmarshalJSON decodes the line of the journal.
+func (e *journalEntry) UnmarshalJSON(data []byte) error {
+	var entry struct {
+		Job       *DeleteJob        `json:"job"`
+		Operation *journalOperation `json:"operation"`
+	}
+
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return err
+	}
+
+	e.Job = entry.Job
+	if entry.Operation != nil {
+		op := entry.Operation.DeleteOperation
+		op.UserID, op.Planned = entry.Operation.UserID, entry.Operation.Planned
+		e.Operation = &op
+	}
+
+	return nil
+}
+
+func (q *JournalQueue) append(job *DeleteJob) error {
+	return q.appendEntry(journalEntry{Job: job})
+}
+
+func (q *JournalQueue) appendOperation(op *DeleteOperation) error {
+	return q.appendEntry(journalEntry{Operation: op})
+}
+
+func (q *JournalQueue) appendEntry(entry journalEntry) error {
+	if q.file == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.writer.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	q.lines++
+	return nil
+}
+
+func (q *JournalQueue) flush() error {
+	if q.file == nil {
+		return nil
+	}
+
+	return q.writer.Flush()
+}
+
+func (q *JournalQueue) sync() error {
+	if err := q.flush(); err != nil || q.file == nil {
+		return err
+	}
+
+	return q.file.Sync()
+}
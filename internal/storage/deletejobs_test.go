@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournalQueuePrune(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "jobs.log")
+
+	q, err := NewJournalQueue(path)
+	require.NoError(t, err)
+	defer q.Close()
+
+	created := time.Now().Add(-operationRetention - time.Minute)
+	require.NoError(t, q.SaveDeleteOperation(ctx, DeleteOperation{ID: "old", UserID: "1", Planned: true, CreatedAt: created}))
+	require.NoError(t, q.SaveDeleteOperation(ctx, DeleteOperation{ID: "new", UserID: "1", Planned: true, CreatedAt: time.Now()}))
+
+	jobs := make([]DeleteJob, compactMinLines)
+	for i := range jobs {
+		jobs[i] = DeleteJob{UserID: "1", ShortURL: "http://localhost:8080/a", OperationID: "old"}
+	}
+	jobs[0].OperationID = "new"
+	jobs[1].ShortURL = "http://localhost:8080/b"
+	require.NoError(t, q.EnqueueDeleteJobs(ctx, jobs))
+
+	claimed, err := q.ClaimDeleteJobs(ctx, time.Now(), len(jobs), time.Minute)
+	require.NoError(t, err)
+
+	ids := make([]int64, 0, len(claimed))
+	for _, job := range claimed {
+		if job.ShortURL != "http://localhost:8080/b" {
+			ids = append(ids, job.ID)
+		}
+	}
+
+	// The expired operation and its done jobs are removed at runtime, the pending job is kept.
+	q.lastPrune = time.Now().Add(-pruneInterval)
+	require.NoError(t, q.CompleteDeleteJobs(ctx, ids))
+
+	_, err = q.GetDeleteOperation(ctx, "old")
+	assert.ErrorIs(t, err, ErrNotFoundOperation)
+	assert.NotContains(t, q.byOperation, "old")
+
+	op, err := q.GetDeleteOperation(ctx, "new")
+	require.NoError(t, err)
+	assert.Equal(t, 1, op.Done)
+
+	n, err := q.CountDeleteJobs(ctx, JobDone)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	n, err = q.CountDeleteJobs(ctx, JobPending)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// The journal is compacted to the remaining entries.
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	lines := 0
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		lines++
+	}
+	assert.Equal(t, 3, lines)
+
+	restored, err := NewJournalQueue(path)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	op, err = restored.GetDeleteOperation(ctx, "new")
+	require.NoError(t, err)
+	assert.Equal(t, OperationDone, op.Status)
+}
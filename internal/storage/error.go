@@ -7,4 +7,10 @@ var (
 	ErrUniqueValue = errors.New("not unique value")
 	ErrDeletedURL  = errors.New("URL mark on deleted")
 	ErrNotFoundURL = errors.New("URL not found")
+	// ErrNotFoundVariant is returned if the URL has no variant with the index.
+	ErrNotFoundVariant = errors.New("variant not found")
+	// ErrNotFoundQuota is returned if the user has no quota of their own.
+	ErrNotFoundQuota = errors.New("quota not found")
+	// ErrQuotaExceeded is returned if the user already holds as many active links as the quota allows.
+	ErrQuotaExceeded = errors.New("quota exceeded")
 )
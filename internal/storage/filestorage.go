@@ -3,110 +3,697 @@ package storage
 import (
 	"bufio"
 	"context"
-	"errors"
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 // FileStorage manages the storage of data in a file on disk.
+// Each change of a URL appends a JSON line with its whole state to the file,
+// the lines written by the previous versions are still read, see parseRecord.
+// The changes of the users' quotas are appended as separate lines, see quotaEntry.
+// The redirects to the variants are counted in memory and the clicked URLs are written
+// once in a clickWriteInterval, see AddVariantClick.
 type FileStorage struct {
 	file       *os.File
 	writer     *bufio.Writer
 	memStorage *MemStorage
+	// clicked are the URLs whose clicks have not been written since clicksWritten.
+	clicked       map[string]struct{}
+	clicksWritten time.Time
+	// batch defers the rewrite of the file after the replaced records until EndBatch,
+	// stale is set if the file has the replaced records.
+	batch, stale bool
+	mu           sync.Mutex
 }
 
+// clickWriteInterval is how often the URLs with the new clicks of their variants are written to the file.
+const clickWriteInterval = 10 * time.Second
+
 // NewFileStorage is a constructor for the FileStorage structure.
 func NewFileStorage(ctx context.Context, filePath string) *FileStorage {
 	flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
 	file, _ := os.OpenFile(filePath, flag, 0777)
 
 	return &FileStorage{
-		file:       file,
-		writer:     bufio.NewWriter(file),
-		memStorage: createMemStorage(ctx, filePath),
+		file:          file,
+		writer:        bufio.NewWriter(file),
+		memStorage:    createMemStorage(filePath),
+		clicked:       make(map[string]struct{}),
+		clicksWritten: time.Now(),
 	}
 }
 
-// Add writes the original and its shortened URL by user id.
-func (f *FileStorage) Add(ctx context.Context, userID, shortURL, origURL string) error {
-	_, errUser := f.memStorage.GetByUser(ctx, userID)
-	_, errURLs := f.memStorage.Get(ctx, shortURL)
-	if errUser != nil || errURLs != nil {
-		err := f.memStorage.Add(ctx, userID, shortURL, origURL)
-		if err != nil {
-			return err
-		}
+// Get retrieves the link by its shortened URL. In-memory storage is used for acceleration.
+func (f *FileStorage) Get(ctx context.Context, shortURL string) (Link, error) {
+	return f.memStorage.Get(ctx, shortURL)
+}
 
-		data := fmt.Sprintf("%s=%s=%s\n", userID, shortURL, origURL)
-		if _, err := f.writer.Write([]byte(data)); err != nil {
-			return err
-		}
+// GetByUser gets the links of the user. In-memory storage is used for acceleration.
+func (f *FileStorage) GetByUser(ctx context.Context, userID string) ([]Link, error) {
+	return f.memStorage.GetByUser(ctx, userID)
+}
 
-		return f.writer.Flush()
+// CheckStorage checks that the file is still present on the disk and that buffered data can be written to it.
+func (f *FileStorage) CheckStorage(_ context.Context) error {
+	info, err := f.file.Stat()
+	if err != nil {
+		return err
 	}
 
-	return nil
+	if _, err = os.Stat(f.file.Name()); err != nil {
+		return err
+	}
+
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", f.file.Name())
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.writeClicks(); err != nil {
+		return err
+	}
+
+	return f.writer.Flush()
 }
 
-// Get retrieves the original URL by its shortened value. In-memory storage is used for acceleration.
-func (f *FileStorage) Get(ctx context.Context, shortURL string) (string, error) {
-	return f.memStorage.Get(ctx, shortURL)
+// DeleteBatch marks as deleted the shortened URLs owned by the user and writes them to the file at once.
+func (f *FileStorage) DeleteBatch(_ context.Context, userID string, shortURLs []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.memStorage.mu.Lock()
+	deleted := f.memStorage.deleteOwned(userID, shortURLs, time.Now().UTC())
+	f.memStorage.mu.Unlock()
+
+	return f.write(f.records(userID, deleted)...)
 }
 
-// GetByUser gets a map of URLs by user ID. In-memory storage is used for acceleration.
-func (f *FileStorage) GetByUser(ctx context.Context, userID string) (map[string]string, error) {
-	return f.memStorage.GetByUser(ctx, userID)
+// Restore clears the deletion mark of the user's URLs deleted after deletedAfter within the quota of the user
+// and writes them to the file.
+func (f *FileStorage) Restore(_ context.Context, userID string, shortURLs []string, deletedAfter time.Time, defaultQuota int) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.memStorage.mu.Lock()
+	restored, err := f.memStorage.restoreOwned(userID, shortURLs, deletedAfter, defaultQuota)
+	f.memStorage.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.write(f.records(userID, restored)...); err != nil {
+		return nil, err
+	}
+
+	return restored, nil
 }
 
-// CheckStorage checks for the presence of a file.
-func (f *FileStorage) CheckStorage(_ context.Context) error {
-	_, err := f.file.Stat()
-	return err
+// GetDeletedByUser lists the user's deleted URLs. In-memory storage is used for acceleration.
+func (f *FileStorage) GetDeletedByUser(ctx context.Context, userID string) ([]DeletedURL, error) {
+	return f.memStorage.GetDeletedByUser(ctx, userID)
+}
+
+// Walk calls fn for every record with the shortened URL greater than after, in the order of shortened URLs.
+// In-memory storage is used for acceleration.
+func (f *FileStorage) Walk(ctx context.Context, after string, fn func(Link) error) error {
+	return f.memStorage.Walk(ctx, after, fn)
+}
+
+// WalkByUser calls fn for every record of the user, including the deleted ones, in the order of shortened URLs.
+// In-memory storage is used for acceleration.
+func (f *FileStorage) WalkByUser(ctx context.Context, userID string, fn func(Link) error) error {
+	return f.memStorage.WalkByUser(ctx, userID, fn)
+}
+
+// ListByUser returns the page of the user's URLs selected by the query. In-memory storage is used for acceleration.
+func (f *FileStorage) ListByUser(ctx context.Context, userID string, q ListQuery) ([]Link, error) {
+	return f.memStorage.ListByUser(ctx, userID, q)
+}
+
+// Put saves the record and writes it to the file. If the shortened URL exists,
+// ErrUniqueValue is returned unless overwrite is set, the replaced record is dropped by rewriting the file.
+// Within a batch, the record is appended and the file is rewritten once by EndBatch.
+func (f *FileStorage) Put(_ context.Context, link Link, overwrite bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.memStorage.mu.Lock()
+	defer f.memStorage.mu.Unlock()
+
+	replaced, err := f.memStorage.put(link, overwrite)
+	if err != nil {
+		return err
+	}
+
+	if replaced && !f.batch {
+		return f.rewrite()
+	}
+
+	f.stale = f.stale || replaced
+
+	link, _ = f.memStorage.link(link.UserID, link.ShortURL)
+	return f.write(link)
+}
+
+// BeginBatch defers the rewrites of the file after the records replaced by Put until EndBatch.
+func (f *FileStorage) BeginBatch() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.batch = true
+}
+
+// EndBatch rewrites the file if the records were replaced since BeginBatch.
+func (f *FileStorage) EndBatch() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.batch = false
+	if !f.stale {
+		return nil
+	}
+
+	f.memStorage.mu.Lock()
+	defer f.memStorage.mu.Unlock()
+
+	return f.rewrite()
+}
+
+// Create stores the new link within the quota of the user and writes it to the file.
+func (f *FileStorage) Create(_ context.Context, link Link, defaultQuota int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.memStorage.mu.Lock()
+	defer f.memStorage.mu.Unlock()
+
+	if err := f.memStorage.create(link, defaultQuota); err != nil {
+		return err
+	}
+
+	link, _ = f.memStorage.link(link.UserID, link.ShortURL)
+	return f.write(link)
+}
+
+// Update applies the patch to the URL and writes it to the file. The URL must be owned by the user
+// unless userID is empty, as for the changes made by the administrators.
+func (f *FileStorage) Update(_ context.Context, userID, shortURL string, patch LinkPatch) (Link, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.memStorage.mu.Lock()
+	link, err := f.memStorage.update(userID, shortURL, patch)
+	f.memStorage.mu.Unlock()
+
+	if err != nil {
+		return Link{}, err
+	}
+
+	return link, f.write(link)
 }
 
-// Delete marks the URL as deleted in the file.
-func (f *FileStorage) Delete(ctx context.Context, shortURL string) error {
-	origURL, err := f.memStorage.Get(ctx, shortURL)
+// AddVariantClick counts a redirect to the variant of the URL. The URL is not written to the file on every
+// redirect, the clicked URLs are written at once when clickWriteInterval has passed, the storage is checked
+// or closed. The clicks counted since the last write are lost if the service crashes.
+func (f *FileStorage) AddVariantClick(_ context.Context, shortURL string, variant int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.memStorage.mu.Lock()
+	_, err := f.memStorage.addVariantClick(shortURL, variant)
+	f.memStorage.mu.Unlock()
+
 	if err != nil {
 		return err
 	}
 
-	f.memStorage.Delete(ctx, shortURL)
+	f.clicked[shortURL] = struct{}{}
+
+	if time.Since(f.clicksWritten) < clickWriteInterval {
+		return nil
+	}
+
+	return f.writeClicks()
+}
+
+// writeClicks appends the URLs clicked since the last write to the file, the caller must hold the mutex.
+func (f *FileStorage) writeClicks() error {
+	f.clicksWritten = time.Now()
+
+	if len(f.clicked) == 0 {
+		return nil
+	}
+
+	f.memStorage.mu.RLock()
+	links := make([]Link, 0, len(f.clicked))
+	for userID, shortURLs := range f.memStorage.users {
+		for _, v := range shortURLs {
+			if _, ok := f.clicked[v]; !ok {
+				continue
+			}
+
+			if link, ok := f.memStorage.link(userID, v); ok {
+				links = append(links, link)
+			}
+		}
+	}
+	f.memStorage.mu.RUnlock()
+
+	f.clicked = make(map[string]struct{})
+
+	return f.write(links...)
+}
+
+// GetTags lists the tags of the user's URLs. In-memory storage is used for acceleration.
+func (f *FileStorage) GetTags(ctx context.Context, userID string) ([]Tag, error) {
+	return f.memStorage.GetTags(ctx, userID)
+}
+
+// RenameTag renames the tag of the user's URLs and writes the changed URLs to the file.
+func (f *FileStorage) RenameTag(_ context.Context, userID, name, newName string) (int, error) {
+	return f.retag(userID, name, newName)
+}
+
+// DeleteTag removes the tag from the user's URLs and writes the changed URLs to the file.
+func (f *FileStorage) DeleteTag(_ context.Context, userID, name string) (int, error) {
+	return f.retag(userID, name, "")
+}
+
+func (f *FileStorage) retag(userID, name, newName string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.memStorage.mu.Lock()
+	changed := f.memStorage.retag(userID, name, newName)
+	f.memStorage.mu.Unlock()
+
+	return len(changed), f.write(f.records(userID, changed)...)
+}
+
+// Purge permanently removes up to limit URLs deleted before deletedBefore from memory
+// and drops their records by rewriting the file. With dryRun, the URLs are only counted.
+func (f *FileStorage) Purge(_ context.Context, deletedBefore time.Time, limit int, dryRun bool) (PurgeResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.memStorage.mu.Lock()
+	defer f.memStorage.mu.Unlock()
+
+	rst := f.memStorage.purge(deletedBefore, limit, dryRun)
+	if dryRun || rst.URLs+rst.Orphans == 0 {
+		return rst, nil
+	}
+
+	return rst, f.rewrite()
+}
+
+// CountActive returns the number of the user's links that are not deleted. In-memory storage is used for acceleration.
+func (f *FileStorage) CountActive(ctx context.Context, userID string) (int, error) {
+	return f.memStorage.CountActive(ctx, userID)
+}
+
+// GetQuota returns the quota of the user. In-memory storage is used for acceleration.
+func (f *FileStorage) GetQuota(ctx context.Context, userID string) (int, error) {
+	return f.memStorage.GetQuota(ctx, userID)
+}
+
+// SetQuota sets the quota of the user and writes it to the file.
+func (f *FileStorage) SetQuota(ctx context.Context, userID string, quota int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.memStorage.SetQuota(ctx, userID, quota)
+
+	return f.writeQuota(userID, &quota)
+}
+
+// DeleteQuota removes the quota of the user and writes the removal to the file.
+func (f *FileStorage) DeleteQuota(ctx context.Context, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.memStorage.DeleteQuota(ctx, userID); err != nil {
+		return err
+	}
+
+	return f.writeQuota(userID, nil)
+}
 
-	userID, ok := ctx.Value("userID").(string)
-	if !ok {
-		return errors.New("UserID not found")
+// writeQuota appends the quota of the user to the file, nil for the removed quota. The caller must hold the mutex.
+func (f *FileStorage) writeQuota(userID string, quota *int) error {
+	line, err := encodeQuota(userID, quota)
+	if err != nil {
+		return err
 	}
 
-	data := fmt.Sprintf("%s=%s=%s=%s\n", userID, shortURL, origURL, "true")
-	if _, err := f.writer.Write([]byte(data)); err != nil {
+	if _, err := f.writer.WriteString(line); err != nil {
 		return err
 	}
 
 	return f.writer.Flush()
 }
 
-// Close closes the file after writing, reading.
+// rewrite replaces the file with the current records, the caller must hold both mutexes.
+// The records are written to a temporary file which is then renamed, so the file is never left half-written.
+func (f *FileStorage) rewrite() error {
+	name := f.file.Name()
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, link := range f.memStorage.records("", "") {
+		line, err := encodeRecord(link)
+		if err == nil {
+			_, err = w.WriteString(line)
+		}
+
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	users := make([]string, 0, len(f.memStorage.quotas))
+	for userID := range f.memStorage.quotas {
+		users = append(users, userID)
+	}
+	sort.Strings(users)
+
+	for _, userID := range users {
+		quota := f.memStorage.quotas[userID]
+
+		line, err := encodeQuota(userID, &quota)
+		if err == nil {
+			_, err = w.WriteString(line)
+		}
+
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := f.writer.Flush(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0777)
+	if err != nil {
+		return err
+	}
+
+	f.file.Close()
+	f.file, f.writer = file, bufio.NewWriter(file)
+	f.clicked = make(map[string]struct{})
+	f.stale = false
+
+	return nil
+}
+
+// Close writes the buffered data and closes the file.
 func (f *FileStorage) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.writeClicks(); err != nil {
+		f.file.Close()
+		return err
+	}
+
+	if err := f.writer.Flush(); err != nil {
+		f.file.Close()
+		return err
+	}
+
 	return f.file.Close()
 }
 
-func createMemStorage(ctx context.Context, filePath string) *MemStorage {
+// write appends the records to the file, the caller must hold the mutex.
+func (f *FileStorage) write(links ...Link) error {
+	for _, link := range links {
+		line, err := encodeRecord(link)
+		if err != nil {
+			return err
+		}
+
+		if _, err := f.writer.WriteString(line); err != nil {
+			return err
+		}
+	}
+
+	return f.writer.Flush()
+}
+
+// records returns the current state of the user's URLs, the caller must hold the mutex of the file.
+func (f *FileStorage) records(userID string, shortURLs []string) []Link {
+	f.memStorage.mu.RLock()
+	defer f.memStorage.mu.RUnlock()
+
+	rst := make([]Link, 0, len(shortURLs))
+	for _, v := range shortURLs {
+		if link, ok := f.memStorage.link(userID, v); ok {
+			rst = append(rst, link)
+		}
+	}
+
+	return rst
+}
+
+// fileEntry is a line of the file with the whole state of the URL.
+type fileEntry struct {
+	UserID       string     `json:"user_id"`
+	ShortURL     string     `json:"short_url"`
+	OriginalURL  string     `json:"original_url"`
+	Deleted      bool       `json:"deleted,omitempty"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+	CreatedAt    *time.Time `json:"created_at,omitempty"`
+	UpdatedAt    *time.Time `json:"updated_at,omitempty"`
+	Title        string     `json:"title,omitempty"`
+	Notes        string     `json:"notes,omitempty"`
+	Tags         []string   `json:"tags,omitempty"`
+	RedirectType int        `json:"redirect_type,omitempty"`
+	Passthrough  bool       `json:"passthrough,omitempty"`
+	UTM          *UTM       `json:"utm,omitempty"`
+	Rules        []Rule     `json:"rules,omitempty"`
+	Variants     []Variant  `json:"variants,omitempty"`
+	Threat       string     `json:"threat,omitempty"`
+}
+
+func encodeRecord(link Link) (string, error) {
+	e := fileEntry{
+		UserID:       link.UserID,
+		ShortURL:     link.ShortURL,
+		OriginalURL:  link.OriginalURL,
+		Deleted:      link.Deleted,
+		DeletedAt:    timeOrNil(link.DeletedAt),
+		CreatedAt:    timeOrNil(link.CreatedAt),
+		UpdatedAt:    timeOrNil(link.UpdatedAt),
+		Title:        link.Title,
+		Notes:        link.Notes,
+		Tags:         link.Tags,
+		RedirectType: link.RedirectType,
+		Passthrough:  link.Passthrough,
+		UTM:          utmOrNil(link.UTM),
+		Rules:        link.Rules,
+		Variants:     link.Variants,
+		Threat:       link.Threat,
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data) + "\n", nil
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+func timeOrZero(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+
+	return *t
+}
+
+func createMemStorage(filePath string) *MemStorage {
 	storage := NewMemStorage()
 
 	file, err := os.OpenFile(filePath, os.O_RDONLY|os.O_CREATE, 0777)
 	if err == nil {
+		defer file.Close()
+
 		scanner := bufio.NewScanner(file)
 		for scanner.Scan() {
-			data := scanner.Text()
-			arr := strings.Split(data, "=")
-			storage.Add(ctx, arr[0], arr[1], arr[2])
-			if len(arr) > 3 && arr[3] == "true" {
-				storage.Delete(ctx, arr[1])
+			if q, ok := parseQuota(scanner.Text()); ok {
+				if q.Quota != nil {
+					storage.quotas[q.UserID] = *q.Quota
+				} else {
+					storage.deleteQuota(q.UserID)
+				}
+				continue
+			}
+
+			if rec, ok := parseRecord(scanner.Text()); ok {
+				replay(storage, rec)
 			}
 		}
 	}
 
 	return storage
 }
+
+// replay applies the line of the file to the storage.
+func replay(storage *MemStorage, rec fileRecord) {
+	link, exists := storage.links[rec.link.ShortURL]
+	if !exists {
+		storage.users[rec.link.UserID] = append(storage.users[rec.link.UserID], rec.link.ShortURL)
+	}
+
+	switch {
+	case rec.full || !exists:
+		link = rec.link
+	case rec.mark:
+		link.Deleted, link.DeletedAt = rec.link.Deleted, rec.link.DeletedAt
+	}
+
+	storage.links[link.ShortURL] = link
+}
+
+// quotaEntry is the line of the file with the quota of the user, the removed quota is null.
+// The previous versions skip the line, as it has no shortened URL.
+type quotaEntry struct {
+	UserID string `json:"quota_user_id"`
+	Quota  *int   `json:"quota"`
+}
+
+func encodeQuota(userID string, quota *int) (string, error) {
+	data, err := json.Marshal(quotaEntry{UserID: userID, Quota: quota})
+	if err != nil {
+		return "", err
+	}
+
+	return string(data) + "\n", nil
+}
+
+// parseQuota parses the line of the file with the quota of the user.
+func parseQuota(line string) (quotaEntry, bool) {
+	if !strings.HasPrefix(line, `{"quota_user_id":`) {
+		return quotaEntry{}, false
+	}
+
+	var q quotaEntry
+	if err := json.Unmarshal([]byte(line), &q); err != nil || q.UserID == "" {
+		return quotaEntry{}, false
+	}
+
+	return q, true
+}
+
+type fileRecord struct {
+	link Link
+	// full is set for the lines with the whole state of the URL. The lines of the previous versions
+	// either add the URL or, if mark is set, change its deletion mark.
+	full bool
+	mark bool
+}
+
+// parseRecord parses a line of the file. The lines written by the previous versions are in the format
+// user=short=original, a deleted URL has the "=true=<time of deletion>" or "=true" suffix
+// and a restored URL the "=false" suffix. The original URL may contain '=',
+// so the deletion mark and the time of deletion are taken from the end of the line.
+func parseRecord(line string) (fileRecord, bool) {
+	if strings.HasPrefix(line, "{") {
+		var e fileEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil || e.ShortURL == "" {
+			return fileRecord{}, false
+		}
+
+		link := Link{
+			UserID:       e.UserID,
+			ShortURL:     e.ShortURL,
+			OriginalURL:  e.OriginalURL,
+			Deleted:      e.Deleted,
+			DeletedAt:    timeOrZero(e.DeletedAt),
+			CreatedAt:    timeOrZero(e.CreatedAt),
+			UpdatedAt:    timeOrZero(e.UpdatedAt),
+			Title:        e.Title,
+			Notes:        e.Notes,
+			Tags:         e.Tags,
+			RedirectType: e.RedirectType,
+			Passthrough:  e.Passthrough,
+			Rules:        e.Rules,
+			Variants:     e.Variants,
+			Threat:       e.Threat,
+		}
+
+		if e.UTM != nil {
+			link.UTM = *e.UTM
+		}
+
+		return fileRecord{link: link, full: true}, true
+	}
+
+	arr := strings.SplitN(line, "=", 3)
+	if len(arr) < 3 {
+		return fileRecord{}, false
+	}
+
+	rec := fileRecord{link: Link{UserID: arr[0], ShortURL: arr[1], OriginalURL: arr[2]}}
+
+	parts := strings.Split(arr[2], "=")
+	n := len(parts)
+
+	if n > 2 && parts[n-2] == "true" {
+		if deletedAt, err := time.Parse(time.RFC3339Nano, parts[n-1]); err == nil {
+			rec.link.OriginalURL = strings.Join(parts[:n-2], "=")
+			rec.link.Deleted, rec.link.DeletedAt, rec.mark = true, deletedAt, true
+			return rec, true
+		}
+	}
+
+	if n > 1 && (parts[n-1] == "true" || parts[n-1] == "false") {
+		rec.link.OriginalURL = strings.Join(parts[:n-1], "=")
+		rec.link.Deleted, rec.mark = parts[n-1] == "true", true
+	}
+
+	return rec, true
+}
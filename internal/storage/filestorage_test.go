@@ -0,0 +1,456 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRecord(t *testing.T) {
+	at := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	link := Link{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com"}
+
+	withQuery := link
+	withQuery.OriginalURL = "http://example.com/?q=1"
+
+	tests := []struct {
+		name string
+		line string
+		want fileRecord
+		ok   bool
+	}{
+		{
+			name: "added",
+			line: "1=http://localhost:8080/a=http://example.com",
+			want: fileRecord{link: link},
+			ok:   true,
+		},
+		{
+			name: "deleted by the previous versions",
+			line: "1=http://localhost:8080/a=http://example.com=true",
+			want: fileRecord{link: Link{UserID: "1", ShortURL: link.ShortURL, OriginalURL: link.OriginalURL, Deleted: true}, mark: true},
+			ok:   true,
+		},
+		{
+			name: "deleted with query in original URL",
+			line: "1=http://localhost:8080/a=http://example.com/?q=1=true=2023-10-01T12:00:00Z",
+			want: fileRecord{
+				link: Link{UserID: "1", ShortURL: link.ShortURL, OriginalURL: withQuery.OriginalURL, Deleted: true, DeletedAt: at},
+				mark: true,
+			},
+			ok: true,
+		},
+		{
+			name: "restored",
+			line: "1=http://localhost:8080/a=http://example.com=false",
+			want: fileRecord{link: link, mark: true},
+			ok:   true,
+		},
+		{
+			name: "deleted",
+			line: `{"user_id":"1","short_url":"http://localhost:8080/a","original_url":"http://example.com/?q=1",` +
+				`"deleted":true,"deleted_at":"2023-10-01T12:00:00Z","created_at":"2023-10-01T12:00:00Z",` +
+				`"updated_at":"2023-10-01T12:00:00Z","title":"Example","notes":"a=b"}`,
+			want: fileRecord{
+				link: Link{
+					UserID:      "1",
+					ShortURL:    link.ShortURL,
+					OriginalURL: withQuery.OriginalURL,
+					Deleted:     true,
+					DeletedAt:   at,
+					CreatedAt:   at,
+					UpdatedAt:   at,
+					Title:       "Example",
+					Notes:       "a=b",
+				},
+				full: true,
+			},
+			ok: true,
+		},
+		{
+			name: "active",
+			line: `{"user_id":"1","short_url":"http://localhost:8080/a","original_url":"http://example.com"}`,
+			want: fileRecord{link: link, full: true},
+			ok:   true,
+		},
+		{
+			name: "broken",
+			line: "1=http://localhost:8080/a",
+			ok:   false,
+		},
+		{
+			name: "broken JSON",
+			line: `{"user_id":"1"`,
+			ok:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, ok := parseRecord(tt.line)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, rec)
+		})
+	}
+}
+
+func TestFileStorageRestore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls")
+
+	f := NewFileStorage(ctx, path)
+	require.NoError(t, f.Put(ctx,
+		Link{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com/a"}, false))
+	require.NoError(t, f.Put(ctx,
+		Link{UserID: "1", ShortURL: "http://localhost:8080/b", OriginalURL: "http://example.com/b"}, false))
+	require.NoError(t, f.DeleteBatch(ctx, "1", []string{"http://localhost:8080/a", "http://localhost:8080/b"}))
+
+	// The URLs deleted already are not deleted again.
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, f.DeleteBatch(ctx, "1", []string{"http://localhost:8080/a"}))
+	after, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), after.Size())
+
+	restored, err := f.Restore(ctx, "1", []string{"http://localhost:8080/a"}, time.Now().Add(-time.Hour), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://localhost:8080/a"}, restored)
+	require.NoError(t, f.Close())
+
+	f = NewFileStorage(ctx, path)
+	defer f.Close()
+
+	_, err = f.Get(ctx, "http://localhost:8080/a")
+	assert.NoError(t, err)
+
+	deleted, err := f.GetDeletedByUser(ctx, "1")
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+	assert.Equal(t, "http://localhost:8080/b", deleted[0].ShortURL)
+	assert.WithinDuration(t, time.Now(), deleted[0].DeletedAt, time.Minute)
+
+	// Nothing is restored over the quota.
+	_, err = f.Restore(ctx, "1", []string{"http://localhost:8080/b"}, time.Now().Add(-time.Hour), 1)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	// The URL deleted before deletedAfter is not restored.
+	restored, err = f.Restore(ctx, "1", []string{"http://localhost:8080/b"}, time.Now().Add(time.Hour), 0)
+	require.NoError(t, err)
+	assert.Empty(t, restored)
+}
+
+func TestFileStoragePurge(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls")
+
+	f := NewFileStorage(ctx, path)
+	require.NoError(t, f.Put(ctx,
+		Link{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com/?a=1"}, false))
+	require.NoError(t, f.Put(ctx,
+		Link{UserID: "1", ShortURL: "http://localhost:8080/b", OriginalURL: "http://example.com/b"}, false))
+	require.NoError(t, f.DeleteBatch(ctx, "1", []string{"http://localhost:8080/b"}))
+
+	rst, err := f.Purge(ctx, time.Now().Add(time.Hour), 10, true)
+	require.NoError(t, err)
+	assert.Equal(t, PurgeResult{URLs: 1}, rst)
+
+	rst, err = f.Purge(ctx, time.Now().Add(time.Hour), 10, false)
+	require.NoError(t, err)
+	assert.Equal(t, PurgeResult{URLs: 1}, rst)
+
+	// The file is still appended after the rewrite.
+	require.NoError(t, f.Put(ctx,
+		Link{UserID: "2", ShortURL: "http://localhost:8080/c", OriginalURL: "http://example.com/c"}, false))
+	require.NoError(t, f.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"))
+	assert.NotContains(t, string(data), "http://localhost:8080/b")
+
+	f = NewFileStorage(ctx, path)
+	defer f.Close()
+
+	_, err = f.Get(ctx, "http://localhost:8080/b")
+	assert.ErrorIs(t, err, ErrNotFoundURL)
+
+	link, err := f.Get(ctx, "http://localhost:8080/a")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/?a=1", link.OriginalURL)
+}
+
+func TestFileStorageRedirectType(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls")
+
+	f := NewFileStorage(ctx, path)
+	require.NoError(t, f.Put(ctx,
+		Link{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com/a"}, false))
+
+	link, err := f.Update(ctx, "1", "http://localhost:8080/a", LinkPatch{RedirectType: ptr(301)})
+	require.NoError(t, err)
+	assert.Equal(t, 301, link.RedirectType)
+
+	// The URL of another user is not changed.
+	_, err = f.Update(ctx, "2", "http://localhost:8080/a", LinkPatch{RedirectType: ptr(302)})
+	assert.ErrorIs(t, err, ErrNotFoundURL)
+	require.NoError(t, f.Close())
+
+	f = NewFileStorage(ctx, path)
+	defer f.Close()
+
+	link, err = f.Get(ctx, "http://localhost:8080/a")
+	require.NoError(t, err)
+	assert.Equal(t, 301, link.RedirectType)
+}
+
+func TestFileStorageRules(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls")
+	rules := []Rule{{OS: "ios", URL: "https://apps.apple.com/app/id1"}, {Language: "de", URL: "http://example.com/de"}}
+
+	f := NewFileStorage(ctx, path)
+	require.NoError(t, f.Put(ctx,
+		Link{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com/a"}, false))
+
+	link, err := f.Update(ctx, "1", "http://localhost:8080/a", LinkPatch{Rules: ptr(rules)})
+	require.NoError(t, err)
+	assert.Equal(t, rules, link.Rules)
+
+	// The stored rules do not share the slice of the caller.
+	rules[0].URL = "http://example.com/changed"
+	require.NoError(t, f.Close())
+
+	f = NewFileStorage(ctx, path)
+	defer f.Close()
+
+	link, err = f.Get(ctx, "http://localhost:8080/a")
+	require.NoError(t, err)
+	assert.Equal(t, "https://apps.apple.com/app/id1", link.Rules[0].URL)
+	assert.Len(t, link.Rules, 2)
+
+	link, err = f.Update(ctx, "1", "http://localhost:8080/a", LinkPatch{Rules: &[]Rule{}})
+	require.NoError(t, err)
+	assert.Nil(t, link.Rules)
+}
+
+func TestFileStorageVariants(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls")
+	variants := []Variant{{URL: "http://example.com/a1", Weight: 70, Clicks: 5}, {URL: "http://example.com/a2", Weight: 30}}
+
+	f := NewFileStorage(ctx, path)
+	require.NoError(t, f.Put(ctx,
+		Link{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com/a"}, false))
+
+	// Setting the variants resets their clicks.
+	link, err := f.Update(ctx, "1", "http://localhost:8080/a", LinkPatch{Variants: ptr(variants)})
+	require.NoError(t, err)
+	assert.Equal(t, []Variant{{URL: "http://example.com/a1", Weight: 70}, {URL: "http://example.com/a2", Weight: 30}}, link.Variants)
+
+	before, err := f.Get(ctx, "http://localhost:8080/a")
+	require.NoError(t, err)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	require.NoError(t, f.AddVariantClick(ctx, "http://localhost:8080/a", 1))
+	require.NoError(t, f.AddVariantClick(ctx, "http://localhost:8080/a", 1))
+	assert.ErrorIs(t, f.AddVariantClick(ctx, "http://localhost:8080/a", 2), ErrNotFoundVariant)
+	assert.ErrorIs(t, f.AddVariantClick(ctx, "http://localhost:8080/b", 0), ErrNotFoundURL)
+
+	// The clicks are not written on every redirect.
+	clicked, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), clicked.Size())
+
+	// The links returned before keep their counts.
+	assert.Zero(t, before.Variants[1].Clicks)
+	require.NoError(t, f.Close())
+
+	f = NewFileStorage(ctx, path)
+	defer f.Close()
+
+	link, err = f.Get(ctx, "http://localhost:8080/a")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{0, 2}, []int64{link.Variants[0].Clicks, link.Variants[1].Clicks})
+	assert.Equal(t, before.UpdatedAt, link.UpdatedAt)
+
+	links, err := f.GetByUser(ctx, "1")
+	require.NoError(t, err)
+	assert.Len(t, links, 1)
+
+	link, err = f.Update(ctx, "1", "http://localhost:8080/a", LinkPatch{Variants: &[]Variant{}})
+	require.NoError(t, err)
+	assert.Nil(t, link.Variants)
+}
+
+func TestFileStorageQuota(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls")
+
+	f := NewFileStorage(ctx, path)
+	require.NoError(t, f.Put(ctx,
+		Link{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com/a"}, false))
+	require.NoError(t, f.Put(ctx,
+		Link{UserID: "1", ShortURL: "http://localhost:8080/b", OriginalURL: "http://example.com/b"}, false))
+	require.NoError(t, f.DeleteBatch(ctx, "1", []string{"http://localhost:8080/b"}))
+
+	n, err := f.CountActive(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = f.GetQuota(ctx, "1")
+	assert.ErrorIs(t, err, ErrNotFoundQuota)
+	assert.ErrorIs(t, f.DeleteQuota(ctx, "1"), ErrNotFoundQuota)
+
+	require.NoError(t, f.SetQuota(ctx, "1", 5))
+	require.NoError(t, f.SetQuota(ctx, "1", 10))
+	require.NoError(t, f.SetQuota(ctx, "2", 0))
+	require.NoError(t, f.SetQuota(ctx, "3", 1))
+	require.NoError(t, f.DeleteQuota(ctx, "3"))
+	require.NoError(t, f.Close())
+
+	f = NewFileStorage(ctx, path)
+
+	quota, err := f.GetQuota(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, 10, quota)
+
+	quota, err = f.GetQuota(ctx, "2")
+	require.NoError(t, err)
+	assert.Zero(t, quota)
+
+	_, err = f.GetQuota(ctx, "3")
+	assert.ErrorIs(t, err, ErrNotFoundQuota)
+
+	// The quotas are kept when the file is rewritten.
+	require.NoError(t, f.Put(ctx, Link{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com/c"}, true))
+	require.NoError(t, f.Close())
+
+	f = NewFileStorage(ctx, path)
+	defer f.Close()
+
+	quota, err = f.GetQuota(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, 10, quota)
+
+	n, err = f.CountActive(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestFileStorageCreate(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls")
+
+	f := NewFileStorage(ctx, path)
+	require.NoError(t, f.Create(ctx, Link{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com/a"}, 2))
+	require.NoError(t, f.Create(ctx, Link{UserID: "1", ShortURL: "http://localhost:8080/b", OriginalURL: "http://example.com/b"}, 2))
+
+	// The existing URL is reported before the exhausted quota.
+	err := f.Create(ctx, Link{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com/a"}, 2)
+	assert.ErrorIs(t, err, ErrUniqueValue)
+
+	err = f.Create(ctx, Link{UserID: "1", ShortURL: "http://localhost:8080/c", OriginalURL: "http://example.com/c"}, 2)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	// The own quota of the user replaces the default, zero for no limit.
+	require.NoError(t, f.SetQuota(ctx, "1", 0))
+	require.NoError(t, f.Create(ctx, Link{UserID: "1", ShortURL: "http://localhost:8080/c", OriginalURL: "http://example.com/c"}, 2))
+	require.NoError(t, f.Close())
+
+	f = NewFileStorage(ctx, path)
+	defer f.Close()
+
+	n, err := f.CountActive(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+}
+
+func TestFileStorageBatch(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls")
+
+	lines := func() int {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return strings.Count(string(data), "\n")
+	}
+
+	f := NewFileStorage(ctx, path)
+	require.NoError(t, f.Put(ctx, Link{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com/a"}, false))
+	require.NoError(t, f.Put(ctx, Link{UserID: "1", ShortURL: "http://localhost:8080/b", OriginalURL: "http://example.com/b"}, false))
+
+	// The replaced records are appended within the batch and dropped by one rewrite at its end.
+	f.BeginBatch()
+	require.NoError(t, f.Put(ctx, Link{UserID: "2", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com/a2"}, true))
+	require.NoError(t, f.Put(ctx, Link{UserID: "2", ShortURL: "http://localhost:8080/b", OriginalURL: "http://example.com/b2"}, true))
+	assert.Equal(t, 4, lines())
+
+	require.NoError(t, f.EndBatch())
+	assert.Equal(t, 2, lines())
+	require.NoError(t, f.Close())
+
+	f = NewFileStorage(ctx, path)
+	defer f.Close()
+
+	links, err := f.GetByUser(ctx, "2")
+	require.NoError(t, err)
+	require.Len(t, links, 2)
+	assert.Equal(t, "http://example.com/a2", links[0].OriginalURL)
+
+	_, err = f.GetByUser(ctx, "1")
+	assert.ErrorIs(t, err, ErrNotFoundURL)
+}
+
+func TestFileStorageUpdate(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls")
+
+	f := NewFileStorage(ctx, path)
+	require.NoError(t, f.Put(ctx,
+		Link{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com/a"}, false))
+
+	link, err := f.Update(ctx, "1", "http://localhost:8080/a", LinkPatch{
+		Tags:         ptr([]string{"promo"}),
+		RedirectType: ptr(308),
+		Passthrough:  ptr(true),
+		UTM:          &UTM{Source: "mail"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"promo"}, link.Tags)
+	assert.Equal(t, 308, link.RedirectType)
+	assert.True(t, link.Passthrough)
+	assert.Equal(t, UTM{Source: "mail"}, link.UTM)
+
+	// The administrators quarantine the URL of any user, the time of update is kept.
+	quarantined, err := f.Update(ctx, "", "http://localhost:8080/a", LinkPatch{Threat: ptr("phishing")})
+	require.NoError(t, err)
+	assert.Equal(t, "phishing", quarantined.Threat)
+	assert.Equal(t, link.UpdatedAt, quarantined.UpdatedAt)
+
+	_, err = f.Update(ctx, "", "http://localhost:8080/b", LinkPatch{Threat: ptr("phishing")})
+	assert.ErrorIs(t, err, ErrNotFoundURL)
+	require.NoError(t, f.Close())
+
+	f = NewFileStorage(ctx, path)
+	defer f.Close()
+
+	link, err = f.Get(ctx, "http://localhost:8080/a")
+	require.NoError(t, err)
+	assert.Equal(t, "phishing", link.Threat)
+	assert.Equal(t, 308, link.RedirectType)
+	assert.Equal(t, []string{"promo"}, link.Tags)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ListQuery selects a page of the user's URLs listed by ListByUser.
+// The URLs are ordered by the time of creation and then by the shortened URL.
+type ListQuery struct {
+	// After is the position of the last URL of the previous page, the first page is listed if it is nil.
+	After *Cursor
+	// Limit is the maximum number of URLs on the page, zero means no limit.
+	Limit int
+	// Domain keeps the URLs whose original host is the domain or its subdomain.
+	Domain string
+	// Deleted keeps only the deleted URLs if it is true and only the active ones if it is false.
+	Deleted *bool
+	// CreatedFrom and CreatedTo keep the URLs created within [CreatedFrom, CreatedTo), zero values are not checked.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// Search keeps the URLs whose original URL contains the string, case-insensitively.
+	Search string
+	// Tag keeps the URLs with the tag attached.
+	Tag string
+	// UTM keeps the URLs whose UTM components are equal to the components set in the filter.
+	UTM UTM
+}
+
+// Cursor is a position in the list of URLs ordered by the time of creation and the shortened URL.
+type Cursor struct {
+	CreatedAt time.Time
+	ShortURL  string
+}
+
+// CursorOf returns the position of the record in the list.
+func CursorOf(rec Link) Cursor {
+	return Cursor{CreatedAt: rec.CreatedAt, ShortURL: rec.ShortURL}
+}
+
+func (c Cursor) less(other Cursor) bool {
+	if !c.CreatedAt.Equal(other.CreatedAt) {
+		return c.CreatedAt.Before(other.CreatedAt)
+	}
+
+	return c.ShortURL < other.ShortURL
+}
+
+// match reports whether the record passes the filters of the query, the position is not checked.
+func (q ListQuery) match(rec Link) bool {
+	if q.Deleted != nil && *q.Deleted != rec.Deleted {
+		return false
+	}
+
+	if !q.CreatedFrom.IsZero() && rec.CreatedAt.Before(q.CreatedFrom) {
+		return false
+	}
+
+	if !q.CreatedTo.IsZero() && !rec.CreatedAt.Before(q.CreatedTo) {
+		return false
+	}
+
+	if q.Domain != "" {
+		host, domain := hostOf(rec.OriginalURL), strings.ToLower(q.Domain)
+		if host != domain && !strings.HasSuffix(host, "."+domain) {
+			return false
+		}
+	}
+
+	if q.Tag != "" && !hasTag(rec.Tags, q.Tag) {
+		return false
+	}
+
+	if !rec.UTM.match(q.UTM) {
+		return false
+	}
+
+	if q.Search != "" && !strings.Contains(strings.ToLower(rec.OriginalURL), strings.ToLower(q.Search)) {
+		return false
+	}
+
+	return true
+}
+
+// list sorts the records and returns the page selected by the query.
+func list(records []Link, q ListQuery) []Link {
+	sort.Slice(records, func(i, j int) bool { return CursorOf(records[i]).less(CursorOf(records[j])) })
+
+	rst := make([]Link, 0)
+	for _, rec := range records {
+		if q.Limit > 0 && len(rst) >= q.Limit {
+			break
+		}
+
+		if q.After != nil && !q.After.less(CursorOf(rec)) {
+			continue
+		}
+
+		if q.match(rec) {
+			rst = append(rst, rec)
+		}
+	}
+
+	return rst
+}
+
+// hostOf returns the lower-cased host of the URL without the port, or an empty string if it cannot be parsed.
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Hostname())
+}
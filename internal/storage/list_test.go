@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListByUser(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	deleted, active := true, false
+
+	store := NewMemStorage()
+	for _, rec := range []Link{
+		{UserID: "1", ShortURL: "http://localhost:8080/c", OriginalURL: "https://Example.com/Path", CreatedAt: day,
+			UTM: UTM{Source: "news", Medium: "email"}},
+		{UserID: "1", ShortURL: "http://localhost:8080/b", OriginalURL: "https://go.dev/doc", CreatedAt: day,
+			Tags: []string{"q3", "docs"}},
+		{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "https://blog.example.com:8443/x", CreatedAt: day.Add(time.Hour),
+			UTM: UTM{Source: "news"}},
+		{UserID: "1", ShortURL: "http://localhost:8080/d", OriginalURL: "https://notexample.com", CreatedAt: day.Add(48 * time.Hour),
+			Deleted: true},
+		{UserID: "2", ShortURL: "http://localhost:8080/e", OriginalURL: "https://example.com/e", CreatedAt: day},
+	} {
+		require.NoError(t, store.Put(ctx, rec, false))
+	}
+
+	tests := []struct {
+		name  string
+		query ListQuery
+		want  []string
+	}{
+		{
+			name: "ordered by creation and shortened URL",
+			want: []string{"b", "c", "a", "d"},
+		},
+		{
+			name:  "limit",
+			query: ListQuery{Limit: 2},
+			want:  []string{"b", "c"},
+		},
+		{
+			name:  "after cursor",
+			query: ListQuery{After: &Cursor{CreatedAt: day, ShortURL: "http://localhost:8080/c"}, Limit: 1},
+			want:  []string{"a"},
+		},
+		{
+			name:  "domain and subdomains",
+			query: ListQuery{Domain: "EXAMPLE.com"},
+			want:  []string{"c", "a"},
+		},
+		{
+			name:  "deleted",
+			query: ListQuery{Deleted: &deleted},
+			want:  []string{"d"},
+		},
+		{
+			name:  "active",
+			query: ListQuery{Deleted: &active},
+			want:  []string{"b", "c", "a"},
+		},
+		{
+			name:  "created range",
+			query: ListQuery{CreatedFrom: day.Add(time.Minute), CreatedTo: day.Add(48 * time.Hour)},
+			want:  []string{"a"},
+		},
+		{
+			name:  "search",
+			query: ListQuery{Search: "/path"},
+			want:  []string{"c"},
+		},
+		{
+			name:  "tag",
+			query: ListQuery{Tag: "docs"},
+			want:  []string{"b"},
+		},
+		{
+			name:  "utm",
+			query: ListQuery{UTM: UTM{Source: "news"}},
+			want:  []string{"c", "a"},
+		},
+		{
+			name:  "utm components",
+			query: ListQuery{UTM: UTM{Source: "news", Medium: "email"}},
+			want:  []string{"c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := store.ListByUser(ctx, "1", tt.query)
+			require.NoError(t, err)
+
+			got := make([]string, 0, len(records))
+			for _, rec := range records {
+				got = append(got, rec.ShortURL[len("http://localhost:8080/"):])
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFileStorageCreatedAt(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls")
+
+	f := NewFileStorage(ctx, path)
+	require.NoError(t, f.Put(ctx,
+		Link{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com/a"}, false))
+	require.NoError(t, f.DeleteBatch(ctx, "1", []string{"http://localhost:8080/a"}))
+
+	before, err := f.ListByUser(ctx, "1", ListQuery{})
+	require.NoError(t, err)
+	require.Len(t, before, 1)
+	require.NoError(t, f.Close())
+
+	f = NewFileStorage(ctx, path)
+	defer f.Close()
+
+	after, err := f.ListByUser(ctx, "1", ListQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+	assert.WithinDuration(t, time.Now(), after[0].CreatedAt, time.Minute)
+}
@@ -2,80 +2,567 @@ package storage
 
 import (
 	"context"
+	"sort"
 	"sync"
+	"time"
 )
 
 // MemStorage has collections for storing data in memory and data management facilities.
 type MemStorage struct {
-	urls    map[string]string
-	users   map[string][]string
-	deleted map[string]bool
-	mu      sync.RWMutex
+	// links are stored by the shortened URL, the owners are taken from users.
+	links map[string]Link
+	users map[string][]string
+	// quotas are the numbers of the active links the users may hold instead of the default.
+	quotas map[string]int
+	mu     sync.RWMutex
 }
 
 // NewMemStorage is the constructor for the MemStorage structure.
 func NewMemStorage() *MemStorage {
 	return &MemStorage{
-		urls:    make(map[string]string),
-		users:   make(map[string][]string),
-		deleted: make(map[string]bool),
+		links:  make(map[string]Link),
+		users:  make(map[string][]string),
+		quotas: make(map[string]int),
 	}
 }
 
-// Add adds the user id, the original and its shortened URL to the data store.
-func (m *MemStorage) Add(_ context.Context, userID, shortURL, origURL string) error {
-	if m.urls[shortURL] == origURL {
-		return ErrUniqueValue
+// Get retrieves the link from the data store by its shortened URL.
+func (m *MemStorage) Get(_ context.Context, shortURL string) (Link, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	link, ok := m.links[shortURL]
+	if !ok {
+		return Link{}, ErrNotFoundURL
 	}
 
-	m.users[userID] = append(m.users[userID], shortURL)
-	m.urls[shortURL] = origURL
-	return nil
+	if link.Deleted {
+		return Link{}, ErrDeletedURL
+	}
+
+	return link, nil
 }
 
-// Get retrieves the original URL from the data store by its shortened value.
-func (m *MemStorage) Get(_ context.Context, shortURL string) (string, error) {
-	originalURL, ok := m.urls[shortURL]
+// GetByUser gets all links of the user, including the deleted ones, in the order they were added.
+func (m *MemStorage) GetByUser(_ context.Context, userID string) ([]Link, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	shortURLs, ok := m.users[userID]
 	if !ok {
-		return "", ErrNotFoundURL
+		return nil, ErrNotFoundURL
 	}
 
+	rst := make([]Link, 0, len(shortURLs))
+	for _, v := range shortURLs {
+		if link, ok := m.link(userID, v); ok {
+			rst = append(rst, link)
+		}
+	}
+
+	return rst, nil
+}
+
+// ListByUser returns the page of the user's URLs, including the deleted ones, selected by the query.
+func (m *MemStorage) ListByUser(_ context.Context, userID string, q ListQuery) ([]Link, error) {
+	m.mu.RLock()
+	links := m.records(userID, "")
+	m.mu.RUnlock()
+
+	return list(links, q), nil
+}
+
+// CheckStorage is implemented in this structure for compatibility with other data stores.
+func (m *MemStorage) CheckStorage(_ context.Context) error {
+	return nil
+}
+
+// DeleteBatch marks as deleted the shortened URLs owned by the user, others are ignored.
+// The URLs deleted already keep their time of deletion.
+func (m *MemStorage) DeleteBatch(_ context.Context, userID string, shortURLs []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteOwned(userID, shortURLs, time.Now().UTC())
+	return nil
+}
+
+// Restore clears the deletion mark of the user's URLs deleted after deletedAfter and returns the restored URLs.
+// Nothing is restored if the user would hold more active links than the quota, defaultQuota if the user
+// has no quota of their own.
+func (m *MemStorage) Restore(_ context.Context, userID string, shortURLs []string, deletedAfter time.Time, defaultQuota int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.restoreOwned(userID, shortURLs, deletedAfter, defaultQuota)
+}
+
+// GetDeletedByUser lists the user's deleted URLs, the most recently deleted first.
+func (m *MemStorage) GetDeletedByUser(_ context.Context, userID string) ([]DeletedURL, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	if _, ok := m.deleted[shortURL]; ok {
-		return "", ErrDeletedURL
+	rst := make([]DeletedURL, 0)
+	for _, v := range m.users[userID] {
+		if link, ok := m.links[v]; ok && link.Deleted {
+			rst = append(rst, DeletedURL{ShortURL: v, OriginalURL: link.OriginalURL, DeletedAt: link.DeletedAt})
+		}
 	}
 
-	return originalURL, nil
+	sort.Slice(rst, func(i, j int) bool { return rst[i].DeletedAt.After(rst[j].DeletedAt) })
+
+	return rst, nil
 }
 
-// GetByUser gets a map of all original and shortened URLs by user id.
-func (m *MemStorage) GetByUser(_ context.Context, userID string) (map[string]string, error) {
-	rst := make(map[string]string)
+// Purge permanently removes up to limit URLs deleted before deletedBefore.
+// URLs with an unknown time of deletion are removed as well. With dryRun, the URLs are only counted without a limit.
+func (m *MemStorage) Purge(_ context.Context, deletedBefore time.Time, limit int, dryRun bool) (PurgeResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	shortURLs, ok := m.users[userID]
+	return m.purge(deletedBefore, limit, dryRun), nil
+}
+
+// purge removes the URLs deleted before deletedBefore, the caller must hold the mutex.
+func (m *MemStorage) purge(deletedBefore time.Time, limit int, dryRun bool) PurgeResult {
+	purged := make(map[string]bool)
+	for shortURL, link := range m.links {
+		if !dryRun && len(purged) >= limit {
+			break
+		}
+
+		if link.Deleted && link.DeletedAt.Before(deletedBefore) {
+			purged[shortURL] = true
+		}
+	}
+
+	rst := PurgeResult{URLs: len(purged)}
+
+	for userID, shortURLs := range m.users {
+		kept := shortURLs[:0:0]
+		for _, v := range shortURLs {
+			_, exists := m.links[v]
+
+			switch {
+			case !exists:
+				rst.Orphans++
+			case !purged[v]:
+				kept = append(kept, v)
+			}
+		}
+
+		if dryRun {
+			continue
+		}
+
+		if len(kept) == 0 {
+			delete(m.users, userID)
+		} else {
+			m.users[userID] = kept
+		}
+	}
+
+	if !dryRun {
+		for v := range purged {
+			delete(m.links, v)
+		}
+	}
+
+	return rst
+}
+
+// Walk calls fn for every link with the shortened URL greater than after, in the order of shortened URLs.
+// The links are copied beforehand, so fn may use the data store.
+func (m *MemStorage) Walk(ctx context.Context, after string, fn func(Link) error) error {
+	m.mu.RLock()
+	links := m.records("", after)
+	m.mu.RUnlock()
+
+	return walk(ctx, links, fn)
+}
+
+// WalkByUser calls fn for every link of the user, including the deleted ones, in the order of shortened URLs.
+func (m *MemStorage) WalkByUser(ctx context.Context, userID string, fn func(Link) error) error {
+	m.mu.RLock()
+	links := m.records(userID, "")
+	m.mu.RUnlock()
+
+	return walk(ctx, links, fn)
+}
+
+// Put saves the link. If the shortened URL exists, ErrUniqueValue is returned unless overwrite is set.
+func (m *MemStorage) Put(_ context.Context, link Link, overwrite bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.put(link, overwrite)
+	return err
+}
+
+// put saves the link and reports whether it replaced an existing one, the caller must hold the mutex.
+// The unknown times of creation and update are set to the current time.
+func (m *MemStorage) put(link Link, overwrite bool) (bool, error) {
+	_, exists := m.links[link.ShortURL]
+	if exists && !overwrite {
+		return false, ErrUniqueValue
+	}
+
+	if exists {
+		for userID, shortURLs := range m.users {
+			kept := shortURLs[:0:0]
+			for _, v := range shortURLs {
+				if v != link.ShortURL {
+					kept = append(kept, v)
+				}
+			}
+
+			if len(kept) == 0 {
+				delete(m.users, userID)
+			} else {
+				m.users[userID] = kept
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = now
+	}
+	if link.UpdatedAt.IsZero() {
+		link.UpdatedAt = now
+	}
+	if !link.Deleted {
+		link.DeletedAt = time.Time{}
+	}
+	link.Tags = sortTags(append([]string(nil), link.Tags...))
+
+	m.links[link.ShortURL] = link
+	m.users[link.UserID] = append(m.users[link.UserID], link.ShortURL)
+
+	return exists, nil
+}
+
+// Create stores the new link unless it exists or the user already holds as many active links as the quota,
+// defaultQuota if the user has no quota of their own.
+func (m *MemStorage) Create(_ context.Context, link Link, defaultQuota int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.create(link, defaultQuota)
+}
+
+// create stores the new link within the quota of the user, the caller must hold the mutex.
+func (m *MemStorage) create(link Link, defaultQuota int) error {
+	if _, exists := m.links[link.ShortURL]; exists {
+		return ErrUniqueValue
+	}
+
+	if quota := m.quota(link.UserID, defaultQuota); quota > 0 && m.countActive(link.UserID) >= quota {
+		return ErrQuotaExceeded
+	}
+
+	_, err := m.put(link, false)
+	return err
+}
+
+// Update applies the patch to the URL and returns the updated link. The URL must be owned by the user
+// unless userID is empty, as for the changes made by the administrators.
+func (m *MemStorage) Update(_ context.Context, userID, shortURL string, patch LinkPatch) (Link, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.update(userID, shortURL, patch)
+}
+
+// AddVariantClick counts a redirect to the variant of the URL with the index.
+func (m *MemStorage) AddVariantClick(_ context.Context, shortURL string, variant int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.addVariantClick(shortURL, variant)
+	return err
+}
+
+// addVariantClick counts the click and returns the stored link, the caller must hold the mutex.
+// The variants are copied, the links returned earlier keep the previous counts. The time of update is not changed.
+func (m *MemStorage) addVariantClick(shortURL string, variant int) (Link, error) {
+	link, ok := m.links[shortURL]
 	if !ok {
-		return nil, ErrNotFoundURL
+		return Link{}, ErrNotFoundURL
 	}
 
+	if variant < 0 || variant >= len(link.Variants) {
+		return Link{}, ErrNotFoundVariant
+	}
+
+	link.Variants = copyVariants(link.Variants)
+	link.Variants[variant].Clicks++
+	m.links[shortURL] = link
+
+	return link, nil
+}
+
+// resetClicks copies the variants with zero clicks.
+func resetClicks(variants []Variant) []Variant {
+	rst := copyVariants(variants)
+	for i := range rst {
+		rst[i].Clicks = 0
+	}
+
+	return rst
+}
+
+// copyRules copies the rules, so that the stored links do not share them with the callers, nil stays nil.
+func copyRules(rules []Rule) []Rule {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	return append([]Rule(nil), rules...)
+}
+
+// update applies the patch to the URL and sets the time of update if the patch touches the settings
+// of the owner, the caller must hold the mutex.
+func (m *MemStorage) update(userID, shortURL string, patch LinkPatch) (Link, error) {
+	link, ok := m.links[shortURL]
+	if !ok || (userID != "" && !m.owned(userID)[shortURL]) {
+		return Link{}, ErrNotFoundURL
+	}
+
+	patch.apply(&link)
+	if patch.touches() {
+		link.UpdatedAt = time.Now().UTC()
+	}
+	m.links[shortURL] = link
+
+	if userID != "" {
+		link.UserID = userID
+	}
+	return link, nil
+}
+
+// GetTags lists the tags attached to the user's URLs, ordered by name.
+func (m *MemStorage) GetTags(_ context.Context, userID string) ([]Tag, error) {
+	m.mu.RLock()
+	links := m.records(userID, "")
+	m.mu.RUnlock()
+
+	return countTags(links), nil
+}
+
+// RenameTag renames the tag of the user's URLs, merging it with newName if the URL has both,
+// and returns the number of changed URLs.
+func (m *MemStorage) RenameTag(_ context.Context, userID, name, newName string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.retag(userID, name, newName)), nil
+}
+
+// DeleteTag removes the tag from the user's URLs and returns the number of changed URLs.
+func (m *MemStorage) DeleteTag(_ context.Context, userID, name string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.retag(userID, name, "")), nil
+}
+
+// retag replaces the tag of the user's URLs with newName, or removes it if newName is empty,
+// and returns the changed URLs. The caller must hold the mutex.
+func (m *MemStorage) retag(userID, name, newName string) []string {
+	now := time.Now().UTC()
+
+	rst := make([]string, 0)
+	for _, v := range m.users[userID] {
+		link, ok := m.links[v]
+		if !ok || !hasTag(link.Tags, name) {
+			continue
+		}
+
+		link.Tags, link.UpdatedAt = retag(link.Tags, name, newName), now
+		m.links[v] = link
+		rst = append(rst, v)
+	}
+
+	return rst
+}
+
+// records returns the links of the user, or of all users if userID is empty,
+// with the shortened URL greater than after, sorted by it. The caller must hold the mutex.
+func (m *MemStorage) records(owner, after string) []Link {
+	rst := make([]Link, 0)
+
+	for userID, shortURLs := range m.users {
+		if owner != "" && userID != owner {
+			continue
+		}
+
+		for _, v := range shortURLs {
+			if v <= after {
+				continue
+			}
+
+			if link, ok := m.link(userID, v); ok {
+				rst = append(rst, link)
+			}
+		}
+	}
+
+	sort.Slice(rst, func(i, j int) bool { return rst[i].ShortURL < rst[j].ShortURL })
+
+	return rst
+}
+
+// link returns the current state of the URL owned by the user, the caller must hold the mutex.
+func (m *MemStorage) link(userID, shortURL string) (Link, bool) {
+	link, ok := m.links[shortURL]
+	if !ok {
+		return Link{}, false
+	}
+
+	link.UserID = userID
+	return link, true
+}
+
+func walk(ctx context.Context, links []Link, fn func(Link) error) error {
+	for _, link := range links {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := fn(link); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteOwned marks the user's URLs that are not deleted yet as deleted at the given time and returns them.
+// The caller must hold the mutex.
+func (m *MemStorage) deleteOwned(userID string, shortURLs []string, at time.Time) []string {
+	owned := m.owned(userID)
+
+	rst := make([]string, 0, len(shortURLs))
+	for _, v := range shortURLs {
+		link, ok := m.links[v]
+		if !ok || !owned[v] || link.Deleted {
+			continue
+		}
+
+		link.Deleted, link.DeletedAt, link.UpdatedAt = true, at, at
+		m.links[v] = link
+		rst = append(rst, v)
+	}
+
+	return rst
+}
+
+// restoreOwned clears the deletion mark of the user's URLs deleted after deletedAfter within the quota
+// of the user. The caller must hold the mutex.
+func (m *MemStorage) restoreOwned(userID string, shortURLs []string, deletedAfter time.Time, defaultQuota int) ([]string, error) {
+	owned := m.owned(userID)
+
+	rst := make([]string, 0, len(shortURLs))
 	for _, v := range shortURLs {
-		rst[v] = m.urls[v]
+		link, ok := m.links[v]
+		if !ok || !owned[v] || !link.Deleted || link.DeletedAt.Before(deletedAfter) {
+			continue
+		}
+
+		rst = append(rst, v)
+	}
+
+	if quota := m.quota(userID, defaultQuota); quota > 0 && len(rst) > 0 && m.countActive(userID)+len(rst) > quota {
+		return nil, ErrQuotaExceeded
+	}
+
+	now := time.Now().UTC()
+	for _, v := range rst {
+		link := m.links[v]
+		link.Deleted, link.DeletedAt, link.UpdatedAt = false, time.Time{}, now
+		m.links[v] = link
 	}
 
 	return rst, nil
 }
 
-// CheckStorage is implemented in this structure for compatibility with other data stores.
-func (m *MemStorage) CheckStorage(_ context.Context) error {
+func (m *MemStorage) owned(userID string) map[string]bool {
+	rst := make(map[string]bool, len(m.users[userID]))
+	for _, v := range m.users[userID] {
+		rst[v] = true
+	}
+
+	return rst
+}
+
+// CountActive returns the number of the user's links that are not deleted.
+func (m *MemStorage) CountActive(_ context.Context, userID string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.countActive(userID), nil
+}
+
+// countActive returns the number of the user's links that are not deleted, the caller must hold the mutex.
+func (m *MemStorage) countActive(userID string) int {
+	n := 0
+	for _, v := range m.users[userID] {
+		if link, ok := m.link(userID, v); ok && !link.Deleted {
+			n++
+		}
+	}
+
+	return n
+}
+
+// GetQuota returns the quota of the user, ErrNotFoundQuota if the user has no quota of their own.
+func (m *MemStorage) GetQuota(_ context.Context, userID string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	quota, ok := m.quotas[userID]
+	if !ok {
+		return 0, ErrNotFoundQuota
+	}
+
+	return quota, nil
+}
+
+// SetQuota sets the quota of the user, replacing the default.
+func (m *MemStorage) SetQuota(_ context.Context, userID string, quota int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.quotas[userID] = quota
 	return nil
 }
 
-// Delete marks the shortened URL as deleted.
-func (m *MemStorage) Delete(_ context.Context, shortURL string) error {
+// quota returns the quota of the user, defaultQuota if the user has no quota of their own.
+// The caller must hold the mutex.
+func (m *MemStorage) quota(userID string, defaultQuota int) int {
+	if quota, ok := m.quotas[userID]; ok {
+		return quota
+	}
+
+	return defaultQuota
+}
+
+// DeleteQuota removes the quota of the user, so the default applies again.
+func (m *MemStorage) DeleteQuota(_ context.Context, userID string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.deleted[shortURL] = true
+
+	return m.deleteQuota(userID)
+}
+
+// deleteQuota removes the quota of the user, the caller must hold the mutex.
+func (m *MemStorage) deleteQuota(userID string) error {
+	if _, ok := m.quotas[userID]; !ok {
+		return ErrNotFoundQuota
+	}
+
+	delete(m.quotas, userID)
 	return nil
 }
 
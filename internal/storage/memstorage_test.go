@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemStorageLink(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemStorage()
+
+	require.NoError(t, m.Put(ctx,
+		Link{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com/a"}, false))
+
+	link, err := m.Get(ctx, "http://localhost:8080/a")
+	require.NoError(t, err)
+	assert.Equal(t, "a", link.Code())
+	assert.Equal(t, "1", link.UserID)
+	assert.WithinDuration(t, time.Now(), link.CreatedAt, time.Minute)
+	assert.Equal(t, link.CreatedAt, link.UpdatedAt)
+	assert.True(t, link.DeletedAt.IsZero())
+
+	require.NoError(t, m.DeleteBatch(ctx, "1", []string{"http://localhost:8080/a"}))
+
+	_, err = m.Get(ctx, "http://localhost:8080/a")
+	assert.ErrorIs(t, err, ErrDeletedURL)
+
+	links, err := m.GetByUser(ctx, "1")
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.True(t, links[0].Deleted)
+	assert.Equal(t, links[0].DeletedAt, links[0].UpdatedAt)
+	assert.Equal(t, link.CreatedAt, links[0].CreatedAt)
+
+	_, err = m.GetByUser(ctx, "2")
+	assert.ErrorIs(t, err, ErrNotFoundURL)
+}
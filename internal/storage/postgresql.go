@@ -3,147 +3,1215 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // Postgresql contains a connection to the database and the necessary methods for working with data.
 type Postgresql struct {
 	db *sql.DB
+
+	mu        sync.Mutex
+	lastPrune time.Time
 }
 
 // NewPostgresql is the constructor for the Postgresql structure.
 func NewPostgresql(ctx context.Context, addrConnDB string) (*Postgresql, error) {
 	db, err := sql.Open("postgres", addrConnDB)
 	if err != nil {
-		return nil, err
+		return nil, err
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := createTables(ctx, db); err != nil {
+		return nil, err
+	}
+
+	return &Postgresql{db: db}, nil
+}
+
+// Get retrieves the link from the database by its shortened URL.
+func (d *Postgresql) Get(ctx context.Context, shortURL string) (Link, error) {
+	query := `SELECT ` + linkColumns + ` 
+		FROM 
+		    urls AS t2 
+		    	LEFT JOIN users AS t1 
+		    	ON t1.short_url = t2.short_url 
+		WHERE t2.short_url = $1`
+	row := d.db.QueryRowContext(ctx, query, shortURL)
+
+	link, err := scanLink(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Link{}, ErrNotFoundURL
+	} else if err != nil {
+		return Link{}, err
+	} else if link.Deleted {
+		return Link{}, ErrDeletedURL
+	} else {
+		return link, nil
+	}
+}
+
+// GetByUser receives all links of the user from the database, including the deleted ones.
+func (d *Postgresql) GetByUser(ctx context.Context, userID string) ([]Link, error) {
+	query := `SELECT ` + linkColumns + ` 
+		FROM 
+		    users AS t1 
+		    	INNER JOIN urls AS t2 
+		    	ON t1.short_url = t2.short_url 
+		WHERE 
+		    t1.user_id = $1`
+
+	rows, err := d.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanLinks(rows, 0)
+}
+
+// DeleteBatch marks as deleted the shortened URLs owned by the user in a single statement.
+// The URLs deleted already keep their time of deletion.
+func (d *Postgresql) DeleteBatch(ctx context.Context, userID string, shortURLs []string) error {
+	const op = "internal.storage.postgresql.DeleteBatch"
+
+	query := `UPDATE urls AS t1 
+		SET mark_del = TRUE, deleted_at = NOW(), updated_at = NOW() 
+		FROM users AS t2 
+		WHERE 
+		    t2.short_url = t1.short_url 
+		    AND t2.user_id = $1 
+		    AND t1.short_url = ANY($2) 
+		    AND NOT t1.mark_del`
+
+	if _, err := d.db.ExecContext(ctx, query, userID, pq.Array(shortURLs)); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Restore clears the deletion mark of the user's URLs deleted after deletedAfter and returns the restored URLs.
+// Nothing is restored if the user would hold more active links than the quota, defaultQuota if the user
+// has no quota of their own.
+func (d *Postgresql) Restore(ctx context.Context, userID string, shortURLs []string, deletedAfter time.Time, defaultQuota int) ([]string, error) {
+	const op = "internal.storage.postgresql.Restore"
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s.BeginTx: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	// The restorations are serialized with the creations of the user by the same lock as in Create.
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, userID); err != nil {
+		return nil, fmt.Errorf("%s.Lock: %w", op, err)
+	}
+
+	query := `UPDATE urls AS t1 
+		SET mark_del = FALSE, deleted_at = NULL, updated_at = NOW() 
+		FROM users AS t2 
+		WHERE 
+		    t2.short_url = t1.short_url 
+		    AND t2.user_id = $1 
+		    AND t1.short_url = ANY($2) 
+		    AND t1.mark_del 
+		    AND t1.deleted_at >= $3 
+		RETURNING t1.short_url`
+
+	rows, err := tx.QueryContext(ctx, query, userID, pq.Array(shortURLs), deletedAfter)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	rst := make([]string, 0, len(shortURLs))
+	for rows.Next() {
+		var shortURL string
+		if err := rows.Scan(&shortURL); err != nil {
+			return nil, fmt.Errorf("%s.Scan: %w", op, err)
+		}
+
+		rst = append(rst, shortURL)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(rst) == 0 {
+		return rst, nil
+	}
+
+	var quota int
+	query = `SELECT COALESCE((SELECT quota FROM user_quotas WHERE user_id = $1), $2)`
+	if err := tx.QueryRowContext(ctx, query, userID, defaultQuota).Scan(&quota); err != nil {
+		return nil, fmt.Errorf("%s.Quota: %w", op, err)
+	}
+
+	if quota > 0 {
+		var used int
+		if err := tx.QueryRowContext(ctx, countActiveQuery, userID).Scan(&used); err != nil {
+			return nil, fmt.Errorf("%s.CountActive: %w", op, err)
+		}
+
+		// The restored links are counted too, the transaction is rolled back if they are over the quota.
+		if used > quota {
+			return nil, ErrQuotaExceeded
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s.Commit: %w", op, err)
+	}
+
+	return rst, nil
+}
+
+// GetDeletedByUser lists the user's deleted URLs, the most recently deleted first.
+// URLs deleted before the time of deletion was recorded have a zero DeletedAt.
+func (d *Postgresql) GetDeletedByUser(ctx context.Context, userID string) ([]DeletedURL, error) {
+	const op = "internal.storage.postgresql.GetDeletedByUser"
+
+	query := `SELECT 
+    		t2.short_url, 
+    		t2.original_url, 
+    		t2.deleted_at 
+		FROM 
+		    users AS t1 
+		    	INNER JOIN urls AS t2 
+		    	ON t1.short_url = t2.short_url 
+		WHERE 
+		    t1.user_id = $1 
+		    AND t2.mark_del 
+		ORDER BY t2.deleted_at DESC NULLS LAST`
+
+	rows, err := d.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	rst := make([]DeletedURL, 0)
+	for rows.Next() {
+		var (
+			v         DeletedURL
+			deletedAt sql.NullTime
+		)
+
+		if err := rows.Scan(&v.ShortURL, &v.OriginalURL, &deletedAt); err != nil {
+			return nil, fmt.Errorf("%s.Scan: %w", op, err)
+		}
+
+		v.DeletedAt = deletedAt.Time
+		rst = append(rst, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return rst, nil
+}
+
+// Purge permanently removes up to limit URLs deleted before deletedBefore together with the records
+// of their owners, and up to limit records of owners whose URL no longer exists.
+// URLs with an unknown time of deletion are removed as well. With dryRun, the records are only counted without a limit.
+func (d *Postgresql) Purge(ctx context.Context, deletedBefore time.Time, limit int, dryRun bool) (PurgeResult, error) {
+	const op = "internal.storage.postgresql.Purge"
+
+	var rst PurgeResult
+
+	if dryRun {
+		query := `SELECT 
+    			(SELECT COUNT(*) FROM urls 
+    			 WHERE mark_del AND (deleted_at IS NULL OR deleted_at < $1)), 
+    			(SELECT COUNT(*) FROM users AS t1 
+    			 WHERE NOT EXISTS (SELECT 1 FROM urls AS t2 WHERE t2.short_url = t1.short_url))`
+
+		if err := d.db.QueryRowContext(ctx, query, deletedBefore).Scan(&rst.URLs, &rst.Orphans); err != nil {
+			return PurgeResult{}, fmt.Errorf("%s.Count: %w", op, err)
+		}
+
+		return rst, nil
+	}
+
+	query := `WITH purged AS (
+			DELETE FROM urls 
+			WHERE short_url IN (
+				SELECT short_url FROM urls 
+				WHERE mark_del AND (deleted_at IS NULL OR deleted_at < $1) 
+				LIMIT $2 
+				FOR UPDATE SKIP LOCKED) 
+			RETURNING short_url
+		), owners AS (
+			DELETE FROM users 
+			WHERE short_url IN (SELECT short_url FROM purged)
+		), tagged AS (
+			DELETE FROM url_tags 
+			WHERE short_url IN (SELECT short_url FROM purged)
+		)
+		SELECT COUNT(*) FROM purged`
+
+	if err := d.db.QueryRowContext(ctx, query, deletedBefore, limit).Scan(&rst.URLs); err != nil {
+		return PurgeResult{}, fmt.Errorf("%s.DeleteURLs: %w", op, err)
+	}
+
+	query = `DELETE FROM users 
+		WHERE short_url IN (
+			SELECT t1.short_url FROM users AS t1 
+			WHERE NOT EXISTS (SELECT 1 FROM urls AS t2 WHERE t2.short_url = t1.short_url) 
+			LIMIT $1)`
+
+	res, err := d.db.ExecContext(ctx, query, limit)
+	if err != nil {
+		return PurgeResult{}, fmt.Errorf("%s.DeleteOrphans: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return PurgeResult{}, fmt.Errorf("%s.RowsAffected: %w", op, err)
+	}
+	rst.Orphans = int(n)
+
+	return rst, nil
+}
+
+// Walk calls fn for every record with the shortened URL greater than after, in the order of shortened URLs.
+// The records are read in pages, so fn may use the data store.
+func (d *Postgresql) Walk(ctx context.Context, after string, fn func(Link) error) error {
+	const op = "internal.storage.postgresql.Walk"
+
+	query := `SELECT ` + linkColumns + ` 
+		FROM 
+		    urls AS t2 
+		    	LEFT JOIN users AS t1 
+		    	ON t1.short_url = t2.short_url 
+		WHERE 
+		    t2.short_url > $1 
+		ORDER BY t2.short_url 
+		LIMIT $2`
+
+	if err := d.walk(ctx, query, after, fn); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// WalkByUser calls fn for every record of the user, including the deleted ones, in the order of shortened URLs.
+// The records are read in pages, so fn may use the data store.
+func (d *Postgresql) WalkByUser(ctx context.Context, userID string, fn func(Link) error) error {
+	const op = "internal.storage.postgresql.WalkByUser"
+
+	query := `SELECT ` + linkColumns + ` 
+		FROM 
+		    users AS t1 
+		    	INNER JOIN urls AS t2 
+		    	ON t1.short_url = t2.short_url 
+		WHERE 
+		    t1.short_url > $1 
+		    AND t1.user_id = $3 
+		ORDER BY t1.short_url 
+		LIMIT $2`
+
+	if err := d.walk(ctx, query, "", fn, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// walk reads the records page by page, the query takes the last shortened URL of the previous page as $1,
+// the size of the page as $2 and args from $3.
+func (d *Postgresql) walk(ctx context.Context, query, after string, fn func(Link) error, args ...any) error {
+	const pageSize = 1000
+
+	for {
+		rows, err := d.db.QueryContext(ctx, query, append([]any{after, pageSize}, args...)...)
+		if err != nil {
+			return err
+		}
+
+		page, err := scanLinks(rows, pageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, rec := range page {
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < pageSize {
+			return nil
+		}
+
+		after = page[len(page)-1].ShortURL
+	}
+}
+
+// Put saves the record. If the shortened URL exists, ErrUniqueValue is returned unless overwrite is set.
+// ErrUniqueValue is also returned if the original URL is already shortened to another URL.
+func (d *Postgresql) Put(ctx context.Context, link Link, overwrite bool) error {
+	const op = "internal.storage.postgresql.Put"
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s.BeginTx: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if err := put(ctx, tx, link, overwrite); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s.Commit: %w", op, err)
+	}
+
+	return nil
+}
+
+// Create stores the new link unless it exists or the user would hold more active links than the quota,
+// defaultQuota if the user has no quota of their own. The links of the user are created one at a time,
+// so the concurrent requests cannot exceed the quota.
+func (d *Postgresql) Create(ctx context.Context, link Link, defaultQuota int) error {
+	const op = "internal.storage.postgresql.Create"
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s.BeginTx: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	// The user may have no row in user_quotas to lock, so the user's creations are serialized by the lock
+	// held until the end of the transaction.
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, link.UserID); err != nil {
+		return fmt.Errorf("%s.Lock: %w", op, err)
+	}
+
+	if err := put(ctx, tx, link, false); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `SELECT COALESCE((SELECT quota FROM user_quotas WHERE user_id = $1), $2)`
+
+	var quota int
+	if err := tx.QueryRowContext(ctx, query, link.UserID, defaultQuota).Scan(&quota); err != nil {
+		return fmt.Errorf("%s.Quota: %w", op, err)
+	}
+
+	if quota > 0 {
+		var used int
+		if err := tx.QueryRowContext(ctx, countActiveQuery, link.UserID).Scan(&used); err != nil {
+			return fmt.Errorf("%s.CountActive: %w", op, err)
+		}
+
+		// The new link is counted too, the transaction is rolled back if it is over the quota.
+		if used > quota {
+			return ErrQuotaExceeded
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s.Commit: %w", op, err)
+	}
+
+	return nil
+}
+
+// put saves the link in the transaction, replacing the existing one if overwrite is set.
+func put(ctx context.Context, tx *sql.Tx, link Link, overwrite bool) error {
+	var deletedAt sql.NullTime
+	if link.Deleted {
+		deletedAt = nullTime(link.DeletedAt)
+	}
+
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM urls WHERE short_url = $1)`
+	if err := tx.QueryRowContext(ctx, query, link.ShortURL).Scan(&exists); err != nil {
+		return fmt.Errorf("Exists: %w", err)
+	}
+
+	if exists && !overwrite {
+		return ErrUniqueValue
+	}
+
+	if exists {
+		query = `UPDATE urls 
+			SET 
+			    original_url = $2, mark_del = $3, deleted_at = $4, 
+			    updated_at = COALESCE($5, NOW()), title = NULLIF($6, ''), notes = NULLIF($7, ''), 
+			    redirect_type = $8, passthrough = $9, 
+			    utm_source = NULLIF($10, ''), utm_medium = NULLIF($11, ''), utm_campaign = NULLIF($12, ''), 
+			    utm_term = NULLIF($13, ''), utm_content = NULLIF($14, ''), rules = $15::jsonb, variants = $16::jsonb, 
+			    threat = NULLIF($17, '') 
+			WHERE short_url = $1`
+	} else {
+		query = `INSERT INTO 
+    			urls(short_url, original_url, mark_del, deleted_at, updated_at, title, notes, redirect_type, passthrough, 
+    			     utm_source, utm_medium, utm_campaign, utm_term, utm_content, rules, variants, threat) 
+			VALUES ($1, $2, $3, $4, COALESCE($5, NOW()), NULLIF($6, ''), NULLIF($7, ''), $8, $9, 
+			        NULLIF($10, ''), NULLIF($11, ''), NULLIF($12, ''), NULLIF($13, ''), NULLIF($14, ''), $15::jsonb, 
+			        $16::jsonb, NULLIF($17, ''))`
+	}
+
+	rules, err := jsonOrNull(link.Rules)
+	if err != nil {
+		return fmt.Errorf("Rules: %w", err)
+	}
+
+	variants, err := jsonOrNull(link.Variants)
+	if err != nil {
+		return fmt.Errorf("Variants: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, query, link.ShortURL, link.OriginalURL, link.Deleted, deletedAt,
+		nullTime(link.UpdatedAt), link.Title, link.Notes, link.RedirectType, link.Passthrough,
+		link.UTM.Source, link.UTM.Medium, link.UTM.Campaign, link.UTM.Term, link.UTM.Content, rules, variants, link.Threat)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pgUniqueViolation {
+			return ErrUniqueValue
+		}
+
+		return fmt.Errorf("SaveURL: %w", err)
+	}
+
+	query = `INSERT INTO 
+    			users(user_id, short_url, created_at) 
+			VALUES ($1, $2, COALESCE($3, NOW())) 
+			ON CONFLICT (short_url) DO UPDATE SET 
+			    user_id = EXCLUDED.user_id, 
+			    created_at = EXCLUDED.created_at`
+	if _, err := tx.ExecContext(ctx, query, link.UserID, link.ShortURL, nullTime(link.CreatedAt)); err != nil {
+		return fmt.Errorf("SaveUser: %w", err)
+	}
+
+	if err := setTags(ctx, tx, link.UserID, link.ShortURL, link.Tags); err != nil {
+		return fmt.Errorf("SetTags: %w", err)
+	}
+
+	return nil
+}
+
+// ListByUser returns the page of the user's URLs, including the deleted ones, selected by the query.
+// The time of creation is kept with the owner of the URL, so the page is read in the order
+// of the (user_id, createdKey, short_url) index and the filters are applied to the user's URLs only.
+func (d *Postgresql) ListByUser(ctx context.Context, userID string, q ListQuery) ([]Link, error) {
+	const op = "internal.storage.postgresql.ListByUser"
+
+	query, args := listQuery(userID, q)
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rst, err := scanLinks(rows, q.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s.Scan: %w", op, err)
+	}
+
+	return rst, nil
+}
+
+// listQuery builds the statement selecting the page of the user's URLs and its arguments.
+func listQuery(userID string, q ListQuery) (string, []any) {
+	var b strings.Builder
+
+	b.WriteString(`SELECT ` + linkColumns + ` 
+		FROM 
+		    users AS t1 
+		    	INNER JOIN urls AS t2 
+		    	ON t1.short_url = t2.short_url 
+		WHERE 
+		    t1.user_id = $1`)
+
+	args := []any{userID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.After != nil {
+		fmt.Fprintf(&b, " AND (%s, t1.short_url) > (%s, %s)", createdKey, arg(q.After.CreatedAt), arg(q.After.ShortURL))
+	}
+
+	if q.Deleted != nil {
+		fmt.Fprintf(&b, " AND COALESCE(t2.mark_del, FALSE) = %s", arg(*q.Deleted))
+	}
+
+	if !q.CreatedFrom.IsZero() {
+		fmt.Fprintf(&b, " AND %s >= %s", createdKey, arg(q.CreatedFrom))
+	}
+
+	if !q.CreatedTo.IsZero() {
+		fmt.Fprintf(&b, " AND %s < %s", createdKey, arg(q.CreatedTo))
+	}
+
+	if q.Domain != "" {
+		host := `lower(substring(t2.original_url FROM '^[^:/?#]+://(?:[^@/?#]*@)?(\[[^]]*\]|[^:/?#]*)'))`
+		domain := arg(strings.ToLower(q.Domain))
+		fmt.Fprintf(&b, " AND (%s = %s OR %s LIKE '%%.' || %s)", host, domain, host, arg(escapeLike(strings.ToLower(q.Domain))))
+	}
+
+	if q.Tag != "" {
+		fmt.Fprintf(&b, ` AND EXISTS (SELECT 1 FROM url_tags AS ut INNER JOIN tags AS tg ON tg.id = ut.tag_id 
+			WHERE ut.short_url = t1.short_url AND tg.user_id = $1 AND tg.name = %s)`, arg(q.Tag))
+	}
+
+	for _, p := range q.UTM.Params() {
+		if p[1] != "" {
+			fmt.Fprintf(&b, " AND t2.%s = %s", p[0], arg(p[1]))
+		}
+	}
+
+	if q.Search != "" {
+		fmt.Fprintf(&b, " AND t2.original_url ILIKE '%%' || %s || '%%'", arg(escapeLike(q.Search)))
+	}
+
+	fmt.Fprintf(&b, " ORDER BY %s, t1.short_url", createdKey)
+
+	if q.Limit > 0 {
+		fmt.Fprintf(&b, " LIMIT %s", arg(q.Limit))
+	}
+
+	return b.String(), args
+}
+
+// escapeLike escapes the wildcards of the LIKE pattern with the default escape character.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// Update applies the patch to the URL in one transaction and returns the updated link. The URL must be owned
+// by the user unless userID is empty, as for the changes made by the administrators.
+func (d *Postgresql) Update(ctx context.Context, userID, shortURL string, patch LinkPatch) (Link, error) {
+	const op = "internal.storage.postgresql.Update"
+
+	set, args, err := patchClauses(patch)
+	if err != nil {
+		return Link{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Link{}, fmt.Errorf("%s.BeginTx: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var owner string
+	query := `SELECT 
+    		COALESCE(t1.user_id, '') 
+		FROM 
+		    urls AS t2 
+		    	LEFT JOIN users AS t1 
+		    	ON t1.short_url = t2.short_url 
+		WHERE t2.short_url = $1 
+		FOR UPDATE OF t2`
+
+	err = tx.QueryRowContext(ctx, query, shortURL).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && userID != "" && owner != userID) {
+		return Link{}, ErrNotFoundURL
+	} else if err != nil {
+		return Link{}, fmt.Errorf("%s.Lock: %w", op, err)
+	}
+
+	if len(set) > 0 {
+		query = `UPDATE urls SET ` + strings.Join(set, ", ") + ` WHERE short_url = $1`
+		if _, err := tx.ExecContext(ctx, query, append([]any{shortURL}, args...)...); err != nil {
+			return Link{}, fmt.Errorf("%s.Update: %w", op, err)
+		}
+	}
+
+	if patch.Tags != nil {
+		if err := setTags(ctx, tx, owner, shortURL, *patch.Tags); err != nil {
+			return Link{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	query = `SELECT ` + linkColumns + ` 
+		FROM 
+		    urls AS t2 
+		    	LEFT JOIN users AS t1 
+		    	ON t1.short_url = t2.short_url 
+		WHERE t2.short_url = $1`
+
+	link, err := scanLink(tx.QueryRowContext(ctx, query, shortURL))
+	if err != nil {
+		return Link{}, fmt.Errorf("%s.Get: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Link{}, fmt.Errorf("%s.Commit: %w", op, err)
+	}
+
+	return link, nil
+}
+
+// patchClauses returns the SET clauses of the columns changed by the patch with their arguments from $2.
+// The tags are kept in their own tables and are not included.
+func patchClauses(patch LinkPatch) ([]string, []any, error) {
+	var (
+		set  []string
+		args []any
+	)
+
+	column := func(clause string, v any) {
+		args = append(args, v)
+		set = append(set, fmt.Sprintf(clause, len(args)+1))
+	}
+
+	if patch.RedirectType != nil {
+		column("redirect_type = $%d", *patch.RedirectType)
+	}
+
+	if patch.Passthrough != nil {
+		column("passthrough = $%d", *patch.Passthrough)
+	}
+
+	if patch.UTM != nil {
+		for _, p := range patch.UTM.Params() {
+			column(p[0]+" = NULLIF($%d, '')", p[1])
+		}
+	}
+
+	if patch.Rules != nil {
+		value, err := jsonOrNull(*patch.Rules)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Rules: %w", err)
+		}
+		column("rules = $%d::jsonb", value)
+	}
+
+	if patch.Variants != nil {
+		value, err := jsonOrNull(resetClicks(*patch.Variants))
+		if err != nil {
+			return nil, nil, fmt.Errorf("Variants: %w", err)
+		}
+		column("variants = $%d::jsonb", value)
+	}
+
+	if patch.Threat != nil {
+		column("threat = NULLIF($%d, '')", *patch.Threat)
+	}
+
+	if patch.touches() {
+		set = append(set, "updated_at = NOW()")
+	}
+
+	return set, args, nil
+}
+
+// AddVariantClick counts a redirect to the variant of the URL with the index. The counter is incremented
+// in place, concurrent clicks are serialized by the lock of the row. The time of update is not changed.
+func (d *Postgresql) AddVariantClick(ctx context.Context, shortURL string, variant int) error {
+	const op = "internal.storage.postgresql.AddVariantClick"
+
+	query := `UPDATE urls 
+		SET variants = jsonb_set(variants, ARRAY[$2::int::text, 'clicks'], 
+		    to_jsonb(COALESCE((variants->($2::int)->>'clicks')::bigint, 0) + 1)) 
+		WHERE 
+		    short_url = $1 
+		    AND $2::int >= 0 
+		    AND $2::int < jsonb_array_length(COALESCE(variants, '[]'::jsonb))`
+
+	res, err := d.db.ExecContext(ctx, query, shortURL, variant)
+	if err != nil {
+		return fmt.Errorf("%s.Update: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s.RowsAffected: %w", op, err)
 	}
 
-	if err := db.PingContext(ctx); err != nil {
-		return nil, err
+	if n < 1 {
+		return ErrNotFoundVariant
 	}
 
-	if err := createTables(ctx, db); err != nil {
-		return nil, err
+	return nil
+}
+
+// countActiveQuery counts the active links of the user.
+const countActiveQuery = `SELECT 
+    		COUNT(*) 
+		FROM 
+		    users AS t1 
+		    	INNER JOIN urls AS t2 
+		    	ON t1.short_url = t2.short_url 
+		WHERE 
+		    t1.user_id = $1 
+		    AND NOT COALESCE(t2.mark_del, FALSE)`
+
+// CountActive returns the number of the user's links that are not deleted.
+func (d *Postgresql) CountActive(ctx context.Context, userID string) (int, error) {
+	const op = "internal.storage.postgresql.CountActive"
+
+	var n int
+	if err := d.db.QueryRowContext(ctx, countActiveQuery, userID).Scan(&n); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return &Postgresql{db: db}, nil
+	return n, nil
 }
 
-// Add records the user's id, the original URL, and its shortened URL.
-func (d *Postgresql) Add(ctx context.Context, userID, shortURL, originURL string) error {
-	const op = "internal.storage.postgresql.Add"
+// GetQuota returns the quota of the user, ErrNotFoundQuota if the user has no quota of their own.
+func (d *Postgresql) GetQuota(ctx context.Context, userID string) (int, error) {
+	const op = "internal.storage.postgresql.GetQuota"
 
-	query := `INSERT INTO 
-    			urls(original_url, short_url) 
+	var quota int
+	err := d.db.QueryRowContext(ctx, `SELECT quota FROM user_quotas WHERE user_id = $1`, userID).Scan(&quota)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFoundQuota
+	} else if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return quota, nil
+}
+
+// SetQuota sets the quota of the user, replacing the default.
+func (d *Postgresql) SetQuota(ctx context.Context, userID string, quota int) error {
+	const op = "internal.storage.postgresql.SetQuota"
+
+	query := `INSERT INTO user_quotas (user_id, quota) 
 			VALUES ($1, $2) 
-			ON CONFLICT (original_url) DO NOTHING`
-	res, err := d.db.ExecContext(ctx, query, originURL, shortURL)
+			ON CONFLICT (user_id) DO UPDATE SET quota = EXCLUDED.quota`
+
+	if _, err := d.db.ExecContext(ctx, query, userID, quota); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteQuota removes the quota of the user, so the default applies again.
+func (d *Postgresql) DeleteQuota(ctx context.Context, userID string) error {
+	const op = "internal.storage.postgresql.DeleteQuota"
+
+	res, err := d.db.ExecContext(ctx, `DELETE FROM user_quotas WHERE user_id = $1`, userID)
 	if err != nil {
-		return fmt.Errorf("%s.InsertIntoURLs: %w", op, err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	row, err := res.RowsAffected()
+	n, err := res.RowsAffected()
 	if err != nil {
 		return fmt.Errorf("%s.RowsAffected: %w", op, err)
 	}
 
-	if row < 1 {
-		return ErrUniqueValue
+	if n == 0 {
+		return ErrNotFoundQuota
 	}
 
-	query = `INSERT INTO 
-    			users(user_id, short_url) 
-			VALUES ($1, $2)`
-	_, err = d.db.ExecContext(ctx, query, userID, shortURL)
+	return nil
+}
+
+// jsonOrNull encodes the rules or the variants for their JSONB column, an empty list is stored as NULL.
+func jsonOrNull[T any](list []T) (sql.NullString, error) {
+	if len(list) == 0 {
+		return sql.NullString{}, nil
+	}
+
+	data, err := json.Marshal(list)
 	if err != nil {
-		return fmt.Errorf("%s.InsertIntoUsers: %w", op, err)
+		return sql.NullString{}, err
 	}
 
-	return nil
+	return sql.NullString{String: string(data), Valid: true}, nil
 }
 
-// Get retrieves the original URL from the database by its shortened value.
-func (d *Postgresql) Get(ctx context.Context, shortURL string) (string, error) {
-	var (
-		originalURL string
-		markDelete  bool
-	)
+// setTags replaces the tags of the URL with the user's tags of the given names, creating the missing ones.
+// The tags left without URLs are removed.
+func setTags(ctx context.Context, tx *sql.Tx, userID, shortURL string, tags []string) error {
+	query := `DELETE FROM url_tags WHERE short_url = $1`
+	if _, err := tx.ExecContext(ctx, query, shortURL); err != nil {
+		return fmt.Errorf("DeleteURLTags: %w", err)
+	}
 
-	query := `SELECT 
-    		original_url, 
-    		COALESCE(mark_del, FALSE) AS mark_del 
-		FROM urls 
-		WHERE short_url = $1`
-	row := d.db.QueryRowContext(ctx, query, shortURL)
+	if len(tags) > 0 {
+		query = `INSERT INTO 
+    			tags(user_id, name) 
+			SELECT $1, unnest($2::TEXT[]) 
+			ON CONFLICT (user_id, name) DO NOTHING`
+		if _, err := tx.ExecContext(ctx, query, userID, pq.Array(tags)); err != nil {
+			return fmt.Errorf("InsertTags: %w", err)
+		}
 
-	err := row.Scan(&originalURL, &markDelete)
-	if err != nil {
-		return "", err
-	} else if markDelete {
-		return "", ErrDeletedURL
-	} else {
-		return originalURL, nil
+		query = `INSERT INTO 
+    			url_tags(tag_id, short_url) 
+			SELECT id, $3 FROM tags 
+			WHERE user_id = $1 AND name = ANY($2)`
+		if _, err := tx.ExecContext(ctx, query, userID, pq.Array(tags), shortURL); err != nil {
+			return fmt.Errorf("InsertURLTags: %w", err)
+		}
+	}
+
+	query = `DELETE FROM tags AS t 
+		WHERE 
+		    t.user_id = $1 
+		    AND NOT EXISTS (SELECT 1 FROM url_tags AS ut WHERE ut.tag_id = t.id)`
+	if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+		return fmt.Errorf("DeleteUnusedTags: %w", err)
 	}
+
+	return nil
 }
 
-// GetByUser receives a map of shortened and original URLs by user from the database.
-func (d *Postgresql) GetByUser(ctx context.Context, userID string) (map[string]string, error) {
-	rst := make(map[string]string)
+// GetTags lists the tags attached to the user's URLs with the number of URLs, ordered by name.
+func (d *Postgresql) GetTags(ctx context.Context, userID string) ([]Tag, error) {
+	const op = "internal.storage.postgresql.GetTags"
 
 	query := `SELECT 
-    		t2.short_url, 
-    		t2.original_url 
+    		t.name, 
+    		COUNT(*) 
 		FROM 
-		    users AS t1 
-		    	LEFT JOIN urls AS t2 
-		    	ON t1.short_url = t2.short_url 
+		    tags AS t 
+		    	INNER JOIN url_tags AS ut 
+		    	ON ut.tag_id = t.id 
 		WHERE 
-		    t1.user_id = $1`
+		    t.user_id = $1 
+		GROUP BY t.name 
+		ORDER BY t.name`
 
 	rows, err := d.db.QueryContext(ctx, query, userID)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 	defer rows.Close()
 
+	rst := make([]Tag, 0)
 	for rows.Next() {
-		var (
-			shortURL string
-			origURL  string
-		)
+		var tag Tag
+		if err := rows.Scan(&tag.Name, &tag.URLs); err != nil {
+			return nil, fmt.Errorf("%s.Scan: %w", op, err)
+		}
 
-		err = rows.Scan(&shortURL, &origURL)
-		if err != nil {
-			return nil, err
+		rst = append(rst, tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return rst, nil
+}
+
+// RenameTag renames the tag of the user's URLs, merging it with newName if the tag exists,
+// and returns the number of changed URLs.
+func (d *Postgresql) RenameTag(ctx context.Context, userID, name, newName string) (int, error) {
+	const op = "internal.storage.postgresql.RenameTag"
+
+	// The tag would be moved onto itself and lost, so only its URLs are counted.
+	if name == newName {
+		var n int
+
+		query := `SELECT COUNT(*) 
+			FROM 
+			    tags AS t 
+			    	INNER JOIN url_tags AS ut 
+			    	ON ut.tag_id = t.id 
+			WHERE t.user_id = $1 AND t.name = $2`
+		if err := d.db.QueryRowContext(ctx, query, userID, name).Scan(&n); err != nil {
+			return 0, fmt.Errorf("%s.Count: %w", op, err)
 		}
 
-		rst[shortURL] = origURL
+		return n, nil
 	}
 
-	err = rows.Err()
+	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return 0, fmt.Errorf("%s.BeginTx: %w", op, err)
 	}
+	defer tx.Rollback()
 
-	return rst, nil
+	query := `INSERT INTO 
+    			tags(user_id, name) 
+			VALUES ($1, $2) 
+			ON CONFLICT (user_id, name) DO NOTHING`
+	if _, err := tx.ExecContext(ctx, query, userID, newName); err != nil {
+		return 0, fmt.Errorf("%s.InsertTag: %w", op, err)
+	}
+
+	query = `WITH old AS (
+			SELECT id FROM tags WHERE user_id = $1 AND name = $2
+		), moved AS (
+			DELETE FROM url_tags 
+			WHERE tag_id IN (SELECT id FROM old) 
+			RETURNING short_url
+		), merged AS (
+			INSERT INTO url_tags(tag_id, short_url) 
+			SELECT t.id, m.short_url 
+			FROM tags AS t, moved AS m 
+			WHERE t.user_id = $1 AND t.name = $3 
+			ON CONFLICT DO NOTHING
+		), touched AS (
+			UPDATE urls SET updated_at = NOW() 
+			WHERE short_url IN (SELECT short_url FROM moved)
+		)
+		SELECT COUNT(*) FROM moved`
+
+	var n int
+	if err := tx.QueryRowContext(ctx, query, userID, name, newName).Scan(&n); err != nil {
+		return 0, fmt.Errorf("%s.MoveURLTags: %w", op, err)
+	}
+
+	query = `DELETE FROM tags AS t 
+		WHERE 
+		    t.user_id = $1 
+		    AND t.name IN ($2, $3) 
+		    AND NOT EXISTS (SELECT 1 FROM url_tags AS ut WHERE ut.tag_id = t.id)`
+	if _, err := tx.ExecContext(ctx, query, userID, name, newName); err != nil {
+		return 0, fmt.Errorf("%s.DeleteUnusedTags: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s.Commit: %w", op, err)
+	}
+
+	return n, nil
 }
 
-// Delete marks the shortened URL in the database as deleted.
-func (d *Postgresql) Delete(ctx context.Context, shortURL string) error {
-	query := `UPDATE urls 
-		SET mark_del = TRUE 
-		WHERE short_url = $1`
+// DeleteTag removes the tag from the user's URLs and returns the number of changed URLs.
+func (d *Postgresql) DeleteTag(ctx context.Context, userID, name string) (int, error) {
+	const op = "internal.storage.postgresql.DeleteTag"
+
+	query := `WITH tag AS (
+			DELETE FROM tags WHERE user_id = $1 AND name = $2 
+			RETURNING id
+		), untagged AS (
+			DELETE FROM url_tags 
+			WHERE tag_id IN (SELECT id FROM tag) 
+			RETURNING short_url
+		), touched AS (
+			UPDATE urls SET updated_at = NOW() 
+			WHERE short_url IN (SELECT short_url FROM untagged)
+		)
+		SELECT COUNT(*) FROM untagged`
+
+	var n int
+	if err := d.db.QueryRowContext(ctx, query, userID, name).Scan(&n); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n, nil
+}
+
+// EnqueueDeleteJobs saves pending deletion jobs in a single statement.
+func (d *Postgresql) EnqueueDeleteJobs(ctx context.Context, jobs []DeleteJob) error {
+	const op = "internal.storage.postgresql.EnqueueDeleteJobs"
+
+	userIDs := make([]string, 0, len(jobs))
+	shortURLs := make([]string, 0, len(jobs))
+	traceParents := make([]string, 0, len(jobs))
+	operationIDs := make([]string, 0, len(jobs))
+
+	for _, j := range jobs {
+		userIDs = append(userIDs, j.UserID)
+		shortURLs = append(shortURLs, j.ShortURL)
+		traceParents = append(traceParents, j.TraceParent)
+		operationIDs = append(operationIDs, j.OperationID)
+	}
 
-	_, err := d.db.ExecContext(ctx, query, shortURL)
+	query := `INSERT INTO 
+    			delete_jobs(user_id, short_url, trace_parent, operation_id) 
+			SELECT u, s, t, NULLIF(o, '') 
+			FROM unnest($1::TEXT[], $2::TEXT[], $3::TEXT[], $4::TEXT[]) AS j(u, s, t, o)`
+	_, err := d.db.ExecContext(ctx, query,
+		pq.Array(userIDs), pq.Array(shortURLs), pq.Array(traceParents), pq.Array(operationIDs))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ClaimDeleteJobs takes the due pending jobs, skipping those locked by other replicas.
+func (d *Postgresql) ClaimDeleteJobs(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]DeleteJob, error) {
+	const op = "internal.storage.postgresql.ClaimDeleteJobs"
+
+	query := `UPDATE delete_jobs 
+		SET 
+		    attempts = attempts + 1, 
+		    next_run_at = $2 
+		WHERE id IN (
+		    SELECT id 
+		    FROM delete_jobs 
+		    WHERE state = 'pending' AND next_run_at <= $1 
+		    ORDER BY id 
+		    LIMIT $3 
+		    FOR UPDATE SKIP LOCKED) 
+		RETURNING ` + deleteJobColumns
+
+	rows, err := d.db.QueryContext(ctx, query, now, now.Add(lease), limit)
 	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return scanDeleteJobs(rows)
+}
+
+// CompleteDeleteJobs marks the deletion jobs as done and removes the expired operations.
+func (d *Postgresql) CompleteDeleteJobs(ctx context.Context, ids []int64) error {
+	query := `UPDATE delete_jobs 
+		SET state = 'done', last_error = NULL 
+		WHERE id = ANY($1)`
+
+	if err := d.execJob(ctx, query, pq.Array(ids)); err != nil {
 		return err
 	}
 
+	d.pruneDeleteJobs(ctx)
+	return nil
+}
+
+// pruneDeleteJobs removes the operations older than the retention period and the done jobs
+// that do not belong to a kept operation, as the journal does on compaction. It runs once in a pruneInterval,
+// the errors are ignored as the next prune retries.
+func (d *Postgresql) pruneDeleteJobs(ctx context.Context) {
+	d.mu.Lock()
+	due := time.Since(d.lastPrune) >= pruneInterval
+	if due {
+		d.lastPrune = time.Now()
+	}
+	d.mu.Unlock()
+
+	if !due {
+		return
+	}
+
+	query := `DELETE FROM delete_operations WHERE created_at < $1`
+	if _, err := d.db.ExecContext(ctx, query, time.Now().Add(-operationRetention)); err != nil {
+		return
+	}
+
+	query = `DELETE FROM delete_jobs AS j 
+		WHERE j.state = 'done' AND NOT EXISTS (
+		    SELECT 1 
+		    FROM delete_operations AS o 
+		    WHERE o.id = j.operation_id)`
+	d.db.ExecContext(ctx, query)
+}
+
+// RetryDeleteJob schedules the next attempt of the deletion job.
+func (d *Postgresql) RetryDeleteJob(ctx context.Context, id int64, nextRunAt time.Time, reason string) error {
+	query := `UPDATE delete_jobs 
+		SET next_run_at = $2, last_error = $3 
+		WHERE id = $1`
+
+	return d.execJob(ctx, query, id, nextRunAt, reason)
+}
+
+// BuryDeleteJob moves the deletion job to the dead-letter state.
+func (d *Postgresql) BuryDeleteJob(ctx context.Context, id int64, reason string) error {
+	query := `UPDATE delete_jobs 
+		SET state = 'dead', last_error = $2 
+		WHERE id = $1`
+
+	return d.execJob(ctx, query, id, reason)
+}
+
+// DeleteJobs lists up to limit deletion jobs in the given state, oldest first.
+func (d *Postgresql) DeleteJobs(ctx context.Context, state string, limit int) ([]DeleteJob, error) {
+	const op = "internal.storage.postgresql.DeleteJobs"
+
+	query := `SELECT ` + deleteJobColumns + ` 
+		FROM delete_jobs 
+		WHERE state = $1 
+		ORDER BY id 
+		LIMIT $2`
+
+	rows, err := d.db.QueryContext(ctx, query, state, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return scanDeleteJobs(rows)
+}
+
+// CountDeleteJobs returns the number of deletion jobs in the given state.
+func (d *Postgresql) CountDeleteJobs(ctx context.Context, state string) (int, error) {
+	var n int
+
+	query := `SELECT COUNT(*) FROM delete_jobs WHERE state = $1`
+	if err := d.db.QueryRowContext(ctx, query, state).Scan(&n); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// SaveDeleteOperation creates the deletion operation or updates its stored fields.
+func (d *Postgresql) SaveDeleteOperation(ctx context.Context, operation DeleteOperation) error {
+	const op = "internal.storage.postgresql.SaveDeleteOperation"
+
+	query := `INSERT INTO 
+    			delete_operations(id, user_id, requested, skipped, planned, error, created_at) 
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7) 
+			ON CONFLICT (id) DO UPDATE SET 
+			    skipped = EXCLUDED.skipped, 
+			    planned = EXCLUDED.planned, 
+			    error = EXCLUDED.error`
+	_, err := d.db.ExecContext(ctx, query, operation.ID, operation.UserID, operation.Requested,
+		pq.Array(operation.Skipped), operation.Planned, operation.Error, operation.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
 	return nil
 }
 
+// GetDeleteOperation returns the deletion operation with the counters of its jobs.
+func (d *Postgresql) GetDeleteOperation(ctx context.Context, id string) (DeleteOperation, error) {
+	const op = "internal.storage.postgresql.GetDeleteOperation"
+
+	var rst DeleteOperation
+
+	query := `SELECT 
+    		o.id, 
+    		o.user_id, 
+    		o.requested, 
+    		o.skipped, 
+    		o.planned, 
+    		COALESCE(o.error, ''), 
+    		o.created_at, 
+    		COUNT(j.id) FILTER (WHERE j.state = 'pending' AND j.attempts = 0), 
+    		COUNT(j.id) FILTER (WHERE j.state = 'pending' AND j.attempts > 0), 
+    		COUNT(j.id) FILTER (WHERE j.state = 'done'), 
+    		COUNT(j.id) FILTER (WHERE j.state = 'dead') 
+		FROM 
+		    delete_operations AS o 
+		    	LEFT JOIN delete_jobs AS j 
+		    	ON j.operation_id = o.id 
+		WHERE o.id = $1 
+		GROUP BY o.id`
+
+	err := d.db.QueryRowContext(ctx, query, id).Scan(
+		&rst.ID, &rst.UserID, &rst.Requested, pq.Array(&rst.Skipped), &rst.Planned, &rst.Error, &rst.CreatedAt,
+		&rst.Pending, &rst.Running, &rst.Done, &rst.Failed,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DeleteOperation{}, ErrNotFoundOperation
+	} else if err != nil {
+		return DeleteOperation{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rst.resolve()
+	return rst, nil
+}
+
 // CheckStorage checks the connection to the database.
 func (d *Postgresql) CheckStorage(ctx context.Context) error {
 	err := d.db.PingContext(ctx)
@@ -159,13 +1227,21 @@ func (d *Postgresql) Close() error {
 	return d.db.Close()
 }
 
+// createdKey orders the users' URLs by the time of creation. The time is unknown for the URLs added before
+// it was recorded, it is NULL for them and sorts as the zero time, as in the other data stores.
+const createdKey = `COALESCE(created_at, '0001-01-01 00:00:00+00')`
+
 func createTables(ctx context.Context, db *sql.DB) error {
 	query := `
 		CREATE TABLE IF NOT EXISTS users (
     		user_id VARCHAR(255), 
     		short_url VARCHAR(255) PRIMARY KEY);
 		CREATE INDEX IF NOT EXISTS idx_user ON users(user_id);
-		CREATE INDEX IF NOT EXISTS idx_url ON users(short_url);`
+		CREATE INDEX IF NOT EXISTS idx_url ON users(short_url);
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ;
+		ALTER TABLE users ALTER COLUMN created_at SET DEFAULT NOW();
+		DROP INDEX IF EXISTS idx_users_user_created;
+		CREATE INDEX IF NOT EXISTS idx_users_user_created_key ON users(user_id, ` + createdKey + `, short_url);`
 
 	_, err := db.ExecContext(ctx, query)
 	if err != nil {
@@ -177,12 +1253,219 @@ func createTables(ctx context.Context, db *sql.DB) error {
     		original_url TEXT PRIMARY KEY, 
     		short_url VARCHAR(255), 
     		mark_del BOOLEAN);
-		CREATE UNIQUE INDEX IF NOT EXISTS idx_original_url ON urls(original_url)`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_original_url ON urls(original_url);
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS title TEXT;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS notes TEXT;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS redirect_type SMALLINT NOT NULL DEFAULT 0;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS passthrough BOOLEAN NOT NULL DEFAULT FALSE;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS utm_source TEXT;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS utm_medium TEXT;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS utm_campaign TEXT;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS utm_term TEXT;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS utm_content TEXT;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS rules JSONB;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS variants JSONB;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS threat TEXT;
+		CREATE INDEX IF NOT EXISTS idx_urls_short_url ON urls(short_url);
+		CREATE INDEX IF NOT EXISTS idx_urls_deleted_at ON urls(deleted_at) WHERE mark_del`
+
+	_, err = db.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		CREATE TABLE IF NOT EXISTS delete_jobs (
+    		id BIGSERIAL PRIMARY KEY, 
+    		user_id VARCHAR(255) NOT NULL, 
+    		short_url VARCHAR(255) NOT NULL, 
+    		state VARCHAR(16) NOT NULL DEFAULT 'pending', 
+    		attempts INTEGER NOT NULL DEFAULT 0, 
+    		next_run_at TIMESTAMPTZ NOT NULL DEFAULT now(), 
+    		last_error TEXT, 
+    		trace_parent TEXT, 
+    		created_at TIMESTAMPTZ NOT NULL DEFAULT now());
+		CREATE INDEX IF NOT EXISTS idx_delete_jobs_due ON delete_jobs(state, next_run_at)`
+
+	_, err = db.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		CREATE TABLE IF NOT EXISTS delete_operations (
+    		id VARCHAR(64) PRIMARY KEY, 
+    		user_id VARCHAR(255) NOT NULL, 
+    		requested INTEGER NOT NULL, 
+    		skipped TEXT[], 
+    		planned BOOLEAN NOT NULL DEFAULT FALSE, 
+    		error TEXT, 
+    		created_at TIMESTAMPTZ NOT NULL DEFAULT now());
+		ALTER TABLE delete_jobs ADD COLUMN IF NOT EXISTS operation_id VARCHAR(64);
+		CREATE INDEX IF NOT EXISTS idx_delete_jobs_operation ON delete_jobs(operation_id)`
+
+	_, err = db.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		CREATE TABLE IF NOT EXISTS tags (
+    		id BIGSERIAL PRIMARY KEY, 
+    		user_id VARCHAR(255) NOT NULL, 
+    		name VARCHAR(64) NOT NULL, 
+    		UNIQUE (user_id, name));
+		CREATE TABLE IF NOT EXISTS url_tags (
+    		tag_id BIGINT NOT NULL REFERENCES tags(id) ON DELETE CASCADE, 
+    		short_url VARCHAR(255) NOT NULL, 
+    		PRIMARY KEY (short_url, tag_id));
+		CREATE INDEX IF NOT EXISTS idx_url_tags_tag ON url_tags(tag_id)`
+
+	_, err = db.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		CREATE TABLE IF NOT EXISTS user_quotas (
+    		user_id VARCHAR(255) PRIMARY KEY, 
+    		quota INTEGER NOT NULL)`
 
 	_, err = db.ExecContext(ctx, query)
 	if err != nil {
 		return err
 	}
 
+	query = `
+		CREATE TABLE IF NOT EXISTS rate_limits (
+    		key VARCHAR(255) PRIMARY KEY, 
+    		tokens DOUBLE PRECISION NOT NULL, 
+    		updated_at TIMESTAMPTZ NOT NULL, 
+    		full_at TIMESTAMPTZ NOT NULL);
+		CREATE INDEX IF NOT EXISTS idx_rate_limits_full_at ON rate_limits(full_at)`
+
+	_, err = db.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// pgUniqueViolation is the code of the PostgreSQL error raised when a unique constraint is violated.
+const pgUniqueViolation = "23505"
+
+const deleteJobColumns = `id, user_id, short_url, state, attempts, next_run_at, 
+	COALESCE(last_error, ''), created_at, COALESCE(trace_parent, ''), COALESCE(operation_id, '')`
+
+func (d *Postgresql) execJob(ctx context.Context, query string, args ...any) error {
+	res, err := d.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n < 1 {
+		return ErrNotFoundJob
+	}
+
 	return nil
 }
+
+// linkColumns selects a link from urls AS t2 joined with users AS t1, see scanLink.
+const linkColumns = `t2.short_url, t2.original_url, COALESCE(t2.mark_del, FALSE), t2.deleted_at, 
+	COALESCE(t1.user_id, ''), t1.created_at, t2.updated_at, COALESCE(t2.title, ''), COALESCE(t2.notes, ''), 
+	ARRAY(SELECT tg.name FROM url_tags AS ut INNER JOIN tags AS tg ON tg.id = ut.tag_id 
+	      WHERE ut.short_url = t2.short_url ORDER BY tg.name), t2.redirect_type, t2.passthrough, 
+	COALESCE(t2.utm_source, ''), COALESCE(t2.utm_medium, ''), COALESCE(t2.utm_campaign, ''), 
+	COALESCE(t2.utm_term, ''), COALESCE(t2.utm_content, ''), COALESCE(t2.rules::text, ''), 
+	COALESCE(t2.variants::text, ''), COALESCE(t2.threat, '')`
+
+func scanLink(row interface{ Scan(dest ...any) error }) (Link, error) {
+	var (
+		link                            Link
+		deletedAt, createdAt, updatedAt sql.NullTime
+		rules, variants                 string
+	)
+
+	err := row.Scan(&link.ShortURL, &link.OriginalURL, &link.Deleted, &deletedAt,
+		&link.UserID, &createdAt, &updatedAt, &link.Title, &link.Notes, pq.Array(&link.Tags), &link.RedirectType, &link.Passthrough,
+		&link.UTM.Source, &link.UTM.Medium, &link.UTM.Campaign, &link.UTM.Term, &link.UTM.Content, &rules, &variants, &link.Threat)
+	if err != nil {
+		return Link{}, err
+	}
+
+	if rules != "" {
+		if err := json.Unmarshal([]byte(rules), &link.Rules); err != nil {
+			return Link{}, fmt.Errorf("rules: %w", err)
+		}
+	}
+
+	if variants != "" {
+		if err := json.Unmarshal([]byte(variants), &link.Variants); err != nil {
+			return Link{}, fmt.Errorf("variants: %w", err)
+		}
+	}
+
+	link.DeletedAt, link.CreatedAt, link.UpdatedAt = deletedAt.Time, createdAt.Time, updatedAt.Time
+	if len(link.Tags) == 0 {
+		link.Tags = nil
+	}
+
+	return link, nil
+}
+
+// scanLinks reads the links selected with linkColumns and closes the rows.
+func scanLinks(rows *sql.Rows, sizeHint int) ([]Link, error) {
+	defer rows.Close()
+
+	rst := make([]Link, 0, sizeHint)
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		rst = append(rst, link)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rst, nil
+}
+
+// nullTime converts the zero time to NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func scanDeleteJobs(rows *sql.Rows) ([]DeleteJob, error) {
+	defer rows.Close()
+
+	rst := make([]DeleteJob, 0)
+	for rows.Next() {
+		var job DeleteJob
+
+		err := rows.Scan(&job.ID, &job.UserID, &job.ShortURL, &job.State,
+			&job.Attempts, &job.NextRunAt, &job.LastError, &job.CreatedAt, &job.TraceParent, &job.OperationID)
+		if err != nil {
+			return nil, err
+		}
+
+		rst = append(rst, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rst, nil
+}
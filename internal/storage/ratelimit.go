@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is the interval between the removals of the full buckets, which are the same as no bucket.
+const sweepInterval = time.Minute
+
+// RateLimit is the token bucket of a client: it holds Burst tokens at most and is refilled
+// at Rate tokens per second. The zero limit does not limit the requests.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// IsZero reports whether the limit is disabled.
+func (l RateLimit) IsZero() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// RateLimiter takes tokens from the buckets of the clients.
+type RateLimiter interface {
+	// Take takes a token from the bucket of the key. It returns zero if the token is taken,
+	// otherwise the time until the bucket has a token again.
+	Take(ctx context.Context, key string, limit RateLimit) (time.Duration, error)
+}
+
+// NewRateLimiter returns the rate limiter sharing the buckets between the replicas of the service
+// in the database if shared is set and the data store is PostgreSQL, otherwise the buckets are kept in memory.
+func NewRateLimiter(store Storage, shared bool) RateLimiter {
+	if w, ok := store.(interface{ Unwrap() Storage }); ok {
+		store = w.Unwrap()
+	}
+
+	if pg, ok := store.(*Postgresql); ok && shared {
+		return &PgRateLimiter{db: pg.db}
+	}
+
+	return NewMemRateLimiter()
+}
+
+// refill returns the tokens of the bucket after the time elapsed since the last take, taking one token
+// if there is one. The wait is the time until the bucket has a token if it is empty, zero otherwise.
+func refill(tokens float64, elapsed time.Duration, limit RateLimit) (float64, time.Duration) {
+	tokens = math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*limit.Rate)
+	if tokens >= 1 {
+		return tokens - 1, 0
+	}
+
+	return tokens, time.Duration(math.Ceil((1 - tokens) / limit.Rate * float64(time.Second)))
+}
+
+// fullAt returns the time when the bucket with the tokens is full again.
+func fullAt(now time.Time, tokens float64, limit RateLimit) time.Time {
+	return now.Add(time.Duration((float64(limit.Burst) - tokens) / limit.Rate * float64(time.Second)))
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time
+}
+
+// MemRateLimiter keeps the buckets in memory, so the limits apply to one replica of the service.
+type MemRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemRateLimiter is the constructor for the MemRateLimiter structure.
+func NewMemRateLimiter() *MemRateLimiter {
+	return &MemRateLimiter{buckets: make(map[string]bucket), now: time.Now}
+}
+
+// Take takes a token from the bucket of the key, see RateLimiter.
+func (l *MemRateLimiter) Take(_ context.Context, key string, limit RateLimit) (time.Duration, error) {
+	if limit.IsZero() {
+		return 0, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		for k, b := range l.buckets {
+			if !now.Before(b.fullAt) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = bucket{tokens: float64(limit.Burst), updatedAt: now}
+	}
+
+	tokens, wait := refill(b.tokens, now.Sub(b.updatedAt), limit)
+	l.buckets[key] = bucket{tokens: tokens, updatedAt: now, fullAt: fullAt(now, tokens, limit)}
+
+	return wait, nil
+}
+
+// PgRateLimiter keeps the buckets in the database, so the limits hold across the replicas of the service.
+// The time of the database is used, so the clocks of the replicas do not matter.
+type PgRateLimiter struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// Take takes a token from the bucket of the key, see RateLimiter.
+func (l *PgRateLimiter) Take(ctx context.Context, key string, limit RateLimit) (time.Duration, error) {
+	const op = "internal.storage.postgresql.Take"
+
+	if limit.IsZero() {
+		return 0, nil
+	}
+
+	l.sweep(ctx)
+
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s.BeginTx: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO rate_limits (key, tokens, updated_at, full_at)
+			VALUES ($1, $2, now(), now())
+			ON CONFLICT (key) DO NOTHING`
+
+	if _, err := tx.ExecContext(ctx, query, key, limit.Burst); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var (
+		tokens  float64
+		elapsed float64
+		now     time.Time
+	)
+
+	query = `SELECT tokens, GREATEST(EXTRACT(EPOCH FROM now() - updated_at), 0), now()
+			FROM rate_limits
+			WHERE key = $1
+			FOR UPDATE`
+
+	if err := tx.QueryRowContext(ctx, query, key).Scan(&tokens, &elapsed, &now); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, wait := refill(tokens, time.Duration(elapsed*float64(time.Second)), limit)
+
+	query = `UPDATE rate_limits SET tokens = $2, updated_at = now(), full_at = $3 WHERE key = $1`
+
+	if _, err := tx.ExecContext(ctx, query, key, tokens, fullAt(now, tokens, limit)); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s.Commit: %w", op, err)
+	}
+
+	return wait, nil
+}
+
+// sweep removes the full buckets once in a sweepInterval, the errors are ignored as the next sweep retries.
+func (l *PgRateLimiter) sweep(ctx context.Context) {
+	l.mu.Lock()
+	due := time.Since(l.lastSweep) >= sweepInterval
+	if due {
+		l.lastSweep = time.Now()
+	}
+	l.mu.Unlock()
+
+	if due {
+		l.db.ExecContext(ctx, `DELETE FROM rate_limits WHERE full_at <= now()`)
+	}
+}
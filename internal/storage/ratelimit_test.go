@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	l := NewMemRateLimiter()
+	l.now = func() time.Time { return now }
+
+	// 2 tokens per second, 3 at most.
+	limit := RateLimit{Rate: 2, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		wait, err := l.Take(ctx, "a", limit)
+		require.NoError(t, err)
+		assert.Zero(t, wait, i)
+	}
+
+	wait, err := l.Take(ctx, "a", limit)
+	require.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// The buckets of the other keys are not affected.
+	wait, err = l.Take(ctx, "b", limit)
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+
+	now = now.Add(250 * time.Millisecond)
+	wait, err = l.Take(ctx, "a", limit)
+	require.NoError(t, err)
+	assert.Equal(t, 250*time.Millisecond, wait)
+
+	now = now.Add(250 * time.Millisecond)
+	wait, err = l.Take(ctx, "a", limit)
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+
+	// The bucket is refilled up to the burst.
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		wait, err = l.Take(ctx, "a", limit)
+		require.NoError(t, err)
+		assert.Zero(t, wait, i)
+	}
+	wait, err = l.Take(ctx, "a", limit)
+	require.NoError(t, err)
+	assert.NotZero(t, wait)
+
+	// The full buckets are removed.
+	now = now.Add(time.Hour)
+	_, err = l.Take(ctx, "c", limit)
+	require.NoError(t, err)
+	assert.Len(t, l.buckets, 1)
+
+	wait, err = l.Take(ctx, "a", RateLimit{})
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+}
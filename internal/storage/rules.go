@@ -0,0 +1,16 @@
+package storage
+
+// Rule sends the clients matching all its set conditions to URL instead of the original URL.
+type Rule struct {
+	// OS is the operating system of the client: ios, android, windows, macos, chromeos, linux or other.
+	OS string `json:"os,omitempty"`
+	// Device is the class of the client: mobile, tablet or desktop.
+	Device string `json:"device,omitempty"`
+	// Agent tells bots from humans: bot or human.
+	Agent string `json:"agent,omitempty"`
+	// Language is the range of the preferred language of the client, "pt" matches "pt-BR".
+	Language string `json:"language,omitempty"`
+	// Country is the ISO 3166-1 alpha-2 code of the country of the client, such as "DE".
+	Country string `json:"country,omitempty"`
+	URL     string `json:"url"`
+}
@@ -8,18 +8,147 @@ package storage
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 )
 
 // Storage describes the contract for working with the data storage.
 type Storage interface {
-	Add(ctx context.Context, userID, shortURL, origURL string) error
-	Get(ctx context.Context, shortURL string) (string, error)
-	GetByUser(ctx context.Context, userID string) (map[string]string, error)
-	Delete(ctx context.Context, shortURL string) error
+	Get(ctx context.Context, shortURL string) (Link, error)
+	GetByUser(ctx context.Context, userID string) ([]Link, error)
+	ListByUser(ctx context.Context, userID string, q ListQuery) ([]Link, error)
+	DeleteBatch(ctx context.Context, userID string, shortURLs []string) error
+	Restore(ctx context.Context, userID string, shortURLs []string, deletedAfter time.Time, defaultQuota int) ([]string, error)
+	GetDeletedByUser(ctx context.Context, userID string) ([]DeletedURL, error)
+	Purge(ctx context.Context, deletedBefore time.Time, limit int, dryRun bool) (PurgeResult, error)
+	Walk(ctx context.Context, after string, fn func(Link) error) error
+	WalkByUser(ctx context.Context, userID string, fn func(Link) error) error
+	Put(ctx context.Context, rec Link, overwrite bool) error
+	Create(ctx context.Context, link Link, defaultQuota int) error
+	Update(ctx context.Context, userID, shortURL string, patch LinkPatch) (Link, error)
+	AddVariantClick(ctx context.Context, shortURL string, variant int) error
+	GetTags(ctx context.Context, userID string) ([]Tag, error)
+	RenameTag(ctx context.Context, userID, name, newName string) (int, error)
+	DeleteTag(ctx context.Context, userID, name string) (int, error)
+	CountActive(ctx context.Context, userID string) (int, error)
+	GetQuota(ctx context.Context, userID string) (int, error)
+	SetQuota(ctx context.Context, userID string, quota int) error
+	DeleteQuota(ctx context.Context, userID string) error
 	CheckStorage(ctx context.Context) error
 	Close() error
 }
 
+// Batcher is implemented by the data stores that defer the costly work of a series of writes,
+// such as the import of a dump, until the end of the series.
+type Batcher interface {
+	// BeginBatch starts the series of writes.
+	BeginBatch()
+	// EndBatch ends the series and completes the deferred work.
+	EndBatch() error
+}
+
+// DeletedURL is a shortened URL marked as deleted, DeletedAt is zero if the time of deletion is unknown.
+type DeletedURL struct {
+	ShortURL    string
+	OriginalURL string
+	DeletedAt   time.Time
+}
+
+// Link is a shortened URL with its owner, deletion mark and metadata.
+// The times are zero if they are unknown, as for the links stored by the previous versions,
+// DeletedAt is also zero if the link is not deleted.
+type Link struct {
+	UserID      string
+	ShortURL    string
+	OriginalURL string
+	Deleted     bool
+	DeletedAt   time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	// Title and Notes are optional descriptions of the link given by its owner.
+	Title string
+	Notes string
+	// Tags are the sorted names of the owner's tags attached to the link.
+	Tags []string
+	// RedirectType is the HTTP status of the redirect, zero for the default of the service.
+	RedirectType int
+	// Passthrough merges the query and the trailing path of the request into the original URL.
+	Passthrough bool
+	// UTM are the components of the UTM parameters added to the original URL when it was shortened.
+	UTM UTM
+	// Rules are the ordered targeting rules, the first matching rule selects the destination
+	// and the original URL is the fallback.
+	Rules []Rule
+	// Variants are the weighted destinations replacing the original URL, the visitors are split between them.
+	Variants []Variant
+	// Threat is the type of the threat the URL is quarantined for, such as "phishing", empty if it is not.
+	Threat string
+}
+
+// LinkPatch is a change of the settings of a link applied at once by Update, the nil fields are kept.
+type LinkPatch struct {
+	Tags         *[]string
+	RedirectType *int
+	Passthrough  *bool
+	UTM          *UTM
+	Rules        *[]Rule
+	// Variants replace the variants of the link with their clicks reset.
+	Variants *[]Variant
+	// Threat quarantines the link, the empty threat releases it.
+	Threat *string
+}
+
+// touches reports whether the patch changes the settings of the owner, which sets the time of update.
+// The quarantine alone does not change it.
+func (p LinkPatch) touches() bool {
+	return p.Tags != nil || p.RedirectType != nil || p.Passthrough != nil || p.UTM != nil || p.Rules != nil ||
+		p.Variants != nil
+}
+
+// apply changes the link with the patch, the lists are copied so the link does not share them with the caller.
+func (p LinkPatch) apply(link *Link) {
+	if p.Tags != nil {
+		link.Tags = sortTags(append([]string(nil), *p.Tags...))
+	}
+	if p.RedirectType != nil {
+		link.RedirectType = *p.RedirectType
+	}
+	if p.Passthrough != nil {
+		link.Passthrough = *p.Passthrough
+	}
+	if p.UTM != nil {
+		link.UTM = *p.UTM
+	}
+	if p.Rules != nil {
+		link.Rules = copyRules(*p.Rules)
+	}
+	if p.Variants != nil {
+		link.Variants = resetClicks(*p.Variants)
+	}
+	if p.Threat != nil {
+		link.Threat = *p.Threat
+	}
+}
+
+// Code returns the identifier of the link, the last segment of the shortened URL.
+func (l Link) Code() string {
+	return l.ShortURL[strings.LastIndex(l.ShortURL, "/")+1:]
+}
+
+// Tag is a tag of the user with the number of links it is attached to.
+type Tag struct {
+	Name string `json:"name"`
+	URLs int    `json:"urls"`
+}
+
+// PurgeResult contains the number of records removed permanently or, in the dry-run mode, to be removed.
+type PurgeResult struct {
+	// URLs is the number of URLs deleted before the retention time, together with the records of their owners.
+	URLs int `json:"urls"`
+	// Orphans is the number of records of owners whose URL no longer exists.
+	Orphans int `json:"orphans"`
+}
+
 // New is a constructor for Storage, which determines which storage will be used as the main one.
 func New(ctx context.Context, addrConnDB, pathFileStorage string) Storage {
 	var store Storage
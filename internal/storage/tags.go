@@ -0,0 +1,62 @@
+package storage
+
+import "sort"
+
+// hasTag reports whether the sorted tags contain the name.
+func hasTag(tags []string, name string) bool {
+	i := sort.SearchStrings(tags, name)
+	return i < len(tags) && tags[i] == name
+}
+
+// retag returns a sorted copy of the tags with the name replaced by newName, or removed if newName is empty.
+func retag(tags []string, name, newName string) []string {
+	rst := make([]string, 0, len(tags))
+	for _, v := range tags {
+		if v == name {
+			v = newName
+		}
+
+		if v != "" {
+			rst = append(rst, v)
+		}
+	}
+
+	return sortTags(rst)
+}
+
+// sortTags sorts the tags in place and removes duplicates, nil is returned instead of an empty slice.
+func sortTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	sort.Strings(tags)
+
+	rst := tags[:1]
+	for _, v := range tags[1:] {
+		if v != rst[len(rst)-1] {
+			rst = append(rst, v)
+		}
+	}
+
+	return rst
+}
+
+// countTags returns the tags attached to the links with the number of links, ordered by name.
+func countTags(links []Link) []Tag {
+	counts := make(map[string]int)
+	for _, link := range links {
+		for _, v := range link.Tags {
+			counts[v]++
+		}
+	}
+
+	rst := make([]Tag, 0, len(counts))
+	for name, n := range counts {
+		rst = append(rst, Tag{Name: name, URLs: n})
+	}
+
+	sort.Slice(rst, func(i, j int) bool { return rst[i].Name < rst[j].Name })
+
+	return rst
+}
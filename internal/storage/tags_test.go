@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTags(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls")
+
+	f := NewFileStorage(ctx, path)
+	require.NoError(t, f.Put(ctx,
+		Link{UserID: "1", ShortURL: "http://localhost:8080/a", OriginalURL: "http://example.com/a"}, false))
+	require.NoError(t, f.Put(ctx,
+		Link{UserID: "1", ShortURL: "http://localhost:8080/b", OriginalURL: "http://example.com/b"}, false))
+	require.NoError(t, f.Put(ctx,
+		Link{UserID: "2", ShortURL: "http://localhost:8080/c", OriginalURL: "http://example.com/c"}, false))
+
+	link, err := f.Update(ctx, "1", "http://localhost:8080/a", LinkPatch{Tags: ptr([]string{"q3", "promo", "q3"})})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"promo", "q3"}, link.Tags)
+
+	_, err = f.Update(ctx, "1", "http://localhost:8080/b", LinkPatch{Tags: ptr([]string{"q4"})})
+	require.NoError(t, err)
+	_, err = f.Update(ctx, "2", "http://localhost:8080/c", LinkPatch{Tags: ptr([]string{"promo"})})
+	require.NoError(t, err)
+
+	// The URL of another user is not tagged.
+	_, err = f.Update(ctx, "1", "http://localhost:8080/c", LinkPatch{Tags: ptr([]string{"q3"})})
+	assert.ErrorIs(t, err, ErrNotFoundURL)
+
+	n, err := f.RenameTag(ctx, "1", "q4", "q3")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	n, err = f.DeleteTag(ctx, "1", "promo")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	n, err = f.DeleteTag(ctx, "1", "unknown")
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	require.NoError(t, f.Close())
+
+	// The tags survive reopening the file.
+	f = NewFileStorage(ctx, path)
+	defer f.Close()
+
+	tags, err := f.GetTags(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, []Tag{{Name: "q3", URLs: 2}}, tags)
+
+	tags, err = f.GetTags(ctx, "2")
+	require.NoError(t, err)
+	assert.Equal(t, []Tag{{Name: "promo", URLs: 1}}, tags)
+
+	links, err := f.ListByUser(ctx, "1", ListQuery{Tag: "q3"})
+	require.NoError(t, err)
+	assert.Len(t, links, 2)
+}
+
+func TestRetag(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    []string
+		oldName string
+		newName string
+		want    []string
+	}{
+		{name: "renamed", tags: []string{"a", "b"}, oldName: "a", newName: "c", want: []string{"b", "c"}},
+		{name: "merged", tags: []string{"a", "b"}, oldName: "a", newName: "b", want: []string{"b"}},
+		{name: "removed", tags: []string{"a", "b"}, oldName: "b", want: []string{"a"}},
+		{name: "last removed", tags: []string{"a"}, oldName: "a", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, retag(tt.tags, tt.oldName, tt.newName))
+		})
+	}
+}
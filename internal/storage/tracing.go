@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "go-shortener-url/internal/storage"
+
+// TracedStorage is a Storage decorator that records every call as a span.
+type TracedStorage struct {
+	next   Storage
+	tracer trace.Tracer
+	system string
+}
+
+// WithTracing wraps the data store so that all its methods are traced.
+func WithTracing(s Storage) *TracedStorage {
+	var system string
+
+	switch s.(type) {
+	case *Postgresql:
+		system = "postgresql"
+	case *FileStorage:
+		system = "file"
+	case *MemStorage:
+		system = "memory"
+	default:
+		system = "other"
+	}
+
+	return &TracedStorage{
+		next:   s,
+		tracer: otel.Tracer(tracerName),
+		system: system,
+	}
+}
+
+// Unwrap returns the decorated data store.
+func (t *TracedStorage) Unwrap() Storage {
+	return t.next
+}
+
+// Get records the call of Get on the decorated data store.
+func (t *TracedStorage) Get(ctx context.Context, shortURL string) (_ Link, err error) {
+	ctx, span := t.start(ctx, "Get", attribute.String("url.short", shortURL))
+	defer func() { finish(span, err) }()
+
+	return t.next.Get(ctx, shortURL)
+}
+
+// GetByUser records the call of GetByUser on the decorated data store.
+func (t *TracedStorage) GetByUser(ctx context.Context, userID string) (_ []Link, err error) {
+	ctx, span := t.start(ctx, "GetByUser")
+	defer func() { finish(span, err) }()
+
+	return t.next.GetByUser(ctx, userID)
+}
+
+// DeleteBatch records the call of DeleteBatch on the decorated data store.
+func (t *TracedStorage) DeleteBatch(ctx context.Context, userID string, shortURLs []string) (err error) {
+	ctx, span := t.start(ctx, "DeleteBatch", attribute.Int("batch.size", len(shortURLs)))
+	defer func() { finish(span, err) }()
+
+	return t.next.DeleteBatch(ctx, userID, shortURLs)
+}
+
+// Restore records the call of Restore on the decorated data store.
+func (t *TracedStorage) Restore(ctx context.Context, userID string, shortURLs []string, deletedAfter time.Time, defaultQuota int) (_ []string, err error) {
+	ctx, span := t.start(ctx, "Restore", attribute.Int("batch.size", len(shortURLs)))
+	defer func() { finish(span, err) }()
+
+	return t.next.Restore(ctx, userID, shortURLs, deletedAfter, defaultQuota)
+}
+
+// GetDeletedByUser records the call of GetDeletedByUser on the decorated data store.
+func (t *TracedStorage) GetDeletedByUser(ctx context.Context, userID string) (_ []DeletedURL, err error) {
+	ctx, span := t.start(ctx, "GetDeletedByUser")
+	defer func() { finish(span, err) }()
+
+	return t.next.GetDeletedByUser(ctx, userID)
+}
+
+// Purge records the call of Purge on the decorated data store.
+func (t *TracedStorage) Purge(ctx context.Context, deletedBefore time.Time, limit int, dryRun bool) (_ PurgeResult, err error) {
+	ctx, span := t.start(ctx, "Purge", attribute.Int("batch.size", limit), attribute.Bool("purge.dry_run", dryRun))
+	defer func() { finish(span, err) }()
+
+	return t.next.Purge(ctx, deletedBefore, limit, dryRun)
+}
+
+// Walk records the call of Walk on the decorated data store.
+func (t *TracedStorage) Walk(ctx context.Context, after string, fn func(Link) error) (err error) {
+	ctx, span := t.start(ctx, "Walk")
+	defer func() { finish(span, err) }()
+
+	return t.next.Walk(ctx, after, fn)
+}
+
+// WalkByUser records the call of WalkByUser on the decorated data store.
+func (t *TracedStorage) WalkByUser(ctx context.Context, userID string, fn func(Link) error) (err error) {
+	ctx, span := t.start(ctx, "WalkByUser")
+	defer func() { finish(span, err) }()
+
+	return t.next.WalkByUser(ctx, userID, fn)
+}
+
+// ListByUser records the call of ListByUser on the decorated data store.
+func (t *TracedStorage) ListByUser(ctx context.Context, userID string, q ListQuery) (_ []Link, err error) {
+	ctx, span := t.start(ctx, "ListByUser", attribute.Int("list.limit", q.Limit))
+	defer func() { finish(span, err) }()
+
+	return t.next.ListByUser(ctx, userID, q)
+}
+
+// Put records the call of Put on the decorated data store.
+func (t *TracedStorage) Put(ctx context.Context, rec Link, overwrite bool) (err error) {
+	ctx, span := t.start(ctx, "Put", attribute.String("url.short", rec.ShortURL), attribute.Bool("put.overwrite", overwrite))
+	defer func() { finish(span, err) }()
+
+	return t.next.Put(ctx, rec, overwrite)
+}
+
+// Create records the call of Create on the decorated data store.
+func (t *TracedStorage) Create(ctx context.Context, link Link, defaultQuota int) (err error) {
+	ctx, span := t.start(ctx, "Create", attribute.String("url.short", link.ShortURL))
+	defer func() { finish(span, err) }()
+
+	return t.next.Create(ctx, link, defaultQuota)
+}
+
+// Update records the call of Update on the decorated data store.
+func (t *TracedStorage) Update(ctx context.Context, userID, shortURL string, patch LinkPatch) (_ Link, err error) {
+	ctx, span := t.start(ctx, "Update", attribute.String("url.short", shortURL))
+	defer func() { finish(span, err) }()
+
+	return t.next.Update(ctx, userID, shortURL, patch)
+}
+
+// AddVariantClick records the call of AddVariantClick on the decorated data store.
+func (t *TracedStorage) AddVariantClick(ctx context.Context, shortURL string, variant int) (err error) {
+	ctx, span := t.start(ctx, "AddVariantClick", attribute.String("url.short", shortURL), attribute.Int("variant", variant))
+	defer func() { finish(span, err) }()
+
+	return t.next.AddVariantClick(ctx, shortURL, variant)
+}
+
+// CountActive records the call of CountActive on the decorated data store.
+func (t *TracedStorage) CountActive(ctx context.Context, userID string) (_ int, err error) {
+	ctx, span := t.start(ctx, "CountActive")
+	defer func() { finish(span, err) }()
+
+	return t.next.CountActive(ctx, userID)
+}
+
+// GetQuota records the call of GetQuota on the decorated data store.
+func (t *TracedStorage) GetQuota(ctx context.Context, userID string) (_ int, err error) {
+	ctx, span := t.start(ctx, "GetQuota")
+	defer func() { finish(span, err) }()
+
+	return t.next.GetQuota(ctx, userID)
+}
+
+// SetQuota records the call of SetQuota on the decorated data store.
+func (t *TracedStorage) SetQuota(ctx context.Context, userID string, quota int) (err error) {
+	ctx, span := t.start(ctx, "SetQuota", attribute.Int("user.quota", quota))
+	defer func() { finish(span, err) }()
+
+	return t.next.SetQuota(ctx, userID, quota)
+}
+
+// DeleteQuota records the call of DeleteQuota on the decorated data store.
+func (t *TracedStorage) DeleteQuota(ctx context.Context, userID string) (err error) {
+	ctx, span := t.start(ctx, "DeleteQuota")
+	defer func() { finish(span, err) }()
+
+	return t.next.DeleteQuota(ctx, userID)
+}
+
+// GetTags records the call of GetTags on the decorated data store.
+func (t *TracedStorage) GetTags(ctx context.Context, userID string) (_ []Tag, err error) {
+	ctx, span := t.start(ctx, "GetTags")
+	defer func() { finish(span, err) }()
+
+	return t.next.GetTags(ctx, userID)
+}
+
+// RenameTag records the call of RenameTag on the decorated data store.
+func (t *TracedStorage) RenameTag(ctx context.Context, userID, name, newName string) (_ int, err error) {
+	ctx, span := t.start(ctx, "RenameTag")
+	defer func() { finish(span, err) }()
+
+	return t.next.RenameTag(ctx, userID, name, newName)
+}
+
+// DeleteTag records the call of DeleteTag on the decorated data store.
+func (t *TracedStorage) DeleteTag(ctx context.Context, userID, name string) (_ int, err error) {
+	ctx, span := t.start(ctx, "DeleteTag")
+	defer func() { finish(span, err) }()
+
+	return t.next.DeleteTag(ctx, userID, name)
+}
+
+// CheckStorage records the call of CheckStorage on the decorated data store.
+func (t *TracedStorage) CheckStorage(ctx context.Context) (err error) {
+	ctx, span := t.start(ctx, "CheckStorage")
+	defer func() { finish(span, err) }()
+
+	return t.next.CheckStorage(ctx)
+}
+
+// Close closes the decorated data store.
+func (t *TracedStorage) Close() error {
+	return t.next.Close()
+}
+
+func (t *TracedStorage) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("db.system", t.system))
+
+	return t.tracer.Start(ctx, "storage."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+func finish(span trace.Span, err error) {
+	defer span.End()
+
+	if err == nil {
+		return
+	}
+
+	// Expected results of a lookup are not failures of the data store.
+	if errors.Is(err, ErrUniqueValue) || errors.Is(err, ErrNotFoundURL) || errors.Is(err, ErrDeletedURL) ||
+		errors.Is(err, ErrNotFoundQuota) || errors.Is(err, ErrQuotaExceeded) {
+		span.SetAttributes(attribute.String("storage.result", err.Error()))
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,48 @@
+package storage
+
+// UTM are the components of the UTM parameters the original URL was built with.
+type UTM struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// IsZero reports whether no component is set.
+func (u UTM) IsZero() bool {
+	return u == UTM{}
+}
+
+// Params returns the names of the UTM parameters with the values of the components in the canonical order,
+// the components that are not set are included with empty values.
+func (u UTM) Params() [][2]string {
+	return [][2]string{
+		{"utm_source", u.Source},
+		{"utm_medium", u.Medium},
+		{"utm_campaign", u.Campaign},
+		{"utm_term", u.Term},
+		{"utm_content", u.Content},
+	}
+}
+
+// match reports whether the components set in the filter are equal to the components of u.
+func (u UTM) match(filter UTM) bool {
+	params, want := u.Params(), filter.Params()
+	for i := range want {
+		if want[i][1] != "" && want[i][1] != params[i][1] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// utmOrNil returns nil for the zero UTM, so that it is omitted from JSON.
+func utmOrNil(u UTM) *UTM {
+	if u.IsZero() {
+		return nil
+	}
+
+	return &u
+}
@@ -0,0 +1,19 @@
+package storage
+
+// Variant is one of the destinations of a URL split between several landing pages.
+type Variant struct {
+	URL string `json:"url"`
+	// Weight is the share of the visitors sent to the variant relative to the sum of the weights.
+	Weight int `json:"weight"`
+	// Clicks is the number of redirects to the variant since the variants were set.
+	Clicks int64 `json:"clicks,omitempty"`
+}
+
+// copyVariants copies the variants, so that the stored links do not share them with the callers, nil stays nil.
+func copyVariants(variants []Variant) []Variant {
+	if len(variants) == 0 {
+		return nil
+	}
+
+	return append([]Variant(nil), variants...)
+}
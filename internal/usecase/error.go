@@ -7,4 +7,44 @@ var (
 	ErrUniqueValue = errors.New("not unique value")
 	ErrDeletedURL  = errors.New("URL mark on deleted")
 	ErrNotFoundURL = errors.New("URL not found")
+
+	ErrInvalidState      = errors.New("unknown job state")
+	ErrNotFoundOperation = errors.New("operation not found")
+	ErrPurgeDisabled     = errors.New("purge is disabled")
+
+	ErrInvalidLimit  = errors.New("limit must be between 0 and 1000")
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	ErrInvalidTag  = errors.New("tag must have 1 to 64 characters and no commas")
+	ErrTooManyTags = errors.New("too many tags, the maximum is 20")
+	ErrNotFoundTag = errors.New("tag not found")
+
+	ErrInvalidQRFormat = errors.New("format must be png or svg")
+	ErrInvalidQRSize   = errors.New("size must be between 64 and 2048")
+	ErrInvalidQRMargin = errors.New("margin must be between 0 and 16")
+
+	ErrInvalidInterstitial = errors.New("interstitial mode must be empty, external or all")
+	ErrInvalidRedirectType = errors.New("redirect type must be 301, 302, 303, 307 or 308")
+	ErrEmptyUpdate         = errors.New("nothing to update")
+
+	ErrInvalidQueryConflict = errors.New("query conflict policy must be keep, override or append")
+
+	ErrInvalidUTM = errors.New("UTM values must have at most 256 characters")
+
+	ErrInvalidRule  = errors.New("invalid targeting rule")
+	ErrTooManyRules = errors.New("too many targeting rules, the maximum is 20")
+
+	ErrUnsafeURL        = errors.New("URL is not allowed by the policy")
+	ErrInvalidURLPolicy = errors.New("invalid URL policy")
+
+	ErrInvalidVariant  = errors.New("invalid variant")
+	ErrTooManyVariants = errors.New("too many variants, the maximum is 10")
+
+	ErrInvalidThreat = errors.New("threat must have 1 to 64 characters")
+
+	ErrInvalidRateLimit = errors.New("rate limit must be a positive number per s, m or h, such as 30/m")
+
+	ErrQuotaExceeded = errors.New("quota of active links exceeded")
+	ErrInvalidQuota  = errors.New("quota must not be negative")
+	ErrNotFoundQuota = errors.New("quota not found")
 )
@@ -18,7 +18,7 @@ func ExampleManager_ExecDeleting() {
 	store := storage.NewMemStorage()
 	baseURL := "http://localhost:8080"
 
-	deleter := deleteurl.InitUrlDeleteService(store)
+	deleter := deleteurl.InitUrlDeleteService(store, nil, deleteurl.Config{})
 	deleter.Run(1)
 	defer deleter.Stop()
 
@@ -30,10 +30,10 @@ func ExampleManager_ExecDeleting() {
 	}
 	shortURL := fmt.Sprintf("%s/%s", baseURL, id)
 
-	err = store.Add(context.Background(), userID, shortURL, fullURL)
+	err = store.Put(context.Background(), storage.Link{UserID: userID, ShortURL: shortURL, OriginalURL: fullURL}, false)
 	if err != nil {
 		return
 	}
 
-	manager.ExecDeleting(items, userID)
+	manager.ExecDeleting(context.Background(), "", items, userID)
 }
@@ -0,0 +1,46 @@
+package usecase
+
+import (
+	"context"
+	"io"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-shortener-url/internal/pkg/dump"
+)
+
+// exportFlushEvery is the number of records after which the exported data is sent to the client.
+const exportFlushEvery = 100
+
+// ExportUserURLs streams all URLs of the user, including the deleted ones, to w in the dump format.
+// The records are read from the data store in pages, so the whole export is never kept in memory.
+// If w supports flushing, it is flushed periodically.
+func (m *Manager) ExportUserURLs(ctxReq context.Context, userID string, w io.Writer, format string) error {
+	ctx, span := tracer.Start(ctxReq, "Manager.ExportUserURLs",
+		trace.WithAttributes(attribute.String("export.format", format)),
+	)
+	defer span.End()
+
+	flusher, _ := w.(interface{ Flush() })
+
+	stats, err := dump.Export(ctx, m.store, w, dump.Options{
+		Format:        format,
+		UserID:        userID,
+		ProgressEvery: exportFlushEvery,
+		Progress: func(dump.Stats) error {
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		},
+	})
+	span.SetAttributes(attribute.Int("export.records", stats.Written))
+
+	if err != nil {
+		recordError(span, err)
+		return err
+	}
+
+	return nil
+}
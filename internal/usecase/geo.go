@@ -0,0 +1,29 @@
+package usecase
+
+import "net/netip"
+
+// GeoLocator resolves the country of an IP address, the code is empty if the address is unknown.
+type GeoLocator interface {
+	Country(addr netip.Addr) (string, error)
+}
+
+// SetGeoLocator sets the locator of the countries of the visitors for the targeting rules,
+// without it the rules with a country never match.
+func (m *Manager) SetGeoLocator(geo GeoLocator) {
+	m.geo = geo
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country of the IP address,
+// it is empty if the address is unknown or no locator is set.
+func (m *Manager) Country(addr netip.Addr) (string, error) {
+	if m.geo == nil {
+		return "", nil
+	}
+
+	return m.geo.Country(addr)
+}
+
+// isCountryCode reports whether s is an uppercase ISO 3166-1 alpha-2 code.
+func isCountryCode(s string) bool {
+	return len(s) == 2 && s[0] >= 'A' && s[0] <= 'Z' && s[1] >= 'A' && s[1] <= 'Z'
+}
@@ -0,0 +1,81 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Statuses of the readiness checks.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check is the result of checking one dependency of the service.
+type Check struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Readiness is the result of checking all dependencies of the service.
+type Readiness struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+// Ready reports whether all dependencies are available.
+func (r Readiness) Ready() bool {
+	return r.Status == StatusOK
+}
+
+// SetShuttingDown marks the service as not ready to accept new requests.
+func (m *Manager) SetShuttingDown() {
+	m.shuttingDown.Store(true)
+}
+
+// Readiness checks the data store, the URL removal workers and their queue.
+func (m *Manager) Readiness(ctxReq context.Context) Readiness {
+	ctxSpan, span := tracer.Start(ctxReq, "Manager.Readiness")
+	defer span.End()
+
+	rst := Readiness{Status: StatusOK, Checks: make(map[string]Check)}
+
+	set := func(name string, err error) {
+		if err != nil {
+			rst.Status = StatusFail
+			rst.Checks[name] = Check{Status: StatusFail, Error: err.Error()}
+			return
+		}
+
+		rst.Checks[name] = Check{Status: StatusOK}
+	}
+
+	var errShutdown error
+	if m.shuttingDown.Load() {
+		errShutdown = errors.New("service is shutting down")
+	}
+	set("shutdown", errShutdown)
+
+	ctx, cancel := context.WithTimeout(ctxSpan, 1*time.Second)
+	defer cancel()
+
+	set("storage", m.store.CheckStorage(ctx))
+
+	if m.deleterURLs != nil {
+		var errWorkers error
+		if m.deleterURLs.Workers() == 0 {
+			errWorkers = errors.New("no running workers")
+		}
+		set("delete_workers", errWorkers)
+
+		backlog, errQueue := m.deleterURLs.Backlog(ctx)
+		if max := m.deleterURLs.MaxBacklog(); errQueue == nil && backlog > max {
+			errQueue = fmt.Errorf("queue backlog is %d jobs, the limit is %d", backlog, max)
+		}
+		set("delete_queue", errQueue)
+	}
+
+	return rst
+}
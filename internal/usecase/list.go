@@ -0,0 +1,172 @@
+package usecase
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-shortener-url/internal/storage"
+)
+
+// MaxPageSize is the maximum number of URLs on a page of ListUserURLs.
+const MaxPageSize = 1000
+
+// ListParams selects the page of the user's URLs. Cursor is the NextCursor of the previous page,
+// the first page is listed if it is empty. Without a limit, all URLs after the cursor are listed.
+type ListParams struct {
+	Limit       int
+	Cursor      string
+	Domain      string
+	Deleted     *bool
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Search      string
+	Tag         string
+	UTM         storage.UTM
+}
+
+// UserURL is a URL of the user, CreatedAt is omitted if the time of creation is unknown
+// and RedirectType if the URL is redirected with the default status. UTM are the components added on creation.
+type UserURL struct {
+	ShortURL     string            `json:"short_url"`
+	OriginalURL  string            `json:"original_url"`
+	Deleted      bool              `json:"deleted,omitempty"`
+	CreatedAt    *time.Time        `json:"created_at,omitempty"`
+	Title        string            `json:"title,omitempty"`
+	Notes        string            `json:"notes,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	RedirectType int               `json:"redirect_type,omitempty"`
+	Passthrough  bool              `json:"passthrough,omitempty"`
+	UTM          *storage.UTM      `json:"utm,omitempty"`
+	Rules        []storage.Rule    `json:"rules,omitempty"`
+	Variants     []storage.Variant `json:"variants,omitempty"`
+	Threat       string            `json:"threat,omitempty"`
+}
+
+// URLPage is a page of the user's URLs, NextCursor is empty on the last page.
+type URLPage struct {
+	URLs       []UserURL
+	NextCursor string
+}
+
+// ListUserURLs returns the page of the user's URLs ordered by the time of creation.
+// The order is stable, so URLs added while paging appear on the last pages.
+func (m *Manager) ListUserURLs(ctxReq context.Context, userID string, params ListParams) (URLPage, error) {
+	ctxSpan, span := tracer.Start(ctxReq, "Manager.ListUserURLs",
+		trace.WithAttributes(attribute.Int("list.limit", params.Limit)),
+	)
+	defer span.End()
+
+	if params.Limit < 0 || params.Limit > MaxPageSize {
+		return URLPage{}, ErrInvalidLimit
+	}
+
+	q := storage.ListQuery{
+		Domain:      params.Domain,
+		Deleted:     params.Deleted,
+		CreatedFrom: params.CreatedFrom,
+		CreatedTo:   params.CreatedTo,
+		Search:      params.Search,
+		UTM:         params.UTM,
+	}
+
+	if params.Tag != "" {
+		tag, err := normalizeTag(params.Tag)
+		if err != nil {
+			return URLPage{}, err
+		}
+		q.Tag = tag
+	}
+
+	if params.Cursor != "" {
+		after, err := decodeCursor(params.Cursor)
+		if err != nil {
+			return URLPage{}, ErrInvalidCursor
+		}
+		q.After = &after
+	}
+
+	// One more URL is read to know whether there is a next page.
+	if params.Limit > 0 {
+		q.Limit = params.Limit + 1
+	}
+
+	ctx, cancel := context.WithTimeout(ctxSpan, 1*time.Second)
+	defer cancel()
+
+	records, err := m.store.ListByUser(ctx, userID, q)
+	if err != nil {
+		recordError(span, err)
+		return URLPage{}, err
+	}
+
+	var page URLPage
+
+	if params.Limit > 0 && len(records) > params.Limit {
+		records = records[:params.Limit]
+		page.NextCursor = encodeCursor(storage.CursorOf(records[len(records)-1]))
+	}
+
+	page.URLs = make([]UserURL, 0, len(records))
+	for _, rec := range records {
+		page.URLs = append(page.URLs, userURL(rec))
+	}
+
+	return page, nil
+}
+
+func userURL(link storage.Link) UserURL {
+	rst := UserURL{
+		ShortURL:     link.ShortURL,
+		OriginalURL:  link.OriginalURL,
+		Deleted:      link.Deleted,
+		Title:        link.Title,
+		Notes:        link.Notes,
+		Tags:         link.Tags,
+		RedirectType: link.RedirectType,
+		Passthrough:  link.Passthrough,
+		Rules:        link.Rules,
+		Variants:     link.Variants,
+		Threat:       link.Threat,
+	}
+
+	if !link.UTM.IsZero() {
+		utm := link.UTM
+		rst.UTM = &utm
+	}
+
+	if !link.CreatedAt.IsZero() {
+		createdAt := link.CreatedAt
+		rst.CreatedAt = &createdAt
+	}
+
+	return rst
+}
+
+// encodeCursor returns the opaque cursor in the form base64(<time of creation>|<shortened URL>).
+func encodeCursor(c storage.Cursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ShortURL))
+}
+
+func decodeCursor(s string) (storage.Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return storage.Cursor{}, err
+	}
+
+	createdAt, shortURL, ok := strings.Cut(string(data), "|")
+	if !ok {
+		return storage.Cursor{}, ErrInvalidCursor
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return storage.Cursor{}, err
+	}
+
+	return storage.Cursor{CreatedAt: t, ShortURL: shortURL}, nil
+}
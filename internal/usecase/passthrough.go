@@ -0,0 +1,104 @@
+package usecase
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"go-shortener-url/internal/storage"
+)
+
+// Policies of merging a query parameter present both in the request and in the original URL.
+const (
+	// QueryKeep keeps the values of the original URL.
+	QueryKeep = "keep"
+	// QueryOverride replaces the values of the original URL with the values of the request.
+	QueryOverride = "override"
+	// QueryAppend adds the values of the request after the values of the original URL.
+	QueryAppend = "append"
+)
+
+// DefaultQueryConflict is the policy of the conflicting query parameters, unless changed by SetQueryConflict.
+const DefaultQueryConflict = QueryKeep
+
+// Passthrough is the part of the request passed through to the original URL of the links with the passthrough:
+// the query parameters and the escaped path after the identifier. The path "qr" alone never reaches
+// the original URL, as it is reserved for the QR code of the link.
+type Passthrough struct {
+	Path  string
+	Query url.Values
+}
+
+// ValidateQueryConflict checks the policy of the conflicting query parameters.
+func ValidateQueryConflict(policy string) error {
+	switch policy {
+	case QueryKeep, QueryOverride, QueryAppend:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidQueryConflict, policy)
+	}
+}
+
+// SetQueryConflict sets the policy of the conflicting query parameters, the policy is expected to be valid.
+// The empty policy restores DefaultQueryConflict.
+func (m *Manager) SetQueryConflict(policy string) {
+	if policy == "" {
+		policy = DefaultQueryConflict
+	}
+
+	m.queryConflict = policy
+}
+
+// destination returns the original URL of the link with the passthrough applied.
+// The path is only accepted by the links with the passthrough, ErrNotFoundURL is returned otherwise.
+func (m *Manager) destination(link storage.Link, pass Passthrough) (string, error) {
+	if !link.Passthrough {
+		if pass.Path != "" {
+			return "", ErrNotFoundURL
+		}
+
+		return link.OriginalURL, nil
+	}
+
+	if pass.Path == "" && len(pass.Query) == 0 {
+		return link.OriginalURL, nil
+	}
+
+	u, err := url.Parse(link.OriginalURL)
+	if err != nil {
+		return "", err
+	}
+
+	if pass.Path != "" {
+		// The path is cleaned before joining, so that its dot segments cannot leave the path of the original URL.
+		tail := path.Clean("/" + pass.Path)
+		if strings.HasSuffix(pass.Path, "/") && tail != "/" {
+			tail += "/"
+		}
+
+		u = u.JoinPath(tail)
+	}
+
+	if len(pass.Query) > 0 {
+		u.RawQuery = mergeQuery(u.Query(), pass.Query, m.queryConflict).Encode()
+	}
+
+	return u.String(), nil
+}
+
+// mergeQuery merges the query parameters of the request into the parameters of the original URL.
+func mergeQuery(dst, src url.Values, policy string) url.Values {
+	for key, values := range src {
+		_, conflict := dst[key]
+
+		switch {
+		case !conflict, policy == QueryOverride:
+			dst[key] = values
+		case policy == QueryAppend:
+			dst[key] = append(dst[key], values...)
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,181 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"go-shortener-url/internal/storage"
+)
+
+// Modes of the interstitial page shown instead of the redirect.
+const (
+	InterstitialOff      = ""
+	InterstitialExternal = "external"
+	InterstitialAll      = "all"
+)
+
+// DefaultInterstitialDelay is the countdown of the interstitial page, unless set by the policy.
+const DefaultInterstitialDelay = 5 * time.Second
+
+// InterstitialPolicy selects the URLs followed through the interstitial page with a countdown.
+type InterstitialPolicy struct {
+	// Mode is InterstitialOff, InterstitialExternal for the URLs leading outside of the internal hosts
+	// or InterstitialAll.
+	Mode string
+	// Delay is the countdown before the redirect.
+	Delay time.Duration
+	// InternalHosts are the hosts whose URLs, including the subdomains, are not external.
+	// The host of the base URL is always internal.
+	InternalHosts []string
+}
+
+// LinkPreview describes where a shortened URL leads, CreatedAt is zero if the time of creation is unknown.
+type LinkPreview struct {
+	ShortURL    string
+	OriginalURL string
+	Title       string
+	CreatedAt   time.Time
+	// External is set if the URL leads outside of the internal hosts.
+	External bool
+	// Countdown is the delay of the interstitial page, it is zero if the URL is redirected immediately.
+	Countdown time.Duration
+	// RedirectStatus is the HTTP status of the redirect to the original URL.
+	RedirectStatus int
+	// Targeted is set if the URL has targeting rules, so the original URL depends on the visitor.
+	Targeted bool
+	// Variant is the key of the variant the visitor is sent to if the URL is split.
+	Variant string
+	// Threat is set if the URL is quarantined, the visitor is warned and there is no countdown.
+	Threat string
+}
+
+// Validate checks the mode of the policy.
+func (p InterstitialPolicy) Validate() error {
+	switch p.Mode {
+	case InterstitialOff, InterstitialExternal, InterstitialAll:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidInterstitial, p.Mode)
+	}
+}
+
+// SetInterstitial sets the policy of the interstitial page, the policy is expected to be valid.
+func (m *Manager) SetInterstitial(policy InterstitialPolicy) {
+	if policy.Delay <= 0 {
+		policy.Delay = DefaultInterstitialDelay
+	}
+
+	hosts := make([]string, 0, len(policy.InternalHosts))
+	for _, h := range policy.InternalHosts {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	policy.InternalHosts = hosts
+
+	m.interstitial = policy
+}
+
+// InterstitialEnabled reports whether some URLs are followed through the interstitial page.
+func (m *Manager) InterstitialEnabled() bool {
+	return m.interstitial.Mode != InterstitialOff
+}
+
+// GetPreview returns the description of the shortened URL for the preview or the interstitial page.
+// The destination is selected by the targeting rules, the variants and the passthrough as in GetFullURL.
+// The click on the variant is counted unless the visitor only views the preview without a countdown
+// or the URL is quarantined.
+func (m *Manager) GetPreview(ctxReq context.Context, id string, pass Passthrough, visitor Visitor) (LinkPreview, error) {
+	ctxSpan, span := tracer.Start(ctxReq, "Manager.GetPreview")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctxSpan, 1*time.Second)
+	defer cancel()
+
+	shortURL := fmt.Sprintf("%s/%s", m.baseURL, id)
+	span.SetAttributes(attribute.String("url.short", shortURL))
+
+	link, err := m.store.Get(ctx, shortURL)
+	if err != nil {
+		if errors.Is(err, storage.ErrDeletedURL) {
+			return LinkPreview{}, ErrDeletedURL
+		}
+
+		if errors.Is(err, storage.ErrNotFoundURL) {
+			return LinkPreview{}, ErrNotFoundURL
+		}
+
+		recordError(span, err)
+		return LinkPreview{}, err
+	}
+
+	if link.Threat != "" {
+		return LinkPreview{
+			ShortURL:    link.ShortURL,
+			OriginalURL: link.OriginalURL,
+			Title:       link.Title,
+			CreatedAt:   link.CreatedAt,
+			External:    m.isExternal(link.OriginalURL),
+			Threat:      link.Threat,
+		}, nil
+	}
+
+	targeted := len(link.Rules) > 0
+	link, matched := target(link, visitor)
+	link, variant, key := split(link, matched, visitor.Variant)
+
+	to, err := m.destination(link, pass)
+	if err != nil {
+		return LinkPreview{}, err
+	}
+
+	rst := LinkPreview{
+		ShortURL:       link.ShortURL,
+		OriginalURL:    to,
+		Title:          link.Title,
+		CreatedAt:      link.CreatedAt,
+		External:       m.isExternal(link.OriginalURL),
+		RedirectStatus: m.redirectStatus(link),
+		Targeted:       targeted,
+		Variant:        key,
+	}
+
+	if m.interstitial.Mode == InterstitialAll || (m.interstitial.Mode == InterstitialExternal && rst.External) {
+		rst.Countdown = m.interstitial.Delay
+	}
+
+	if variant >= 0 && (!visitor.Preview || rst.Countdown > 0) {
+		m.countClick(ctx, span, shortURL, variant)
+	}
+
+	return rst, nil
+}
+
+// isExternal reports whether the URL leads outside of the internal hosts,
+// the URLs that cannot be parsed are external.
+func (m *Manager) isExternal(originalURL string) bool {
+	u, err := url.Parse(originalURL)
+	if err != nil {
+		return true
+	}
+
+	hosts := m.interstitial.InternalHosts
+	if base, err := url.Parse(m.baseURL); err == nil && base.Hostname() != "" {
+		hosts = append([]string{strings.ToLower(base.Hostname())}, hosts...)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, h := range hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return false
+		}
+	}
+
+	return true
+}
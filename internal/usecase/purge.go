@@ -0,0 +1,28 @@
+package usecase
+
+import (
+	"context"
+
+	"go-shortener-url/internal/pkg/purge"
+	"go-shortener-url/internal/storage"
+)
+
+// SetPurger sets the service that permanently removes deleted URLs.
+func (m *Manager) SetPurger(p purge.Purger) {
+	m.purger = p
+}
+
+// Purge permanently removes the URLs deleted before the retention period.
+// With dryRun, the records to remove are only counted.
+func (m *Manager) Purge(ctxReq context.Context, dryRun bool) (storage.PurgeResult, error) {
+	if m.purger == nil {
+		return storage.PurgeResult{}, ErrPurgeDisabled
+	}
+
+	rst, err := m.purger.Purge(ctxReq, dryRun)
+	if err != nil {
+		return storage.PurgeResult{}, err
+	}
+
+	return rst, nil
+}
@@ -0,0 +1,101 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/exp/slog"
+
+	"go-shortener-url/internal/pkg/qrcode"
+	"go-shortener-url/internal/storage"
+)
+
+// Formats of the QR code image.
+const (
+	QRFormatPNG = "png"
+	QRFormatSVG = "svg"
+)
+
+// Limits of the QR code image.
+const (
+	DefaultQRSize = 256
+	MinQRSize     = 64
+	MaxQRSize     = 2048
+	MaxQRMargin   = 16
+)
+
+// QRParams are the parameters of the QR code image.
+type QRParams struct {
+	Format string
+	Level  qrcode.Level
+	qrcode.Options
+}
+
+// DefaultQRParams returns the parameters of a black on white PNG image of the default size.
+func DefaultQRParams() QRParams {
+	return QRParams{
+		Format:  QRFormatPNG,
+		Level:   qrcode.M,
+		Options: qrcode.DefaultOptions(DefaultQRSize),
+	}
+}
+
+// QRCode renders the QR code of the shortened URL. Deleted and unknown URLs have no code.
+func (m *Manager) QRCode(ctxReq context.Context, id string, params QRParams) ([]byte, error) {
+	const op = "internal.usecase.QRCode"
+
+	ctxSpan, span := tracer.Start(ctxReq, "Manager.QRCode")
+	defer span.End()
+
+	switch {
+	case params.Format != QRFormatPNG && params.Format != QRFormatSVG:
+		return nil, ErrInvalidQRFormat
+	case params.Size < MinQRSize || params.Size > MaxQRSize:
+		return nil, ErrInvalidQRSize
+	case params.Margin < 0 || params.Margin > MaxQRMargin:
+		return nil, ErrInvalidQRMargin
+	}
+
+	ctx, cancel := context.WithTimeout(ctxSpan, 1*time.Second)
+	defer cancel()
+
+	shortURL := fmt.Sprintf("%s/%s", m.baseURL, id)
+	span.SetAttributes(attribute.String("url.short", shortURL))
+
+	if _, err := m.store.Get(ctx, shortURL); err != nil {
+		if errors.Is(err, storage.ErrDeletedURL) {
+			return nil, ErrDeletedURL
+		}
+
+		if errors.Is(err, storage.ErrNotFoundURL) {
+			return nil, ErrNotFoundURL
+		}
+
+		recordError(span, err)
+		return nil, err
+	}
+
+	code, err := qrcode.Encode([]byte(shortURL), params.Level)
+	if err != nil {
+		slog.Error(fmt.Sprintf("%s.Encode: %v\n", op, err))
+		recordError(span, err)
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if params.Format == QRFormatSVG {
+		err = code.WriteSVG(&buf, params.Options)
+	} else {
+		err = code.WritePNG(&buf, params.Options)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,178 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-shortener-url/internal/storage"
+)
+
+// CodeQuotaExceeded is the code of the requests rejected by the quota of the user.
+const CodeQuotaExceeded = "quota_exceeded"
+
+// QuotaError is returned if the user would hold more active links than the quota allows.
+// It matches ErrQuotaExceeded.
+type QuotaError struct {
+	Quota int
+	Used  int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("quota of %d active links exceeded, %d in use", e.Quota, e.Used)
+}
+
+// Is reports whether the target is ErrQuotaExceeded.
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// Quota is the number of the active links the user may hold, zero for no limit, and the number in use.
+// Override is set if the user has a quota of their own instead of the default.
+type Quota struct {
+	UserID   string `json:"user_id"`
+	Quota    int    `json:"quota"`
+	Used     int    `json:"used"`
+	Override bool   `json:"override"`
+}
+
+// SetDefaultQuota sets the number of the active links the users without a quota of their own may hold,
+// zero for no limit.
+func (m *Manager) SetDefaultQuota(quota int) {
+	m.defaultQuota = quota
+}
+
+// quota returns the quota of the user and reports whether it is the user's own.
+func (m *Manager) quota(ctx context.Context, userID string) (int, bool, error) {
+	quota, err := m.store.GetQuota(ctx, userID)
+	if errors.Is(err, storage.ErrNotFoundQuota) {
+		return m.defaultQuota, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	return quota, true, nil
+}
+
+// checkQuota checks that the user may add the number of the active links returned by count,
+// which is only called if the user has a quota.
+func (m *Manager) checkQuota(ctx context.Context, span trace.Span, userID string, count func() (int, error)) error {
+	quota, _, err := m.quota(ctx, userID)
+	if err != nil {
+		recordError(span, err)
+		return err
+	}
+
+	if quota == 0 {
+		return nil
+	}
+
+	n, err := count()
+	if err != nil {
+		recordError(span, err)
+		return err
+	}
+
+	if n <= 0 {
+		return nil
+	}
+
+	used, err := m.store.CountActive(ctx, userID)
+	if err != nil {
+		recordError(span, err)
+		return err
+	}
+
+	if used+n > quota {
+		span.SetAttributes(attribute.Int("quota.limit", quota), attribute.Int("quota.used", used))
+		return &QuotaError{Quota: quota, Used: used}
+	}
+
+	return nil
+}
+
+// quotaExceeded describes the quota of the user whose link was rejected by the data store.
+// ErrQuotaExceeded is returned if the quota cannot be read.
+func (m *Manager) quotaExceeded(ctx context.Context, span trace.Span, userID string) error {
+	quota, _, err := m.quota(ctx, userID)
+	if err != nil {
+		recordError(span, err)
+		return ErrQuotaExceeded
+	}
+
+	used, err := m.store.CountActive(ctx, userID)
+	if err != nil {
+		recordError(span, err)
+		return ErrQuotaExceeded
+	}
+
+	span.SetAttributes(attribute.Int("quota.limit", quota), attribute.Int("quota.used", used))
+	return &QuotaError{Quota: quota, Used: used}
+}
+
+// GetQuota returns the quota of the user with the number of the active links.
+func (m *Manager) GetQuota(ctxReq context.Context, userID string) (Quota, error) {
+	ctxSpan, span := tracer.Start(ctxReq, "Manager.GetQuota")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctxSpan, 1*time.Second)
+	defer cancel()
+
+	quota, override, err := m.quota(ctx, userID)
+	if err != nil {
+		recordError(span, err)
+		return Quota{}, err
+	}
+
+	used, err := m.store.CountActive(ctx, userID)
+	if err != nil {
+		recordError(span, err)
+		return Quota{}, err
+	}
+
+	return Quota{UserID: userID, Quota: quota, Used: used, Override: override}, nil
+}
+
+// SetQuota sets the quota of the user instead of the default, zero for no limit. The links the user
+// already holds are kept if there are more of them than the quota, only the new ones are rejected.
+func (m *Manager) SetQuota(ctxReq context.Context, userID string, quota int) (Quota, error) {
+	if quota < 0 {
+		return Quota{}, ErrInvalidQuota
+	}
+
+	ctxSpan, span := tracer.Start(ctxReq, "Manager.SetQuota")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctxSpan, 1*time.Second)
+	defer cancel()
+
+	if err := m.store.SetQuota(ctx, userID, quota); err != nil {
+		recordError(span, err)
+		return Quota{}, err
+	}
+
+	return m.GetQuota(ctxSpan, userID)
+}
+
+// DeleteQuota removes the quota of the user, so the default applies again.
+func (m *Manager) DeleteQuota(ctxReq context.Context, userID string) (Quota, error) {
+	ctxSpan, span := tracer.Start(ctxReq, "Manager.DeleteQuota")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctxSpan, 1*time.Second)
+	defer cancel()
+
+	err := m.store.DeleteQuota(ctx, userID)
+	if errors.Is(err, storage.ErrNotFoundQuota) {
+		return Quota{}, ErrNotFoundQuota
+	} else if err != nil {
+		recordError(span, err)
+		return Quota{}, err
+	}
+
+	return m.GetQuota(ctxSpan, userID)
+}
@@ -0,0 +1,66 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-shortener-url/internal/storage"
+)
+
+// ParseRateLimit parses the rate of the requests, such as "30/m", with the units s, m and h
+// and the burst, the number of the requests allowed at once. The burst is the number of the requests
+// of one unit if zero. The empty rate disables the limit.
+func ParseRateLimit(rate string, burst int) (storage.RateLimit, error) {
+	rate = strings.TrimSpace(rate)
+	if rate == "" {
+		return storage.RateLimit{}, nil
+	}
+
+	n, unit, ok := strings.Cut(rate, "/")
+	if !ok {
+		return storage.RateLimit{}, fmt.Errorf("%w: %q", ErrInvalidRateLimit, rate)
+	}
+
+	count, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+	if err != nil || count <= 0 || burst < 0 {
+		return storage.RateLimit{}, fmt.Errorf("%w: %q", ErrInvalidRateLimit, rate)
+	}
+
+	var per time.Duration
+	switch strings.TrimSpace(unit) {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return storage.RateLimit{}, fmt.Errorf("%w: %q", ErrInvalidRateLimit, rate)
+	}
+
+	if burst == 0 {
+		burst = int(count)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+
+	return storage.RateLimit{Rate: count / per.Seconds(), Burst: burst}, nil
+}
+
+// SetRateLimiter sets the rate limiter keeping the buckets of the clients, they are kept in memory by default.
+func (m *Manager) SetRateLimiter(l storage.RateLimiter) {
+	m.rateLimiter = l
+}
+
+// TakeToken takes a token from the bucket of the client identified by the key. It returns zero if the request
+// is allowed, otherwise the time until the client may retry.
+func (m *Manager) TakeToken(ctxReq context.Context, key string, limit storage.RateLimit) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctxReq, 1*time.Second)
+	defer cancel()
+
+	return m.rateLimiter.Take(ctx, key, limit)
+}
@@ -0,0 +1,52 @@
+package usecase
+
+import (
+	"net/http"
+
+	"go-shortener-url/internal/storage"
+)
+
+// DefaultRedirectType is the HTTP status of the redirects, unless changed by SetDefaultRedirectType.
+const DefaultRedirectType = http.StatusTemporaryRedirect
+
+// Redirect is where a shortened URL leads and the HTTP status of the redirect.
+// Targeted is set if the URL has targeting rules, so the destination depends on the visitor.
+// Variant is the key of the variant the visitor is sent to if the URL is split.
+// Threat is set if the URL is quarantined, so the visitor must be warned instead of redirected.
+type Redirect struct {
+	URL      string
+	Status   int
+	Targeted bool
+	Variant  string
+	Threat   string
+}
+
+// ValidateRedirectType checks that the HTTP status is a redirect: 301, 302, 303, 307 or 308.
+func ValidateRedirectType(status int) error {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return nil
+	default:
+		return ErrInvalidRedirectType
+	}
+}
+
+// SetDefaultRedirectType sets the HTTP status of the redirects of the URLs without their own,
+// the status is expected to be valid. Zero restores DefaultRedirectType.
+func (m *Manager) SetDefaultRedirectType(status int) {
+	if status == 0 {
+		status = DefaultRedirectType
+	}
+
+	m.redirectType = status
+}
+
+// redirectStatus returns the HTTP status of the redirect of the link.
+func (m *Manager) redirectStatus(link storage.Link) int {
+	if link.RedirectType != 0 {
+		return link.RedirectType
+	}
+
+	return m.redirectType
+}
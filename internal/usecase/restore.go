@@ -0,0 +1,110 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go-shortener-url/internal/storage"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultRestorePeriod is the time during which a deleted URL can be restored, unless changed by SetRestorePeriod.
+const DefaultRestorePeriod = 24 * time.Hour
+
+// DeletedURL is a deleted URL of the user, it can be restored while Restorable is true.
+type DeletedURL struct {
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	Restorable  bool       `json:"restorable"`
+}
+
+// SetRestorePeriod sets the time during which a deleted URL can be restored.
+func (m *Manager) SetRestorePeriod(period time.Duration) {
+	m.restorePeriod = period
+}
+
+// RestoreURLs clears the deletion mark of the user's URLs deleted within the restore period.
+// The identifiers of the restored URLs and of the rest are returned separately.
+// Nothing is restored if the restored URLs would exceed the quota of the user.
+func (m *Manager) RestoreURLs(ctxReq context.Context, items []string, userID string) ([]string, []string, error) {
+	ctxSpan, span := tracer.Start(ctxReq, "Manager.RestoreURLs",
+		trace.WithAttributes(attribute.Int("items", len(items))),
+	)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctxSpan, 1*time.Second)
+	defer cancel()
+
+	shortURLs := make([]string, 0, len(items))
+	for _, item := range items {
+		shortURLs = append(shortURLs, fmt.Sprintf("%s/%s", m.baseURL, item))
+	}
+
+	// The quota is checked by the same write, so the concurrent requests of the user cannot exceed it.
+	rst, err := m.store.Restore(ctx, userID, shortURLs, time.Now().Add(-m.restorePeriod), m.defaultQuota)
+	if errors.Is(err, storage.ErrQuotaExceeded) {
+		return nil, nil, m.quotaExceeded(ctx, span, userID)
+	} else if err != nil {
+		recordError(span, err)
+		return nil, nil, err
+	}
+
+	restored := make(map[string]bool, len(rst))
+	for _, v := range rst {
+		restored[v] = true
+	}
+
+	ok := make([]string, 0, len(rst))
+	skipped := make([]string, 0)
+
+	for i, item := range items {
+		if restored[shortURLs[i]] {
+			ok = append(ok, item)
+		} else {
+			skipped = append(skipped, item)
+		}
+	}
+
+	return ok, skipped, nil
+}
+
+// GetDeletedURLs lists the user's deleted URLs, the most recently deleted first.
+func (m *Manager) GetDeletedURLs(ctxReq context.Context, userID string) ([]DeletedURL, error) {
+	ctxSpan, span := tracer.Start(ctxReq, "Manager.GetDeletedURLs")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctxSpan, 1*time.Second)
+	defer cancel()
+
+	urls, err := m.store.GetDeletedByUser(ctx, userID)
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+
+	deletedAfter := time.Now().Add(-m.restorePeriod)
+
+	rst := make([]DeletedURL, 0, len(urls))
+	for _, v := range urls {
+		item := DeletedURL{
+			ShortURL:    v.ShortURL,
+			OriginalURL: v.OriginalURL,
+		}
+
+		// The time of deletion is unknown for URLs deleted by the previous versions.
+		if !v.DeletedAt.IsZero() {
+			deletedAt := v.DeletedAt
+			item.DeletedAt = &deletedAt
+			item.Restorable = !deletedAt.Before(deletedAfter)
+		}
+
+		rst = append(rst, item)
+	}
+
+	return rst, nil
+}
@@ -0,0 +1,161 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"go-shortener-url/internal/storage"
+)
+
+// Limits of the variants of a URL split between several destinations.
+const (
+	MaxVariants      = 10
+	MaxVariantWeight = 1000
+)
+
+// VariantStats is a variant of the user's URL with the number of redirects to it.
+// Key identifies the variant in the cookie of the visitors assigned to it.
+type VariantStats struct {
+	Key    string `json:"key"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+	Clicks int64  `json:"clicks"`
+}
+
+// normalizeVariants trims the URLs of the variants and checks them. A split needs at least two variants
+// with distinct absolute URLs and weights from 1 to MaxVariantWeight, no variants disable the split.
+func normalizeVariants(variants []storage.Variant) ([]storage.Variant, error) {
+	if len(variants) > MaxVariants {
+		return nil, ErrTooManyVariants
+	}
+
+	if len(variants) == 1 {
+		return nil, fmt.Errorf("%w: a split needs at least 2 variants", ErrInvalidVariant)
+	}
+
+	rst := make([]storage.Variant, 0, len(variants))
+	seen := make(map[string]bool, len(variants))
+	for i, v := range variants {
+		v.URL = strings.TrimSpace(v.URL)
+		v.Clicks = 0
+
+		if u, err := url.ParseRequestURI(v.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("%w: variant %d: invalid URL %q", ErrInvalidVariant, i+1, v.URL)
+		}
+
+		if v.Weight < 1 || v.Weight > MaxVariantWeight {
+			return nil, fmt.Errorf("%w: variant %d: weight must be between 1 and %d", ErrInvalidVariant, i+1, MaxVariantWeight)
+		}
+
+		if seen[v.URL] {
+			return nil, fmt.Errorf("%w: variant %d: duplicate URL %q", ErrInvalidVariant, i+1, v.URL)
+		}
+		seen[v.URL] = true
+
+		rst = append(rst, v)
+	}
+
+	return rst, nil
+}
+
+// GetVariants returns the variants of the user's URL with their clicks, none if the URL is not split.
+func (m *Manager) GetVariants(ctxReq context.Context, userID, id string) ([]VariantStats, error) {
+	ctxSpan, span := tracer.Start(ctxReq, "Manager.GetVariants")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctxSpan, 1*time.Second)
+	defer cancel()
+
+	link, err := m.store.Get(ctx, fmt.Sprintf("%s/%s", m.baseURL, id))
+	if errors.Is(err, storage.ErrNotFoundURL) || errors.Is(err, storage.ErrDeletedURL) || (err == nil && link.UserID != userID) {
+		return nil, ErrNotFoundURL
+	} else if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+
+	rst := make([]VariantStats, 0, len(link.Variants))
+	for _, v := range link.Variants {
+		rst = append(rst, VariantStats{Key: variantKey(v), URL: v.URL, Weight: v.Weight, Clicks: v.Clicks})
+	}
+
+	return rst, nil
+}
+
+// variantKey identifies the variant by its URL, so the visitors keep their variant
+// while it is not removed, even if the other variants or the weights are changed.
+func variantKey(v storage.Variant) string {
+	h := fnv.New32a()
+	h.Write([]byte(v.URL))
+
+	return strconv.FormatUint(uint64(h.Sum32()), 16)
+}
+
+// pickVariant returns the index of the variant whose key the visitor was assigned to,
+// otherwise it picks one at random in proportion to the weights. It is -1 if the URL is not split.
+func pickVariant(variants []storage.Variant, key string) int {
+	if len(variants) == 0 {
+		return -1
+	}
+
+	if key != "" {
+		for i, v := range variants {
+			if variantKey(v) == key {
+				return i
+			}
+		}
+	}
+
+	total := 0
+	for _, v := range variants {
+		total += v.Weight
+	}
+
+	// The weights of stored variants are positive, a zero total only guards against corrupted data.
+	if total <= 0 {
+		return rand.Intn(len(variants))
+	}
+
+	n := rand.Intn(total)
+	for i, v := range variants {
+		if n < v.Weight {
+			return i
+		}
+		n -= v.Weight
+	}
+
+	return len(variants) - 1
+}
+
+// split sends the visitor to a variant of the split link unless a targeting rule has already selected
+// the destination. It returns the link leading to the variant, the index and the key of the variant,
+// the index is -1 if there is none.
+func split(link storage.Link, matched bool, key string) (storage.Link, int, string) {
+	if matched {
+		return link, -1, ""
+	}
+
+	i := pickVariant(link.Variants, key)
+	if i < 0 {
+		return link, -1, ""
+	}
+
+	link.OriginalURL = link.Variants[i].URL
+	return link, i, variantKey(link.Variants[i])
+}
+
+// countClick records the redirect to the variant, the redirect is not failed if it cannot be recorded.
+func (m *Manager) countClick(ctx context.Context, span trace.Span, shortURL string, variant int) {
+	if err := m.store.AddVariantClick(ctx, shortURL, variant); err != nil {
+		span.RecordError(fmt.Errorf("AddVariantClick: %w", err))
+	}
+}
@@ -0,0 +1,131 @@
+package usecase
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go-shortener-url/internal/storage"
+)
+
+// MaxTags is the maximum number of tags attached to a URL.
+const MaxTags = 20
+
+const maxTagLength = 64
+
+// TagUpdate is the result of renaming or deleting a tag: the name of the tag and the number of changed URLs.
+type TagUpdate struct {
+	Name    string `json:"name"`
+	Updated int    `json:"updated"`
+}
+
+// GetTags lists the tags of the user with the number of URLs, ordered by name.
+func (m *Manager) GetTags(ctxReq context.Context, userID string) ([]storage.Tag, error) {
+	ctxSpan, span := tracer.Start(ctxReq, "Manager.GetTags")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctxSpan, 1*time.Second)
+	defer cancel()
+
+	tags, err := m.store.GetTags(ctx, userID)
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// RenameTag renames the user's tag, merging it with the tag of the new name if it exists.
+func (m *Manager) RenameTag(ctxReq context.Context, userID, name, newName string) (TagUpdate, error) {
+	ctxSpan, span := tracer.Start(ctxReq, "Manager.RenameTag")
+	defer span.End()
+
+	name, err := normalizeTag(name)
+	if err != nil {
+		return TagUpdate{}, err
+	}
+
+	newName, err = normalizeTag(newName)
+	if err != nil {
+		return TagUpdate{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctxSpan, 1*time.Second)
+	defer cancel()
+
+	n, err := m.store.RenameTag(ctx, userID, name, newName)
+	if err != nil {
+		recordError(span, err)
+		return TagUpdate{}, err
+	}
+
+	if n == 0 {
+		return TagUpdate{}, ErrNotFoundTag
+	}
+
+	return TagUpdate{Name: newName, Updated: n}, nil
+}
+
+// DeleteTag removes the user's tag from all URLs.
+func (m *Manager) DeleteTag(ctxReq context.Context, userID, name string) (TagUpdate, error) {
+	ctxSpan, span := tracer.Start(ctxReq, "Manager.DeleteTag")
+	defer span.End()
+
+	name, err := normalizeTag(name)
+	if err != nil {
+		return TagUpdate{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctxSpan, 1*time.Second)
+	defer cancel()
+
+	n, err := m.store.DeleteTag(ctx, userID, name)
+	if err != nil {
+		recordError(span, err)
+		return TagUpdate{}, err
+	}
+
+	if n == 0 {
+		return TagUpdate{}, ErrNotFoundTag
+	}
+
+	return TagUpdate{Name: name, Updated: n}, nil
+}
+
+// normalizeTags normalizes the tags and drops duplicates, keeping the order.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+
+	rst := make([]string, 0, len(tags))
+	for _, v := range tags {
+		name, err := normalizeTag(v)
+		if err != nil {
+			return nil, err
+		}
+
+		if !seen[name] {
+			seen[name] = true
+			rst = append(rst, name)
+		}
+	}
+
+	if len(rst) > MaxTags {
+		return nil, ErrTooManyTags
+	}
+
+	return rst, nil
+}
+
+// normalizeTag trims and lower-cases the name of the tag. The name must not be empty or contain commas,
+// which separate the tags in CSV dumps.
+func normalizeTag(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	if name == "" || utf8.RuneCountInString(name) > maxTagLength || strings.Contains(name, ",") {
+		return "", ErrInvalidTag
+	}
+
+	return name, nil
+}
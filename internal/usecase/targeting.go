@@ -0,0 +1,157 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"go-shortener-url/internal/pkg/useragent"
+	"go-shortener-url/internal/storage"
+)
+
+// MaxRules is the maximum number of the targeting rules of a URL.
+const MaxRules = 20
+
+// Values of the agent condition of the targeting rules.
+const (
+	AgentBot   = "bot"
+	AgentHuman = "human"
+)
+
+// Visitor describes the client following a shortened URL for the targeting rules.
+type Visitor struct {
+	UserAgent      string
+	AcceptLanguage string
+	// Country is the ISO 3166-1 alpha-2 code of the country of the visitor, see Manager.Country.
+	Country string
+	// Variant is the key of the variant of the split URL the visitor was assigned to before.
+	Variant string
+	// Preview is set if the visitor only views the preview page, so no click is counted.
+	Preview bool
+}
+
+// normalizeRules trims the conditions of the rules, lowercases them except the uppercase country, and checks them.
+// Every rule needs at least one condition and an absolute URL.
+func normalizeRules(rules []storage.Rule) ([]storage.Rule, error) {
+	if len(rules) > MaxRules {
+		return nil, ErrTooManyRules
+	}
+
+	rst := make([]storage.Rule, 0, len(rules))
+	for i, r := range rules {
+		r.OS = strings.ToLower(strings.TrimSpace(r.OS))
+		r.Device = strings.ToLower(strings.TrimSpace(r.Device))
+		r.Agent = strings.ToLower(strings.TrimSpace(r.Agent))
+		r.Language = strings.ToLower(strings.TrimSpace(r.Language))
+		r.Country = strings.ToUpper(strings.TrimSpace(r.Country))
+		r.URL = strings.TrimSpace(r.URL)
+
+		if err := validateRule(r); err != nil {
+			return nil, fmt.Errorf("%w: rule %d: %v", ErrInvalidRule, i+1, err)
+		}
+
+		rst = append(rst, r)
+	}
+
+	return rst, nil
+}
+
+func validateRule(r storage.Rule) error {
+	if r.OS == "" && r.Device == "" && r.Agent == "" && r.Language == "" && r.Country == "" {
+		return errors.New("no condition")
+	}
+
+	switch r.OS {
+	case "", useragent.OSIOS, useragent.OSAndroid, useragent.OSWindows, useragent.OSMacOS,
+		useragent.OSChromeOS, useragent.OSLinux, useragent.OSOther:
+	default:
+		return fmt.Errorf("unknown os %q", r.OS)
+	}
+
+	switch r.Device {
+	case "", useragent.DeviceMobile, useragent.DeviceTablet, useragent.DeviceDesktop:
+	default:
+		return fmt.Errorf("unknown device %q", r.Device)
+	}
+
+	switch r.Agent {
+	case "", AgentBot, AgentHuman:
+	default:
+		return fmt.Errorf("unknown agent %q", r.Agent)
+	}
+
+	if r.Language != "" && !isLanguageRange(r.Language) {
+		return fmt.Errorf("invalid language %q", r.Language)
+	}
+
+	if r.Country != "" && !isCountryCode(r.Country) {
+		return fmt.Errorf("invalid country %q", r.Country)
+	}
+
+	u, err := url.ParseRequestURI(r.URL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid URL %q", r.URL)
+	}
+
+	return nil
+}
+
+// isLanguageRange reports whether s is a language tag like "pt" or "zh-hant-tw".
+func isLanguageRange(s string) bool {
+	for i, sub := range strings.Split(s, "-") {
+		if len(sub) == 0 || len(sub) > 8 {
+			return false
+		}
+
+		for _, c := range sub {
+			if !(c >= 'a' && c <= 'z' || i > 0 && c >= '0' && c <= '9') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// target returns the link leading to the URL of the first rule matching the visitor and whether a rule matched,
+// the link is returned unchanged if no rule matches.
+func target(link storage.Link, v Visitor) (storage.Link, bool) {
+	if len(link.Rules) == 0 {
+		return link, false
+	}
+
+	agent := useragent.Parse(v.UserAgent)
+
+	// The rules match the most preferred language only.
+	var lang string
+	if langs := useragent.Languages(v.AcceptLanguage); len(langs) > 0 {
+		lang = langs[0]
+	}
+
+	for _, r := range link.Rules {
+		if matchRule(r, agent, lang, v.Country) {
+			link.OriginalURL = r.URL
+			return link, true
+		}
+	}
+
+	return link, false
+}
+
+func matchRule(r storage.Rule, agent useragent.Agent, lang, country string) bool {
+	switch {
+	case r.OS != "" && r.OS != agent.OS:
+		return false
+	case r.Device != "" && r.Device != agent.Device:
+		return false
+	case r.Agent == AgentBot && !agent.Bot, r.Agent == AgentHuman && agent.Bot:
+		return false
+	case r.Language != "" && !useragent.MatchLanguage(lang, r.Language):
+		return false
+	case r.Country != "" && r.Country != country:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,105 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"go-shortener-url/internal/storage"
+)
+
+// URLUpdate changes the settings of the user's URL, the nil fields are left unchanged.
+type URLUpdate struct {
+	Tags         *[]string
+	RedirectType *int
+	Passthrough  *bool
+	Rules        *[]storage.Rule
+	Variants     *[]storage.Variant
+}
+
+// UpdateURL applies the update to the user's URL and returns the updated URL.
+// The whole update is validated first and then applied at once, so either all settings are changed or none.
+func (m *Manager) UpdateURL(ctxReq context.Context, userID, id string, update URLUpdate) (UserURL, error) {
+	ctx, span := tracer.Start(ctxReq, "Manager.UpdateURL")
+	defer span.End()
+
+	if update.Tags == nil && update.RedirectType == nil && update.Passthrough == nil && update.Rules == nil &&
+		update.Variants == nil {
+		return UserURL{}, ErrEmptyUpdate
+	}
+
+	patch := storage.LinkPatch{RedirectType: update.RedirectType, Passthrough: update.Passthrough}
+
+	if update.Tags != nil {
+		tags, err := normalizeTags(*update.Tags)
+		if err != nil {
+			return UserURL{}, err
+		}
+		patch.Tags = &tags
+	}
+
+	if update.RedirectType != nil && *update.RedirectType != 0 {
+		if err := ValidateRedirectType(*update.RedirectType); err != nil {
+			return UserURL{}, err
+		}
+	}
+
+	if update.Rules != nil {
+		rules, err := normalizeRules(*update.Rules)
+		if err != nil {
+			return UserURL{}, err
+		}
+
+		if err := m.checkDestinations(rules, nil); err != nil {
+			return UserURL{}, err
+		}
+		patch.Rules = &rules
+	}
+
+	if update.Variants != nil {
+		variants, err := normalizeVariants(*update.Variants)
+		if err != nil {
+			return UserURL{}, err
+		}
+
+		if err := m.checkDestinations(nil, variants); err != nil {
+			return UserURL{}, err
+		}
+		patch.Variants = &variants
+	}
+
+	var rules []storage.Rule
+	if patch.Rules != nil {
+		rules = *patch.Rules
+	}
+
+	var variants []storage.Variant
+	if patch.Variants != nil {
+		variants = *patch.Variants
+	}
+
+	if err := m.checkThreats(ctx, span, "", rules, variants); err != nil {
+		return UserURL{}, err
+	}
+
+	return m.update(ctx, span, userID, id, patch)
+}
+
+// update applies the patch to the URL of the user, or of any owner if userID is empty, and returns the updated URL.
+func (m *Manager) update(ctxSpan context.Context, span trace.Span, userID, id string, patch storage.LinkPatch) (UserURL, error) {
+	ctx, cancel := context.WithTimeout(ctxSpan, 1*time.Second)
+	defer cancel()
+
+	link, err := m.store.Update(ctx, userID, fmt.Sprintf("%s/%s", m.baseURL, id), patch)
+	if errors.Is(err, storage.ErrNotFoundURL) {
+		return UserURL{}, ErrNotFoundURL
+	} else if err != nil {
+		recordError(span, err)
+		return UserURL{}, err
+	}
+
+	return userURL(link), nil
+}
@@ -0,0 +1,129 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+
+	"go-shortener-url/internal/storage"
+)
+
+// CodeMaliciousURL is the code of the destination URLs flagged by the URL checker.
+const CodeMaliciousURL = "malicious_url"
+
+// URLChecker looks up the destination URLs in the lists of unsafe URLs, the threat is the type
+// of the threat the URL is flagged for, such as "phishing", empty if it is safe.
+type URLChecker interface {
+	Check(ctx context.Context, rawURL string) (threat string, err error)
+}
+
+// SetURLChecker sets the checker of the destination URLs of the new URLs, the targeting rules and the variants.
+// The URLs are not checked if it is nil.
+func (m *Manager) SetURLChecker(checker URLChecker) {
+	m.urlChecker = checker
+}
+
+// SetRecheckOnRedirect enables checking the destination again on every redirect,
+// the URLs flagged after their creation are quarantined.
+func (m *Manager) SetRecheckOnRedirect(recheck bool) {
+	m.recheckOnRedirect = recheck
+}
+
+// checkThreat returns the threat of the URL, empty if it is safe or there is no checker. The URLs are not
+// rejected if the checker fails, the error is only recorded.
+func (m *Manager) checkThreat(ctx context.Context, span trace.Span, rawURL string) string {
+	if m.urlChecker == nil {
+		return ""
+	}
+
+	threat, err := m.urlChecker.Check(ctx, rawURL)
+	if err != nil {
+		slog.Error(fmt.Sprintf("internal.usecase.checkThreat: %v", err))
+		span.RecordError(fmt.Errorf("URLChecker.Check: %w", err))
+		return ""
+	}
+
+	return threat
+}
+
+// checkThreats rejects the destination URLs flagged by the checker.
+func (m *Manager) checkThreats(ctx context.Context, span trace.Span, originalURL string, rules []storage.Rule, variants []storage.Variant) error {
+	if m.urlChecker == nil {
+		return nil
+	}
+
+	if originalURL != "" {
+		if threat := m.checkThreat(ctx, span, originalURL); threat != "" {
+			return threatError(threat)
+		}
+	}
+
+	for i, r := range rules {
+		if threat := m.checkThreat(ctx, span, r.URL); threat != "" {
+			return withPrefix(threatError(threat), fmt.Sprintf("rule %d", i+1))
+		}
+	}
+
+	for i, v := range variants {
+		if threat := m.checkThreat(ctx, span, v.URL); threat != "" {
+			return withPrefix(threatError(threat), fmt.Sprintf("variant %d", i+1))
+		}
+	}
+
+	return nil
+}
+
+func threatError(threat string) error {
+	return &PolicyError{Code: CodeMaliciousURL, Reason: fmt.Sprintf("URL is flagged as %s", threat)}
+}
+
+// recheck checks the destination of the redirect again if enabled and quarantines the URL if it is flagged.
+// It returns the threat, empty if the destination is safe.
+func (m *Manager) recheck(ctx context.Context, span trace.Span, shortURL, destination string) string {
+	if !m.recheckOnRedirect {
+		return ""
+	}
+
+	threat := m.checkThreat(ctx, span, destination)
+	if threat == "" {
+		return ""
+	}
+
+	span.SetAttributes(attribute.String("url.threat", threat))
+	if _, err := m.store.Update(ctx, "", shortURL, storage.LinkPatch{Threat: &threat}); err != nil {
+		span.RecordError(fmt.Errorf("Update: %w", err))
+	}
+
+	return threat
+}
+
+// MaxThreatLength is the maximum length of the threat of a quarantined URL.
+const MaxThreatLength = 64
+
+// QuarantineURL quarantines the URL for the threat, so the redirects to it show the warning page instead.
+// It is used by the administrators and does not depend on the owner of the URL.
+func (m *Manager) QuarantineURL(ctxReq context.Context, id, threat string) (UserURL, error) {
+	threat = strings.ToLower(strings.TrimSpace(threat))
+	if threat == "" || utf8.RuneCountInString(threat) > MaxThreatLength {
+		return UserURL{}, ErrInvalidThreat
+	}
+
+	return m.setQuarantine(ctxReq, "Manager.QuarantineURL", id, threat)
+}
+
+// ReleaseURL releases the quarantined URL, so it is redirected again.
+func (m *Manager) ReleaseURL(ctxReq context.Context, id string) (UserURL, error) {
+	return m.setQuarantine(ctxReq, "Manager.ReleaseURL", id, "")
+}
+
+func (m *Manager) setQuarantine(ctxReq context.Context, name, id, threat string) (UserURL, error) {
+	ctxSpan, span := tracer.Start(ctxReq, name)
+	defer span.End()
+
+	return m.update(ctxSpan, span, "", id, storage.LinkPatch{Threat: &threat})
+}
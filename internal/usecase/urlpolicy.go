@@ -0,0 +1,215 @@
+package usecase
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"go-shortener-url/internal/storage"
+)
+
+// DefaultMaxURLLength is the maximum length of the destination URLs, unless set by the policy.
+const DefaultMaxURLLength = 2048
+
+// DefaultSchemes are the allowed schemes of the destination URLs, unless set by the policy.
+var DefaultSchemes = []string{"http", "https"}
+
+// Codes of the destination URLs rejected by the policy.
+const (
+	CodeURLTooLong       = "url_too_long"
+	CodeSchemeNotAllowed = "scheme_not_allowed"
+	CodeMissingHost      = "missing_host"
+	CodeSelfReference    = "self_reference"
+	CodePrivateAddress   = "private_address"
+	CodeDomainDenied     = "domain_denied"
+	CodeDomainNotAllowed = "domain_not_allowed"
+)
+
+// PolicyError is returned for the destination URLs rejected by the policy, Code tells the reason to the clients.
+// It matches ErrUnsafeURL.
+type PolicyError struct {
+	Code   string
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Reason
+}
+
+// Is reports whether the target is ErrUnsafeURL.
+func (e *PolicyError) Is(target error) bool {
+	return target == ErrUnsafeURL
+}
+
+// DomainList decides on the hosts of the destination URLs.
+type DomainList interface {
+	// Denied reports whether the host is denied.
+	Denied(host string) bool
+	// Allowed reports whether the host is allowed, all hosts are allowed by an empty allow list.
+	Allowed(host string) bool
+}
+
+// URLPolicy restricts the destinations of the shortened URLs, the targeting rules and the variants.
+// The URLs leading back to the service and to the private, loopback and link-local IP addresses
+// are always rejected.
+type URLPolicy struct {
+	// Schemes are the allowed schemes, DefaultSchemes if empty.
+	Schemes []string
+	// MaxLength is the maximum length of the URLs in bytes, DefaultMaxURLLength if zero.
+	MaxLength int
+	// Domains are the allow and deny lists of the hosts, all hosts are allowed if it is nil.
+	Domains DomainList
+}
+
+// Validate checks the schemes and the maximum length of the policy.
+func (p URLPolicy) Validate() error {
+	if p.MaxLength < 0 {
+		return fmt.Errorf("%w: negative maximum length %d", ErrInvalidURLPolicy, p.MaxLength)
+	}
+
+	for _, s := range p.Schemes {
+		if !isScheme(strings.ToLower(strings.TrimSpace(s))) {
+			return fmt.Errorf("%w: invalid scheme %q", ErrInvalidURLPolicy, s)
+		}
+	}
+
+	return nil
+}
+
+// SetURLPolicy sets the policy of the destination URLs, the policy is expected to be valid.
+func (m *Manager) SetURLPolicy(policy URLPolicy) {
+	if policy.MaxLength == 0 {
+		policy.MaxLength = DefaultMaxURLLength
+	}
+
+	schemes := make([]string, 0, len(policy.Schemes))
+	for _, s := range policy.Schemes {
+		if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+			schemes = append(schemes, s)
+		}
+	}
+	if len(schemes) == 0 {
+		schemes = DefaultSchemes
+	}
+	policy.Schemes = schemes
+
+	m.urlPolicy = policy
+}
+
+// checkURL checks the destination URL against the policy, the URL is expected to be parsable.
+func (m *Manager) checkURL(rawURL string) error {
+	p := m.urlPolicy
+
+	if len(rawURL) > p.MaxLength {
+		return &PolicyError{Code: CodeURLTooLong, Reason: fmt.Sprintf("URL is longer than %d bytes", p.MaxLength)}
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if !contains(p.Schemes, scheme) {
+		return &PolicyError{Code: CodeSchemeNotAllowed, Reason: fmt.Sprintf("scheme %q is not allowed", scheme)}
+	}
+
+	// The URLs of the opaque schemes, such as mailto, have no host.
+	if u.Opaque != "" {
+		return nil
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return &PolicyError{Code: CodeMissingHost, Reason: "URL has no host"}
+	}
+
+	if base, err := url.Parse(m.baseURL); err == nil && host == strings.TrimSuffix(strings.ToLower(base.Hostname()), ".") {
+		return &PolicyError{Code: CodeSelfReference, Reason: "URL leads back to the service"}
+	}
+
+	if isPrivateHost(host) {
+		return &PolicyError{Code: CodePrivateAddress, Reason: fmt.Sprintf("host %q is a private or loopback address", host)}
+	}
+
+	if p.Domains != nil {
+		if p.Domains.Denied(host) {
+			return &PolicyError{Code: CodeDomainDenied, Reason: fmt.Sprintf("domain %q is denied", host)}
+		}
+
+		if !p.Domains.Allowed(host) {
+			return &PolicyError{Code: CodeDomainNotAllowed, Reason: fmt.Sprintf("domain %q is not allowed", host)}
+		}
+	}
+
+	return nil
+}
+
+// checkDestinations checks the URLs of the targeting rules and the variants against the policy.
+func (m *Manager) checkDestinations(rules []storage.Rule, variants []storage.Variant) error {
+	for i, r := range rules {
+		if err := m.checkURL(r.URL); err != nil {
+			return withPrefix(err, fmt.Sprintf("rule %d", i+1))
+		}
+	}
+
+	for i, v := range variants {
+		if err := m.checkURL(v.URL); err != nil {
+			return withPrefix(err, fmt.Sprintf("variant %d", i+1))
+		}
+	}
+
+	return nil
+}
+
+// withPrefix adds the prefix to the reason of the policy error, keeping its code.
+func withPrefix(err error, prefix string) error {
+	if pe, ok := err.(*PolicyError); ok {
+		return &PolicyError{Code: pe.Code, Reason: prefix + ": " + pe.Reason}
+	}
+
+	return fmt.Errorf("%s: %w", prefix, err)
+}
+
+// isPrivateHost reports whether the host is localhost or an IP literal of a private, loopback,
+// link-local or unspecified address. The IPv4 addresses mapped to IPv6 are checked as IPv4.
+func isPrivateHost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	return addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsUnspecified()
+}
+
+// isScheme reports whether s is a URL scheme as defined by RFC 3986.
+func isScheme(s string) bool {
+	if s == "" || !(s[0] >= 'a' && s[0] <= 'z') {
+		return false
+	}
+
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -7,19 +7,42 @@ import (
 	"fmt"
 	"go-shortener-url/internal/pkg/deleteurl"
 	"net/url"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/exp/slog"
 
+	"go-shortener-url/internal/pkg/purge"
 	"go-shortener-url/internal/pkg/shortener"
 	"go-shortener-url/internal/storage"
 )
 
+var tracer = otel.Tracer("go-shortener-url/internal/usecase")
+
 // Manager is designed to manage all business logic of the service.
 type Manager struct {
 	store       storage.Storage
 	deleterURLs deleteurl.DeleterURLs
 	baseURL     string
+
+	restorePeriod time.Duration
+	purger        purge.Purger
+	interstitial  InterstitialPolicy
+	redirectType  int
+	queryConflict string
+	geo           GeoLocator
+	urlPolicy     URLPolicy
+
+	urlChecker        URLChecker
+	recheckOnRedirect bool
+	rateLimiter       storage.RateLimiter
+	defaultQuota      int
+
+	shuttingDown atomic.Bool
 }
 
 // New is the constructor for the Manager structure.
@@ -28,110 +51,379 @@ func New(store storage.Storage, deleter deleteurl.DeleterURLs, baseURL string) *
 		store:       store,
 		deleterURLs: deleter,
 		baseURL:     baseURL,
+
+		restorePeriod: DefaultRestorePeriod,
+		redirectType:  DefaultRedirectType,
+		queryConflict: DefaultQueryConflict,
+		urlPolicy:     URLPolicy{Schemes: DefaultSchemes, MaxLength: DefaultMaxURLLength},
+		rateLimiter:   storage.NewMemRateLimiter(),
 	}
 }
 
+// LinkOptions are the optional settings of a new URL.
+type LinkOptions struct {
+	Tags []string
+	// RedirectType is the HTTP status of the redirect, zero for the default of the service.
+	RedirectType int
+	// Passthrough merges the query and the trailing path of the request into the original URL.
+	Passthrough bool
+	// UTM are added to the query of the original URL before it is shortened.
+	UTM storage.UTM
+	// Rules are the ordered targeting rules, the original URL is the fallback.
+	Rules []storage.Rule
+	// Variants split the visitors between several destinations by weight instead of the original URL.
+	Variants []storage.Variant
+}
+
 // CreateShortURL shortens the original URL and writes to the data store.
-func (m *Manager) CreateShortURL(ctxReq context.Context, originalURL, userID string) (string, error) {
+// The options are applied to the new URL, the settings of an existing one are not changed.
+// The UTM parameters are added to the original URL first, so the URLs are deduplicated with them.
+// The original URL and the destinations of the targeting rules and the variants must pass the URL policy
+// and must not be flagged by the URL checker. The new URL must fit in the quota of the user.
+func (m *Manager) CreateShortURL(ctxReq context.Context, originalURL, userID string, opts LinkOptions) (string, error) {
+	ctxSpan, span := tracer.Start(ctxReq, "Manager.CreateShortURL")
+	defer span.End()
+
+	link, err := m.newLink(ctxSpan, span, originalURL, userID, opts)
+	if err != nil {
+		return "", err
+	}
+
+	span.SetAttributes(attribute.String("url.short", link.ShortURL))
+
+	return m.createLink(ctxSpan, span, link)
+}
+
+// NewURL is the original URL of the batch with the options of its new URL.
+type NewURL struct {
+	OriginalURL string
+	Options     LinkOptions
+}
+
+// CreateShortURLs shortens the batch of the original URLs as CreateShortURL does and returns
+// the shortened URLs in the same order. All the URLs are validated and checked against the quota
+// of the user before any of them is written, so an invalid URL rejects the whole batch.
+func (m *Manager) CreateShortURLs(ctxReq context.Context, userID string, urls []NewURL) ([]string, error) {
+	ctxSpan, span := tracer.Start(ctxReq, "Manager.CreateShortURLs",
+		trace.WithAttributes(attribute.Int("links.count", len(urls))),
+	)
+	defer span.End()
+
+	links := make([]storage.Link, 0, len(urls))
+	for _, v := range urls {
+		link, err := m.newLink(ctxSpan, span, v.OriginalURL, userID, v.Options)
+		if err != nil {
+			return nil, err
+		}
+
+		links = append(links, link)
+	}
+
+	ctx, cancel := context.WithTimeout(ctxSpan, 1*time.Second)
+	defer cancel()
+
+	if err := m.checkQuota(ctx, span, userID, func() (int, error) { return len(links), nil }); err != nil {
+		return nil, err
+	}
+
+	shortURLs := make([]string, 0, len(links))
+	for _, link := range links {
+		shortURL, err := m.createLink(ctxSpan, span, link)
+		if err != nil {
+			return nil, err
+		}
+
+		shortURLs = append(shortURLs, shortURL)
+	}
+
+	return shortURLs, nil
+}
+
+// newLink validates the original URL with the options and returns the new link of the user.
+func (m *Manager) newLink(ctx context.Context, span trace.Span, originalURL, userID string, opts LinkOptions) (storage.Link, error) {
 	const op = "internal.usecase.CreateShortURL"
 
 	if originalURL == "" {
-		return "", ErrNotFoundURL
+		return storage.Link{}, ErrNotFoundURL
 	}
 
 	if _, err := url.ParseRequestURI(originalURL); err != nil {
 		slog.Error(fmt.Sprintf("%s.ParseRequestURI: %v\n", op, err))
-		return "", err
+		return storage.Link{}, err
+	}
+
+	tags, err := normalizeTags(opts.Tags)
+	if err != nil {
+		return storage.Link{}, err
+	}
+
+	if opts.RedirectType != 0 {
+		if err := ValidateRedirectType(opts.RedirectType); err != nil {
+			return storage.Link{}, err
+		}
+	}
+
+	utm, err := normalizeUTM(opts.UTM)
+	if err != nil {
+		return storage.Link{}, err
+	}
+
+	rules, err := normalizeRules(opts.Rules)
+	if err != nil {
+		return storage.Link{}, err
+	}
+
+	variants, err := normalizeVariants(opts.Variants)
+	if err != nil {
+		return storage.Link{}, err
+	}
+
+	if !utm.IsZero() {
+		if originalURL, err = applyUTM(originalURL, utm); err != nil {
+			slog.Error(fmt.Sprintf("%s.applyUTM: %v\n", op, err))
+			return storage.Link{}, err
+		}
+	}
+
+	if err := m.checkURL(originalURL); err != nil {
+		return storage.Link{}, err
+	}
+
+	if err := m.checkDestinations(rules, variants); err != nil {
+		return storage.Link{}, err
+	}
+
+	if err := m.checkThreats(ctx, span, originalURL, rules, variants); err != nil {
+		return storage.Link{}, err
 	}
 
 	id, err := shortener.ShortenURL(originalURL)
 	if err != nil {
 		slog.Error(fmt.Sprintf("%s.shortenURL: %v\n", op, err))
-		return "", err
+		recordError(span, err)
+		return storage.Link{}, err
 	}
 
-	shortURL := fmt.Sprintf("%s/%s", m.baseURL, id)
+	return storage.Link{
+		UserID:       userID,
+		ShortURL:     fmt.Sprintf("%s/%s", m.baseURL, id),
+		OriginalURL:  originalURL,
+		Tags:         tags,
+		RedirectType: opts.RedirectType,
+		Passthrough:  opts.Passthrough,
+		UTM:          utm,
+		Rules:        rules,
+		Variants:     variants,
+	}, nil
+}
 
-	ctx, cancel := context.WithTimeout(ctxReq, 1*time.Second)
+// createLink writes the new link and returns its shortened URL, which is also returned with ErrUniqueValue
+// for the existing URL.
+func (m *Manager) createLink(ctxSpan context.Context, span trace.Span, link storage.Link) (string, error) {
+	const op = "internal.usecase.CreateShortURL"
+
+	ctx, cancel := context.WithTimeout(ctxSpan, 1*time.Second)
 	defer cancel()
 
-	err = m.store.Add(ctx, userID, shortURL, originalURL)
+	// The link is stored with all its options at once, so a failure does not leave it half-configured.
+	// The quota is checked by the same write, so the concurrent requests of the user cannot exceed it,
+	// and the existing URL is reported before the exceeded quota.
+	err := m.store.Create(ctx, link, m.defaultQuota)
 	if err != nil {
 		if errors.Is(err, storage.ErrUniqueValue) {
-			return shortURL, ErrUniqueValue
+			return link.ShortURL, ErrUniqueValue
+		}
+
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			return "", m.quotaExceeded(ctx, span, link.UserID)
 		}
 
 		slog.Error(fmt.Sprintf("%s: %v\n", op, err))
+		recordError(span, err)
 		return "", err
 	}
 
-	return shortURL, nil
+	return link.ShortURL, nil
 }
 
-// GetFullURL from a shortened URL queries the original URL in the data store.
-func (m *Manager) GetFullURL(ctxReq context.Context, shortURL string) (string, error) {
-	ctx, cancel := context.WithTimeout(ctxReq, 1*time.Second)
+// GetFullURL from a shortened URL queries the original URL in the data store
+// and the HTTP status of the redirect to it. The first targeting rule of the URL matching the visitor
+// replaces the original URL, otherwise the visitor is sent to a variant of the split URL and the click
+// is counted. Then the passthrough is applied if it is enabled for the URL. The redirects of the quarantined
+// URLs, including the URLs flagged by the recheck on redirect, return the threat and are not counted.
+func (m *Manager) GetFullURL(ctxReq context.Context, shortURL string, pass Passthrough, visitor Visitor) (Redirect, error) {
+	ctxSpan, span := tracer.Start(ctxReq, "Manager.GetFullURL")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctxSpan, 1*time.Second)
 	defer cancel()
 
 	searchURL := fmt.Sprintf("%s/%s", m.baseURL, shortURL)
+	span.SetAttributes(attribute.String("url.short", searchURL))
 
-	originalURL, err := m.store.Get(ctx, searchURL)
+	link, err := m.store.Get(ctx, searchURL)
 	if err != nil {
 		if errors.Is(err, storage.ErrDeletedURL) {
-			return "", ErrDeletedURL
+			return Redirect{}, ErrDeletedURL
 		}
 
-		return "", err
+		if !errors.Is(err, storage.ErrNotFoundURL) {
+			recordError(span, err)
+		}
+		return Redirect{}, err
 	}
 
-	return originalURL, nil
-}
+	if link.Threat != "" {
+		return Redirect{URL: link.OriginalURL, Threat: link.Threat}, nil
+	}
 
-// GetUserURLs queries the data store to retrieve all shortened URLs by user.
-func (m *Manager) GetUserURLs(ctxReq context.Context, userID string) (map[string]string, error) {
-	ctx, cancel := context.WithTimeout(ctxReq, 1*time.Second)
-	defer cancel()
+	targeted := len(link.Rules) > 0
+	link, matched := target(link, visitor)
+	link, variant, key := split(link, matched, visitor.Variant)
 
-	urls, err := m.store.GetByUser(ctx, userID)
+	to, err := m.destination(link, pass)
 	if err != nil {
-		return nil, err
+		return Redirect{}, err
+	}
+
+	if threat := m.recheck(ctx, span, searchURL, to); threat != "" {
+		return Redirect{URL: to, Threat: threat}, nil
+	}
+
+	if variant >= 0 {
+		m.countClick(ctx, span, searchURL, variant)
 	}
 
-	return urls, nil
+	return Redirect{URL: to, Status: m.redirectStatus(link), Targeted: targeted, Variant: key}, nil
 }
 
 // CheckStorage checks the availability of the data storage.
 func (m *Manager) CheckStorage(ctxReq context.Context) error {
-	ctx, cancel := context.WithTimeout(ctxReq, 1*time.Second)
+	ctxSpan, span := tracer.Start(ctxReq, "Manager.CheckStorage")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctxSpan, 1*time.Second)
 	defer cancel()
 
-	return m.store.CheckStorage(ctx)
-}
+	err := m.store.CheckStorage(ctx)
+	if err != nil {
+		recordError(span, err)
+	}
 
-// ExecDeleting in multiple threads marks shortened URLs as deleted.
-func (m *Manager) ExecDeleting(items []string, userID string) {
+	return err
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+// ScheduleDeleting registers a deletion operation and queues its jobs, the URLs are marked as deleted
+// by the URL removal service in the background. The jobs are persisted before the identifier
+// of the operation is returned, so the deletion is not lost if the service stops.
+func (m *Manager) ScheduleDeleting(ctxTrace context.Context, items []string, userID string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctxTrace, 1*time.Second)
 	defer cancel()
 
-	urls, err := m.store.GetByUser(ctx, userID)
+	opID, err := m.deleterURLs.Begin(ctx, userID, len(items))
 	if err != nil {
-		slog.Error("usecase.ExecDeleting.GetByUser", err.Error())
-		return
-	} else if len(urls) == 0 {
-		slog.Error("usecase.ExecDeleting.GetByUser: empty arr URLs")
-		return
+		return "", err
+	}
+
+	if err := m.ExecDeleting(ctxTrace, opID, items, userID); err != nil {
+		return "", err
+	}
+
+	return opID, nil
+}
+
+// ExecDeleting queues the jobs marking shortened URLs as deleted.
+// Identifiers of URLs that the user does not own are skipped and recorded in the operation,
+// the operation is not tracked if opID is empty.
+func (m *Manager) ExecDeleting(ctxTrace context.Context, opID string, items []string, userID string) error {
+	ctxSpan, span := tracer.Start(ctxTrace, "Manager.ExecDeleting",
+		trace.WithAttributes(
+			attribute.String("operation.id", opID),
+			attribute.Int("items", len(items)),
+		),
+	)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctxSpan, time.Second)
+	defer cancel()
+
+	links, err := m.store.GetByUser(ctx, userID)
+	if err != nil && !errors.Is(err, storage.ErrNotFoundURL) {
+		slog.Error("usecase.ExecDeleting.GetByUser", "err", err)
+		recordError(span, err)
+		m.abortDeleting(ctx, opID, err)
+		return err
+	}
+
+	owned := make(map[string]bool, len(links))
+	for _, link := range links {
+		owned[link.ShortURL] = true
 	}
 
 	shortURLs := make([]string, 0, len(items))
+	skipped := make([]string, 0)
 
 	for _, item := range items {
 		shortURL := fmt.Sprintf("%s/%s", m.baseURL, item)
 
-		if _, ok := urls[shortURL]; ok {
+		if owned[shortURL] {
 			shortURLs = append(shortURLs, shortURL)
+		} else {
+			skipped = append(skipped, item)
 		}
 	}
 
-	m.deleterURLs.Delete(shortURLs, userID)
+	if err := m.deleterURLs.Delete(ctxSpan, opID, userID, shortURLs, skipped); err != nil {
+		slog.Error("usecase.ExecDeleting.Delete", "err", err)
+		recordError(span, err)
+		m.abortDeleting(ctx, opID, err)
+		return err
+	}
+
+	return nil
+}
+
+// abortDeleting marks the tracked operation as failed, so it does not stay pending.
+func (m *Manager) abortDeleting(ctx context.Context, opID string, reason error) {
+	if opID == "" {
+		return
+	}
+
+	if err := m.deleterURLs.Abort(ctx, opID, reason); err != nil {
+		slog.Error("usecase.ExecDeleting.Abort", "err", err)
+	}
+}
+
+// GetDeleteOperation returns the deletion operation of the user.
+func (m *Manager) GetDeleteOperation(ctxReq context.Context, opID, userID string) (storage.DeleteOperation, error) {
+	ctx, cancel := context.WithTimeout(ctxReq, 1*time.Second)
+	defer cancel()
+
+	op, err := m.deleterURLs.Operation(ctx, opID)
+	if errors.Is(err, storage.ErrNotFoundOperation) || (err == nil && op.UserID != userID) {
+		return storage.DeleteOperation{}, ErrNotFoundOperation
+	} else if err != nil {
+		return storage.DeleteOperation{}, err
+	}
+
+	return op, nil
+}
+
+// DeleteJobs lists up to limit deletion jobs in the given state.
+func (m *Manager) DeleteJobs(ctxReq context.Context, state string, limit int) ([]storage.DeleteJob, error) {
+	ctx, cancel := context.WithTimeout(ctxReq, 1*time.Second)
+	defer cancel()
+
+	switch state {
+	case storage.JobPending, storage.JobDone, storage.JobDead:
+	default:
+		return nil, ErrInvalidState
+	}
+
+	return m.deleterURLs.Jobs(ctx, state, limit)
+}
+
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
 }
@@ -4,14 +4,17 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"go-shortener-url/internal/pkg/deleteurl"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"go-shortener-url/internal/pkg/deleteurl"
 	"go-shortener-url/internal/pkg/shortener"
 	"go-shortener-url/internal/storage"
 	"go-shortener-url/internal/usecase"
@@ -41,7 +44,7 @@ func TestExecDeleting(t *testing.T) {
 	store := storage.NewMemStorage()
 	baseURL := "http://localhost:8080"
 
-	deleter := deleteurl.InitUrlDeleteService(store)
+	deleter := deleteurl.InitUrlDeleteService(store, nil, deleteurl.Config{})
 	deleter.Run(1)
 	defer deleter.Stop()
 
@@ -65,26 +68,173 @@ func TestExecDeleting(t *testing.T) {
 	}
 
 	for _, b := range basics {
-		err := store.Add(context.Background(), b.userID, b.shortURL, b.fullURL)
+		err := store.Put(context.Background(),
+			storage.Link{UserID: b.userID, ShortURL: b.shortURL, OriginalURL: b.fullURL}, false)
 		require.NoError(t, err)
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			manager.ExecDeleting(tt.items, tt.userID)
-
-			time.Sleep(1 * time.Millisecond)
+			manager.ExecDeleting(context.Background(), "", tt.items, tt.userID)
 
 			for _, i := range tt.items {
 				shortURL := fmt.Sprintf("%s/%s", baseURL, i)
-				v, err := store.Get(context.Background(), shortURL)
-				assert.Equal(t, tt.want.value, v)
-				assert.Equal(t, tt.want.err, err)
+				assert.Eventually(t, func() bool {
+					v, err := store.Get(context.Background(), shortURL)
+					return v.OriginalURL == tt.want.value && assert.ObjectsAreEqual(tt.want.err, err)
+				}, time.Second, time.Millisecond)
 			}
 		})
 	}
 }
 
+func TestScheduleDeleting(t *testing.T) {
+	store := storage.NewMemStorage()
+	baseURL := "http://localhost:8080"
+	path := filepath.Join(t.TempDir(), "jobs.log")
+
+	queue, err := storage.NewJournalQueue(path)
+	require.NoError(t, err)
+	defer queue.Close()
+
+	// The workers are not started, so the jobs stay in the queue as if the service stopped after the response.
+	deleter := deleteurl.InitUrlDeleteService(store, queue, deleteurl.Config{})
+	manager := usecase.New(store, deleter, baseURL)
+
+	require.NoError(t, store.Put(context.Background(),
+		storage.Link{UserID: "1", ShortURL: baseURL + "/a", OriginalURL: "https://example.com/a"}, false))
+
+	opID, err := manager.ScheduleDeleting(context.Background(), []string{"a", "b"}, "1")
+	require.NoError(t, err)
+
+	restored, err := storage.NewJournalQueue(path)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	jobs, err := restored.DeleteJobs(context.Background(), storage.JobPending, 10)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, baseURL+"/a", jobs[0].ShortURL)
+
+	op, err := restored.GetDeleteOperation(context.Background(), opID)
+	require.NoError(t, err)
+	assert.Equal(t, storage.OperationPending, op.Status)
+	assert.Equal(t, []string{"b"}, op.Skipped)
+}
+
+type failingQueue struct {
+	*storage.JournalQueue
+}
+
+func (q failingQueue) EnqueueDeleteJobs(context.Context, []storage.DeleteJob) error {
+	return errors.New("queue is unavailable")
+}
+
+func TestExecDeletingAbort(t *testing.T) {
+	store := storage.NewMemStorage()
+	baseURL := "http://localhost:8080"
+
+	queue, err := storage.NewJournalQueue("")
+	require.NoError(t, err)
+
+	deleter := deleteurl.InitUrlDeleteService(store, failingQueue{queue}, deleteurl.Config{})
+	manager := usecase.New(store, deleter, baseURL)
+
+	require.NoError(t, store.Put(context.Background(),
+		storage.Link{UserID: "1", ShortURL: baseURL + "/a", OriginalURL: "https://example.com/a"}, false))
+
+	opID, err := deleter.Begin(context.Background(), "1", 1)
+	require.NoError(t, err)
+
+	err = manager.ExecDeleting(context.Background(), opID, []string{"a"}, "1")
+	require.Error(t, err)
+
+	op, err := manager.GetDeleteOperation(context.Background(), opID, "1")
+	require.NoError(t, err)
+	assert.Equal(t, storage.OperationFailed, op.Status)
+	assert.Equal(t, "queue is unavailable", op.Error)
+
+	_, err = manager.ScheduleDeleting(context.Background(), []string{"a"}, "1")
+	assert.Error(t, err)
+}
+
+type failingStore struct {
+	*storage.MemStorage
+	failures int
+}
+
+func (s *failingStore) Create(ctx context.Context, link storage.Link, defaultQuota int) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("storage is unavailable")
+	}
+
+	return s.MemStorage.Create(ctx, link, defaultQuota)
+}
+
+func TestCreateShortURLAtomic(t *testing.T) {
+	store := &failingStore{MemStorage: storage.NewMemStorage(), failures: 1}
+	manager := usecase.New(store, nil, "http://localhost:8080")
+
+	opts := usecase.LinkOptions{Tags: []string{"promo"}, RedirectType: 301, Passthrough: true}
+
+	// A failed write leaves nothing behind, so the retry creates the URL with all its options.
+	_, err := manager.CreateShortURL(context.Background(), "https://example.com/a", "1", opts)
+	require.Error(t, err)
+
+	shortURL, err := manager.CreateShortURL(context.Background(), "https://example.com/a", "1", opts)
+	require.NoError(t, err)
+
+	link, err := store.Get(context.Background(), shortURL)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"promo"}, link.Tags)
+	assert.Equal(t, 301, link.RedirectType)
+	assert.True(t, link.Passthrough)
+
+	_, err = manager.CreateShortURL(context.Background(), "https://example.com/a", "1", opts)
+	assert.ErrorIs(t, err, usecase.ErrUniqueValue)
+}
+
+func TestCreateShortURLQuota(t *testing.T) {
+	store := storage.NewMemStorage()
+	manager := usecase.New(store, nil, "http://localhost:8080")
+	manager.SetDefaultQuota(3)
+
+	// The concurrent requests of the user cannot hold more links than the quota.
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = manager.CreateShortURL(context.Background(), fmt.Sprintf("https://example.com/%d", i), "1",
+				usecase.LinkOptions{})
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		if err == nil {
+			created++
+			continue
+		}
+
+		var quotaErr *usecase.QuotaError
+		require.ErrorAs(t, err, &quotaErr)
+		assert.Equal(t, usecase.QuotaError{Quota: 3, Used: 3}, *quotaErr)
+	}
+	assert.Equal(t, 3, created)
+
+	links, err := store.GetByUser(context.Background(), "1")
+	require.NoError(t, err)
+	require.Len(t, links, 3)
+
+	// The existing URL is reported even though the quota is exhausted.
+	_, err = manager.CreateShortURL(context.Background(), links[0].OriginalURL, "1", usecase.LinkOptions{})
+	assert.ErrorIs(t, err, usecase.ErrUniqueValue)
+}
+
 func BenchmarkExecDeleting(b *testing.B) {
 	type test struct {
 		userID string
@@ -94,7 +244,7 @@ func BenchmarkExecDeleting(b *testing.B) {
 	store := storage.NewMemStorage()
 	baseURL := "http://localhost:8080"
 
-	deleter := deleteurl.InitUrlDeleteService(store)
+	deleter := deleteurl.InitUrlDeleteService(store, nil, deleteurl.Config{})
 	deleter.Run(1)
 	defer deleter.Stop()
 
@@ -111,7 +261,8 @@ func BenchmarkExecDeleting(b *testing.B) {
 		if err != nil {
 			continue
 		}
-		_ = store.Add(context.Background(), userID, fmt.Sprintf("%s/%s", baseURL, shortURL), fullURL)
+		_ = store.Put(context.Background(),
+			storage.Link{UserID: userID, ShortURL: fmt.Sprintf("%s/%s", baseURL, shortURL), OriginalURL: fullURL}, false)
 
 		tests := []test{
 			{
@@ -122,7 +273,7 @@ func BenchmarkExecDeleting(b *testing.B) {
 
 		b.StartTimer()
 		for _, v := range tests {
-			manager.ExecDeleting(v.items, v.userID)
+			manager.ExecDeleting(context.Background(), "", v.items, v.userID)
 		}
 	}
 }
@@ -134,3 +285,34 @@ func generateSecureToken(length int) string {
 	}
 	return hex.EncodeToString(b)
 }
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		rate    string
+		burst   int
+		want    storage.RateLimit
+		wantErr bool
+	}{
+		{rate: "", want: storage.RateLimit{}},
+		{rate: "30/m", want: storage.RateLimit{Rate: 0.5, Burst: 30}},
+		{rate: " 20 / s ", burst: 50, want: storage.RateLimit{Rate: 20, Burst: 50}},
+		{rate: "0.5/s", want: storage.RateLimit{Rate: 0.5, Burst: 1}},
+		{rate: "3600/h", burst: 10, want: storage.RateLimit{Rate: 1, Burst: 10}},
+		{rate: "30", wantErr: true},
+		{rate: "30/d", wantErr: true},
+		{rate: "0/s", wantErr: true},
+		{rate: "x/s", wantErr: true},
+		{rate: "10/s", burst: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := usecase.ParseRateLimit(tt.rate, tt.burst)
+		if tt.wantErr {
+			assert.ErrorIs(t, err, usecase.ErrInvalidRateLimit, tt.rate)
+			continue
+		}
+
+		require.NoError(t, err, tt.rate)
+		assert.Equal(t, tt.want, got, tt.rate)
+	}
+}
@@ -0,0 +1,68 @@
+package usecase
+
+import (
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"go-shortener-url/internal/storage"
+)
+
+// MaxUTMLength is the maximum number of characters of a UTM component.
+const MaxUTMLength = 256
+
+// normalizeUTM trims the spaces around the UTM components and checks their length.
+func normalizeUTM(utm storage.UTM) (storage.UTM, error) {
+	for _, v := range []*string{&utm.Source, &utm.Medium, &utm.Campaign, &utm.Term, &utm.Content} {
+		*v = strings.TrimSpace(*v)
+		if utf8.RuneCountInString(*v) > MaxUTMLength {
+			return storage.UTM{}, ErrInvalidUTM
+		}
+	}
+
+	return utm, nil
+}
+
+// applyUTM adds the UTM parameters of the set components to the original URL in the canonical order
+// after its other query parameters. The parameters of the original URL with the same names are replaced,
+// the rest of the query is kept as is.
+func applyUTM(originalURL string, utm storage.UTM) (string, error) {
+	u, err := url.Parse(originalURL)
+	if err != nil {
+		return "", err
+	}
+
+	set := make(map[string]string)
+	for _, p := range utm.Params() {
+		if p[1] != "" {
+			set[p[0]] = p[1]
+		}
+	}
+
+	var pairs []string
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+
+		key, _, _ := strings.Cut(pair, "=")
+		if name, err := url.QueryUnescape(key); err == nil {
+			if _, ok := set[name]; ok {
+				continue
+			}
+		}
+
+		pairs = append(pairs, pair)
+	}
+
+	for _, p := range utm.Params() {
+		if p[1] != "" {
+			pairs = append(pairs, p[0]+"="+url.QueryEscape(p[1]))
+		}
+	}
+
+	u.RawQuery = strings.Join(pairs, "&")
+	u.ForceQuery = false
+
+	return u.String(), nil
+}